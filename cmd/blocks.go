@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/meirongdev/ethereum_parser/internal/config"
+	"github.com/meirongdev/ethereum_parser/internal/ethereum"
+	"github.com/meirongdev/ethereum_parser/internal/parser"
+)
+
+// newNodeAPI creates the client of the node of the config
+func newNodeAPI(cfg config.Node) ethereum.API {
+	return ethereum.NewEthereumAPI(ethereum.WithURL(cfg.URL), ethereum.WithTimeout(cfg.Timeout))
+}
+
+// parseBlockNumber reads a decimal or 0x hex block number, latest is the current block of the node
+func parseBlockNumber(api ethereum.API, raw string) (int, error) {
+	if raw == "latest" {
+		head, err := api.GetCurrentBlock()
+		if err != nil {
+			return 0, fmt.Errorf("failed to get the current block %w", err)
+		}
+		raw = head
+	}
+	var n int64
+	var err error
+	if digits, ok := strings.CutPrefix(raw, "0x"); ok {
+		n, err = strconv.ParseInt(digits, 16, 64)
+	} else {
+		n, err = strconv.ParseInt(raw, 10, 64)
+	}
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid block number %q", raw)
+	}
+	return int(n), nil
+}
+
+// runBackfill decodes a block range and prints the transactions of the addresses as NDJSON,
+// one line per address side of a transaction
+func runBackfill(args []string) error {
+	var addresses addressList
+	var from, to string
+	cfg, rest, err := loadConfig("backfill", args, func(fs *flag.FlagSet) {
+		fs.Var(&addresses, "address", "address to scan for, separated by commas or repeated")
+		fs.StringVar(&from, "from", "", "first block of the range, decimal or 0x hex")
+		fs.StringVar(&to, "to", "latest", "last block of the range, decimal, 0x hex or latest")
+	})
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return fmt.Errorf("unexpected arguments %q", rest)
+	}
+	if len(addresses) == 0 || from == "" {
+		return errors.New("-address and -from are required")
+	}
+	logger := newLogger(cfg.Log)
+	api := newNodeAPI(cfg.Node)
+	first, err := parseBlockNumber(api, from)
+	if err != nil {
+		return err
+	}
+	last, err := parseBlockNumber(api, to)
+	if err != nil {
+		return err
+	}
+	if last < first {
+		return fmt.Errorf("the range %d to %d is empty", first, last)
+	}
+
+	// the blocks are decoded and printed one by one instead of going through a parser,
+	// which would keep every transaction of the range in memory
+	encoder := json.NewEncoder(os.Stdout)
+	logger.Info("Backfilling", "from", first, "to", last, "addresses", len(addresses))
+	for number := first; number <= last; number++ {
+		block, err := api.GetBlock(fmt.Sprintf("0x%x", number))
+		if err != nil {
+			return fmt.Errorf("failed to get block %d %w", number, err)
+		}
+		inspected, err := inspectBlock(number, block, addresses)
+		if err != nil {
+			return err
+		}
+		for _, skipped := range inspected.Skipped {
+			logger.Debug("Skipping transaction", "block", number, "tx", skipped.Hash, "error", skipped.Error)
+		}
+		for _, tx := range inspected.Matched {
+			if err := encoder.Encode(tx); err != nil {
+				return err
+			}
+		}
+		logger.Debug("Backfilled block", "block", number, "matched", len(inspected.Matched))
+	}
+	return nil
+}
+
+// inspectedBlock is the output of inspect-block
+type inspectedBlock struct {
+	Number           int                  `json:"number"`
+	Hash             string               `json:"hash"`
+	ParentHash       string               `json:"parentHash"`
+	Timestamp        time.Time            `json:"timestamp"`
+	TransactionCount int                  `json:"transactionCount"`
+	Transactions     []parser.Transaction `json:"transactions"`
+	Skipped          []skippedTransaction `json:"skipped,omitempty"`
+	Matched          []parser.Transaction `json:"matched,omitempty"`
+}
+
+type skippedTransaction struct {
+	Hash  string `json:"hash"`
+	Error string `json:"error"`
+}
+
+// runInspectBlock prints a block decoded like the parser does, with the transactions of the addresses
+func runInspectBlock(args []string) error {
+	var addresses addressList
+	cfg, rest, err := loadConfig("inspect-block", args, func(fs *flag.FlagSet) {
+		fs.Var(&addresses, "address", "address whose transactions are listed as matched, separated by commas or repeated")
+		fs.Usage = func() {
+			fmt.Fprintf(fs.Output(), "Usage: inspect-block [flags] <number|latest>\n")
+			fs.PrintDefaults()
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if len(rest) != 1 {
+		return errors.New("expected one block number, decimal, 0x hex or latest")
+	}
+	api := newNodeAPI(cfg.Node)
+	number, err := parseBlockNumber(api, rest[0])
+	if err != nil {
+		return err
+	}
+	block, err := api.GetBlock(fmt.Sprintf("0x%x", number))
+	if err != nil {
+		return fmt.Errorf("failed to get block %d %w", number, err)
+	}
+	inspected, err := inspectBlock(number, block, addresses)
+	if err != nil {
+		return err
+	}
+	return writeIndented(os.Stdout, inspected)
+}
+
+// inspectBlock decodes the transactions of the block and matches them against the addresses
+func inspectBlock(number int, block ethereum.Block, addresses []ethereum.Address) (inspectedBlock, error) {
+	inspected := inspectedBlock{
+		Number:           number,
+		Hash:             block.Hash,
+		ParentHash:       block.ParentHash,
+		TransactionCount: len(block.Transactions),
+		Transactions:     []parser.Transaction{},
+	}
+	timestamp, err := strconv.ParseInt(strings.TrimPrefix(block.Timestamp, "0x"), 16, 64)
+	if err != nil {
+		return inspected, fmt.Errorf("invalid timestamp %q", block.Timestamp)
+	}
+	inspected.Timestamp = time.Unix(timestamp, 0).UTC()
+	for _, raw := range block.Transactions {
+		tx, err := parser.DecodeTransaction(raw)
+		if err != nil {
+			inspected.Skipped = append(inspected.Skipped, skippedTransaction{Hash: tx.Hash, Error: err.Error()})
+			continue
+		}
+		tx.BlockNumber = number
+		tx.BlockHash = block.Hash
+		tx.BlockTimestamp = inspected.Timestamp
+		inspected.Transactions = append(inspected.Transactions, tx)
+		for _, address := range addresses {
+			if matched, ok := tx.SideOf(address); ok {
+				inspected.Matched = append(inspected.Matched, matched)
+			}
+		}
+	}
+	return inspected, nil
+}
+
+func writeIndented(w io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
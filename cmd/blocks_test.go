@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/meirongdev/ethereum_parser/internal/ethereum"
+	"github.com/meirongdev/ethereum_parser/internal/ethereum/mocks"
+	"github.com/meirongdev/ethereum_parser/internal/parser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	hexABC = "0x0000000000000000000000000000000000000abc"
+	hexDEF = "0x0000000000000000000000000000000000000def"
+)
+
+func mustAddress(t *testing.T, hex string) ethereum.Address {
+	address, err := ethereum.ParseAddress(hex)
+	require.NoError(t, err)
+	return address
+}
+
+func TestParseBlockNumber(t *testing.T) {
+	api := new(mocks.API)
+	api.On("GetCurrentBlock").Return("0x1b4", nil).Once()
+
+	for raw, expected := range map[string]int{"0": 0, "436": 436, "0x1b4": 436, "latest": 436} {
+		number, err := parseBlockNumber(api, raw)
+		assert.NoError(t, err, raw)
+		assert.Equal(t, expected, number, raw)
+	}
+	for _, raw := range []string{"", "-1", "0x", "0xzz", "1.5", "head"} {
+		_, err := parseBlockNumber(api, raw)
+		assert.ErrorContains(t, err, "invalid block number", raw)
+	}
+
+	api.On("GetCurrentBlock").Return("", errors.New("unreachable")).Once()
+	_, err := parseBlockNumber(api, "latest")
+	assert.ErrorContains(t, err, "failed to get the current block")
+	api.AssertExpectations(t)
+}
+
+func TestInspectBlock(t *testing.T) {
+	abc, def := mustAddress(t, hexABC), mustAddress(t, hexDEF)
+	block := ethereum.Block{
+		Hash:       "0xb10c",
+		ParentHash: "0xb10b",
+		Timestamp:  "0x6553f100",
+		Transactions: []interface{}{
+			map[string]interface{}{"hash": "0x01", "from": hexABC, "to": hexDEF, "value": "0x1"},
+			map[string]interface{}{"hash": "0x02", "from": hexDEF, "to": hexDEF, "value": "0x2"},
+			// a contract creation has no recipient
+			map[string]interface{}{"hash": "0x03", "from": hexABC, "value": "0x0"},
+		},
+	}
+
+	inspected, err := inspectBlock(7, block, []ethereum.Address{abc, def})
+	require.NoError(t, err)
+	assert.Equal(t, 7, inspected.Number)
+	assert.Equal(t, time.Unix(0x6553f100, 0).UTC(), inspected.Timestamp)
+	assert.Equal(t, 3, inspected.TransactionCount)
+	require.Len(t, inspected.Transactions, 2)
+	assert.Equal(t, 7, inspected.Transactions[0].BlockNumber)
+	assert.Equal(t, "0xb10c", inspected.Transactions[0].BlockHash)
+	require.Len(t, inspected.Skipped, 1)
+	assert.Equal(t, "0x03", inspected.Skipped[0].Hash)
+
+	type match struct {
+		hash      string
+		direction parser.Direction
+	}
+	var matched []match
+	for _, tx := range inspected.Matched {
+		matched = append(matched, match{tx.Hash, tx.Direction})
+	}
+	// one match per address side, a self-transfer matches once
+	assert.Equal(t, []match{{"0x01", parser.DirectionOut}, {"0x01", parser.DirectionIn}, {"0x02", parser.DirectionSelf}}, matched)
+
+	inspected, err = inspectBlock(7, block, nil)
+	require.NoError(t, err)
+	assert.Empty(t, inspected.Matched)
+
+	block.Timestamp = "soon"
+	_, err = inspectBlock(7, block, nil)
+	assert.ErrorContains(t, err, "invalid timestamp")
+}
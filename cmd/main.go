@@ -1,128 +1,115 @@
 package main
 
 import (
-	"context"
-	"encoding/json"
-	"log"
-	"net/http"
+	"errors"
+	"flag"
+	"fmt"
 	"os"
-	"os/signal"
-	"sync"
-	"syscall"
-	"time"
+	"sort"
+	"strings"
 
+	"github.com/meirongdev/ethereum_parser/internal/config"
 	"github.com/meirongdev/ethereum_parser/internal/ethereum"
-	"github.com/meirongdev/ethereum_parser/internal/parser"
 )
 
-type Response struct {
-	Data interface{} `json:"data"`
+// command is a subcommand of the binary
+type command struct {
+	summary string
+	run     func(args []string) error
 }
 
-func main() {
-	var wg sync.WaitGroup
+// commands are the subcommands, serve runs when none is given
+var commands = map[string]command{
+	"serve":         {"run the parser and the HTTP server", runServe},
+	"backfill":      {"scan a block range for the transactions of addresses and print them", runBackfill},
+	"inspect-block": {"print a decoded block and the transactions matching addresses", runInspectBlock},
+	"export":        {"dump the stored transactions of an address from a running server", runExport},
+	"subscribe":     {"subscribe a running server to addresses", runSubscribe},
+	"unsubscribe":   {"unsubscribe a running server from addresses", runUnsubscribe},
+	"list":          {"list the subscriptions of a running server", runList},
+}
 
-	eAPI := ethereum.NewEthereumAPI()
-	eParser := parser.NewEthereumParser(eAPI, parser.WithWaitTime(30*time.Second))
-	go eParser.Start()
+// errUsage is returned for invalid arguments, the flag package already printed the usage
+var errUsage = config.ErrUsage
 
-	mux := http.NewServeMux()
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n\nCommands:\n", os.Args[0])
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-14s %s\n", name, commands[name].summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun %s <command> -h for the flags of a command, serve runs without a command.\n", os.Args[0])
+}
 
-	// get the latest block number
-	mux.HandleFunc("/currentBlock", func(w http.ResponseWriter, _ *http.Request) {
-		blockNumber := eParser.GetCurrentBlock()
-		response := Response{
-			Data: struct {
-				BlockNumber int `json:"blockNumber"`
-			}{
-				BlockNumber: blockNumber,
-			},
-		}
-		err := json.NewEncoder(w).Encode(response)
-		if err != nil {
-			http.Error(w, "Failed to encode block number", http.StatusInternalServerError)
-			return
-		}
-	})
+func main() {
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		usage()
+		return
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+		usage()
+		os.Exit(2)
+	}
+	err := cmd.run(args)
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+	case errors.Is(err, errUsage):
+		os.Exit(2)
+	default:
+		fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+		os.Exit(1)
+	}
+}
 
-	mux.HandleFunc("/subscribe", func(w http.ResponseWriter, r *http.Request) {
-		address := r.URL.Query().Get("address")
-		if address == "" {
-			http.Error(w, "Address is required", http.StatusBadRequest)
-			return
-		}
-		subscribed := eParser.Subscribe(address)
-		msg := "Subscribed to address: " + address
-		if !subscribed {
-			msg = "Already subscribe to address: " + address
-		}
-		response := Response{
-			Data: struct {
-				Message string `json:"message"`
-			}{
-				Message: msg,
-			},
-		}
-		err := json.NewEncoder(w).Encode(response)
-		if err != nil {
-			http.Error(w, "Failed to encode response", http.StatusInternalServerError)
-			return
-		}
-	})
+// newFlagSet creates the flag set of a command, its parse errors are returned as errUsage
+func newFlagSet(name string) *flag.FlagSet {
+	return flag.NewFlagSet(name, flag.ContinueOnError)
+}
 
-	mux.HandleFunc("/transactions", func(w http.ResponseWriter, r *http.Request) {
-		address := r.URL.Query().Get("address")
-		if address == "" {
-			http.Error(w, "Address is required", http.StatusBadRequest)
-			return
+// parseFlags parses the args, errors other than -h become errUsage since the flag package printed them
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
 		}
-		transactions := eParser.GetTransactions(address)
-		response := Response{
-			Data: struct {
-				Transactions []parser.Transaction `json:"transactions"`
-			}{
-				Transactions: transactions,
-			},
-		}
-		err := json.NewEncoder(w).Encode(response)
-		if err != nil {
-			http.Error(w, "Failed to encode transactions", http.StatusInternalServerError)
-			return
-		}
-	})
+		return errUsage
+	}
+	return nil
+}
+
+// loadConfig loads the config of a command with the flags defined by define, see config.Load
+func loadConfig(name string, args []string, define func(fs *flag.FlagSet)) (config.Config, []string, error) {
+	return config.Load(name, args, os.Getenv, define)
+}
+
+// addressList is a flag taking addresses separated by commas, it can be repeated
+type addressList []ethereum.Address
 
-	closeCh := make(chan struct{})
-	server := &http.Server{
-		Addr:         ":8080",
-		Handler:      mux,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		IdleTimeout:  15 * time.Second,
+func (l *addressList) String() string {
+	hexes := make([]string, len(*l))
+	for i, address := range *l {
+		hexes[i] = address.Hex()
 	}
-	wg.Add(1)
-	server.RegisterOnShutdown(func() {
-		defer wg.Done()
-		eParser.Stop()
-	})
-	log.Println("Server started at :8080")
-	go func() {
-		sigChan := make(chan os.Signal, 1)
-		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
-		<-sigChan
-		log.Println("Received stop signal, shutting down...")
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-		if err := server.Shutdown(ctx); err != nil {
-			log.Fatalf("Server forced to shutdown: %v", err)
-		}
-		close(closeCh)
-	}()
+	return strings.Join(hexes, ",")
+}
 
-	if err := server.ListenAndServe(); err != http.ErrServerClosed {
-		log.Fatalf("HTTP server ListenAndServe: %v", err)
+func (l *addressList) Set(raw string) error {
+	for _, part := range strings.Split(raw, ",") {
+		address, err := ethereum.ParseAddress(strings.TrimSpace(part))
+		if err != nil {
+			return err
+		}
+		*l = append(*l, address)
 	}
-	<-closeCh
-	log.Println("Server shutdown finished")
-	wg.Wait()
-	log.Println("Clear all resources")
+	return nil
 }
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddressList(t *testing.T) {
+	var addresses addressList
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.Var(&addresses, "address", "")
+
+	assert.NoError(t, fs.Parse([]string{"-address", hexABC + ", " + hexDEF, "-address", hexABC}))
+	assert.Equal(t, addressList{mustAddress(t, hexABC), mustAddress(t, hexDEF), mustAddress(t, hexABC)}, addresses)
+	assert.Equal(t, addresses[0].Hex()+","+addresses[1].Hex()+","+addresses[2].Hex(), addresses.String())
+
+	var invalid addressList
+	assert.Error(t, invalid.Set(hexABC+",0x123"))
+	assert.Error(t, invalid.Set(""))
+}
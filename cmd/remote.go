@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/meirongdev/ethereum_parser/internal/client"
+	"github.com/meirongdev/ethereum_parser/internal/export"
+)
+
+// remoteFlags registers the flags locating a running server, the client is created once they are parsed
+func remoteFlags(fs *flag.FlagSet) func() *client.Client {
+	server := fs.String("server", envOr("ETH_PARSER_SERVER", "http://localhost:8080"), "URL of the running server, ETH_PARSER_SERVER")
+	token := fs.String("token", os.Getenv("ETH_PARSER_TOKEN"), "bearer token of an API key, ETH_PARSER_TOKEN")
+	return func() *client.Client {
+		return client.New(*server, client.WithToken(*token))
+	}
+}
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// interruptible returns a context cancelled by SIGINT
+func interruptible() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt)
+}
+
+func runSubscribe(args []string) error {
+	fs := newFlagSet("subscribe")
+	newClient := remoteFlags(fs)
+	label := fs.String("label", "", "label of the subscriptions")
+	startBlock := fs.Int("start-block", 0, "block from which the transactions are returned")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: subscribe [flags] <address>...\n")
+		fs.PrintDefaults()
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	items := make([]client.BatchItem, fs.NArg())
+	for i, address := range fs.Args() {
+		items[i] = client.BatchItem{Address: address, Label: *label, StartBlock: *startBlock}
+	}
+	return applyBatch(newClient(), items)
+}
+
+func runUnsubscribe(args []string) error {
+	fs := newFlagSet("unsubscribe")
+	newClient := remoteFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: unsubscribe [flags] <address>...\n")
+		fs.PrintDefaults()
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	items := make([]client.BatchItem, fs.NArg())
+	for i, address := range fs.Args() {
+		items[i] = client.BatchItem{Address: address, Action: "unsubscribe"}
+	}
+	return applyBatch(newClient(), items)
+}
+
+// applyBatch sends the changes at once and prints the status of each address,
+// it fails when one of them is invalid
+func applyBatch(c *client.Client, items []client.BatchItem) error {
+	if len(items) == 0 {
+		return errors.New("expected at least one address")
+	}
+	ctx, cancel := interruptible()
+	defer cancel()
+	batch, err := c.Apply(ctx, items)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, result := range batch.Results {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", result.Address, result.Status, result.Error)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if invalid := batch.Counts["invalid"]; invalid > 0 {
+		return fmt.Errorf("%d invalid addresses", invalid)
+	}
+	return nil
+}
+
+func runList(args []string) error {
+	fs := newFlagSet("list")
+	newClient := remoteFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments %q", fs.Args())
+	}
+	ctx, cancel := interruptible()
+	defer cancel()
+	subscriptions, err := newClient().Subscriptions(ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ADDRESS\tLABEL\tSTART BLOCK")
+	for _, subscription := range subscriptions {
+		fmt.Fprintf(w, "%s\t%s\t%d\n", subscription.Address.Hex(), subscription.Label, subscription.StartBlock)
+	}
+	return w.Flush()
+}
+
+// runExport writes the transactions of an address stored by a running server as CSV or NDJSON,
+// the server streams them so the output starts before the whole range is read
+func runExport(args []string) error {
+	fs := newFlagSet("export")
+	newClient := remoteFlags(fs)
+	var address addressList
+	fs.Var(&address, "address", "address whose transactions are exported")
+	query := url.Values{}
+	for _, param := range []struct{ name, usage string }{
+		{"fromBlock", "first block of the range"},
+		{"toBlock", "last block of the range"},
+		{"since", "earliest block time, RFC 3339 or unix seconds"},
+		{"until", "latest block time, RFC 3339 or unix seconds"},
+		{"direction", "in, out or self"},
+		{"status", "status of the transactions"},
+		{"order", "asc or desc"},
+		{"format", "csv or ndjson, csv by default"},
+		{"columns", "comma separated columns, among " + strings.Join(export.Columns, ",")},
+		{"units", "wei, gwei or eth, the unit of the amounts"},
+		{"timeFormat", "rfc3339, unix or a Go layout such as 2006-01-02 15:04:05"},
+		{"tz", "time zone of the timestamps such as Asia/Singapore, UTC by default"},
+	} {
+		fs.Func(param.name, param.usage, func(raw string) error {
+			query.Set(param.name, raw)
+			return nil
+		})
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if len(address) != 1 {
+		return errors.New("expected one -address")
+	}
+	ctx, cancel := interruptible()
+	defer cancel()
+	return newClient().Export(ctx, address[0], query, os.Stdout)
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+
+	"github.com/meirongdev/ethereum_parser/internal/api"
+	"github.com/meirongdev/ethereum_parser/internal/auth"
+	"github.com/meirongdev/ethereum_parser/internal/config"
+	"github.com/meirongdev/ethereum_parser/internal/ethereum"
+	"github.com/meirongdev/ethereum_parser/internal/health"
+	"github.com/meirongdev/ethereum_parser/internal/metrics"
+	"github.com/meirongdev/ethereum_parser/internal/notifier"
+	"github.com/meirongdev/ethereum_parser/internal/parser"
+	"github.com/meirongdev/ethereum_parser/internal/ratelimit"
+)
+
+// newLogger creates the logger of the config, it becomes the default logger so the log package writes through it too
+func newLogger(cfg config.Log) *slog.Logger {
+	var level slog.Level
+	// the level was checked by the config validation
+	_ = level.UnmarshalText([]byte(cfg.Level))
+	options := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler = slog.NewTextHandler(os.Stderr, options)
+	if cfg.Format == "json" {
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	}
+	logger := slog.New(handler)
+	slog.SetDefault(logger)
+	return logger
+}
+
+// newLimits creates the rate limits and the subscription quota, a rate allows bursts of twice as many requests
+func newLimits(cfg config.Limits) []api.Option {
+	newLimiter := func(rate float64) *ratelimit.Limiter {
+		if rate == 0 {
+			return nil
+		}
+		return ratelimit.New(rate, max(1, int(2*rate)))
+	}
+	return []api.Option{
+		api.WithRateLimits(newLimiter(cfg.IPRate), newLimiter(cfg.KeyRate)),
+		api.WithSubscriptionQuota(cfg.SubscriptionQuota),
+	}
+}
+
+// newProbes creates the liveness and readiness probes,
+// the directories of the outbox and keys files have to be writable to be ready
+func newProbes(cfg config.Config, p health.Progress, logger *slog.Logger) api.Option {
+	readiness := []health.Check{
+		health.Synced(p, cfg.Health.MaxLag),
+		health.NodeReachable(p, cfg.Health.MaxRPCAge),
+	}
+	for _, store := range []struct{ name, path string }{{"outbox_store", cfg.Notify.OutboxFile}, {"keys_store", cfg.Auth.KeysFile}} {
+		if store.path != "" {
+			readiness = append(readiness, health.Writable(store.name, filepath.Dir(store.path)))
+		}
+	}
+	return api.WithProbes(
+		health.Handler([]health.Check{health.Alive(p, cfg.Health.MaxIdle)}, health.WithLogger(logger)),
+		health.Handler(readiness, health.WithLogger(logger)),
+	)
+}
+
+// newNotifier creates the notification sinks, the file sink appends NDJSON events to a file ("-" for stdout)
+// and the webhook sink posts them to a URL.
+// The sinks given are always used, they are the webhook dispatcher and the hub of the streams.
+func newNotifier(cfg config.Notify, logger *slog.Logger, sinks ...notifier.Sink) (*notifier.Notifier, error) {
+	if cfg.File == "-" {
+		sinks = append(sinks, notifier.NewNDJSONSink(os.Stdout))
+	} else if cfg.File != "" {
+		sink, err := notifier.NewFileSink(cfg.File)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	if cfg.WebhookURL != "" {
+		sinks = append(sinks, notifier.NewWebhookSink(cfg.WebhookURL))
+	}
+	return notifier.New(sinks, notifier.WithLogger(logger)), nil
+}
+
+// runServe runs the parser and the HTTP server until SIGINT or SIGTERM
+func runServe(args []string) error {
+	var wg sync.WaitGroup
+	var printConfig bool
+	cfg, rest, err := loadConfig("serve", args, func(fs *flag.FlagSet) {
+		fs.BoolVar(&printConfig, "print-config", false, "print the effective config with its secrets redacted and exit")
+	})
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return fmt.Errorf("unexpected arguments %q", rest)
+	}
+	if printConfig {
+		return cfg.Write(os.Stdout)
+	}
+	logger := newLogger(cfg.Log)
+	logger.Info("Effective configuration", "config", cfg)
+
+	// the outbox file keeps the webhooks and their undelivered events across restarts
+	outbox := notifier.NewOutbox()
+	if path := cfg.Notify.OutboxFile; path != "" {
+		if outbox, err = notifier.OpenOutbox(path, notifier.WithOutboxLogger(logger)); err != nil {
+			return fmt.Errorf("failed to open outbox %w", err)
+		}
+	}
+	dispatcher := notifier.NewWebhookDispatcher(outbox, notifier.WithWebhookLogger(logger))
+	hub := notifier.NewHub()
+	eNotifier, err := newNotifier(cfg.Notify, logger, dispatcher, hub)
+	if err != nil {
+		return fmt.Errorf("failed to create notifier %w", err)
+	}
+	collector := metrics.NewCollector()
+	nodeAPI := newNodeAPI(cfg.Node)
+	eAPI := collector.InstrumentAPI(nodeAPI)
+	parserOptions := append([]parser.Option{
+		parser.WithPollInterval(cfg.Parser.PollInterval),
+		parser.WithCatchUpInterval(cfg.Parser.CatchUpInterval),
+		parser.WithBackoff(cfg.Parser.MinBackoff, cfg.Parser.MaxBackoff),
+		parser.WithConfirmations(cfg.Parser.Confirmations),
+		parser.WithPublisher(eNotifier),
+		parser.WithLogger(logger),
+	}, collector.ParserOptions()...)
+	// the pending transactions are only watched on request, most hosted nodes do not serve txpool_content
+	if pool, ok := nodeAPI.(ethereum.TxPoolAPI); ok && cfg.Parser.TxPoolInterval > 0 {
+		parserOptions = append(parserOptions,
+			parser.WithTxPool(collector.InstrumentTxPool(pool), cfg.Parser.TxPoolInterval),
+			parser.WithPendingDropAfter(cfg.Parser.PendingDropAfter),
+		)
+	}
+	balances, trackBalances := nodeAPI.(ethereum.BalanceAPI)
+	trackBalances = trackBalances && cfg.Parser.Balances
+	if trackBalances {
+		parserOptions = append(parserOptions, parser.WithBalances(collector.InstrumentBalances(balances)))
+	}
+	eParser := parser.NewEthereumParser(eAPI, parserOptions...)
+	collector.WatchParser(eParser)
+	for _, webhook := range outbox.Webhooks() {
+		eParser.Subscribe(webhook.Address)
+	}
+	options := []api.Option{api.WithHub(hub), api.WithWebhooks(outbox, dispatcher), api.WithMetrics(collector), api.WithLogger(logger)}
+	if trackBalances {
+		options = append(options, api.WithBalances(eParser))
+	}
+	// the admin token enables the API keys, the keys file keeps them and their subscriptions across restarts
+	if adminToken := cfg.Auth.AdminToken; adminToken != "" {
+		keys := auth.NewKeys()
+		if path := cfg.Auth.KeysFile; path != "" {
+			if keys, err = auth.OpenKeys(path); err != nil {
+				return fmt.Errorf("failed to open keys %w", err)
+			}
+		}
+		for _, address := range keys.Addresses() {
+			eParser.Subscribe(address)
+		}
+		options = append(options, api.WithAuth(keys, adminToken))
+	} else {
+		logger.Warn("No admin token is set, the API is open to anyone who can reach it")
+	}
+	options = append(options, newLimits(cfg.Limits)...)
+	options = append(options, newProbes(cfg, eParser, logger))
+	go eParser.Start()
+
+	handler := api.New(eParser, options...)
+
+	closeCh := make(chan struct{})
+	server := &http.Server{
+		Addr:         cfg.Server.Addr,
+		Handler:      handler,
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+		IdleTimeout:  cfg.Server.IdleTimeout,
+	}
+	wg.Add(1)
+	server.RegisterOnShutdown(func() {
+		defer wg.Done()
+		eParser.Stop()
+		if err := eNotifier.Close(); err != nil {
+			logger.Error("Failed to close notifier", "error", err)
+		}
+		// the dispatcher is closed with the notifier, the outbox writes its last changes
+		if err := outbox.Close(); err != nil {
+			logger.Error("Failed to close outbox", "error", err)
+		}
+	})
+	logger.Info("Server started", "addr", cfg.Server.Addr)
+	go func() {
+		sigChan := make(chan os.Signal, 1)
+		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
+		<-sigChan
+		logger.Info("Received stop signal, shutting down")
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			logger.Error("Server forced to shutdown", "error", err)
+			os.Exit(1)
+		}
+		close(closeCh)
+	}()
+
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		return fmt.Errorf("HTTP server ListenAndServe %w", err)
+	}
+	<-closeCh
+	logger.Info("Server shutdown finished")
+	wg.Wait()
+	logger.Info("Clear all resources")
+	return nil
+}
@@ -0,0 +1,184 @@
+// Package api serves the HTTP API of the parser: the /v1 routes, the unversioned routes kept for the
+// existing clients, the Server-Sent Events stream and the websocket subscriptions.
+package api
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+
+	"github.com/meirongdev/ethereum_parser/internal/auth"
+	"github.com/meirongdev/ethereum_parser/internal/ethereum"
+	"github.com/meirongdev/ethereum_parser/internal/notifier"
+	"github.com/meirongdev/ethereum_parser/internal/parser"
+	"github.com/meirongdev/ethereum_parser/internal/ratelimit"
+)
+
+// Parser is what the handlers need from the parser, *parser.EthereumParser implements it
+type Parser interface {
+	GetCurrentBlock() int
+	Subscribe(address ethereum.Address) bool
+	SubscribeAll(subscriptions []parser.Subscription) []bool
+	Unsubscribe(addresses []ethereum.Address) []bool
+	Subscriptions() []parser.Subscription
+	Addresses() []ethereum.Address
+	QueryTransactions(address ethereum.Address, q parser.Query) (parser.Page, error)
+}
+
+// Balances gives the balance history of the subscribed addresses, the parser implements it with parser.WithBalances
+type Balances interface {
+	BalanceHistory(address ethereum.Address) []parser.Balance
+}
+
+// Response is the body of every successful response
+type Response struct {
+	Data interface{} `json:"data"`
+}
+
+// Error codes of the /v1 error envelope
+const (
+	errCodeInvalidArgument  = "invalid_argument"
+	errCodeUnauthenticated  = "unauthenticated"
+	errCodePermissionDenied = "permission_denied"
+	errCodeNotFound         = "not_found"
+	errCodeMethodNotAllowed = "method_not_allowed"
+	errCodeRateLimited      = "rate_limited"
+	errCodeQuotaExceeded    = "quota_exceeded"
+	errCodeInternal         = "internal"
+)
+
+// apiError is the body of every failed /v1 response: {"error": {"code": "...", "message": "..."}}
+type apiError struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// Handler routes the requests to the handlers
+type Handler struct {
+	parser     Parser
+	hub        *notifier.Hub
+	outbox     *notifier.Outbox
+	balances   Balances
+	dispatcher *notifier.WebhookDispatcher
+	keys       *auth.Keys
+	adminHash  string
+	ipLimiter  *ratelimit.Limiter
+	keyLimiter *ratelimit.Limiter
+	quota      int
+	metrics    http.Handler
+	liveness   http.Handler
+	readiness  http.Handler
+	logger     *slog.Logger
+	// lookupIP resolves the hosts of the callback URLs
+	lookupIP notifier.LookupIP
+	mux      *http.ServeMux
+	handler  http.Handler
+}
+
+type Option func(*Handler)
+
+// WithHub enables the stream and websocket routes, the hub has to be a sink of the parser notifier
+func WithHub(hub *notifier.Hub) Option {
+	return func(h *Handler) {
+		h.hub = hub
+	}
+}
+
+// WithWebhooks enables the callbackUrl of the subscriptions and the dead letter routes
+func WithWebhooks(outbox *notifier.Outbox, dispatcher *notifier.WebhookDispatcher) Option {
+	return func(h *Handler) {
+		h.outbox = outbox
+		h.dispatcher = dispatcher
+	}
+}
+
+// WithBalances enables the balance history route
+func WithBalances(balances Balances) Option {
+	return func(h *Handler) {
+		h.balances = balances
+	}
+}
+
+// WithMetrics serves the Prometheus metrics at /metrics, with WithAuth they take the admin token
+func WithMetrics(metrics http.Handler) Option {
+	return func(h *Handler) {
+		h.metrics = metrics
+	}
+}
+
+// WithProbes serves the liveness and readiness probes at /healthz and /readyz,
+// they skip the authentication and the rate limits so the orchestrator always reaches them
+func WithProbes(liveness, readiness http.Handler) Option {
+	return func(h *Handler) {
+		h.liveness = liveness
+		h.readiness = readiness
+	}
+}
+
+// WithLogger sets the logger of the handler, slog.Default() by default
+func WithLogger(logger *slog.Logger) Option {
+	return func(h *Handler) {
+		h.logger = logger
+	}
+}
+
+// New creates the handler of every route
+func New(p Parser, options ...Option) *Handler {
+	h := &Handler{parser: p, mux: http.NewServeMux(), logger: slog.Default(), lookupIP: net.DefaultResolver.LookupIP}
+	for _, option := range options {
+		option(h)
+	}
+	registerV1(h.mux, h.v1Routes())
+	h.registerLegacy()
+	if h.metrics != nil {
+		h.mux.Handle("GET /metrics", h.metrics)
+	}
+	h.handler = h.mux
+	if h.keys != nil {
+		if h.keyLimiter != nil {
+			h.handler = rateLimit(h.keyLimiter, func(r *http.Request) string { return principalFrom(r).keyID }, h.handler)
+		}
+		h.handler = h.authenticate(h.handler)
+	}
+	// the IP limit comes first so the clients guessing tokens are also limited
+	if h.ipLimiter != nil {
+		h.handler = rateLimit(h.ipLimiter, clientIP, h.handler)
+	}
+	if h.liveness != nil || h.readiness != nil {
+		probes := http.NewServeMux()
+		if h.liveness != nil {
+			probes.Handle("GET /healthz", h.liveness)
+		}
+		if h.readiness != nil {
+			probes.Handle("GET /readyz", h.readiness)
+		}
+		probes.Handle("/", h.handler)
+		h.handler = probes
+	}
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.handler.ServeHTTP(w, r)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	// the values always encode, a failed write means the client went away and cannot be told
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeData(w http.ResponseWriter, status int, data interface{}) {
+	writeJSON(w, status, Response{Data: data})
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	var body apiError
+	body.Error.Code = code
+	body.Error.Message = message
+	writeJSON(w, status, body)
+}
@@ -0,0 +1,289 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/meirongdev/ethereum_parser/internal/auth"
+	"github.com/meirongdev/ethereum_parser/internal/ethereum"
+	"github.com/meirongdev/ethereum_parser/internal/notifier"
+	"github.com/meirongdev/ethereum_parser/internal/parser"
+	"github.com/meirongdev/ethereum_parser/internal/ratelimit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var _ Parser = (*parser.EthereumParser)(nil)
+
+const testAddress = "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"
+
+var testAddr = mustAddress(testAddress)
+
+func mustAddress(s string) ethereum.Address {
+	address, err := ethereum.ParseAddress(s)
+	if err != nil {
+		panic(err)
+	}
+	return address
+}
+
+// fakeParser stores the transactions given by the tests and records the queries
+type fakeParser struct {
+	mutex        sync.Mutex
+	currentBlock int
+	subscribed   map[ethereum.Address]parser.Subscription
+	transactions map[ethereum.Address][]parser.Transaction
+	balances     map[ethereum.Address][]parser.Balance
+	queries      []parser.Query
+	queryErr     error
+	// pageSize splits the transactions into pages when it is set
+	pageSize int
+}
+
+func newFakeParser() *fakeParser {
+	return &fakeParser{
+		subscribed:   make(map[ethereum.Address]parser.Subscription),
+		transactions: make(map[ethereum.Address][]parser.Transaction),
+		balances:     make(map[ethereum.Address][]parser.Balance),
+	}
+}
+
+func (p *fakeParser) BalanceHistory(address ethereum.Address) []parser.Balance {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.balances[address]
+}
+
+func (p *fakeParser) GetCurrentBlock() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.currentBlock
+}
+
+func (p *fakeParser) Subscribe(address ethereum.Address) bool {
+	return p.SubscribeAll([]parser.Subscription{{Address: address}})[0]
+}
+
+// SubscribeAll replaces the existing subscriptions, the parser package tests how they are merged
+func (p *fakeParser) SubscribeAll(subscriptions []parser.Subscription) []bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	created := make([]bool, len(subscriptions))
+	for i, subscription := range subscriptions {
+		_, exists := p.subscribed[subscription.Address]
+		created[i] = !exists
+		p.subscribed[subscription.Address] = subscription
+	}
+	return created
+}
+
+func (p *fakeParser) Unsubscribe(addresses []ethereum.Address) []bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	removed := make([]bool, len(addresses))
+	for i, address := range addresses {
+		_, removed[i] = p.subscribed[address]
+		delete(p.subscribed, address)
+	}
+	return removed
+}
+
+func (p *fakeParser) Subscriptions() []parser.Subscription {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	var subscriptions []parser.Subscription
+	for _, subscription := range p.subscribed {
+		subscriptions = append(subscriptions, subscription)
+	}
+	sort.Slice(subscriptions, func(i, j int) bool {
+		return subscriptions[i].Address.Hex() < subscriptions[j].Address.Hex()
+	})
+	return subscriptions
+}
+
+func (p *fakeParser) Addresses() []ethereum.Address {
+	var addresses []ethereum.Address
+	for _, subscription := range p.Subscriptions() {
+		addresses = append(addresses, subscription.Address)
+	}
+	return addresses
+}
+
+func (p *fakeParser) isSubscribed(address ethereum.Address) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	_, exists := p.subscribed[address]
+	return exists
+}
+
+// QueryTransactions ignores the filters, the parser package tests them
+func (p *fakeParser) QueryTransactions(address ethereum.Address, q parser.Query) (parser.Page, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.queries = append(p.queries, q)
+	if p.queryErr != nil {
+		return parser.Page{}, p.queryErr
+	}
+	if _, exists := p.subscribed[address]; !exists {
+		return parser.Page{Transactions: []parser.Transaction{}}, nil
+	}
+	transactions := p.transactions[address]
+	if p.pageSize == 0 {
+		return parser.Page{Transactions: append([]parser.Transaction{}, transactions...)}, nil
+	}
+	start, _ := strconv.Atoi(q.Cursor)
+	end := min(start+p.pageSize, len(transactions))
+	page := parser.Page{Transactions: append([]parser.Transaction{}, transactions[start:end]...)}
+	if end < len(transactions) {
+		page.NextCursor = strconv.Itoa(end)
+	}
+	return page, nil
+}
+
+func (p *fakeParser) lastQuery() parser.Query {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.queries[len(p.queries)-1]
+}
+
+func testTransaction(blockNumber, index int) parser.Transaction {
+	return parser.Transaction{
+		Hash:             fmt.Sprintf("0x%04x%04x", blockNumber, index),
+		From:             testAddr,
+		Value:            ethereum.NewWei(big.NewInt(1)),
+		BlockNumber:      blockNumber,
+		TransactionIndex: index,
+		Direction:        parser.DirectionOut,
+		Status:           parser.StatusMined,
+	}
+}
+
+const testAdminToken = "admin-token"
+
+// testEnv is a handler and its dependencies
+type testEnv struct {
+	parser     *fakeParser
+	hub        *notifier.Hub
+	outbox     *notifier.Outbox
+	dispatcher *notifier.WebhookDispatcher
+	keys       *auth.Keys
+	handler    *Handler
+}
+
+// newTestEnv creates a handler without authentication, its webhook deliveries fail after one attempt
+func newTestEnv(t *testing.T, options ...Option) *testEnv {
+	env := &testEnv{parser: newFakeParser(), hub: notifier.NewHub(), outbox: notifier.NewOutbox()}
+	env.dispatcher = notifier.NewWebhookDispatcher(env.outbox, notifier.WithMaxAttempts(1))
+	t.Cleanup(func() { _ = env.dispatcher.Close() })
+	options = append([]Option{WithHub(env.hub), WithWebhooks(env.outbox, env.dispatcher), WithBalances(env.parser)}, options...)
+	env.handler = New(env.parser, options...)
+	env.handler.lookupIP = testLookupIP
+	return env
+}
+
+// testLookupIP resolves the hosts ending with .internal to a private address and the others to a public one
+func testLookupIP(_ context.Context, _, host string) ([]net.IP, error) {
+	if strings.HasSuffix(host, ".internal") {
+		return []net.IP{net.ParseIP("10.0.0.5")}, nil
+	}
+	return []net.IP{net.ParseIP("203.0.113.10")}, nil
+}
+
+// newAuthTestEnv creates a handler with every option, the admin token is testAdminToken
+func newAuthTestEnv(t *testing.T, options ...Option) *testEnv {
+	keys := auth.NewKeys()
+	env := newTestEnv(t, append([]Option{WithAuth(keys, testAdminToken)}, options...)...)
+	env.keys = keys
+	return env
+}
+
+// newKey creates a tenant key and returns its token
+func (env *testEnv) newKey(t *testing.T, name string) string {
+	_, token, err := env.keys.Create(name)
+	require.NoError(t, err)
+	return token
+}
+
+func (env *testEnv) do(method, target, body string) *httptest.ResponseRecorder {
+	return env.doAs("", method, target, body)
+}
+
+// doAs sends a request with a bearer token
+func (env *testEnv) doAs(token, method, target, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	env.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// addDeadLetter makes a delivery to a failing webhook run out of attempts
+func (env *testEnv) addDeadLetter(t *testing.T) notifier.Delivery {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(server.Close)
+	_, err := env.outbox.RegisterWebhook("", testAddr, server.URL)
+	require.NoError(t, err)
+	require.NoError(t, env.dispatcher.Send(context.Background(), notifier.Event{Type: notifier.EventTransaction, Address: testAddr}))
+	require.Eventually(t, func() bool { return len(env.outbox.DeadLetters()) == 1 }, 5*time.Second, 5*time.Millisecond)
+	return env.outbox.DeadLetters()[0]
+}
+
+func TestNewRegistersRoutesOfTheOptions(t *testing.T) {
+	h := New(newFakeParser())
+	for _, target := range []string{"/v1/stream", "/v1/ws", "/v1/admin/deadletters", "/stream", "/admin/deadletters", "/metrics", "/healthz", "/readyz"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+		assert.Equal(t, http.StatusNotFound, rec.Code, target)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("PUT", "/v1/addresses/"+testAddress+"/subscription", strings.NewReader(`{"callbackUrl":"https://example.com"}`)))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "webhooks are not enabled")
+}
+
+func TestMetricsTakeTheAdminToken(t *testing.T) {
+	metrics := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("requests_total 1\n"))
+	})
+	env := newAuthTestEnv(t, WithMetrics(metrics))
+	tenant := env.newKey(t, "tenant")
+
+	assert.Equal(t, http.StatusUnauthorized, env.do("GET", "/metrics", "").Code)
+	assert.Equal(t, http.StatusForbidden, env.doAs(tenant, "GET", "/metrics", "").Code)
+	rec := env.doAs(testAdminToken, "GET", "/metrics", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "requests_total 1\n", rec.Body.String())
+}
+
+func TestProbesSkipAuthAndRateLimits(t *testing.T) {
+	probe := func(status int) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(status)
+		})
+	}
+	env := newAuthTestEnv(t,
+		WithProbes(probe(http.StatusOK), probe(http.StatusServiceUnavailable)),
+		WithRateLimits(ratelimit.New(0.001, 1), nil))
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusOK, env.do("GET", "/healthz", "").Code)
+		assert.Equal(t, http.StatusServiceUnavailable, env.do("GET", "/readyz", "").Code)
+	}
+	assert.Equal(t, http.StatusUnauthorized, env.do("GET", "/v1/addresses", "").Code)
+	assert.Equal(t, http.StatusTooManyRequests, env.do("GET", "/v1/addresses", "").Code)
+}
@@ -0,0 +1,228 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/meirongdev/ethereum_parser/internal/auth"
+	"github.com/meirongdev/ethereum_parser/internal/ethereum"
+	"github.com/meirongdev/ethereum_parser/internal/parser"
+)
+
+// principal is who sent a request, a tenant key or the admin
+type principal struct {
+	keyID string
+	admin bool
+}
+
+type principalKey struct{}
+
+func principalFrom(r *http.Request) principal {
+	p, _ := r.Context().Value(principalKey{}).(principal)
+	return p
+}
+
+// WithAuth requires a bearer token on every route but the OpenAPI document.
+// The /admin routes take the admin token, the other routes take a key of the store
+// and only see the subscriptions of that key.
+func WithAuth(keys *auth.Keys, adminToken string) Option {
+	return func(h *Handler) {
+		h.keys = keys
+		h.adminHash = auth.HashToken(adminToken)
+	}
+}
+
+// bearerToken reads the Authorization header, browsers cannot set it on an EventSource or a WebSocket
+// so the streams also accept an access_token parameter
+func bearerToken(r *http.Request) string {
+	if scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	switch r.URL.Path {
+	case "/stream", "/ws", "/v1/stream", "/v1/ws":
+		return r.URL.Query().Get("access_token")
+	}
+	return ""
+}
+
+// isAdminPath tells if the path takes the admin token, the metrics are about every tenant
+func isAdminPath(path string) bool {
+	return strings.HasPrefix(path, "/admin/") || strings.HasPrefix(path, "/v1/admin/") || path == "/metrics"
+}
+
+// denied answers a request which is not allowed, with the error envelope on the /v1 routes
+func denied(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="ethereum_parser"`)
+	}
+	if strings.HasPrefix(r.URL.Path, "/v1/") {
+		writeError(w, status, code, message)
+		return
+	}
+	http.Error(w, message, status)
+}
+
+// authenticate checks the token of the request and passes its principal to the next handler
+func (h *Handler) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/openapi.json" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		token := bearerToken(r)
+		if token == "" {
+			denied(w, r, http.StatusUnauthorized, errCodeUnauthenticated, "a bearer token is required")
+			return
+		}
+		var p principal
+		if subtle.ConstantTimeCompare([]byte(auth.HashToken(token)), []byte(h.adminHash)) == 1 {
+			p.admin = true
+		} else {
+			key, err := h.keys.Authenticate(token)
+			if err != nil {
+				denied(w, r, http.StatusUnauthorized, errCodeUnauthenticated, "invalid or revoked token")
+				return
+			}
+			p.keyID = key.ID
+		}
+		if isAdminPath(r.URL.Path) != p.admin {
+			message := "the admin routes require the admin token"
+			if p.admin {
+				message = "the admin token cannot use the tenant routes, create a key"
+			}
+			denied(w, r, http.StatusForbidden, errCodePermissionDenied, message)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	})
+}
+
+// subscribeFor adds the address to the subscriptions of the tenant and makes the parser index it,
+// it returns false when the tenant was already subscribed and auth.ErrQuotaExceeded when it has too many subscriptions
+func (h *Handler) subscribeFor(r *http.Request, address ethereum.Address) (bool, error) {
+	if h.keys == nil {
+		if !h.withinQuota(r, address) {
+			return false, auth.ErrQuotaExceeded
+		}
+		return h.parser.Subscribe(address), nil
+	}
+	created, err := h.keys.SubscribeWithin(principalFrom(r).keyID, address, h.quota)
+	if err != nil {
+		return false, err
+	}
+	h.parser.Subscribe(address)
+	return created, nil
+}
+
+// canRead tells if the tenant of the request subscribed to the address
+func (h *Handler) canRead(r *http.Request, address ethereum.Address) bool {
+	_, ok := h.readStart(r, address)
+	return ok
+}
+
+// readStart tells if the tenant of the request subscribed to the address and returns the start block of its subscription.
+// The parser keeps the earliest start block of all the tenants, the reads of a tenant are bounded by its own.
+func (h *Handler) readStart(r *http.Request, address ethereum.Address) (int, bool) {
+	if h.keys == nil {
+		return 0, true
+	}
+	subscription, ok := h.keys.Subscription(principalFrom(r).keyID, address)
+	return subscription.StartBlock, ok
+}
+
+// addresses returns the addresses the tenant of the request subscribed to
+func (h *Handler) addresses(r *http.Request) []ethereum.Address {
+	if h.keys == nil {
+		return h.parser.Addresses()
+	}
+	subscriptions := h.keys.Subscriptions(principalFrom(r).keyID)
+	addresses := make([]ethereum.Address, 0, len(subscriptions))
+	for _, subscription := range subscriptions {
+		addresses = append(addresses, subscription.Address)
+	}
+	return addresses
+}
+
+// subscriptions returns the subscriptions of the tenant of the request with their labels
+func (h *Handler) subscriptions(r *http.Request) []parser.Subscription {
+	if h.keys == nil {
+		return h.parser.Subscriptions()
+	}
+	return h.keys.Subscriptions(principalFrom(r).keyID)
+}
+
+// createKeyRequest is the body of POST /v1/admin/keys
+type createKeyRequest struct {
+	Name string `json:"name"`
+}
+
+func (h *Handler) createKey(w http.ResponseWriter, r *http.Request) {
+	var req createKeyRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, errCodeInvalidArgument, "invalid body: "+err.Error())
+		return
+	}
+	if strings.TrimSpace(req.Name) == "" {
+		writeError(w, http.StatusBadRequest, errCodeInvalidArgument, "name is required")
+		return
+	}
+	key, token, err := h.keys.Create(req.Name)
+	if err != nil {
+		h.logger.Error("Failed to create key", "error", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to create key")
+		return
+	}
+	writeData(w, http.StatusCreated, struct {
+		Key   auth.Key `json:"key"`
+		Token string   `json:"token"`
+	}{
+		Key:   key,
+		Token: token,
+	})
+}
+
+func (h *Handler) listKeys(w http.ResponseWriter, _ *http.Request) {
+	writeData(w, http.StatusOK, struct {
+		Keys []auth.Key `json:"keys"`
+	}{
+		Keys: h.keys.List(),
+	})
+}
+
+func (h *Handler) revokeKey(w http.ResponseWriter, r *http.Request) {
+	key, err := h.keys.Revoke(r.PathValue("id"))
+	if errors.Is(err, auth.ErrNotFound) {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "key "+r.PathValue("id")+" not found")
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to revoke key", "key", r.PathValue("id"), "error", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to revoke key")
+		return
+	}
+	// the webhooks and the addresses nobody else watches go with the key, revoking again retries a failed removal
+	if h.outbox != nil {
+		if _, err := h.outbox.RemoveKeyWebhooks(key.ID); err != nil {
+			h.logger.Error("Failed to remove the webhooks of a revoked key", "key", key.ID, "error", err)
+			writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to remove the webhooks of the key")
+			return
+		}
+	}
+	var addresses []ethereum.Address
+	for _, subscription := range h.keys.Subscriptions(key.ID) {
+		addresses = append(addresses, subscription.Address)
+	}
+	h.parser.Unsubscribe(h.unwatched(addresses))
+	writeData(w, http.StatusOK, struct {
+		Key auth.Key `json:"key"`
+	}{
+		Key: key,
+	})
+}
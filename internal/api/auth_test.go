@@ -0,0 +1,172 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/meirongdev/ethereum_parser/internal/auth"
+	"github.com/meirongdev/ethereum_parser/internal/parser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const otherAddress = "0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359"
+
+func TestAuthentication(t *testing.T) {
+	env := newAuthTestEnv(t)
+	tenant := env.newKey(t, "tenant")
+	revoked := env.newKey(t, "revoked")
+	key, err := env.keys.Authenticate(revoked)
+	require.NoError(t, err)
+	_, err = env.keys.Revoke(key.ID)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name, token, method, target, pattern string
+		status                               int
+		errorCode                            string
+	}{
+		{"openapi is public", "", "GET", "/v1/openapi.json", "/v1/openapi.json", 200, ""},
+		{"missing token", "", "GET", "/v1/blocks/current", "/v1/blocks/current", 401, errCodeUnauthenticated},
+		{"unknown token", "ep_nope", "GET", "/v1/blocks/current", "/v1/blocks/current", 401, errCodeUnauthenticated},
+		{"revoked token", revoked, "GET", "/v1/blocks/current", "/v1/blocks/current", 401, errCodeUnauthenticated},
+		{"tenant", tenant, "GET", "/v1/blocks/current", "/v1/blocks/current", 200, ""},
+		{"tenant on admin route", tenant, "GET", "/v1/admin/keys", "/v1/admin/keys", 403, errCodePermissionDenied},
+		{"admin on tenant route", testAdminToken, "GET", "/v1/addresses", "/v1/addresses", 403, errCodePermissionDenied},
+		{"admin", testAdminToken, "GET", "/v1/admin/deadletters", "/v1/admin/deadletters", 200, ""},
+		{"unknown path", "", "GET", "/v1/nope", "", 401, errCodeUnauthenticated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := env.doAs(tt.token, tt.method, tt.target, "")
+			assert.Equal(t, tt.status, rec.Code, rec.Body.String())
+			if tt.pattern != "" {
+				assertDocumented(t, tt.method, tt.pattern, tt.status)
+			}
+			if tt.errorCode == "" {
+				return
+			}
+			var body apiError
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+			assert.Equal(t, tt.errorCode, body.Error.Code)
+			if tt.status == http.StatusUnauthorized {
+				assert.Contains(t, rec.Header().Get("WWW-Authenticate"), "Bearer")
+			}
+		})
+	}
+}
+
+func TestAuthenticationOfLegacyRoutes(t *testing.T) {
+	env := newAuthTestEnv(t)
+	tenant := env.newKey(t, "tenant")
+
+	rec := env.do("GET", "/currentBlock", "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, "a bearer token is required\n", rec.Body.String())
+
+	rec = env.doAs(tenant, "GET", "/admin/deadletters", "")
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = env.doAs(tenant, "GET", "/currentBlock", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestTenantsOnlySeeTheirSubscriptions(t *testing.T) {
+	env := newAuthTestEnv(t)
+	alice := env.newKey(t, "alice")
+	bob := env.newKey(t, "bob")
+	env.parser.transactions[mustAddress(otherAddress)] = []parser.Transaction{testTransaction(3, 0)}
+
+	assert.Equal(t, http.StatusCreated, env.doAs(alice, "PUT", "/v1/addresses/"+testAddress+"/subscription", "").Code)
+	assert.Equal(t, http.StatusCreated, env.doAs(bob, "PUT", "/v1/addresses/"+otherAddress+"/subscription", "").Code)
+	// the address is new for alice even though the parser already indexes it
+	assert.Equal(t, http.StatusCreated, env.doAs(alice, "PUT", "/v1/addresses/"+otherAddress+"/subscription", "").Code)
+	assert.Equal(t, http.StatusOK, env.doAs(alice, "PUT", "/v1/addresses/"+otherAddress+"/subscription", "").Code)
+
+	rec := env.doAs(bob, "GET", "/v1/addresses", "")
+	assert.JSONEq(t, `{"data":{"addresses":["`+otherAddress+`"],"subscriptions":[{"address":"`+otherAddress+`"}]}}`, rec.Body.String())
+	rec = env.doAs(alice, "GET", "/v1/addresses", "")
+	assert.JSONEq(t, `{"data":{"addresses":["`+testAddress+`","`+otherAddress+`"],
+		"subscriptions":[{"address":"`+testAddress+`"},{"address":"`+otherAddress+`"}]}}`, rec.Body.String())
+
+	rec = env.doAs(bob, "GET", "/v1/addresses/"+testAddress+"/transactions", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assertDocumented(t, "GET", "/v1/addresses/{address}/transactions", rec.Code)
+	rec = env.doAs(bob, "GET", "/transactions?address="+testAddress, "")
+	assert.JSONEq(t, `{"data":{"transactions":[]}}`, rec.Body.String())
+
+	rec = env.doAs(bob, "GET", "/v1/addresses/"+otherAddress+"/transactions", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), testTransaction(3, 0).Hash)
+}
+
+func TestStreamAcceptsAccessTokenParameter(t *testing.T) {
+	env := newAuthTestEnv(t)
+	tenant := env.newKey(t, "tenant")
+	server := httptest.NewServer(env.handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/v1/stream?address=" + testAddress + "&access_token=" + tenant)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.True(t, env.keys.IsSubscribed(mustKeyID(t, env.keys, tenant), testAddr))
+
+	// the parameter is only read by the streams
+	rec := env.do("GET", "/v1/addresses?access_token="+tenant, "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func mustKeyID(t *testing.T, keys *auth.Keys, token string) string {
+	key, err := keys.Authenticate(token)
+	require.NoError(t, err)
+	return key.ID
+}
+
+func TestKeyAdministration(t *testing.T) {
+	env := newAuthTestEnv(t)
+
+	rec := env.doAs(testAdminToken, "POST", "/v1/admin/keys", `{"name":"carol"}`)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assertDocumented(t, "POST", "/v1/admin/keys", rec.Code)
+	var created struct {
+		Data struct {
+			Key   auth.Key `json:"key"`
+			Token string   `json:"token"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	assert.Equal(t, "carol", created.Data.Key.Name)
+	assert.Equal(t, created.Data.Token[:len(created.Data.Key.Hint)], created.Data.Key.Hint)
+	assert.Equal(t, http.StatusOK, env.doAs(created.Data.Token, "GET", "/v1/addresses", "").Code)
+
+	rec = env.doAs(testAdminToken, "GET", "/v1/admin/keys", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), created.Data.Key.ID)
+	assert.NotContains(t, rec.Body.String(), created.Data.Token)
+	assert.NotContains(t, rec.Body.String(), auth.HashToken(created.Data.Token))
+
+	rec = env.doAs(testAdminToken, "DELETE", "/v1/admin/keys/"+created.Data.Key.ID, "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assertDocumented(t, "DELETE", "/v1/admin/keys/{id}", rec.Code)
+	assert.Contains(t, rec.Body.String(), `"revokedAt"`)
+	assert.Equal(t, http.StatusUnauthorized, env.doAs(created.Data.Token, "GET", "/v1/addresses", "").Code)
+
+	tests := []struct {
+		name, method, target, body, pattern string
+		status                              int
+	}{
+		{"missing name", "POST", "/v1/admin/keys", `{}`, "/v1/admin/keys", 400},
+		{"unknown field", "POST", "/v1/admin/keys", `{"name":"x","admin":true}`, "/v1/admin/keys", 400},
+		{"unknown key", "DELETE", "/v1/admin/keys/nope", "", "/v1/admin/keys/{id}", 404},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := env.doAs(testAdminToken, tt.method, tt.target, tt.body)
+			assert.Equal(t, tt.status, rec.Code, rec.Body.String())
+			assertDocumented(t, tt.method, tt.pattern, tt.status)
+		})
+	}
+}
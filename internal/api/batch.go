@@ -0,0 +1,291 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/meirongdev/ethereum_parser/internal/auth"
+	"github.com/meirongdev/ethereum_parser/internal/ethereum"
+	"github.com/meirongdev/ethereum_parser/internal/parser"
+)
+
+const (
+	// maxBatchItems is the most addresses of a batch, bigger onboardings are split in several requests
+	maxBatchItems = 10000
+	// maxBatchBytes is the most bytes of a batch body
+	maxBatchBytes = 4 << 20
+	// maxLabelLength is the most bytes of a subscription label
+	maxLabelLength = 128
+)
+
+// Actions of a batch item
+const (
+	actionSubscribe   = "subscribe"
+	actionUnsubscribe = "unsubscribe"
+)
+
+// Statuses of a batch result
+const (
+	batchCreated       = "created"
+	batchUpdated       = "updated"
+	batchRemoved       = "removed"
+	batchNotSubscribed = "not_subscribed"
+	batchInvalid       = "invalid"
+)
+
+// batchItem is one entry of POST /v1/addresses/batch, a bare "0x..." string subscribes to the address
+type batchItem struct {
+	Address    string `json:"address"`
+	Label      string `json:"label"`
+	StartBlock int    `json:"startBlock"`
+	// Action is subscribe, the default, or unsubscribe
+	Action string `json:"action"`
+}
+
+// batchResult is the outcome of one entry, the results are in the order of the entries
+type batchResult struct {
+	Index   int    `json:"index"`
+	Address string `json:"address"`
+	Action  string `json:"action,omitempty"`
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+}
+
+// readBatch splits the body in entries, it is a JSON array or one JSON value per line (NDJSON)
+func readBatch(body io.Reader) ([]json.RawMessage, error) {
+	reader := bufio.NewReader(body)
+	var entries []json.RawMessage
+	for {
+		b, err := reader.Peek(1)
+		if err != nil {
+			return nil, errors.New("the body is empty")
+		}
+		if bytes.ContainsAny(b, " \t\r\n") {
+			_, _ = reader.ReadByte()
+			continue
+		}
+		if b[0] == '[' {
+			if err := json.NewDecoder(reader).Decode(&entries); err != nil {
+				return nil, fmt.Errorf("invalid JSON array: %w", err)
+			}
+			return entries, nil
+		}
+		break
+	}
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		entries = append(entries, append(json.RawMessage{}, line...))
+		if len(entries) > maxBatchItems {
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("invalid NDJSON: %w", err)
+	}
+	return entries, nil
+}
+
+// parseBatchItem validates one entry
+func parseBatchItem(entry json.RawMessage) (batchItem, ethereum.Address, error) {
+	var item batchItem
+	if len(entry) > 0 && entry[0] == '"' {
+		if err := json.Unmarshal(entry, &item.Address); err != nil {
+			return item, ethereum.Address{}, err
+		}
+	} else {
+		decoder := json.NewDecoder(bytes.NewReader(entry))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&item); err != nil {
+			return item, ethereum.Address{}, err
+		}
+	}
+	if item.Action == "" {
+		item.Action = actionSubscribe
+	}
+	if item.Action != actionSubscribe && item.Action != actionUnsubscribe {
+		return item, ethereum.Address{}, fmt.Errorf("invalid action %q, it is subscribe or unsubscribe", item.Action)
+	}
+	if len(item.Label) > maxLabelLength {
+		return item, ethereum.Address{}, fmt.Errorf("label is longer than %d bytes", maxLabelLength)
+	}
+	if item.StartBlock < 0 {
+		return item, ethereum.Address{}, errors.New("startBlock must be positive")
+	}
+	address, err := ethereum.ParseAddress(item.Address)
+	if err != nil {
+		return item, ethereum.Address{}, fmt.Errorf("invalid address: %w", err)
+	}
+	return item, address, nil
+}
+
+// batchV1 applies the subscriptions and unsubscriptions of a batch at once,
+// the invalid entries are reported and the valid ones applied
+func (h *Handler) batchV1(w http.ResponseWriter, r *http.Request) {
+	entries, err := readBatch(http.MaxBytesReader(w, r.Body, maxBatchBytes))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errCodeInvalidArgument, err.Error())
+		return
+	}
+	if len(entries) == 0 {
+		writeError(w, http.StatusBadRequest, errCodeInvalidArgument, "the batch is empty")
+		return
+	}
+	if len(entries) > maxBatchItems {
+		writeError(w, http.StatusBadRequest, errCodeInvalidArgument, fmt.Sprintf("a batch has at most %d entries", maxBatchItems))
+		return
+	}
+
+	results := make([]batchResult, len(entries))
+	var changes []auth.Change
+	// indexes maps the changes to their result
+	var indexes []int
+	seen := make(map[ethereum.Address]bool, len(entries))
+	for i, entry := range entries {
+		item, address, err := parseBatchItem(entry)
+		results[i] = batchResult{Index: i, Address: item.Address, Action: item.Action}
+		if err == nil && seen[address] {
+			err = errors.New("the address is already in the batch")
+		}
+		if err != nil {
+			results[i].Status = batchInvalid
+			results[i].Error = err.Error()
+			continue
+		}
+		seen[address] = true
+		results[i].Address = address.Hex()
+		changes = append(changes, auth.Change{
+			Subscription: parser.Subscription{Address: address, Label: item.Label, StartBlock: item.StartBlock},
+			Remove:       item.Action == actionUnsubscribe,
+		})
+		indexes = append(indexes, i)
+	}
+
+	changed, err := h.applyChanges(r, changes)
+	if errors.Is(err, auth.ErrQuotaExceeded) {
+		h.subscribeFailed(w, r, ethereum.Address{}, err)
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to apply a batch", "changes", len(changes), "error", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to apply the batch")
+		return
+	}
+	for i, index := range indexes {
+		switch {
+		case changes[i].Remove && changed[i]:
+			results[index].Status = batchRemoved
+		case changes[i].Remove:
+			results[index].Status = batchNotSubscribed
+		case changed[i]:
+			results[index].Status = batchCreated
+		default:
+			results[index].Status = batchUpdated
+		}
+	}
+	counts := make(map[string]int)
+	for _, result := range results {
+		counts[result.Status]++
+	}
+	h.setQuotaHeaders(w, r)
+	writeData(w, http.StatusOK, struct {
+		Results []batchResult  `json:"results"`
+		Counts  map[string]int `json:"counts"`
+	}{
+		Results: results,
+		Counts:  counts,
+	})
+}
+
+// applyChanges changes the subscriptions of the tenant of the request in one write of the key store,
+// then updates the parser. Each address is at most once in the changes.
+func (h *Handler) applyChanges(r *http.Request, changes []auth.Change) ([]bool, error) {
+	var subscribe []parser.Subscription
+	var unsubscribe []ethereum.Address
+	for _, change := range changes {
+		if change.Remove {
+			unsubscribe = append(unsubscribe, change.Address)
+		} else {
+			subscribe = append(subscribe, change.Subscription)
+		}
+	}
+
+	if h.keys == nil {
+		if h.quota > 0 {
+			subscribed := make(map[ethereum.Address]bool)
+			for _, address := range h.parser.Addresses() {
+				subscribed[address] = true
+			}
+			count := len(subscribed)
+			for _, change := range changes {
+				switch {
+				case change.Remove && subscribed[change.Address]:
+					count--
+				case !change.Remove && !subscribed[change.Address]:
+					count++
+				}
+			}
+			if count > h.quota && count > len(subscribed) {
+				return nil, auth.ErrQuotaExceeded
+			}
+		}
+		created := h.parser.SubscribeAll(subscribe)
+		removed := h.parser.Unsubscribe(unsubscribe)
+		if err := h.removeWebhooks(r, unsubscribe); err != nil {
+			return nil, err
+		}
+		changed := make([]bool, 0, len(changes))
+		for _, change := range changes {
+			if change.Remove {
+				changed = append(changed, removed[0])
+				removed = removed[1:]
+			} else {
+				changed = append(changed, created[0])
+				created = created[1:]
+			}
+		}
+		return changed, nil
+	}
+
+	changed, err := h.keys.Apply(principalFrom(r).keyID, changes, h.quota)
+	if err != nil {
+		return nil, err
+	}
+	// the labels belong to the tenant, the parser only needs the start blocks
+	for i := range subscribe {
+		subscribe[i].Label = ""
+	}
+	h.parser.SubscribeAll(subscribe)
+	h.parser.Unsubscribe(h.unwatched(unsubscribe))
+	if err := h.removeWebhooks(r, unsubscribe); err != nil {
+		return nil, err
+	}
+	return changed, nil
+}
+
+// unwatched returns the addresses no active key subscribed to, the parser can stop indexing them.
+// The webhooks do not keep an address, they go with the subscription of their key.
+func (h *Handler) unwatched(addresses []ethereum.Address) []ethereum.Address {
+	if len(addresses) == 0 {
+		return nil
+	}
+	watched := make(map[ethereum.Address]bool)
+	for _, address := range h.keys.Addresses() {
+		watched[address] = true
+	}
+	var unwatched []ethereum.Address
+	for _, address := range addresses {
+		if !watched[address] {
+			unwatched = append(unwatched, address)
+		}
+	}
+	return unwatched
+}
@@ -0,0 +1,175 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/meirongdev/ethereum_parser/internal/parser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const thirdAddress = "0xdbF03B407c01E7cD3CBea99509d93f8DDDC8C6FB"
+
+type batchResponse struct {
+	Data struct {
+		Results []batchResult  `json:"results"`
+		Counts  map[string]int `json:"counts"`
+	} `json:"data"`
+}
+
+func decodeBatch(t *testing.T, body string) batchResponse {
+	var response batchResponse
+	require.NoError(t, json.Unmarshal([]byte(body), &response), body)
+	return response
+}
+
+func statuses(results []batchResult) []string {
+	var s []string
+	for _, result := range results {
+		s = append(s, result.Status)
+	}
+	return s
+}
+
+func TestBatchJSONArray(t *testing.T) {
+	env := newTestEnv(t)
+	env.parser.Subscribe(mustAddress(otherAddress))
+
+	rec := env.do("POST", "/v1/addresses/batch", `[
+		"`+strings.ToLower(testAddress)+`",
+		{"address": "`+otherAddress+`", "label": "hot wallet", "startBlock": 10},
+		{"address": "`+thirdAddress+`", "action": "unsubscribe"},
+		{"address": "0x123"},
+		{"address": "`+testAddress+`", "action": "unsubscribe"},
+		{"address": "`+thirdAddress+`", "action": "remove"},
+		{"address": "`+thirdAddress+`", "startBlock": -1},
+		{"address": "`+thirdAddress+`", "colour": "red"}
+	]`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assertDocumented(t, "POST", "/v1/addresses/batch", http.StatusOK)
+	response := decodeBatch(t, rec.Body.String())
+	assert.Equal(t, []string{"created", "updated", "not_subscribed", "invalid", "invalid", "invalid", "invalid", "invalid"}, statuses(response.Data.Results))
+	assert.Equal(t, map[string]int{"created": 1, "updated": 1, "not_subscribed": 1, "invalid": 5}, response.Data.Counts)
+	assert.Equal(t, testAddress, response.Data.Results[0].Address)
+	assert.Contains(t, response.Data.Results[3].Error, "invalid address")
+	assert.Contains(t, response.Data.Results[4].Error, "already in the batch")
+
+	assert.Equal(t, []parser.Subscription{
+		{Address: testAddr},
+		{Address: mustAddress(otherAddress), Label: "hot wallet", StartBlock: 10},
+	}, env.parser.Subscriptions())
+}
+
+func TestBatchNDJSONWithKeys(t *testing.T) {
+	env := newAuthTestEnv(t)
+	alice := env.newKey(t, "alice")
+	bob := env.newKey(t, "bob")
+	assert.Equal(t, http.StatusCreated, env.doAs(bob, "PUT", "/v1/addresses/"+otherAddress+"/subscription", "").Code)
+
+	rec := env.doAs(alice, "POST", "/v1/addresses/batch", `{"address": "`+testAddress+`", "label": "deposit 1"}
+
+{"address": "`+otherAddress+`", "label": "deposit 2", "startBlock": 3}
+`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, []string{"created", "created"}, statuses(decodeBatch(t, rec.Body.String()).Data.Results))
+	// the labels stay with the tenant
+	assert.Equal(t, []parser.Subscription{{Address: testAddr}, {Address: mustAddress(otherAddress), StartBlock: 3}}, env.parser.Subscriptions())
+
+	rec = env.doAs(alice, "GET", "/v1/addresses", "")
+	assert.JSONEq(t, `{"data":{"addresses":["`+testAddress+`","`+otherAddress+`"],"subscriptions":[
+		{"address":"`+testAddress+`","label":"deposit 1"},
+		{"address":"`+otherAddress+`","label":"deposit 2","startBlock":3}]}}`, rec.Body.String())
+
+	rec = env.doAs(alice, "POST", "/v1/addresses/batch", `{"address": "`+testAddress+`", "action": "unsubscribe"}
+{"address": "`+otherAddress+`", "action": "unsubscribe"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, []string{"removed", "removed"}, statuses(decodeBatch(t, rec.Body.String()).Data.Results))
+	// bob still watches the other address
+	assert.False(t, env.parser.isSubscribed(testAddr))
+	assert.True(t, env.parser.isSubscribed(mustAddress(otherAddress)))
+	assert.Empty(t, env.keys.Subscriptions(mustKeyID(t, env.keys, alice)))
+}
+
+func TestStartBlockIsPerTenant(t *testing.T) {
+	env := newAuthTestEnv(t)
+	alice := env.newKey(t, "alice")
+	bob := env.newKey(t, "bob")
+	rec := env.doAs(alice, "POST", "/v1/addresses/batch", `[{"address": "`+testAddress+`", "startBlock": 1000000}]`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	// the parser keeps the earliest start block of the two tenants
+	assert.Equal(t, http.StatusCreated, env.doAs(bob, "PUT", "/v1/addresses/"+testAddress+"/subscription", "").Code)
+
+	tests := []struct {
+		name, token, target string
+		fromBlock           int
+	}{
+		{"transactions", alice, "/v1/addresses/" + testAddress + "/transactions", 1000000},
+		{"earlier fromBlock", alice, "/v1/addresses/" + testAddress + "/transactions?fromBlock=5", 1000000},
+		{"later fromBlock", alice, "/v1/addresses/" + testAddress + "/transactions?fromBlock=2000000", 2000000},
+		{"export", alice, "/v1/addresses/" + testAddress + "/transactions/export", 1000000},
+		{"legacy transactions", alice, "/transactions?address=" + testAddress, 1000000},
+		{"other tenant", bob, "/v1/addresses/" + testAddress + "/transactions?fromBlock=5", 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := env.doAs(tt.token, "GET", tt.target, "")
+			require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+			assert.Equal(t, tt.fromBlock, env.parser.lastQuery().FromBlock)
+		})
+	}
+
+	t.Run("stream replay", func(t *testing.T) {
+		server := httptest.NewServer(env.handler)
+		defer server.Close()
+		req, err := http.NewRequest("GET", server.URL+"/v1/stream?address="+testAddress, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+alice)
+		req.Header.Set("Last-Event-ID", "3:0")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, 1000000, env.parser.lastQuery().FromBlock)
+	})
+}
+
+func TestBatchQuota(t *testing.T) {
+	env := newAuthTestEnv(t, WithSubscriptionQuota(2))
+	tenant := env.newKey(t, "tenant")
+	assert.Equal(t, http.StatusCreated, env.doAs(tenant, "PUT", "/v1/addresses/"+testAddress+"/subscription", "").Code)
+
+	rec := env.doAs(tenant, "POST", "/v1/addresses/batch", `["`+otherAddress+`", "`+thirdAddress+`"]`)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assertErrorCode(t, rec, errCodeQuotaExceeded)
+	assert.False(t, env.parser.isSubscribed(mustAddress(otherAddress)))
+
+	// removing makes room in the same batch
+	rec = env.doAs(tenant, "POST", "/v1/addresses/batch", `[{"address": "`+testAddress+`", "action": "unsubscribe"}, "`+otherAddress+`", "`+thirdAddress+`"]`)
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, "0", rec.Header().Get("X-Quota-Remaining"))
+}
+
+func TestBatchRejectsInvalidBodies(t *testing.T) {
+	env := newTestEnv(t)
+	tests := []struct {
+		name, body, message string
+	}{
+		{"empty body", "", "the body is empty"},
+		{"empty array", " []", "the batch is empty"},
+		{"invalid array", `["` + testAddress + `"`, "invalid JSON array"},
+		{"too many entries", "[" + strings.Repeat(`"`+testAddress+`",`, maxBatchItems) + `"` + testAddress + `"]`, "at most"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := env.do("POST", "/v1/addresses/batch", tt.body)
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			assertErrorCode(t, rec, errCodeInvalidArgument)
+			assert.Contains(t, rec.Body.String(), tt.message)
+		})
+	}
+	assert.Empty(t, env.parser.Subscriptions())
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/meirongdev/ethereum_parser/internal/ethereum"
+	"github.com/meirongdev/ethereum_parser/internal/export"
+	"github.com/meirongdev/ethereum_parser/internal/parser"
+)
+
+func (h *Handler) exportV1(w http.ResponseWriter, r *http.Request) {
+	address, ok := pathAddress(w, r)
+	if !ok {
+		return
+	}
+	startBlock, ok := h.readStart(r, address)
+	if !ok {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "address "+address.Hex()+" is not subscribed")
+		return
+	}
+	h.export(w, r, address, startBlock, true, func(err error) {
+		writeError(w, http.StatusBadRequest, errCodeInvalidArgument, err.Error())
+	})
+}
+
+func (h *Handler) exportLegacy(w http.ResponseWriter, r *http.Request) {
+	address, ok := parseAddressParam(w, r)
+	if !ok {
+		return
+	}
+	// like /transactions, the addresses which are not subscribed export no rows
+	startBlock, readable := h.readStart(r, address)
+	h.export(w, r, address, startBlock, readable, func(err error) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	})
+}
+
+// export streams the transactions of the address matching the query parameters from the start block, page by page.
+// fail answers the errors found before the response starts, later ones can only cut the file short.
+func (h *Handler) export(w http.ResponseWriter, r *http.Request, address ethereum.Address, startBlock int, readable bool, fail func(error)) {
+	query, err := parseTransactionQuery(r.URL.Query())
+	if err != nil {
+		fail(err)
+		return
+	}
+	opts, err := parseExportOptions(r.URL.Query())
+	if err != nil {
+		fail(err)
+		return
+	}
+	writer, err := export.NewWriter(w, opts)
+	if err != nil {
+		fail(err)
+		return
+	}
+	query.Limit = parser.MaxQueryLimit
+	query.FromBlock = max(query.FromBlock, startBlock)
+
+	logger := h.logger.With("address", address.Hex())
+	rc := http.NewResponseController(w)
+	started := false
+	start := func() {
+		started = true
+		// the server write timeout would cut a large export
+		if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+			logger.Warn("Failed to clear the write deadline of the export", "error", err)
+		}
+		w.Header().Set("Content-Type", writer.Format().ContentType())
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", address.Hex()+"."+string(writer.Format())))
+		w.Header().Set("Cache-Control", "no-store")
+	}
+	if !readable {
+		start()
+		if err := writer.Flush(); err != nil {
+			logger.Warn("Failed to write the export", "error", err)
+		}
+		return
+	}
+	for page, err := range parser.Pages(h.parser, address, query) {
+		if err != nil && !started {
+			fail(err)
+			return
+		}
+		if err != nil {
+			logger.Error("Export cut short", "error", err)
+			return
+		}
+		if !started {
+			start()
+		}
+		for _, tx := range page.Transactions {
+			if err := writer.Write(tx); err != nil {
+				logger.Warn("Failed to write the export", "error", err)
+				return
+			}
+		}
+		if err := writer.Flush(); err != nil {
+			logger.Warn("Failed to write the export", "error", err)
+			return
+		}
+		_ = rc.Flush()
+		if r.Context().Err() != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/meirongdev/ethereum_parser/internal/parser"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestV1ExportFollowsThePages(t *testing.T) {
+	env := newTestEnv(t)
+	env.parser.Subscribe(testAddr)
+	env.parser.transactions[testAddr] = []parser.Transaction{testTransaction(1, 0), testTransaction(2, 0), testTransaction(3, 5)}
+	env.parser.pageSize = 2
+
+	rec := env.do("GET", "/v1/addresses/"+testAddress+"/transactions/export?columns=blockNumber,hash&fromBlock=1", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="`+testAddress+`.csv"`, rec.Header().Get("Content-Disposition"))
+	assert.Equal(t, "blockNumber,hash\n1,0x00010000\n2,0x00020000\n3,0x00030005\n", rec.Body.String())
+	assert.True(t, rec.Flushed)
+	assert.Equal(t, []parser.Query{
+		{FromBlock: 1, Limit: parser.MaxQueryLimit},
+		{FromBlock: 1, Limit: parser.MaxQueryLimit, Cursor: "2"},
+	}, env.parser.queries)
+}
+
+func TestV1ExportNDJSON(t *testing.T) {
+	env := newTestEnv(t)
+	env.parser.Subscribe(testAddr)
+	env.parser.transactions[testAddr] = []parser.Transaction{testTransaction(7, 1)}
+
+	rec := env.do("GET", "/v1/addresses/"+testAddress+"/transactions/export?format=ndjson&columns=hash,value&units=gwei", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/x-ndjson", rec.Header().Get("Content-Type"))
+	assert.Equal(t, `{"hash":"0x00070001","value":"0.000000001"}`+"\n", rec.Body.String())
+}
+
+func TestV1ExportErrors(t *testing.T) {
+	env := newAuthTestEnv(t)
+	tenant := env.newKey(t, "tenant")
+
+	rec := env.doAs(tenant, "GET", "/v1/addresses/"+testAddress+"/transactions/export", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assertDocumented(t, "GET", "/v1/addresses/{address}/transactions/export", http.StatusNotFound)
+
+	rec = env.doAs(tenant, "PUT", "/v1/addresses/"+testAddress+"/subscription", "")
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	env.parser.queryErr = parser.ErrInvalidCursor
+	rec = env.doAs(tenant, "GET", "/v1/addresses/"+testAddress+"/transactions/export?cursor=x", "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.JSONEq(t, `{"error":{"code":"invalid_argument","message":"invalid cursor"}}`, rec.Body.String())
+}
+
+func TestLegacyExport(t *testing.T) {
+	env := newTestEnv(t)
+
+	// like /transactions, an address which is not subscribed has no rows
+	rec := env.do("GET", "/transactions/export?address="+testAddress+"&columns=hash", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "hash\n", rec.Body.String())
+
+	rec = env.do("GET", "/transactions/export", "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = env.do("GET", "/transactions/export?address="+testAddress+"&tz=Mars/Olympus", "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "invalid tz")
+}
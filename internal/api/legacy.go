@@ -0,0 +1,168 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/meirongdev/ethereum_parser/internal/ethereum"
+	"github.com/meirongdev/ethereum_parser/internal/notifier"
+	"github.com/meirongdev/ethereum_parser/internal/parser"
+)
+
+// registerLegacy adds the unversioned routes, they are kept for the existing clients
+// and answer failures with a plain text body
+func (h *Handler) registerLegacy() {
+	h.mux.HandleFunc("/currentBlock", h.currentBlock)
+	h.mux.HandleFunc("/subscribe", h.subscribe)
+	h.mux.HandleFunc("/transactions", h.transactions)
+	h.mux.HandleFunc("/transactions/export", h.exportLegacy)
+	if h.hub != nil {
+		h.mux.HandleFunc("/stream", h.stream)
+		h.mux.HandleFunc("/ws", h.websocket)
+	}
+	if h.outbox != nil {
+		h.mux.HandleFunc("/admin/deadletters", h.deadLetters)
+		h.mux.HandleFunc("/admin/deadletters/redeliver", h.redeliverLegacy)
+	}
+}
+
+// parseAddressParam reads and validates the address query parameter,
+// it writes a 400 response and returns false when the address is missing or invalid
+func parseAddressParam(w http.ResponseWriter, r *http.Request) (ethereum.Address, bool) {
+	raw := r.URL.Query().Get("address")
+	if raw == "" {
+		http.Error(w, "Address is required", http.StatusBadRequest)
+		return ethereum.Address{}, false
+	}
+	address, err := ethereum.ParseAddress(raw)
+	if err != nil {
+		http.Error(w, "Invalid address: "+err.Error(), http.StatusBadRequest)
+		return ethereum.Address{}, false
+	}
+	return address, true
+}
+
+func encodeResponse(w http.ResponseWriter, data interface{}) {
+	if err := json.NewEncoder(w).Encode(Response{Data: data}); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// currentBlock returns the latest parsed block number
+func (h *Handler) currentBlock(w http.ResponseWriter, _ *http.Request) {
+	encodeResponse(w, struct {
+		BlockNumber int `json:"blockNumber"`
+	}{
+		BlockNumber: h.parser.GetCurrentBlock(),
+	})
+}
+
+func (h *Handler) subscribe(w http.ResponseWriter, r *http.Request) {
+	address, ok := parseAddressParam(w, r)
+	if !ok {
+		return
+	}
+	callbackURL := r.URL.Query().Get("callbackUrl")
+	if callbackURL != "" {
+		if err := h.checkWebhook(r.Context(), callbackURL); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	created, err := h.subscribeFor(r, address)
+	if err != nil {
+		h.subscribeFailed(w, r, address, err)
+		return
+	}
+	// an optional callbackUrl registers a signed webhook for the address
+	var webhook *notifier.Webhook
+	if callbackURL != "" {
+		registered, err := h.registerWebhook(r, address, callbackURL)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		webhook = &registered
+	}
+	h.setQuotaHeaders(w, r)
+	msg := "Subscribed to address: " + address.Hex()
+	if !created {
+		msg = "Already subscribe to address: " + address.Hex()
+	}
+	encodeResponse(w, struct {
+		Message string            `json:"message"`
+		Webhook *notifier.Webhook `json:"webhook,omitempty"`
+	}{
+		Message: msg,
+		Webhook: webhook,
+	})
+}
+
+func (h *Handler) transactions(w http.ResponseWriter, r *http.Request) {
+	address, ok := parseAddressParam(w, r)
+	if !ok {
+		return
+	}
+	query, err := parseTransactionQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	page := parser.Page{Transactions: []parser.Transaction{}}
+	// like the parser, the addresses which are not subscribed have no transactions
+	if startBlock, ok := h.readStart(r, address); ok {
+		query.FromBlock = max(query.FromBlock, startBlock)
+		if page, err = h.parser.QueryTransactions(address, query); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	encodeResponse(w, struct {
+		Transactions []parser.Transaction `json:"transactions"`
+		NextCursor   string               `json:"nextCursor,omitempty"`
+	}{
+		Transactions: page.Transactions,
+		NextCursor:   page.NextCursor,
+	})
+}
+
+// deadLetters lists the webhook deliveries which ran out of attempts
+func (h *Handler) deadLetters(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	encodeResponse(w, struct {
+		DeadLetters []notifier.Delivery `json:"deadLetters"`
+	}{
+		DeadLetters: h.outbox.DeadLetters(),
+	})
+}
+
+// redeliverLegacy queues a dead letter for delivery again
+func (h *Handler) redeliverLegacy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "Id is required", http.StatusBadRequest)
+		return
+	}
+	delivery, err := h.redeliver(id)
+	if errors.Is(err, notifier.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	encodeResponse(w, struct {
+		Delivery notifier.Delivery `json:"delivery"`
+	}{
+		Delivery: delivery,
+	})
+}
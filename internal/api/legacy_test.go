@@ -0,0 +1,102 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/meirongdev/ethereum_parser/internal/parser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLegacyCurrentBlock(t *testing.T) {
+	env := newTestEnv(t)
+	env.parser.currentBlock = 42
+
+	rec := env.do("GET", "/currentBlock", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"data":{"blockNumber":42}}`, rec.Body.String())
+}
+
+func TestLegacySubscribe(t *testing.T) {
+	env := newTestEnv(t)
+	tests := []struct {
+		name   string
+		target string
+		status int
+		// contains is a part of the expected body
+		contains string
+	}{
+		{"missing address", "/subscribe", http.StatusBadRequest, "Address is required"},
+		{"invalid address", "/subscribe?address=0x123", http.StatusBadRequest, "Invalid address"},
+		{"new", "/subscribe?address=" + testAddress, http.StatusOK, "Subscribed to address: " + testAddress},
+		{"existing", "/subscribe?address=" + testAddress, http.StatusOK, "Already subscribe to address: " + testAddress},
+		{"invalid callback", "/subscribe?address=" + testAddress + "&callbackUrl=example.com", http.StatusBadRequest, "invalid callbackUrl"},
+		{"webhook", "/subscribe?address=" + testAddress + "&callbackUrl=https://example.com/hook", http.StatusOK, `"url":"https://example.com/hook"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := env.do("GET", tt.target, "")
+			assert.Equal(t, tt.status, rec.Code)
+			assert.Contains(t, rec.Body.String(), tt.contains)
+		})
+	}
+	assert.True(t, env.parser.isSubscribed(testAddr))
+	assert.Len(t, env.outbox.Webhooks(), 1)
+}
+
+func TestLegacyTransactions(t *testing.T) {
+	env := newTestEnv(t)
+	env.parser.Subscribe(testAddr)
+	env.parser.transactions[testAddr] = []parser.Transaction{testTransaction(1, 0), testTransaction(2, 3)}
+
+	rec := env.do("GET", "/transactions?address="+testAddress+"&direction=out&limit=2", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var body struct {
+		Data struct {
+			Transactions []parser.Transaction `json:"transactions"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Len(t, body.Data.Transactions, 2)
+	assert.Equal(t, parser.Query{Direction: parser.DirectionOut, Limit: 2}, env.parser.lastQuery())
+
+	rec = env.do("GET", "/transactions", "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = env.do("GET", "/transactions?address="+testAddress+"&order=up", "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "invalid sort order")
+
+	env.parser.queryErr = parser.ErrInvalidCursor
+	rec = env.do("GET", "/transactions?address="+testAddress+"&cursor=x", "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "invalid cursor")
+}
+
+func TestLegacyDeadLetters(t *testing.T) {
+	env := newTestEnv(t)
+	dead := env.addDeadLetter(t)
+
+	rec := env.do("GET", "/admin/deadletters", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), dead.ID)
+
+	tests := []struct {
+		name, method, target string
+		status               int
+	}{
+		{"list with POST", "POST", "/admin/deadletters", http.StatusMethodNotAllowed},
+		{"redeliver with GET", "GET", "/admin/deadletters/redeliver?id=" + dead.ID, http.StatusMethodNotAllowed},
+		{"missing id", "POST", "/admin/deadletters/redeliver", http.StatusBadRequest},
+		{"unknown id", "POST", "/admin/deadletters/redeliver?id=nope", http.StatusNotFound},
+		{"redeliver", "POST", "/admin/deadletters/redeliver?id=" + dead.ID, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := env.do(tt.method, tt.target, "")
+			assert.Equal(t, tt.status, rec.Code, rec.Body.String())
+		})
+	}
+}
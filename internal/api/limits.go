@@ -0,0 +1,104 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/meirongdev/ethereum_parser/internal/auth"
+	"github.com/meirongdev/ethereum_parser/internal/ethereum"
+	"github.com/meirongdev/ethereum_parser/internal/ratelimit"
+)
+
+// WithRateLimits limits the requests of each client IP and of each API key, either limiter can be nil.
+// The key limit only applies with WithAuth.
+func WithRateLimits(perIP, perKey *ratelimit.Limiter) Option {
+	return func(h *Handler) {
+		h.ipLimiter = perIP
+		h.keyLimiter = perKey
+	}
+}
+
+// WithSubscriptionQuota caps the number of addresses each API key can subscribe to,
+// without WithAuth it caps the addresses of the whole server. 0 means no quota.
+func WithSubscriptionQuota(limit int) Option {
+	return func(h *Handler) {
+		h.quota = limit
+	}
+}
+
+// clientIP is the address of the peer, a proxy in front of the server makes every client the same
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// rateLimit rejects the requests of a client which is over its limit with a 429 and a Retry-After,
+// the requests for which clientOf returns "" are not limited
+func rateLimit(limiter *ratelimit.Limiter, clientOf func(r *http.Request) string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := clientOf(r)
+		if client == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		result := limiter.Allow(client)
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		if !result.Allowed {
+			seconds := int(math.Ceil(result.RetryAfter.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+			denied(w, r, http.StatusTooManyRequests, errCodeRateLimited, fmt.Sprintf("rate limit exceeded, retry in %d seconds", seconds))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// setQuotaHeaders tells the client how many more addresses it can subscribe to
+func (h *Handler) setQuotaHeaders(w http.ResponseWriter, r *http.Request) {
+	if h.quota <= 0 {
+		return
+	}
+	remaining := h.quota - len(h.addresses(r))
+	if remaining < 0 {
+		remaining = 0
+	}
+	w.Header().Set("X-Quota-Limit", strconv.Itoa(h.quota))
+	w.Header().Set("X-Quota-Remaining", strconv.Itoa(remaining))
+}
+
+// withinQuota tells if the tenant of the request can subscribe to the address,
+// it is true when the address is already one of its subscriptions
+func (h *Handler) withinQuota(r *http.Request, address ethereum.Address) bool {
+	if h.quota <= 0 {
+		return true
+	}
+	addresses := h.addresses(r)
+	if len(addresses) < h.quota {
+		return true
+	}
+	for _, subscribed := range addresses {
+		if subscribed == address {
+			return true
+		}
+	}
+	return false
+}
+
+// subscribeFailed answers a request whose subscription failed
+func (h *Handler) subscribeFailed(w http.ResponseWriter, r *http.Request, address ethereum.Address, err error) {
+	if errors.Is(err, auth.ErrQuotaExceeded) {
+		h.setQuotaHeaders(w, r)
+		denied(w, r, http.StatusTooManyRequests, errCodeQuotaExceeded, fmt.Sprintf("the quota of %d subscribed addresses is reached", h.quota))
+		return
+	}
+	h.logger.Error("Failed to subscribe", "address", address.Hex(), "error", err)
+	denied(w, r, http.StatusInternalServerError, errCodeInternal, "failed to subscribe")
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/meirongdev/ethereum_parser/internal/ratelimit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func assertErrorCode(t *testing.T, rec *httptest.ResponseRecorder, code string) {
+	var body apiError
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body), rec.Body.String())
+	assert.Equal(t, code, body.Error.Code)
+}
+
+func TestIPRateLimit(t *testing.T) {
+	env := newTestEnv(t, WithRateLimits(ratelimit.New(0.5, 2), nil))
+	from := func(ip, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target, nil)
+		req.RemoteAddr = ip + ":4321"
+		rec := httptest.NewRecorder()
+		env.handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := from("192.0.2.1", "/v1/blocks/current")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "2", rec.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "1", rec.Header().Get("X-RateLimit-Remaining"))
+	assert.Equal(t, http.StatusOK, from("192.0.2.1", "/v1/openapi.json").Code)
+
+	rec = from("192.0.2.1", "/v1/blocks/current")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "0", rec.Header().Get("X-RateLimit-Remaining"))
+	assert.Equal(t, "2", rec.Header().Get("Retry-After"))
+	assertErrorCode(t, rec, errCodeRateLimited)
+	assertDocumented(t, "GET", "/v1/blocks/current", http.StatusTooManyRequests)
+
+	rec = from("192.0.2.1", "/currentBlock")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Contains(t, rec.Body.String(), "rate limit exceeded")
+
+	assert.Equal(t, http.StatusOK, from("192.0.2.2", "/v1/blocks/current").Code)
+}
+
+func TestKeyRateLimit(t *testing.T) {
+	env := newAuthTestEnv(t, WithRateLimits(nil, ratelimit.New(0.5, 1)))
+	tenant := env.newKey(t, "tenant")
+	other := env.newKey(t, "other")
+
+	assert.Equal(t, http.StatusOK, env.doAs(tenant, "GET", "/v1/blocks/current", "").Code)
+	rec := env.doAs(tenant, "GET", "/v1/addresses", "")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assertErrorCode(t, rec, errCodeRateLimited)
+	assertDocumented(t, "GET", "/v1/addresses", http.StatusTooManyRequests)
+
+	assert.Equal(t, http.StatusOK, env.doAs(other, "GET", "/v1/blocks/current", "").Code)
+	// the admin and the public routes have no key to limit
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusOK, env.doAs(testAdminToken, "GET", "/v1/admin/keys", "").Code)
+		assert.Equal(t, http.StatusOK, env.do("GET", "/v1/openapi.json", "").Code)
+	}
+}
+
+func TestSubscriptionQuotaPerKey(t *testing.T) {
+	env := newAuthTestEnv(t, WithSubscriptionQuota(1))
+	tenant := env.newKey(t, "tenant")
+	other := env.newKey(t, "other")
+
+	rec := env.doAs(tenant, "PUT", "/v1/addresses/"+testAddress+"/subscription", "")
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("X-Quota-Limit"))
+	assert.Equal(t, "0", rec.Header().Get("X-Quota-Remaining"))
+	// subscribing again does not count
+	assert.Equal(t, http.StatusOK, env.doAs(tenant, "PUT", "/v1/addresses/"+testAddress+"/subscription", "").Code)
+
+	rec = env.doAs(tenant, "PUT", "/v1/addresses/"+otherAddress+"/subscription", `{"callbackUrl":"https://example.com/hook"}`)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Empty(t, rec.Header().Get("Retry-After"))
+	assertErrorCode(t, rec, errCodeQuotaExceeded)
+	assertDocumented(t, "PUT", "/v1/addresses/{address}/subscription", http.StatusTooManyRequests)
+	assert.False(t, env.parser.isSubscribed(mustAddress(otherAddress)))
+	assert.Empty(t, env.outbox.Webhooks())
+
+	rec = env.doAs(tenant, "GET", "/subscribe?address="+otherAddress, "")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Contains(t, rec.Body.String(), "quota")
+
+	assert.Equal(t, http.StatusCreated, env.doAs(other, "PUT", "/v1/addresses/"+otherAddress+"/subscription", "").Code)
+}
+
+func TestSubscriptionQuotaWithoutAuth(t *testing.T) {
+	env := newTestEnv(t, WithSubscriptionQuota(1))
+
+	assert.Equal(t, http.StatusCreated, env.do("PUT", "/v1/addresses/"+testAddress+"/subscription", "").Code)
+	rec := env.do("GET", "/v1/addresses", "")
+	assert.Equal(t, "0", rec.Header().Get("X-Quota-Remaining"))
+
+	rec = env.do("GET", "/v1/stream?address="+otherAddress, "")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assertErrorCode(t, rec, errCodeQuotaExceeded)
+	assert.False(t, env.parser.isSubscribed(mustAddress(otherAddress)))
+}
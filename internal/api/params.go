@@ -0,0 +1,128 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/meirongdev/ethereum_parser/internal/ethereum"
+	"github.com/meirongdev/ethereum_parser/internal/export"
+	"github.com/meirongdev/ethereum_parser/internal/parser"
+)
+
+// parseAddressesParam reads one or more addresses from repeated or comma separated address parameters
+func parseAddressesParam(values url.Values) ([]ethereum.Address, error) {
+	var addresses []ethereum.Address
+	for _, value := range values["address"] {
+		for _, raw := range strings.Split(value, ",") {
+			if raw = strings.TrimSpace(raw); raw == "" {
+				continue
+			}
+			address, err := ethereum.ParseAddress(raw)
+			if err != nil {
+				return nil, err
+			}
+			addresses = append(addresses, address)
+		}
+	}
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("address is required")
+	}
+	return addresses, nil
+}
+
+// parseTransactionQuery builds a parser.Query from the transactions query parameters
+func parseTransactionQuery(values url.Values) (parser.Query, error) {
+	var query parser.Query
+	var err error
+	if raw := values.Get("fromBlock"); raw != "" {
+		if query.FromBlock, err = strconv.Atoi(raw); err != nil || query.FromBlock < 0 {
+			return query, fmt.Errorf("invalid fromBlock %q", raw)
+		}
+	}
+	if raw := values.Get("toBlock"); raw != "" {
+		if query.ToBlock, err = strconv.Atoi(raw); err != nil || query.ToBlock < 0 {
+			return query, fmt.Errorf("invalid toBlock %q", raw)
+		}
+	}
+	if raw := values.Get("since"); raw != "" {
+		if query.Since, err = parseTimeParam(raw); err != nil {
+			return query, fmt.Errorf("invalid since %q", raw)
+		}
+	}
+	if raw := values.Get("until"); raw != "" {
+		if query.Until, err = parseTimeParam(raw); err != nil {
+			return query, fmt.Errorf("invalid until %q", raw)
+		}
+	}
+	if raw := values.Get("direction"); raw != "" {
+		if query.Direction, err = parser.ParseDirection(raw); err != nil {
+			return query, err
+		}
+	}
+	if raw := values.Get("minValue"); raw != "" {
+		if query.MinValue, err = ethereum.ParseWeiDecimal(raw); err != nil {
+			return query, fmt.Errorf("invalid minValue %q, expected an amount in wei", raw)
+		}
+	}
+	query.Token = values.Get("token")
+	if raw := values.Get("status"); raw != "" {
+		if query.Status, err = parser.ParseStatus(raw); err != nil {
+			return query, err
+		}
+	}
+	if raw := values.Get("order"); raw != "" {
+		if query.Order, err = parser.ParseSortOrder(raw); err != nil {
+			return query, err
+		}
+	}
+	if raw := values.Get("limit"); raw != "" {
+		if query.Limit, err = strconv.Atoi(raw); err != nil || query.Limit <= 0 || query.Limit > parser.MaxQueryLimit {
+			return query, fmt.Errorf("invalid limit %q, expected 1 to %d", raw, parser.MaxQueryLimit)
+		}
+	}
+	query.Cursor = values.Get("cursor")
+	return query, nil
+}
+
+// parseExportOptions reads the format, columns, units, timeFormat and tz parameters of an export
+func parseExportOptions(values url.Values) (export.Options, error) {
+	var opts export.Options
+	var err error
+	if raw := values.Get("format"); raw != "" {
+		if opts.Format, err = export.ParseFormat(raw); err != nil {
+			return opts, err
+		}
+	}
+	if raw := values.Get("columns"); raw != "" {
+		if opts.Columns, err = export.ParseColumns(raw); err != nil {
+			return opts, err
+		}
+	}
+	if raw := values.Get("units"); raw != "" {
+		if opts.Unit, err = export.ParseUnit(raw); err != nil {
+			return opts, err
+		}
+	}
+	if raw := values.Get("timeFormat"); raw != "" {
+		if opts.TimeFormat, err = export.ParseTimeFormat(raw); err != nil {
+			return opts, err
+		}
+	}
+	if raw := values.Get("tz"); raw != "" {
+		if opts.Location, err = time.LoadLocation(raw); err != nil {
+			return opts, fmt.Errorf("invalid tz %q, expected an IANA time zone such as Asia/Singapore", raw)
+		}
+	}
+	return opts, nil
+}
+
+// parseTimeParam accepts an RFC 3339 time or unix seconds
+func parseTimeParam(raw string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		return time.Unix(seconds, 0).UTC(), nil
+	}
+	return time.Parse(time.RFC3339, raw)
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"math/big"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/meirongdev/ethereum_parser/internal/ethereum"
+	"github.com/meirongdev/ethereum_parser/internal/export"
+	"github.com/meirongdev/ethereum_parser/internal/parser"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTransactionQuery(t *testing.T) {
+	values := url.Values{
+		"fromBlock": {"10"},
+		"toBlock":   {"20"},
+		"since":     {"2024-01-02T03:04:05Z"},
+		"until":     {"1704165000"},
+		"direction": {"IN"},
+		"minValue":  {"1000"},
+		"token":     {"eth"},
+		"status":    {"confirmed"},
+		"order":     {"desc"},
+		"limit":     {"5"},
+		"cursor":    {"abc"},
+	}
+	query, err := parseTransactionQuery(values)
+	assert.NoError(t, err)
+	assert.Equal(t, parser.Query{
+		FromBlock: 10,
+		ToBlock:   20,
+		Since:     time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Until:     time.Unix(1704165000, 0).UTC(),
+		Direction: parser.DirectionIn,
+		MinValue:  ethereum.NewWei(big.NewInt(1000)),
+		Token:     "eth",
+		Status:    parser.StatusConfirmed,
+		Order:     parser.SortDesc,
+		Limit:     5,
+		Cursor:    "abc",
+	}, query)
+
+	query, err = parseTransactionQuery(url.Values{})
+	assert.NoError(t, err)
+	assert.Equal(t, parser.Query{}, query)
+}
+
+func TestParseTransactionQueryErrors(t *testing.T) {
+	tests := []struct {
+		param, value string
+	}{
+		{"fromBlock", "x"},
+		{"fromBlock", "-1"},
+		{"toBlock", "-1"},
+		{"since", "yesterday"},
+		{"until", "2024-01-02"},
+		{"direction", "sideways"},
+		{"minValue", "0x10"},
+		{"status", "pending"},
+		{"order", "random"},
+		{"limit", "0"},
+		{"limit", "1001"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.param+"="+tt.value, func(t *testing.T) {
+			_, err := parseTransactionQuery(url.Values{tt.param: {tt.value}})
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestParseAddressesParam(t *testing.T) {
+	other := "0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359"
+	addresses, err := parseAddressesParam(url.Values{"address": {testAddress + ", " + other, testAddress}})
+	assert.NoError(t, err)
+	assert.Equal(t, []ethereum.Address{testAddr, mustAddress(other), testAddr}, addresses)
+
+	_, err = parseAddressesParam(url.Values{"address": {" , "}})
+	assert.EqualError(t, err, "address is required")
+
+	_, err = parseAddressesParam(url.Values{"address": {"0x123"}})
+	assert.ErrorIs(t, err, ethereum.ErrInvalidAddress)
+}
+
+func TestParseExportOptions(t *testing.T) {
+	opts, err := parseExportOptions(url.Values{
+		"format":     {"ndjson"},
+		"columns":    {"hash,value"},
+		"units":      {"eth"},
+		"timeFormat": {"unix"},
+		"tz":         {"Asia/Singapore"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, export.FormatNDJSON, opts.Format)
+	assert.Equal(t, []string{"hash", "value"}, opts.Columns)
+	assert.Equal(t, export.UnitEth, opts.Unit)
+	assert.Equal(t, export.TimeUnix, opts.TimeFormat)
+	assert.Equal(t, "Asia/Singapore", opts.Location.String())
+
+	for param, value := range map[string]string{"format": "parquet", "columns": "nope", "units": "finney", "timeFormat": "date", "tz": "Mars/Olympus"} {
+		_, err := parseExportOptions(url.Values{param: {value}})
+		assert.Error(t, err, param)
+	}
+}
@@ -0,0 +1,282 @@
+package api
+
+import (
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"iter"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/meirongdev/ethereum_parser/internal/ethereum"
+	"github.com/meirongdev/ethereum_parser/internal/notifier"
+	"github.com/meirongdev/ethereum_parser/internal/parser"
+)
+
+var (
+	// heartbeatInterval is how often an idle stream sends a comment to keep proxies from closing it
+	heartbeatInterval = 15 * time.Second
+	// streamBuffer is how many events a stream can fall behind before it is disconnected
+	streamBuffer = 256
+)
+
+// streamPosition is the SSE event id, the block number and transaction index of the last event sent
+type streamPosition struct {
+	BlockNumber      int
+	TransactionIndex int
+}
+
+func (p streamPosition) String() string {
+	return fmt.Sprintf("%d:%d", p.BlockNumber, p.TransactionIndex)
+}
+
+func (p streamPosition) after(other streamPosition) bool {
+	if p.BlockNumber != other.BlockNumber {
+		return p.BlockNumber > other.BlockNumber
+	}
+	return p.TransactionIndex > other.TransactionIndex
+}
+
+func parseStreamPosition(id string) (streamPosition, error) {
+	var pos streamPosition
+	if _, err := fmt.Sscanf(id, "%d:%d", &pos.BlockNumber, &pos.TransactionIndex); err != nil || pos.String() != id {
+		return pos, fmt.Errorf("invalid Last-Event-ID %q, expected <block>:<transactionIndex>", id)
+	}
+	return pos, nil
+}
+
+func positionOf(tx parser.Transaction) streamPosition {
+	return streamPosition{BlockNumber: tx.BlockNumber, TransactionIndex: tx.TransactionIndex}
+}
+
+// replayTransactions iterates over the stored transactions of the addresses after the position, oldest first,
+// the transactions before the start block of the subscription of the tenant are left out.
+// The addresses are read page by page and merged by position, so a replay holds a page per address in memory.
+// A transaction of several addresses comes once per address, in the order of the addresses.
+func replayTransactions(eParser Parser, addresses []ethereum.Address, startBlocks map[ethereum.Address]int, from streamPosition) iter.Seq2[notifier.Event, error] {
+	return func(yield func(notifier.Event, error) bool) {
+		sources := make(replaySources, 0, len(addresses))
+		for i, address := range addresses {
+			query := parser.Query{FromBlock: max(from.BlockNumber, startBlocks[address]), Limit: parser.MaxQueryLimit}
+			next, stop := iter.Pull2(transactionsOf(eParser, address, query))
+			defer stop()
+			source := &replaySource{address: address, order: i, next: next}
+			ok, err := source.advance(from)
+			if err != nil {
+				yield(notifier.Event{}, err)
+				return
+			}
+			if ok {
+				sources = append(sources, source)
+			}
+		}
+		heap.Init(&sources)
+		for len(sources) > 0 {
+			source := sources[0]
+			if !yield(notifier.Event{Type: notifier.EventTransaction, Address: source.address, Transaction: source.head}, nil) {
+				return
+			}
+			ok, err := source.advance(from)
+			if err != nil {
+				yield(notifier.Event{}, err)
+				return
+			}
+			if ok {
+				heap.Fix(&sources, 0)
+			} else {
+				heap.Pop(&sources)
+			}
+		}
+	}
+}
+
+// transactionsOf iterates over the transactions of the pages of the address
+func transactionsOf(eParser Parser, address ethereum.Address, q parser.Query) iter.Seq2[parser.Transaction, error] {
+	return func(yield func(parser.Transaction, error) bool) {
+		for page, err := range parser.Pages(eParser, address, q) {
+			if err != nil {
+				yield(parser.Transaction{}, err)
+				return
+			}
+			for _, tx := range page.Transactions {
+				if !yield(tx, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// replaySource is the next transaction to replay of an address
+type replaySource struct {
+	address ethereum.Address
+	// order is the place of the address in the request, it breaks the ties between addresses
+	order int
+	next  func() (parser.Transaction, error, bool)
+	head  parser.Transaction
+}
+
+// advance moves to the next transaction after the position, it returns false when the address has no more
+func (s *replaySource) advance(from streamPosition) (bool, error) {
+	for {
+		tx, err, ok := s.next()
+		if !ok || err != nil {
+			return false, err
+		}
+		if positionOf(tx).after(from) {
+			s.head = tx
+			return true, nil
+		}
+	}
+}
+
+// replaySources is a min-heap of the addresses by the position of their next transaction
+type replaySources []*replaySource
+
+func (h replaySources) Len() int { return len(h) }
+
+func (h replaySources) Less(i, j int) bool {
+	a, b := positionOf(h[i].head), positionOf(h[j].head)
+	if a != b {
+		return b.after(a)
+	}
+	return h[i].order < h[j].order
+}
+
+func (h replaySources) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *replaySources) Push(x any) { *h = append(*h, x.(*replaySource)) }
+
+func (h *replaySources) Pop() any {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
+
+// hasStreamPosition tells if the event moves the stream position, only the recorded transactions do.
+// The other events are sent without an id and are not replayed: a pending transaction has no position yet,
+// and a confirmation or a removal is about a transaction which was already sent.
+func hasStreamPosition(event notifier.Event) bool {
+	return event.Type == notifier.EventTransaction
+}
+
+func writeStreamEvent(w http.ResponseWriter, event notifier.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if !hasStreamPosition(event) {
+		_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", positionOf(event.Transaction), event.Type, data)
+	return err
+}
+
+// stream serves /stream, a Server-Sent Events stream of the transactions of the given addresses.
+// The addresses are subscribed if they are not yet, a Last-Event-ID header (or lastEventId parameter)
+// replays the stored transactions after that position before the live ones.
+func (h *Handler) stream(w http.ResponseWriter, r *http.Request) {
+	addresses, err := parseAddressesParam(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errCodeInvalidArgument, "invalid address: "+err.Error())
+		return
+	}
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("lastEventId")
+	}
+	var resumeFrom *streamPosition
+	if lastEventID != "" {
+		pos, err := parseStreamPosition(lastEventID)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errCodeInvalidArgument, err.Error())
+			return
+		}
+		resumeFrom = &pos
+	}
+
+	startBlocks := make(map[ethereum.Address]int)
+	for _, address := range addresses {
+		if _, err := h.subscribeFor(r, address); err != nil {
+			h.subscribeFailed(w, r, address, err)
+			return
+		}
+		startBlocks[address], _ = h.readStart(r, address)
+	}
+	// listen before replaying so nothing recorded in between is missed
+	listener := h.hub.Listen(addresses, streamBuffer)
+	defer listener.Close()
+
+	// the server write timeout would cut the stream
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		h.logger.Warn("Failed to clear the write deadline of the stream", "error", err)
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	// the last position sent per address, live events at or before it were already replayed
+	sent := make(map[ethereum.Address]streamPosition)
+	if resumeFrom != nil {
+		for event, err := range replayTransactions(h.parser, addresses, startBlocks, *resumeFrom) {
+			if err != nil {
+				h.logger.Error("Failed to replay the stream", "from", resumeFrom.String(), "error", err)
+				return
+			}
+			if err := writeStreamEvent(w, event); err != nil {
+				return
+			}
+			sent[event.Address] = positionOf(event.Transaction)
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case event, ok := <-listener.Events():
+			if !ok {
+				if listener.Lagging() {
+					// the client reconnects with its Last-Event-ID and catches up from the store
+					fmt.Fprint(w, "event: lagging\ndata: {}\n\n")
+				}
+				_ = rc.Flush()
+				return
+			}
+			if !hasStreamPosition(event) {
+				if err := writeStreamEvent(w, event); err != nil {
+					return
+				}
+				if last, ok := sent[event.Address]; ok && event.Type == notifier.EventRemoved && !positionOf(event.Transaction).after(last) {
+					// the block is processed again, its new transactions come at positions already sent
+					sent[event.Address] = streamPosition{BlockNumber: event.Transaction.BlockNumber - 1, TransactionIndex: math.MaxInt}
+				}
+				break
+			}
+			if last, ok := sent[event.Address]; ok && !positionOf(event.Transaction).after(last) {
+				continue
+			}
+			if err := writeStreamEvent(w, event); err != nil {
+				return
+			}
+			sent[event.Address] = positionOf(event.Transaction)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/meirongdev/ethereum_parser/internal/ethereum"
+	"github.com/meirongdev/ethereum_parser/internal/notifier"
+	"github.com/meirongdev/ethereum_parser/internal/parser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readStreamEvent reads the next event of a stream and returns its id, type and data
+func readStreamEvent(t *testing.T, reader *bufio.Reader) (string, string, notifier.Event) {
+	var id, eventType string
+	var event notifier.Event
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && eventType != "":
+			return id, eventType, event
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			eventType = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event))
+		}
+	}
+}
+
+func TestStreamReplaysThenSendsLiveEvents(t *testing.T) {
+	env := newTestEnv(t)
+	env.parser.Subscribe(testAddr)
+	env.parser.transactions[testAddr] = []parser.Transaction{testTransaction(1, 0), testTransaction(2, 1)}
+	server := httptest.NewServer(env.handler)
+	defer server.Close()
+
+	req, err := http.NewRequest("GET", server.URL+"/v1/stream?address="+testAddress, nil)
+	require.NoError(t, err)
+	req.Header.Set("Last-Event-ID", "1:0")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	reader := bufio.NewReader(resp.Body)
+
+	id, eventType, event := readStreamEvent(t, reader)
+	assert.Equal(t, "2:1", id)
+	assert.Equal(t, "transaction", eventType)
+	assert.Equal(t, testTransaction(2, 1).Hash, event.Transaction.Hash)
+
+	// the replayed transaction is not sent twice
+	ctx := context.Background()
+	assert.NoError(t, env.hub.Send(ctx, notifier.Event{Type: notifier.EventTransaction, Address: testAddr, Transaction: testTransaction(2, 1)}))
+	assert.NoError(t, env.hub.Send(ctx, notifier.Event{Type: notifier.EventTransaction, Address: testAddr, Transaction: testTransaction(3, 0)}))
+	id, _, event = readStreamEvent(t, reader)
+	assert.Equal(t, "3:0", id)
+	assert.Equal(t, testTransaction(3, 0).Hash, event.Transaction.Hash)
+
+	// a pending transaction has no position, it is sent without an id
+	pending := parser.Transaction{Hash: "0xaa", From: testAddr, Direction: parser.DirectionOut, Status: parser.StatusPending}
+	assert.NoError(t, env.hub.Send(ctx, notifier.Event{Type: notifier.EventPending, Address: testAddr, Transaction: pending}))
+	id, eventType, event = readStreamEvent(t, reader)
+	assert.Equal(t, "", id)
+	assert.Equal(t, "pending", eventType)
+	assert.Equal(t, "0xaa", event.Transaction.Hash)
+}
+
+func TestStreamSendsConfirmationsAndRemovals(t *testing.T) {
+	env := newTestEnv(t)
+	server := httptest.NewServer(env.handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/v1/stream?address=" + testAddress)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	reader := bufio.NewReader(resp.Body)
+
+	ctx := context.Background()
+	send := func(eventType notifier.EventType, tx parser.Transaction) {
+		assert.NoError(t, env.hub.Send(ctx, notifier.Event{Type: eventType, Address: testAddr, Transaction: tx}))
+	}
+	dropped, kept := testTransaction(5, 0), testTransaction(4, 2)
+	send(notifier.EventTransaction, kept)
+	send(notifier.EventTransaction, dropped)
+	send(notifier.EventConfirmation, kept)
+	send(notifier.EventRemoved, dropped)
+	// the block is processed again with another transaction at the same position
+	replacement := testTransaction(5, 0)
+	replacement.Hash = "0xbeef"
+	send(notifier.EventTransaction, replacement)
+
+	expected := []struct{ id, eventType, hash string }{
+		{"4:2", "transaction", kept.Hash},
+		{"5:0", "transaction", dropped.Hash},
+		{"", "confirmation", kept.Hash},
+		{"", "removed", dropped.Hash},
+		{"5:0", "transaction", "0xbeef"},
+	}
+	for _, want := range expected {
+		id, eventType, event := readStreamEvent(t, reader)
+		assert.Equal(t, want.id, id)
+		assert.Equal(t, want.eventType, eventType)
+		assert.Equal(t, want.hash, event.Transaction.Hash)
+	}
+}
+
+func TestStreamErrors(t *testing.T) {
+	env := newTestEnv(t)
+	tests := []struct {
+		name, target, lastEventID, message string
+	}{
+		{"missing address", "/stream", "", "invalid address: address is required"},
+		{"invalid address", "/v1/stream?address=0x123", "", "invalid address"},
+		{"invalid last event id", "/v1/stream?address=" + testAddress, "yesterday", "invalid Last-Event-ID"},
+		{"invalid lastEventId parameter", "/v1/stream?address=" + testAddress + "&lastEventId=1", "", "invalid Last-Event-ID"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.target, nil)
+			if tt.lastEventID != "" {
+				req.Header.Set("Last-Event-ID", tt.lastEventID)
+			}
+			rec := httptest.NewRecorder()
+			env.handler.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			var body apiError
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+			assert.Equal(t, errCodeInvalidArgument, body.Error.Code)
+			assert.Contains(t, body.Error.Message, tt.message)
+		})
+	}
+}
+
+func TestReplayMergesAddressesByPosition(t *testing.T) {
+	p := newFakeParser()
+	p.pageSize = 2
+	other, third := mustAddress(otherAddress), mustAddress(thirdAddress)
+	for _, address := range []ethereum.Address{testAddr, other, third} {
+		p.Subscribe(address)
+	}
+	p.transactions[testAddr] = []parser.Transaction{testTransaction(1, 0), testTransaction(2, 0), testTransaction(4, 1), testTransaction(6, 0), testTransaction(7, 0)}
+	p.transactions[other] = []parser.Transaction{testTransaction(3, 0), testTransaction(4, 1), testTransaction(4, 2), testTransaction(5, 0)}
+	p.transactions[third] = []parser.Transaction{}
+	addresses := []ethereum.Address{testAddr, other, third}
+
+	type replayed struct {
+		position string
+		address  ethereum.Address
+	}
+	var events []replayed
+	for event, err := range replayTransactions(p, addresses, nil, streamPosition{BlockNumber: 1, TransactionIndex: 0}) {
+		require.NoError(t, err)
+		events = append(events, replayed{positionOf(event.Transaction).String(), event.Address})
+	}
+	// a transaction of both addresses comes once per address, in the order of the addresses
+	assert.Equal(t, []replayed{
+		{"2:0", testAddr}, {"3:0", other}, {"4:1", testAddr}, {"4:1", other}, {"4:2", other},
+		{"5:0", other}, {"6:0", testAddr}, {"7:0", testAddr},
+	}, events)
+
+	// the pages are read as the replay goes
+	p.queries = nil
+	for range replayTransactions(p, addresses, nil, streamPosition{}) {
+		break
+	}
+	assert.Len(t, p.queries, len(addresses))
+
+	p.queryErr = errors.New("store unavailable")
+	for _, err := range replayTransactions(p, addresses, nil, streamPosition{}) {
+		assert.ErrorIs(t, err, p.queryErr)
+	}
+}
@@ -0,0 +1,284 @@
+package api
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/meirongdev/ethereum_parser/internal/ethereum"
+	"github.com/meirongdev/ethereum_parser/internal/notifier"
+	"github.com/meirongdev/ethereum_parser/internal/parser"
+)
+
+// openAPIDocument describes the /v1 routes, the tests check it against the routes of a handler with every option
+//
+//go:embed openapi.json
+var openAPIDocument []byte
+
+// probedMethods are the methods tried to fill the Allow header of a 405 response
+var probedMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+// route is one /v1 endpoint, the pattern uses the net/http wildcards like {address}
+type route struct {
+	Method  string
+	Pattern string
+	Handler http.HandlerFunc
+}
+
+// pathAddress reads the {address} path parameter, it writes a 400 response and returns false when it is invalid
+func pathAddress(w http.ResponseWriter, r *http.Request) (ethereum.Address, bool) {
+	address, err := ethereum.ParseAddress(r.PathValue("address"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errCodeInvalidArgument, "invalid address: "+err.Error())
+		return ethereum.Address{}, false
+	}
+	return address, true
+}
+
+// subscriptionRequest is the optional body of PUT /v1/addresses/{address}/subscription
+type subscriptionRequest struct {
+	CallbackURL string `json:"callbackUrl"`
+}
+
+// v1Routes lists the /v1 endpoints enabled by the options of the handler
+func (h *Handler) v1Routes() []route {
+	routes := []route{
+		{http.MethodGet, "/v1/openapi.json", h.openAPI},
+		{http.MethodGet, "/v1/blocks/current", h.currentBlockV1},
+		{http.MethodGet, "/v1/addresses", h.addressesV1},
+		{http.MethodPost, "/v1/addresses/batch", h.batchV1},
+		{http.MethodPut, "/v1/addresses/{address}/subscription", h.subscribeV1},
+		{http.MethodGet, "/v1/addresses/{address}/transactions", h.transactionsV1},
+		{http.MethodGet, "/v1/addresses/{address}/transactions/export", h.exportV1},
+	}
+	if h.hub != nil {
+		routes = append(routes,
+			route{http.MethodGet, "/v1/stream", h.stream},
+			route{http.MethodGet, "/v1/ws", h.websocket},
+		)
+	}
+	if h.balances != nil {
+		routes = append(routes, route{http.MethodGet, "/v1/addresses/{address}/balances", h.balancesV1})
+	}
+	if h.outbox != nil {
+		routes = append(routes,
+			route{http.MethodGet, "/v1/webhooks", h.webhooksV1},
+			route{http.MethodDelete, "/v1/webhooks/{id}", h.unregisterWebhookV1},
+			route{http.MethodGet, "/v1/admin/deadletters", h.deadLettersV1},
+			route{http.MethodPost, "/v1/admin/deadletters/{id}/redeliver", h.redeliverV1},
+		)
+	}
+	if h.keys != nil {
+		routes = append(routes,
+			route{http.MethodGet, "/v1/admin/keys", h.listKeys},
+			route{http.MethodPost, "/v1/admin/keys", h.createKey},
+			route{http.MethodDelete, "/v1/admin/keys/{id}", h.revokeKey},
+		)
+	}
+	return routes
+}
+
+// registerV1 adds the routes to the mux with a /v1/ fallback,
+// so unknown paths and wrong methods also get the error envelope instead of a plain text body
+func registerV1(mux *http.ServeMux, routes []route) {
+	for _, rt := range routes {
+		mux.HandleFunc(rt.Method+" "+rt.Pattern, rt.Handler)
+	}
+	mux.HandleFunc("/v1/", func(w http.ResponseWriter, r *http.Request) {
+		var allowed []string
+		for _, method := range probedMethods {
+			probe := r.Clone(r.Context())
+			probe.Method = method
+			if _, pattern := mux.Handler(probe); pattern != "/v1/" && pattern != "" {
+				allowed = append(allowed, method)
+			}
+		}
+		if len(allowed) == 0 {
+			writeError(w, http.StatusNotFound, errCodeNotFound, "no route for "+r.URL.Path)
+			return
+		}
+		for _, method := range allowed {
+			w.Header().Add("Allow", method)
+		}
+		writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method "+r.Method+" is not allowed on "+r.URL.Path)
+	})
+}
+
+func (h *Handler) openAPI(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(openAPIDocument)
+}
+
+func (h *Handler) currentBlockV1(w http.ResponseWriter, _ *http.Request) {
+	writeData(w, http.StatusOK, struct {
+		BlockNumber int `json:"blockNumber"`
+	}{
+		BlockNumber: h.parser.GetCurrentBlock(),
+	})
+}
+
+func (h *Handler) subscribeV1(w http.ResponseWriter, r *http.Request) {
+	address, ok := pathAddress(w, r)
+	if !ok {
+		return
+	}
+	var req subscriptionRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, errCodeInvalidArgument, "invalid body: "+err.Error())
+		return
+	}
+	if req.CallbackURL != "" {
+		if err := h.checkWebhook(r.Context(), req.CallbackURL); err != nil {
+			writeError(w, http.StatusBadRequest, errCodeInvalidArgument, err.Error())
+			return
+		}
+	}
+	created, err := h.subscribeFor(r, address)
+	if err != nil {
+		h.subscribeFailed(w, r, address, err)
+		return
+	}
+	// a callbackUrl registers a signed webhook for the address
+	var webhook *notifier.Webhook
+	if req.CallbackURL != "" {
+		registered, err := h.registerWebhook(r, address, req.CallbackURL)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, errCodeInternal, err.Error())
+			return
+		}
+		webhook = &registered
+	}
+	h.setQuotaHeaders(w, r)
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	writeData(w, status, struct {
+		Address ethereum.Address  `json:"address"`
+		Webhook *notifier.Webhook `json:"webhook,omitempty"`
+	}{
+		Address: address,
+		Webhook: webhook,
+	})
+}
+
+func (h *Handler) addressesV1(w http.ResponseWriter, r *http.Request) {
+	h.setQuotaHeaders(w, r)
+	writeData(w, http.StatusOK, struct {
+		Addresses     []ethereum.Address    `json:"addresses"`
+		Subscriptions []parser.Subscription `json:"subscriptions"`
+	}{
+		Addresses:     h.addresses(r),
+		Subscriptions: h.subscriptions(r),
+	})
+}
+
+func (h *Handler) transactionsV1(w http.ResponseWriter, r *http.Request) {
+	address, ok := pathAddress(w, r)
+	if !ok {
+		return
+	}
+	startBlock, ok := h.readStart(r, address)
+	if !ok {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "address "+address.Hex()+" is not subscribed")
+		return
+	}
+	query, err := parseTransactionQuery(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errCodeInvalidArgument, err.Error())
+		return
+	}
+	query.FromBlock = max(query.FromBlock, startBlock)
+	page, err := h.parser.QueryTransactions(address, query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errCodeInvalidArgument, err.Error())
+		return
+	}
+	writeData(w, http.StatusOK, struct {
+		Transactions []parser.Transaction `json:"transactions"`
+		NextCursor   string               `json:"nextCursor,omitempty"`
+	}{
+		Transactions: page.Transactions,
+		NextCursor:   page.NextCursor,
+	})
+}
+
+func (h *Handler) balancesV1(w http.ResponseWriter, r *http.Request) {
+	address, ok := pathAddress(w, r)
+	if !ok {
+		return
+	}
+	startBlock, ok := h.readStart(r, address)
+	if !ok {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "address "+address.Hex()+" is not subscribed")
+		return
+	}
+	balances := []parser.Balance{}
+	for _, balance := range h.balances.BalanceHistory(address) {
+		if balance.BlockNumber >= startBlock {
+			balances = append(balances, balance)
+		}
+	}
+	writeData(w, http.StatusOK, struct {
+		Balances []parser.Balance `json:"balances"`
+	}{
+		Balances: balances,
+	})
+}
+
+// webhooksV1 lists the webhooks of the tenant with their secrets
+func (h *Handler) webhooksV1(w http.ResponseWriter, r *http.Request) {
+	writeData(w, http.StatusOK, struct {
+		Webhooks []notifier.Webhook `json:"webhooks"`
+	}{
+		Webhooks: h.outbox.WebhooksOf(principalFrom(r).keyID),
+	})
+}
+
+// unregisterWebhookV1 removes a webhook of the tenant, the webhooks of the other tenants are not found
+func (h *Handler) unregisterWebhookV1(w http.ResponseWriter, r *http.Request) {
+	webhook, err := h.outbox.UnregisterWebhook(principalFrom(r).keyID, r.PathValue("id"))
+	if errors.Is(err, notifier.ErrNotFound) {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "webhook "+r.PathValue("id")+" not found")
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to unregister webhook", "webhook", r.PathValue("id"), "error", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to unregister webhook")
+		return
+	}
+	writeData(w, http.StatusOK, struct {
+		Webhook notifier.Webhook `json:"webhook"`
+	}{
+		Webhook: webhook,
+	})
+}
+
+func (h *Handler) deadLettersV1(w http.ResponseWriter, _ *http.Request) {
+	writeData(w, http.StatusOK, struct {
+		DeadLetters []notifier.Delivery `json:"deadLetters"`
+	}{
+		DeadLetters: h.outbox.DeadLetters(),
+	})
+}
+
+func (h *Handler) redeliverV1(w http.ResponseWriter, r *http.Request) {
+	delivery, err := h.redeliver(r.PathValue("id"))
+	if errors.Is(err, notifier.ErrNotFound) {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "dead letter "+r.PathValue("id")+" not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	writeData(w, http.StatusOK, struct {
+		Delivery notifier.Delivery `json:"delivery"`
+	}{
+		Delivery: delivery,
+	})
+}
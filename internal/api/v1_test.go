@@ -0,0 +1,216 @@
+package api
+
+import (
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/meirongdev/ethereum_parser/internal/ethereum"
+	"github.com/meirongdev/ethereum_parser/internal/notifier"
+	"github.com/meirongdev/ethereum_parser/internal/parser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// openAPIOperation is the part of an OpenAPI operation the tests check
+type openAPIOperation struct {
+	Parameters []struct {
+		Ref  string `json:"$ref"`
+		Name string `json:"name"`
+		In   string `json:"in"`
+	} `json:"parameters"`
+	Responses map[string]json.RawMessage `json:"responses"`
+}
+
+type openAPI struct {
+	Paths      map[string]map[string]openAPIOperation `json:"paths"`
+	Components struct {
+		Parameters map[string]struct {
+			Name string `json:"name"`
+			In   string `json:"in"`
+		} `json:"parameters"`
+	} `json:"components"`
+}
+
+func loadOpenAPI(t *testing.T) openAPI {
+	var doc openAPI
+	require.NoError(t, json.Unmarshal(openAPIDocument, &doc))
+	return doc
+}
+
+// pathParameters returns the sorted names of the path parameters of an operation
+func (doc openAPI) pathParameters(op openAPIOperation) []string {
+	names := []string{}
+	for _, param := range op.Parameters {
+		if param.Ref != "" {
+			ref := doc.Components.Parameters[strings.TrimPrefix(param.Ref, "#/components/parameters/")]
+			param.Name, param.In = ref.Name, ref.In
+		}
+		if param.In == "path" {
+			names = append(names, param.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+var wildcard = regexp.MustCompile(`\{([a-zA-Z]+)\}`)
+
+// assertDocumented checks the OpenAPI document lists the status among the responses of the operation
+func assertDocumented(t *testing.T, method, pattern string, status int) {
+	op, ok := loadOpenAPI(t).Paths[pattern][strings.ToLower(method)]
+	if assert.True(t, ok, "%s %s is not documented", method, pattern) {
+		assert.Contains(t, op.Responses, strconv.Itoa(status), "status %d of %s %s is not documented", status, method, pattern)
+	}
+}
+
+func TestOpenAPIDocumentsEveryRoute(t *testing.T) {
+	doc := loadOpenAPI(t)
+	routes := newAuthTestEnv(t).handler.v1Routes()
+
+	documented := map[string]bool{}
+	for path, operations := range doc.Paths {
+		for method := range operations {
+			documented[strings.ToUpper(method)+" "+path] = true
+		}
+	}
+	for _, rt := range routes {
+		key := rt.Method + " " + rt.Pattern
+		op, ok := doc.Paths[rt.Pattern][strings.ToLower(rt.Method)]
+		if !assert.True(t, ok, "%s is not documented", key) {
+			continue
+		}
+		delete(documented, key)
+
+		wildcards := []string{}
+		for _, match := range wildcard.FindAllStringSubmatch(rt.Pattern, -1) {
+			wildcards = append(wildcards, match[1])
+		}
+		sort.Strings(wildcards)
+		assert.Equal(t, wildcards, doc.pathParameters(op), "path parameters of %s", key)
+	}
+	assert.Empty(t, documented, "documented operations without a handler")
+}
+
+func TestV1Responses(t *testing.T) {
+	env := newTestEnv(t)
+	dead := env.addDeadLetter(t)
+
+	tests := []struct {
+		name string
+		// method and path of the request, pattern is the documented route or "" when there is none
+		method, path, body, pattern string
+		status                      int
+		errorCode                   string
+	}{
+		{"current block", "GET", "/v1/blocks/current", "", "/v1/blocks/current", 200, ""},
+		{"openapi", "GET", "/v1/openapi.json", "", "/v1/openapi.json", 200, ""},
+		{"new subscription", "PUT", "/v1/addresses/" + testAddress + "/subscription", "", "/v1/addresses/{address}/subscription", 201, ""},
+		{"existing subscription", "PUT", "/v1/addresses/" + testAddress + "/subscription", "", "/v1/addresses/{address}/subscription", 200, ""},
+		{"subscription with webhook", "PUT", "/v1/addresses/" + testAddress + "/subscription", `{"callbackUrl":"https://example.com/hook"}`, "/v1/addresses/{address}/subscription", 200, ""},
+		{"invalid callback", "PUT", "/v1/addresses/" + testAddress + "/subscription", `{"callbackUrl":"ftp://example.com"}`, "/v1/addresses/{address}/subscription", 400, errCodeInvalidArgument},
+		{"unknown body field", "PUT", "/v1/addresses/" + testAddress + "/subscription", `{"callback":"https://example.com"}`, "/v1/addresses/{address}/subscription", 400, errCodeInvalidArgument},
+		{"invalid address", "PUT", "/v1/addresses/0x123/subscription", "", "/v1/addresses/{address}/subscription", 400, errCodeInvalidArgument},
+		{"transactions", "GET", "/v1/addresses/" + testAddress + "/transactions?direction=in", "", "/v1/addresses/{address}/transactions", 200, ""},
+		{"invalid query", "GET", "/v1/addresses/" + testAddress + "/transactions?limit=0", "", "/v1/addresses/{address}/transactions", 400, errCodeInvalidArgument},
+		{"invalid export", "GET", "/v1/addresses/" + testAddress + "/transactions/export?format=parquet", "", "/v1/addresses/{address}/transactions/export", 400, errCodeInvalidArgument},
+		{"balances", "GET", "/v1/addresses/" + testAddress + "/balances", "", "/v1/addresses/{address}/balances", 200, ""},
+		{"stream without address", "GET", "/v1/stream", "", "/v1/stream", 400, errCodeInvalidArgument},
+		{"dead letters", "GET", "/v1/admin/deadletters", "", "/v1/admin/deadletters", 200, ""},
+		{"redeliver", "POST", "/v1/admin/deadletters/" + dead.ID + "/redeliver", "", "/v1/admin/deadletters/{id}/redeliver", 200, ""},
+		{"unknown dead letter", "POST", "/v1/admin/deadletters/nope/redeliver", "", "/v1/admin/deadletters/{id}/redeliver", 404, errCodeNotFound},
+		{"unknown path", "GET", "/v1/nope", "", "", 404, errCodeNotFound},
+		{"wrong method", "DELETE", "/v1/blocks/current", "", "", 405, errCodeMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := env.do(tt.method, tt.path, tt.body)
+
+			assert.Equal(t, tt.status, rec.Code, rec.Body.String())
+			assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+			if tt.pattern != "" {
+				assertDocumented(t, tt.method, tt.pattern, tt.status)
+			}
+			if tt.errorCode == "" {
+				return
+			}
+			var body apiError
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+			assert.Equal(t, tt.errorCode, body.Error.Code)
+			assert.NotEmpty(t, body.Error.Message)
+		})
+	}
+}
+
+func TestV1MethodNotAllowedListsAllowedMethods(t *testing.T) {
+	rec := newTestEnv(t).do("POST", "/v1/addresses/"+testAddress+"/subscription", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	assert.Equal(t, []string{"PUT"}, rec.Header().Values("Allow"))
+}
+
+func TestV1Transactions(t *testing.T) {
+	env := newTestEnv(t)
+	env.parser.Subscribe(testAddr)
+	env.parser.transactions[testAddr] = []parser.Transaction{testTransaction(7, 1)}
+
+	rec := env.do("GET", "/v1/addresses/"+testAddress+"/transactions?fromBlock=5&status=mined", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var body struct {
+		Data struct {
+			Transactions []parser.Transaction `json:"transactions"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, env.parser.transactions[testAddr][0].Hash, body.Data.Transactions[0].Hash)
+	assert.Equal(t, parser.Query{FromBlock: 5, Status: parser.StatusMined}, env.parser.lastQuery())
+
+	env.parser.queryErr = parser.ErrInvalidCursor
+	rec = env.do("GET", "/v1/addresses/"+testAddress+"/transactions?cursor=x", "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.JSONEq(t, `{"error":{"code":"invalid_argument","message":"invalid cursor"}}`, rec.Body.String())
+}
+
+func TestV1Balances(t *testing.T) {
+	env := newTestEnv(t)
+	env.parser.Subscribe(testAddr)
+	env.parser.balances[testAddr] = []parser.Balance{
+		{BlockNumber: 6, Balance: ethereum.NewWei(big.NewInt(10))},
+		{BlockNumber: 7, Balance: ethereum.NewWei(big.NewInt(14)), Change: ethereum.NewWei(big.NewInt(4)), Unexplained: ethereum.NewWei(big.NewInt(5)), Mismatch: true},
+	}
+
+	rec := env.do("GET", "/v1/addresses/"+testAddress+"/balances", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var body struct {
+		Data struct {
+			Balances []parser.Balance `json:"balances"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	require.Len(t, body.Data.Balances, 2)
+	assert.Equal(t, "14", body.Data.Balances[1].Balance.String())
+	assert.Equal(t, "5", body.Data.Balances[1].Unexplained.String())
+	assert.True(t, body.Data.Balances[1].Mismatch)
+}
+
+func TestV1Subscription(t *testing.T) {
+	env := newTestEnv(t)
+
+	rec := env.do("PUT", "/v1/addresses/"+testAddress+"/subscription", `{"callbackUrl":"https://example.com/hook"}`)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	var body struct {
+		Data struct {
+			Address string           `json:"address"`
+			Webhook notifier.Webhook `json:"webhook"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, testAddress, body.Data.Address)
+	assert.Equal(t, "https://example.com/hook", body.Data.Webhook.URL)
+	assert.NotEmpty(t, body.Data.Webhook.Secret)
+	assert.True(t, env.parser.isSubscribed(testAddr))
+}
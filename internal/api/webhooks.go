@@ -0,0 +1,67 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/meirongdev/ethereum_parser/internal/ethereum"
+	"github.com/meirongdev/ethereum_parser/internal/notifier"
+)
+
+// errInternal hides the cause of a failure from the client, the cause is logged
+var errInternal = errors.New("internal error")
+
+// checkWebhook validates a callback URL before the subscription it comes with is made,
+// the URLs of the loopback, private, link-local and unspecified addresses are refused
+func (h *Handler) checkWebhook(ctx context.Context, callbackURL string) error {
+	if h.outbox == nil {
+		return errors.New("webhooks are not enabled")
+	}
+	parsed, err := url.Parse(callbackURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("invalid callbackUrl: %s", callbackURL)
+	}
+	if err := notifier.CheckTarget(ctx, h.lookupIP, parsed.Hostname()); err != nil {
+		return fmt.Errorf("invalid callbackUrl: %w", err)
+	}
+	return nil
+}
+
+// registerWebhook registers a checked callback URL for the address on behalf of the tenant of the request,
+// it is called once the subscription succeeded so that a failed subscription leaves no webhook behind
+func (h *Handler) registerWebhook(r *http.Request, address ethereum.Address, callbackURL string) (notifier.Webhook, error) {
+	webhook, err := h.outbox.RegisterWebhook(principalFrom(r).keyID, address, callbackURL)
+	if err != nil {
+		h.logger.Error("Failed to register webhook", "address", address.Hex(), "error", err)
+		return notifier.Webhook{}, fmt.Errorf("failed to register webhook: %w", errInternal)
+	}
+	return webhook, nil
+}
+
+// removeWebhooks removes the webhooks of the tenant for the addresses it unsubscribed from
+func (h *Handler) removeWebhooks(r *http.Request, addresses []ethereum.Address) error {
+	if h.outbox == nil || len(addresses) == 0 {
+		return nil
+	}
+	_, err := h.outbox.RemoveWebhooks(principalFrom(r).keyID, addresses)
+	return err
+}
+
+// redeliver queues a dead letter again and wakes the dispatcher up
+func (h *Handler) redeliver(id string) (notifier.Delivery, error) {
+	delivery, err := h.outbox.Redeliver(id)
+	if errors.Is(err, notifier.ErrNotFound) {
+		return delivery, err
+	}
+	if err != nil {
+		h.logger.Error("Failed to redeliver", "delivery", id, "error", err)
+		return delivery, fmt.Errorf("failed to redeliver: %w", errInternal)
+	}
+	if h.dispatcher != nil {
+		h.dispatcher.Wake()
+	}
+	return delivery, nil
+}
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/meirongdev/ethereum_parser/internal/notifier"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// subscribeWithWebhook subscribes the tenant to the address with a callback URL and returns its webhook
+func subscribeWithWebhook(t *testing.T, env *testEnv, token, address string) notifier.Webhook {
+	rec := env.doAs(token, "PUT", "/v1/addresses/"+address+"/subscription", `{"callbackUrl":"https://example.com/hook"}`)
+	require.Contains(t, []int{http.StatusOK, http.StatusCreated}, rec.Code, rec.Body.String())
+	var response struct {
+		Data struct {
+			Webhook notifier.Webhook `json:"webhook"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	return response.Data.Webhook
+}
+
+func tenantWebhooks(t *testing.T, env *testEnv, token string) []notifier.Webhook {
+	rec := env.doAs(token, "GET", "/v1/webhooks", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assertDocumented(t, "GET", "/v1/webhooks", http.StatusOK)
+	var response struct {
+		Data struct {
+			Webhooks []notifier.Webhook `json:"webhooks"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	return response.Data.Webhooks
+}
+
+func TestWebhooksArePerTenant(t *testing.T) {
+	env := newAuthTestEnv(t)
+	alice := env.newKey(t, "alice")
+	bob := env.newKey(t, "bob")
+
+	// the same callback URL for the same address is a webhook of each tenant with a secret of its own
+	aliceHook := subscribeWithWebhook(t, env, alice, testAddress)
+	bobHook := subscribeWithWebhook(t, env, bob, testAddress)
+	assert.NotEqual(t, aliceHook.ID, bobHook.ID)
+	assert.NotEqual(t, aliceHook.Secret, bobHook.Secret)
+	assert.Equal(t, aliceHook, subscribeWithWebhook(t, env, alice, testAddress))
+	assert.Equal(t, []notifier.Webhook{bobHook}, tenantWebhooks(t, env, bob))
+
+	// a tenant cannot remove the webhook of another one
+	rec := env.doAs(bob, "DELETE", "/v1/webhooks/"+aliceHook.ID, "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assertErrorCode(t, rec, errCodeNotFound)
+	assertDocumented(t, "DELETE", "/v1/webhooks/{id}", http.StatusNotFound)
+	rec = env.doAs(alice, "DELETE", "/v1/webhooks/"+aliceHook.ID, "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assertDocumented(t, "DELETE", "/v1/webhooks/{id}", http.StatusOK)
+	assert.Empty(t, tenantWebhooks(t, env, alice))
+	assert.Len(t, tenantWebhooks(t, env, bob), 1)
+}
+
+func TestWebhooksGoWithTheSubscription(t *testing.T) {
+	env := newAuthTestEnv(t)
+	alice := env.newKey(t, "alice")
+	bob := env.newKey(t, "bob")
+	subscribeWithWebhook(t, env, alice, testAddress)
+	subscribeWithWebhook(t, env, bob, testAddress)
+	subscribeWithWebhook(t, env, bob, otherAddress)
+
+	// unsubscribing removes the webhooks of the tenant for the address
+	rec := env.doAs(alice, "POST", "/v1/addresses/batch", `[{"address": "`+testAddress+`", "action": "unsubscribe"}]`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Empty(t, tenantWebhooks(t, env, alice))
+	assert.Len(t, tenantWebhooks(t, env, bob), 2)
+	// bob still watches the address
+	assert.True(t, env.parser.isSubscribed(mustAddress(testAddress)))
+
+	// revoking the key removes its webhooks, and the addresses nobody else watches are not indexed anymore
+	var bobID string
+	for _, key := range env.keys.List() {
+		if key.Name == "bob" {
+			bobID = key.ID
+		}
+	}
+	require.Equal(t, http.StatusOK, env.doAs(testAdminToken, "DELETE", "/v1/admin/keys/"+bobID, "").Code)
+	assert.Empty(t, env.outbox.Webhooks())
+	assert.False(t, env.parser.isSubscribed(mustAddress(testAddress)))
+	assert.False(t, env.parser.isSubscribed(mustAddress(otherAddress)))
+}
+
+func TestWebhooksWithoutKeys(t *testing.T) {
+	env := newTestEnv(t)
+	webhook := subscribeWithWebhook(t, env, "", testAddress)
+	assert.Empty(t, webhook.KeyID)
+	assert.Equal(t, []notifier.Webhook{webhook}, tenantWebhooks(t, env, ""))
+
+	rec := env.do("POST", "/v1/addresses/batch", `[{"address": "`+testAddress+`", "action": "unsubscribe"}]`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Empty(t, env.outbox.Webhooks())
+	assert.False(t, env.parser.isSubscribed(mustAddress(testAddress)))
+}
+
+func TestFailedSubscriptionRegistersNoWebhook(t *testing.T) {
+	env := newAuthTestEnv(t, WithSubscriptionQuota(1))
+	tenant := env.newKey(t, "tenant")
+	hook := subscribeWithWebhook(t, env, tenant, testAddress)
+
+	rec := env.doAs(tenant, "PUT", "/v1/addresses/"+otherAddress+"/subscription", `{"callbackUrl":"https://example.com/other"}`)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assertErrorCode(t, rec, errCodeQuotaExceeded)
+	rec = env.doAs(tenant, "GET", "/subscribe?address="+otherAddress+"&callbackUrl=https://example.com/other", "")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+
+	assert.Equal(t, []notifier.Webhook{hook}, tenantWebhooks(t, env, tenant))
+	assert.Equal(t, []notifier.Webhook{hook}, env.outbox.Webhooks())
+
+	// an invalid callback URL fails before the subscription is made
+	rec = env.doAs(tenant, "PUT", "/v1/addresses/"+testAddress+"/subscription", `{"callbackUrl":"ftp://example.com/hook"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assertErrorCode(t, rec, errCodeInvalidArgument)
+	assert.Equal(t, []notifier.Webhook{hook}, env.outbox.Webhooks())
+}
+
+func TestWebhooksRefusePrivateTargets(t *testing.T) {
+	env := newAuthTestEnv(t)
+	tenant := env.newKey(t, "tenant")
+
+	for _, callbackURL := range []string{
+		"http://127.0.0.1:8080/hook",
+		"http://localhost.internal/hook",
+		"http://10.1.2.3/hook",
+		"http://192.168.0.10/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://[::1]/hook",
+		"http://[fe80::1]/hook",
+		"http://0.0.0.0/hook",
+	} {
+		rec := env.doAs(tenant, "PUT", "/v1/addresses/"+testAddress+"/subscription", `{"callbackUrl":"`+callbackURL+`"}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, callbackURL)
+		assertErrorCode(t, rec, errCodeInvalidArgument)
+		assert.Contains(t, rec.Body.String(), "not a public address", callbackURL)
+
+		rec = env.doAs(tenant, "GET", "/subscribe?address="+testAddress+"&callbackUrl="+url.QueryEscape(callbackURL), "")
+		assert.Equal(t, http.StatusBadRequest, rec.Code, callbackURL)
+	}
+	assert.Empty(t, env.outbox.Webhooks())
+	assert.False(t, env.parser.isSubscribed(mustAddress(testAddress)))
+
+	assert.Equal(t, "https://example.com/hook", subscribeWithWebhook(t, env, tenant, testAddress).URL)
+}
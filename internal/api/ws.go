@@ -0,0 +1,306 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/meirongdev/ethereum_parser/internal/auth"
+	"github.com/meirongdev/ethereum_parser/internal/ethereum"
+	"github.com/meirongdev/ethereum_parser/internal/notifier"
+	"github.com/meirongdev/ethereum_parser/internal/websocket"
+)
+
+var (
+	// wsQueueSize is how many frames can wait for a slow client before it is disconnected
+	wsQueueSize = 256
+	// wsWriteTimeout bounds the time to write one frame
+	wsWriteTimeout = 10 * time.Second
+	// wsPingInterval is how often the server pings an idle client
+	wsPingInterval = 30 * time.Second
+)
+
+// JSON-RPC 2.0 error codes
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+)
+
+type rpcRequest struct {
+	JSONRPC string            `json:"jsonrpc"`
+	ID      json.RawMessage   `json:"id"`
+	Method  string            `json:"method"`
+	Params  []json.RawMessage `json:"params"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcNotification struct {
+	JSONRPC string             `json:"jsonrpc"`
+	Method  string             `json:"method"`
+	Params  subscriptionResult `json:"params"`
+}
+
+type subscriptionResult struct {
+	Subscription string         `json:"subscription"`
+	Result       notifier.Event `json:"result"`
+}
+
+// subscribeFilter is the second eth_subscribe parameter
+type subscribeFilter struct {
+	Addresses []ethereum.Address `json:"addresses"`
+}
+
+// wsSession is one websocket client and its subscriptions
+type wsSession struct {
+	conn   *websocket.Conn
+	logger *slog.Logger
+	// subscribeAddress adds an address to the subscriptions of the tenant of the connection
+	subscribeAddress func(ethereum.Address) error
+	listener         *notifier.Listener
+	outgoing         chan []byte
+	done             chan struct{}
+	stopOnce         sync.Once
+	mutex            sync.Mutex
+	// subscription id to the addresses it watches
+	subscriptions map[string]map[ethereum.Address]struct{}
+}
+
+func newSubscriptionID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return "0x" + hex.EncodeToString(b)
+}
+
+// websocket serves /ws, a JSON-RPC websocket API mirroring eth_subscribe:
+//
+//	{"jsonrpc":"2.0","id":1,"method":"eth_subscribe","params":["transactions",{"addresses":["0x..."]}]}
+//	{"jsonrpc":"2.0","id":2,"method":"eth_unsubscribe","params":["<subscription id>"]}
+//
+// Transaction, confirmation and removed events are sent as eth_subscription notifications.
+// The events of a client which cannot keep up are not queued forever, the client is disconnected instead.
+func (h *Handler) websocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		h.logger.Warn("Websocket upgrade failed", "remote", r.RemoteAddr, "error", err)
+		return
+	}
+	s := &wsSession{
+		conn:   conn,
+		logger: h.logger.With("remote", conn.RemoteAddr().String()),
+		subscribeAddress: func(address ethereum.Address) error {
+			_, err := h.subscribeFor(r, address)
+			return err
+		},
+		listener:      h.hub.Listen(nil, wsQueueSize),
+		outgoing:      make(chan []byte, wsQueueSize),
+		done:          make(chan struct{}),
+		subscriptions: make(map[string]map[ethereum.Address]struct{}),
+	}
+	go s.writeLoop()
+	go s.eventLoop()
+	s.readLoop()
+	s.stop(websocket.CloseNormal, "")
+}
+
+// stop closes the session once, the close frame is best effort
+func (s *wsSession) stop(code int, reason string) {
+	s.stopOnce.Do(func() {
+		close(s.done)
+		s.listener.Close()
+		_ = s.conn.WriteClose(code, reason)
+		s.conn.Close()
+	})
+}
+
+// send queues a frame, a client whose queue is full is too slow and gets disconnected
+func (s *wsSession) send(v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		s.logger.Error("Failed to encode websocket message", "error", err)
+		return
+	}
+	select {
+	case s.outgoing <- data:
+	case <-s.done:
+	default:
+		s.logger.Warn("Websocket client is too slow, disconnecting")
+		go s.stop(websocket.CloseTryAgainLater, "client too slow")
+	}
+}
+
+func (s *wsSession) writeLoop() {
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case data := <-s.outgoing:
+			if err := s.conn.WriteMessageDeadline(websocket.OpText, data, time.Now().Add(wsWriteTimeout)); err != nil {
+				s.stop(websocket.CloseGoingAway, "")
+				return
+			}
+		case <-ping.C:
+			if err := s.conn.WriteMessageDeadline(websocket.OpPing, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+				s.stop(websocket.CloseGoingAway, "")
+				return
+			}
+		}
+	}
+}
+
+// eventLoop turns the hub events into notifications of the matching subscriptions
+func (s *wsSession) eventLoop() {
+	for {
+		select {
+		case <-s.done:
+			return
+		case event, ok := <-s.listener.Events():
+			if !ok {
+				if s.listener.Lagging() {
+					s.stop(websocket.CloseTryAgainLater, "client too slow")
+				}
+				return
+			}
+			s.mutex.Lock()
+			var ids []string
+			for id, addresses := range s.subscriptions {
+				if _, ok := addresses[event.Address]; ok {
+					ids = append(ids, id)
+				}
+			}
+			s.mutex.Unlock()
+			for _, id := range ids {
+				s.send(rpcNotification{
+					JSONRPC: "2.0",
+					Method:  "eth_subscription",
+					Params:  subscriptionResult{Subscription: id, Result: event},
+				})
+			}
+		}
+	}
+}
+
+func (s *wsSession) readLoop() {
+	for {
+		opcode, message, err := s.conn.ReadMessage()
+		if err != nil {
+			if !errors.Is(err, websocket.ErrClosed) {
+				s.logger.Info("Websocket read ended", "error", err)
+			}
+			return
+		}
+		if opcode != websocket.OpText {
+			continue
+		}
+		var req rpcRequest
+		if err := json.Unmarshal(message, &req); err != nil {
+			s.send(rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{Code: rpcParseError, Message: "invalid JSON"}})
+			continue
+		}
+		s.send(s.handle(req))
+	}
+}
+
+func (s *wsSession) handle(req rpcRequest) rpcResponse {
+	resp := rpcResponse{JSONRPC: "2.0", ID: req.ID}
+	if len(req.ID) == 0 {
+		resp.ID = json.RawMessage("null")
+	}
+	switch req.Method {
+	case "eth_subscribe":
+		id, err := s.subscribe(req.Params)
+		if err != nil {
+			resp.Error = &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+			return resp
+		}
+		resp.Result = id
+	case "eth_unsubscribe":
+		var id string
+		if len(req.Params) != 1 || json.Unmarshal(req.Params[0], &id) != nil {
+			resp.Error = &rpcError{Code: rpcInvalidParams, Message: "expected the subscription id"}
+			return resp
+		}
+		resp.Result = s.unsubscribe(id)
+	case "":
+		resp.Error = &rpcError{Code: rpcInvalidRequest, Message: "method is required"}
+	default:
+		resp.Error = &rpcError{Code: rpcMethodNotFound, Message: "the method " + req.Method + " does not exist"}
+	}
+	return resp
+}
+
+func (s *wsSession) subscribe(params []json.RawMessage) (string, error) {
+	var kind string
+	if len(params) != 2 || json.Unmarshal(params[0], &kind) != nil || kind != "transactions" {
+		return "", errors.New(`expected ["transactions", {"addresses": [...]}]`)
+	}
+	var filter subscribeFilter
+	if err := json.Unmarshal(params[1], &filter); err != nil {
+		return "", err
+	}
+	if len(filter.Addresses) == 0 {
+		return "", errors.New("addresses is required")
+	}
+	addresses := make(map[ethereum.Address]struct{}, len(filter.Addresses))
+	for _, address := range filter.Addresses {
+		if err := s.subscribeAddress(address); err != nil {
+			if errors.Is(err, auth.ErrQuotaExceeded) {
+				return "", err
+			}
+			s.logger.Error("Failed to subscribe", "address", address.Hex(), "error", err)
+			return "", errors.New("failed to subscribe")
+		}
+		addresses[address] = struct{}{}
+	}
+	id := newSubscriptionID()
+	s.mutex.Lock()
+	s.subscriptions[id] = addresses
+	s.mutex.Unlock()
+	s.updateListener()
+	return id, nil
+}
+
+func (s *wsSession) unsubscribe(id string) bool {
+	s.mutex.Lock()
+	_, exists := s.subscriptions[id]
+	delete(s.subscriptions, id)
+	s.mutex.Unlock()
+	if exists {
+		s.updateListener()
+	}
+	return exists
+}
+
+// updateListener makes the hub send the events of every address of the subscriptions
+func (s *wsSession) updateListener() {
+	s.mutex.Lock()
+	var all []ethereum.Address
+	for _, addresses := range s.subscriptions {
+		for address := range addresses {
+			all = append(all, address)
+		}
+	}
+	s.mutex.Unlock()
+	s.listener.SetAddresses(all)
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/meirongdev/ethereum_parser/internal/notifier"
+	"github.com/meirongdev/ethereum_parser/internal/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// wsClient is a minimal websocket client for the JSON-RPC messages
+type wsClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dialWS(t *testing.T, serverURL, path string) *wsClient {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(serverURL, "http://"))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	request := "GET " + path + " HTTP/1.1\r\nHost: test\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n"
+	_, err = conn.Write([]byte(request))
+	require.NoError(t, err)
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	return &wsClient{conn: conn, reader: reader}
+}
+
+func (c *wsClient) send(t *testing.T, message string) {
+	frame := []byte{0x80 | websocket.OpText}
+	if len(message) < 126 {
+		frame = append(frame, 0x80|byte(len(message)))
+	} else {
+		frame = append(frame, 0x80|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(message)))
+	}
+	mask := []byte{1, 2, 3, 4}
+	frame = append(frame, mask...)
+	for i := range len(message) {
+		frame = append(frame, message[i]^mask[i%4])
+	}
+	_, err := c.conn.Write(frame)
+	require.NoError(t, err)
+}
+
+// receive decodes the next text frame into v
+func (c *wsClient) receive(t *testing.T, v interface{}) {
+	require.NoError(t, c.conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	var header [2]byte
+	_, err := io.ReadFull(c.reader, header[:])
+	require.NoError(t, err)
+	require.Equal(t, byte(websocket.OpText), header[0]&0x0f)
+	length := int(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		_, err = io.ReadFull(c.reader, ext[:])
+		require.NoError(t, err)
+		length = int(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		_, err = io.ReadFull(c.reader, ext[:])
+		require.NoError(t, err)
+		length = int(binary.BigEndian.Uint64(ext[:]))
+	}
+	payload := make([]byte, length)
+	_, err = io.ReadFull(c.reader, payload)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(payload, v))
+}
+
+type testRPCResponse struct {
+	ID     json.RawMessage `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+func TestWebsocketSubscription(t *testing.T) {
+	env := newTestEnv(t)
+	server := httptest.NewServer(env.handler)
+	defer server.Close()
+	client := dialWS(t, server.URL, "/v1/ws")
+
+	client.send(t, `{"jsonrpc":"2.0","id":1,"method":"eth_subscribe","params":["transactions",{"addresses":["`+testAddress+`"]}]}`)
+	var subscribed testRPCResponse
+	client.receive(t, &subscribed)
+	require.Nil(t, subscribed.Error)
+	var id string
+	require.NoError(t, json.Unmarshal(subscribed.Result, &id))
+	assert.True(t, env.parser.isSubscribed(testAddr))
+
+	tx := testTransaction(5, 2)
+	assert.NoError(t, env.hub.Send(context.Background(), notifier.Event{Type: notifier.EventTransaction, Address: testAddr, Transaction: tx}))
+	var notification rpcNotification
+	client.receive(t, &notification)
+	assert.Equal(t, "eth_subscription", notification.Method)
+	assert.Equal(t, id, notification.Params.Subscription)
+	assert.Equal(t, tx.Hash, notification.Params.Result.Transaction.Hash)
+
+	client.send(t, `{"jsonrpc":"2.0","id":2,"method":"eth_unsubscribe","params":["`+id+`"]}`)
+	var unsubscribed testRPCResponse
+	client.receive(t, &unsubscribed)
+	assert.JSONEq(t, "true", string(unsubscribed.Result))
+
+	client.send(t, `{"jsonrpc":"2.0","id":3,"method":"eth_unsubscribe","params":["`+id+`"]}`)
+	client.receive(t, &unsubscribed)
+	assert.JSONEq(t, "false", string(unsubscribed.Result))
+}
+
+func TestWebsocketErrors(t *testing.T) {
+	env := newTestEnv(t)
+	server := httptest.NewServer(env.handler)
+	defer server.Close()
+	client := dialWS(t, server.URL, "/ws")
+
+	tests := []struct {
+		name    string
+		message string
+		code    int
+	}{
+		{"invalid json", `{"jsonrpc":`, rpcParseError},
+		{"missing method", `{"jsonrpc":"2.0","id":1}`, rpcInvalidRequest},
+		{"unknown method", `{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber"}`, rpcMethodNotFound},
+		{"unknown subscription kind", `{"jsonrpc":"2.0","id":1,"method":"eth_subscribe","params":["newHeads",{}]}`, rpcInvalidParams},
+		{"no addresses", `{"jsonrpc":"2.0","id":1,"method":"eth_subscribe","params":["transactions",{"addresses":[]}]}`, rpcInvalidParams},
+		{"invalid address", `{"jsonrpc":"2.0","id":1,"method":"eth_subscribe","params":["transactions",{"addresses":["0x123"]}]}`, rpcInvalidParams},
+		{"unsubscribe without id", `{"jsonrpc":"2.0","id":1,"method":"eth_unsubscribe","params":[]}`, rpcInvalidParams},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client.send(t, tt.message)
+			var resp testRPCResponse
+			client.receive(t, &resp)
+			require.NotNil(t, resp.Error)
+			assert.Equal(t, tt.code, resp.Error.Code)
+		})
+	}
+}
+
+func TestWebsocketRejectsPlainRequests(t *testing.T) {
+	var logs bytes.Buffer
+	rec := newTestEnv(t, WithLogger(slog.New(slog.NewTextHandler(&logs, nil)))).do("GET", "/v1/ws", "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	// the failure goes to the logger of the handler with its fields
+	assert.Contains(t, logs.String(), `msg="Websocket upgrade failed" remote=192.0.2.1:1234`)
+}
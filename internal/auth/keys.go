@@ -0,0 +1,343 @@
+// Package auth keeps the API keys and the addresses each key subscribed to.
+// A key is a random bearer token, only its SHA-256 hash is stored.
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/meirongdev/ethereum_parser/internal/ethereum"
+	"github.com/meirongdev/ethereum_parser/internal/parser"
+)
+
+// tokenPrefix makes the tokens easy to recognize in logs and secret scanners
+const tokenPrefix = "ep_"
+
+var (
+	// ErrNotFound is returned when a key does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrInvalidToken is returned when a token matches no active key
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrQuotaExceeded is returned when a key already has as many subscriptions as it is allowed
+	ErrQuotaExceeded = errors.New("subscription quota exceeded")
+)
+
+// Key is an API key, the tenant owning a set of subscriptions
+type Key struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Hint is the start of the token so its owner can tell the keys apart
+	Hint      string     `json:"hint"`
+	CreatedAt time.Time  `json:"createdAt"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty"`
+}
+
+// Revoked tells if the key can no longer be used
+func (k Key) Revoked() bool {
+	return k.RevokedAt != nil
+}
+
+type storedKey struct {
+	Key
+	Hash string `json:"hash"`
+	// Subscriptions are the addresses the key subscribed to, in subscription order
+	Subscriptions []parser.Subscription `json:"subscriptions"`
+	// Addresses are the subscriptions written before they had labels, OpenKeys moves them to Subscriptions
+	Addresses []ethereum.Address `json:"addresses,omitempty"`
+}
+
+// Change adds or updates a subscription of a key, or removes it when Remove is set
+type Change struct {
+	parser.Subscription
+	Remove bool
+	// Keep leaves an existing subscription as it is, for the subscriptions which do not give a label or a start block
+	Keep bool
+}
+
+// find returns the index of the subscription of the address, or -1
+func (key *storedKey) find(address ethereum.Address) int {
+	for i, subscription := range key.Subscriptions {
+		if subscription.Address == address {
+			return i
+		}
+	}
+	return -1
+}
+
+// Keys stores the API keys. When it has a path every change is written to that file.
+type Keys struct {
+	path  string
+	mutex sync.RWMutex
+	keys  []*storedKey
+	// byHash finds the key of a token hash
+	byHash map[string]*storedKey
+}
+
+// NewKeys creates an in-memory key store
+func NewKeys() *Keys {
+	return &Keys{byHash: make(map[string]*storedKey)}
+}
+
+// OpenKeys loads the keys persisted at path, a missing file is an empty store
+func OpenKeys(path string) (*Keys, error) {
+	k := NewKeys()
+	k.path = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return k, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading keys %w", err)
+	}
+	if err := json.Unmarshal(data, &k.keys); err != nil {
+		return nil, fmt.Errorf("error decoding keys %s %w", path, err)
+	}
+	for _, key := range k.keys {
+		for _, address := range key.Addresses {
+			key.Subscriptions = append(key.Subscriptions, parser.Subscription{Address: address})
+		}
+		key.Addresses = nil
+		k.byHash[key.Hash] = key
+	}
+	return k, nil
+}
+
+// HashToken is the hash stored for a token
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// persist writes the keys to a temporary file and renames it so a crash never leaves half a file,
+// it must be called while holding the write lock
+func (k *Keys) persist() error {
+	if k.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(k.keys)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(k.path), filepath.Base(k.path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("error writing keys %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing keys %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing keys %w", err)
+	}
+	return os.Rename(tmp.Name(), k.path)
+}
+
+// Create adds a key and returns its token, the token cannot be retrieved later
+func (k *Keys) Create(name string) (Key, string, error) {
+	token := tokenPrefix + randomString(32)
+	key := &storedKey{
+		Key: Key{
+			ID:        randomString(12),
+			Name:      name,
+			Hint:      token[:len(tokenPrefix)+4],
+			CreatedAt: time.Now().UTC(),
+		},
+		Hash: HashToken(token),
+	}
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.keys = append(k.keys, key)
+	if err := k.persist(); err != nil {
+		k.keys = k.keys[:len(k.keys)-1]
+		return Key{}, "", err
+	}
+	k.byHash[key.Hash] = key
+	return key.Key, token, nil
+}
+
+// Revoke disables a key, its subscriptions are kept
+func (k *Keys) Revoke(id string) (Key, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	key := k.find(id)
+	if key == nil {
+		return Key{}, ErrNotFound
+	}
+	if key.Revoked() {
+		return key.Key, nil
+	}
+	now := time.Now().UTC()
+	key.RevokedAt = &now
+	if err := k.persist(); err != nil {
+		key.RevokedAt = nil
+		return Key{}, err
+	}
+	return key.Key, nil
+}
+
+// find returns the key with the id, it must be called while holding the lock
+func (k *Keys) find(id string) *storedKey {
+	for _, key := range k.keys {
+		if key.ID == id {
+			return key
+		}
+	}
+	return nil
+}
+
+// List returns every key, the revoked ones included
+func (k *Keys) List() []Key {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	keys := make([]Key, 0, len(k.keys))
+	for _, key := range k.keys {
+		keys = append(keys, key.Key)
+	}
+	return keys
+}
+
+// Authenticate returns the active key of a token
+func (k *Keys) Authenticate(token string) (Key, error) {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	key, ok := k.byHash[HashToken(token)]
+	if !ok || key.Revoked() {
+		return Key{}, ErrInvalidToken
+	}
+	return key.Key, nil
+}
+
+// Subscribe adds an address to the subscriptions of a key, it returns false when it was already there
+func (k *Keys) Subscribe(id string, address ethereum.Address) (bool, error) {
+	return k.SubscribeWithin(id, address, 0)
+}
+
+// SubscribeWithin is Subscribe failing with ErrQuotaExceeded when the key already has limit subscriptions,
+// a limit of 0 means no limit
+func (k *Keys) SubscribeWithin(id string, address ethereum.Address, limit int) (bool, error) {
+	changed, err := k.Apply(id, []Change{{Subscription: parser.Subscription{Address: address}, Keep: true}}, limit)
+	if err != nil {
+		return false, err
+	}
+	return changed[0], nil
+}
+
+// Apply makes the changes to the subscriptions of a key with a single write, nothing changes when it fails.
+// It returns whether each change added or removed a subscription, an existing subscription takes the new label
+// when one is given and keeps the earliest start block like in the parser.
+// It fails with ErrQuotaExceeded when the key would end up with more than limit subscriptions, 0 means no limit.
+func (k *Keys) Apply(id string, changes []Change, limit int) ([]bool, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	key := k.find(id)
+	if key == nil {
+		return nil, ErrNotFound
+	}
+	before := key.Subscriptions
+	key.Subscriptions = append([]parser.Subscription{}, before...)
+	changed := make([]bool, len(changes))
+	for i, change := range changes {
+		index := key.find(change.Address)
+		switch {
+		case change.Remove && index >= 0:
+			key.Subscriptions = append(key.Subscriptions[:index], key.Subscriptions[index+1:]...)
+			changed[i] = true
+		case change.Remove:
+		case index < 0:
+			key.Subscriptions = append(key.Subscriptions, change.Subscription)
+			changed[i] = true
+		case change.Keep:
+		default:
+			existing := &key.Subscriptions[index]
+			if change.Label != "" {
+				existing.Label = change.Label
+			}
+			if change.StartBlock < existing.StartBlock {
+				existing.StartBlock = change.StartBlock
+			}
+		}
+	}
+	// a key above a lowered limit can still remove subscriptions
+	if limit > 0 && len(key.Subscriptions) > limit && len(key.Subscriptions) > len(before) {
+		key.Subscriptions = before
+		return nil, ErrQuotaExceeded
+	}
+	if err := k.persist(); err != nil {
+		key.Subscriptions = before
+		return nil, err
+	}
+	return changed, nil
+}
+
+// IsSubscribed tells if a key subscribed to an address
+func (k *Keys) IsSubscribed(id string, address ethereum.Address) bool {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	key := k.find(id)
+	return key != nil && key.find(address) >= 0
+}
+
+// Subscription returns the subscription of a key to an address, false when the key did not subscribe to it
+func (k *Keys) Subscription(id string, address ethereum.Address) (parser.Subscription, bool) {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	key := k.find(id)
+	if key == nil {
+		return parser.Subscription{}, false
+	}
+	index := key.find(address)
+	if index < 0 {
+		return parser.Subscription{}, false
+	}
+	return key.Subscriptions[index], true
+}
+
+// Subscriptions returns the subscriptions of a key, in subscription order
+func (k *Keys) Subscriptions(id string) []parser.Subscription {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	if key := k.find(id); key != nil {
+		return append([]parser.Subscription{}, key.Subscriptions...)
+	}
+	return nil
+}
+
+// Addresses returns the addresses subscribed by any active key, each once and sorted
+func (k *Keys) Addresses() []ethereum.Address {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	seen := make(map[ethereum.Address]struct{})
+	var addresses []ethereum.Address
+	for _, key := range k.keys {
+		if key.Revoked() {
+			continue
+		}
+		for _, subscription := range key.Subscriptions {
+			if _, ok := seen[subscription.Address]; !ok {
+				seen[subscription.Address] = struct{}{}
+				addresses = append(addresses, subscription.Address)
+			}
+		}
+	}
+	sort.Slice(addresses, func(i, j int) bool { return addresses[i].Hex() < addresses[j].Hex() })
+	return addresses
+}
@@ -0,0 +1,157 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/meirongdev/ethereum_parser/internal/ethereum"
+	"github.com/meirongdev/ethereum_parser/internal/parser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	addressA = mustAddress("0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed")
+	addressB = mustAddress("0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359")
+)
+
+func mustAddress(s string) ethereum.Address {
+	address, err := ethereum.ParseAddress(s)
+	if err != nil {
+		panic(err)
+	}
+	return address
+}
+
+func TestCreateAuthenticateRevoke(t *testing.T) {
+	keys := NewKeys()
+	key, token, err := keys.Create("alice")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(token, tokenPrefix))
+	assert.True(t, strings.HasPrefix(token, key.Hint))
+	assert.False(t, key.Revoked())
+
+	authenticated, err := keys.Authenticate(token)
+	assert.NoError(t, err)
+	assert.Equal(t, key, authenticated)
+
+	_, err = keys.Authenticate(token + "x")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	revoked, err := keys.Revoke(key.ID)
+	assert.NoError(t, err)
+	assert.True(t, revoked.Revoked())
+	_, err = keys.Authenticate(token)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	_, err = keys.Revoke("missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Len(t, keys.List(), 1)
+}
+
+func TestSubscriptions(t *testing.T) {
+	keys := NewKeys()
+	alice, _, _ := keys.Create("alice")
+	bob, _, _ := keys.Create("bob")
+
+	added, err := keys.Subscribe(alice.ID, addressB)
+	assert.NoError(t, err)
+	assert.True(t, added)
+	added, err = keys.Subscribe(alice.ID, addressB)
+	assert.NoError(t, err)
+	assert.False(t, added)
+	_, _ = keys.Subscribe(alice.ID, addressA)
+	_, _ = keys.Subscribe(bob.ID, addressB)
+	_, err = keys.Subscribe("missing", addressA)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	assert.Equal(t, []parser.Subscription{{Address: addressB}, {Address: addressA}}, keys.Subscriptions(alice.ID))
+	assert.Equal(t, []parser.Subscription{{Address: addressB}}, keys.Subscriptions(bob.ID))
+	assert.True(t, keys.IsSubscribed(bob.ID, addressB))
+	assert.False(t, keys.IsSubscribed(bob.ID, addressA))
+	assert.Equal(t, []ethereum.Address{addressA, addressB}, keys.Addresses())
+
+	// the addresses of revoked keys are no longer indexed
+	_, _ = keys.Revoke(alice.ID)
+	assert.Equal(t, []ethereum.Address{addressB}, keys.Addresses())
+}
+
+func TestSubscribeWithin(t *testing.T) {
+	keys := NewKeys()
+	key, _, _ := keys.Create("alice")
+
+	added, err := keys.SubscribeWithin(key.ID, addressA, 1)
+	assert.NoError(t, err)
+	assert.True(t, added)
+	// an existing subscription does not count against the quota
+	added, err = keys.SubscribeWithin(key.ID, addressA, 1)
+	assert.NoError(t, err)
+	assert.False(t, added)
+	_, err = keys.SubscribeWithin(key.ID, addressB, 1)
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+	assert.Equal(t, []parser.Subscription{{Address: addressA}}, keys.Subscriptions(key.ID))
+}
+
+func TestApply(t *testing.T) {
+	keys := NewKeys()
+	key, _, _ := keys.Create("alice")
+	_, _ = keys.Subscribe(key.ID, addressA)
+
+	changed, err := keys.Apply(key.ID, []Change{
+		{Subscription: parser.Subscription{Address: addressB, Label: "deposit", StartBlock: 7}},
+		{Subscription: parser.Subscription{Address: addressA, Label: "hot wallet", StartBlock: 3}},
+		{Subscription: parser.Subscription{Address: addressA}, Remove: true},
+		{Subscription: parser.Subscription{Address: addressA}, Remove: true},
+	}, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []bool{true, false, true, false}, changed)
+	assert.Equal(t, []parser.Subscription{{Address: addressB, Label: "deposit", StartBlock: 7}}, keys.Subscriptions(key.ID))
+
+	// over the quota nothing is applied
+	_, err = keys.Apply(key.ID, []Change{
+		{Subscription: parser.Subscription{Address: addressB}, Remove: true},
+		{Subscription: parser.Subscription{Address: addressA}},
+		{Subscription: parser.Subscription{Address: mustAddress("0xdbF03B407c01E7cD3CBea99509d93f8DDDC8C6FB")}},
+	}, 1)
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+	assert.Equal(t, []parser.Subscription{{Address: addressB, Label: "deposit", StartBlock: 7}}, keys.Subscriptions(key.ID))
+
+	_, err = keys.Apply("missing", nil, 0)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestOpenKeysReadsTheAddressesOfOlderFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	require.NoError(t, os.WriteFile(path, []byte(`[{"id":"k1","name":"alice","hash":"h","addresses":["`+addressA.Hex()+`"]}]`), 0o600))
+	keys, err := OpenKeys(path)
+	require.NoError(t, err)
+	assert.Equal(t, []parser.Subscription{{Address: addressA}}, keys.Subscriptions("k1"))
+}
+
+func TestKeysArePersistedHashed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	keys, err := OpenKeys(path)
+	require.NoError(t, err)
+	key, token, err := keys.Create("alice")
+	require.NoError(t, err)
+	_, err = keys.Subscribe(key.ID, addressA)
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), token)
+	assert.Contains(t, string(data), HashToken(token))
+
+	reopened, err := OpenKeys(path)
+	require.NoError(t, err)
+	authenticated, err := reopened.Authenticate(token)
+	assert.NoError(t, err)
+	assert.Equal(t, key.ID, authenticated.ID)
+	assert.Equal(t, []parser.Subscription{{Address: addressA}}, reopened.Subscriptions(key.ID))
+
+	require.NoError(t, os.WriteFile(path, []byte("{"), 0o600))
+	_, err = OpenKeys(path)
+	assert.Error(t, err)
+}
@@ -0,0 +1,222 @@
+// Package client calls the /v1 API of a running server
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/meirongdev/ethereum_parser/internal/ethereum"
+	"github.com/meirongdev/ethereum_parser/internal/parser"
+)
+
+// Error is a failed response, Code is the code of the error envelope
+type Error struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("server answered %d: %s", e.Status, e.Message)
+	}
+	return fmt.Sprintf("server answered %d %s: %s", e.Status, e.Code, e.Message)
+}
+
+// Client calls the API of one server
+type Client struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+type Option func(*Client)
+
+// WithToken sends the token as a bearer token, it is required when the server has API keys
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// WithHTTPClient replaces the client sending the requests
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Client) {
+		c.http = client
+	}
+}
+
+// New creates a client of the server at baseURL, e.g. http://localhost:8080
+func New(baseURL string, options ...Option) *Client {
+	c := &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		http:    &http.Client{Timeout: 30 * time.Second},
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+// BatchItem is one entry of a bulk change, Action is subscribe (the default) or unsubscribe
+type BatchItem struct {
+	Address    string `json:"address"`
+	Label      string `json:"label,omitempty"`
+	StartBlock int    `json:"startBlock,omitempty"`
+	Action     string `json:"action,omitempty"`
+}
+
+// BatchResult is the outcome of a BatchItem
+type BatchResult struct {
+	Index   int    `json:"index"`
+	Address string `json:"address"`
+	Action  string `json:"action,omitempty"`
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Batch is the response of a bulk change
+type Batch struct {
+	Results []BatchResult  `json:"results"`
+	Counts  map[string]int `json:"counts"`
+}
+
+// Apply subscribes to or unsubscribes from the addresses at once
+func (c *Client) Apply(ctx context.Context, items []BatchItem) (Batch, error) {
+	var batch Batch
+	body, err := json.Marshal(items)
+	if err != nil {
+		return batch, err
+	}
+	err = c.do(ctx, http.MethodPost, "/v1/addresses/batch", bytes.NewReader(body), &batch)
+	return batch, err
+}
+
+// Subscriptions returns the subscriptions of the key, or of the server without API keys
+func (c *Client) Subscriptions(ctx context.Context) ([]parser.Subscription, error) {
+	var data struct {
+		Subscriptions []parser.Subscription `json:"subscriptions"`
+	}
+	err := c.do(ctx, http.MethodGet, "/v1/addresses", nil, &data)
+	return data.Subscriptions, err
+}
+
+// Transactions calls fn with each page of the transactions of the address matching the query,
+// the values are the query parameters of GET /v1/addresses/{address}/transactions without the cursor
+func (c *Client) Transactions(ctx context.Context, address ethereum.Address, query url.Values, fn func([]parser.Transaction) error) error {
+	query = cloneValues(query)
+	for {
+		var page struct {
+			Transactions []parser.Transaction `json:"transactions"`
+			NextCursor   string               `json:"nextCursor"`
+		}
+		path := "/v1/addresses/" + address.Hex() + "/transactions?" + query.Encode()
+		if err := c.do(ctx, http.MethodGet, path, nil, &page); err != nil {
+			return err
+		}
+		if err := fn(page.Transactions); err != nil {
+			return err
+		}
+		if page.NextCursor == "" {
+			return nil
+		}
+		query.Set("cursor", page.NextCursor)
+	}
+}
+
+// Export copies the export of the address to w as the server streams it,
+// the values are the query parameters of GET /v1/addresses/{address}/transactions/export.
+// The timeout of the client does not apply, the export runs until ctx is done.
+func (c *Client) Export(ctx context.Context, address ethereum.Address, query url.Values, w io.Writer) error {
+	req, err := c.newRequest(ctx, http.MethodGet, "/v1/addresses/"+address.Hex()+"/transactions/export?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	streaming := *c.http
+	streaming.Timeout = 0
+	resp, err := streaming.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return readError(resp)
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+func cloneValues(values url.Values) url.Values {
+	clone := url.Values{}
+	for key, value := range values {
+		clone[key] = append([]string(nil), value...)
+	}
+	return clone
+}
+
+// newRequest creates a request to the server with the token
+func (c *Client) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return req, nil
+}
+
+// do sends a request and decodes the data of the response envelope into out
+func (c *Client) do(ctx context.Context, method, path string, body io.Reader, out interface{}) error {
+	req, err := c.newRequest(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return readError(resp)
+	}
+	envelope := struct {
+		Data interface{} `json:"data"`
+	}{Data: out}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return fmt.Errorf("invalid response from %s %w", path, err)
+	}
+	return nil
+}
+
+// readError reads the error envelope of a failed response, or its text when it has none
+func readError(resp *http.Response) error {
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	apiErr := &Error{Status: resp.StatusCode}
+	var envelope struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(raw, &envelope) == nil && envelope.Error.Message != "" {
+		apiErr.Code = envelope.Error.Code
+		apiErr.Message = envelope.Error.Message
+		return apiErr
+	}
+	apiErr.Message = strings.TrimSpace(string(raw))
+	if apiErr.Message == "" {
+		apiErr.Message = http.StatusText(resp.StatusCode)
+	}
+	return apiErr
+}
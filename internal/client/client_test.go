@@ -0,0 +1,126 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/meirongdev/ethereum_parser/internal/ethereum"
+	"github.com/meirongdev/ethereum_parser/internal/parser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testAddress = "0x0000000000000000000000000000000000000abc"
+
+func newTestServer(t *testing.T, handler http.HandlerFunc) *Client {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return New(server.URL+"/", WithToken("tok"))
+}
+
+func TestApply(t *testing.T) {
+	c := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "POST /v1/addresses/batch", r.Method+" "+r.URL.Path)
+		assert.Equal(t, "Bearer tok", r.Header.Get("Authorization"))
+		body, _ := io.ReadAll(r.Body)
+		assert.JSONEq(t, `[{"address":"`+testAddress+`","label":"cold"},{"address":"nope","action":"unsubscribe"}]`, string(body))
+		_, _ = w.Write([]byte(`{"data":{"results":[{"index":0,"address":"` + testAddress + `","status":"created"},{"index":1,"address":"nope","action":"unsubscribe","status":"invalid","error":"bad"}],"counts":{"created":1,"invalid":1}}}`))
+	})
+
+	batch, err := c.Apply(context.Background(), []BatchItem{{Address: testAddress, Label: "cold"}, {Address: "nope", Action: "unsubscribe"}})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"created": 1, "invalid": 1}, batch.Counts)
+	assert.Equal(t, BatchResult{Index: 1, Address: "nope", Action: "unsubscribe", Status: "invalid", Error: "bad"}, batch.Results[1])
+}
+
+func TestSubscriptions(t *testing.T) {
+	c := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/addresses", r.URL.Path)
+		_, _ = w.Write([]byte(`{"data":{"addresses":["` + testAddress + `"],"subscriptions":[{"address":"` + testAddress + `","label":"cold","startBlock":7}]}}`))
+	})
+
+	subscriptions, err := c.Subscriptions(context.Background())
+	require.NoError(t, err)
+	address, _ := ethereum.ParseAddress(testAddress)
+	assert.Equal(t, []parser.Subscription{{Address: address, Label: "cold", StartBlock: 7}}, subscriptions)
+}
+
+func TestTransactionsFollowsTheCursor(t *testing.T) {
+	address, _ := ethereum.ParseAddress(testAddress)
+	var queries []url.Values
+	c := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/addresses/"+address.Hex()+"/transactions", r.URL.Path)
+		queries = append(queries, r.URL.Query())
+		page := map[string]interface{}{"transactions": []parser.Transaction{{Hash: "0x01"}}, "nextCursor": "next"}
+		if r.URL.Query().Get("cursor") != "" {
+			page = map[string]interface{}{"transactions": []parser.Transaction{{Hash: "0x02"}}}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": page})
+	})
+
+	query := url.Values{"fromBlock": {"10"}}
+	var hashes []string
+	err := c.Transactions(context.Background(), address, query, func(transactions []parser.Transaction) error {
+		for _, tx := range transactions {
+			hashes = append(hashes, tx.Hash)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"0x01", "0x02"}, hashes)
+	assert.Equal(t, []url.Values{{"fromBlock": {"10"}}, {"fromBlock": {"10"}, "cursor": {"next"}}}, queries)
+	// the query of the caller is left untouched
+	assert.Equal(t, url.Values{"fromBlock": {"10"}}, query)
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		err    *Error
+	}{
+		{"envelope", http.StatusTooManyRequests, `{"error":{"code":"rate_limited","message":"slow down"}}`, &Error{Status: 429, Code: "rate_limited", Message: "slow down"}},
+		{"text", http.StatusForbidden, "the admin routes require the admin token\n", &Error{Status: 403, Message: "the admin routes require the admin token"}},
+		{"empty", http.StatusBadGateway, "", &Error{Status: 502, Message: "Bad Gateway"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestServer(t, func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			})
+			_, err := c.Subscriptions(context.Background())
+			var apiErr *Error
+			require.ErrorAs(t, err, &apiErr)
+			assert.Equal(t, tt.err, apiErr)
+		})
+	}
+}
+
+func TestExport(t *testing.T) {
+	address, _ := ethereum.ParseAddress(testAddress)
+	c := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/addresses/"+address.Hex()+"/transactions/export", r.URL.Path)
+		assert.Equal(t, url.Values{"format": {"csv"}, "columns": {"hash"}}, r.URL.Query())
+		assert.Equal(t, "Bearer tok", r.Header.Get("Authorization"))
+		_, _ = w.Write([]byte("hash\n0x01\n"))
+	})
+
+	var buf bytes.Buffer
+	require.NoError(t, c.Export(context.Background(), address, url.Values{"format": {"csv"}, "columns": {"hash"}}, &buf))
+	assert.Equal(t, "hash\n0x01\n", buf.String())
+
+	c = newTestServer(t, func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":{"code":"invalid_argument","message":"invalid format"}}`))
+	})
+	err := c.Export(context.Background(), address, nil, &buf)
+	assert.Equal(t, &Error{Status: 400, Code: "invalid_argument", Message: "invalid format"}, err)
+}
@@ -0,0 +1,422 @@
+// Package config loads the settings of the server from a YAML or JSON file, the environment and the command line
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/meirongdev/ethereum_parser/internal/ethereum"
+	"github.com/meirongdev/ethereum_parser/internal/parser"
+)
+
+// Config holds every setting, each field is set from, by increasing precedence,
+// its default, the config file, its environment variable and its flag.
+// The flag of a field is its section and its yaml key, e.g. -server.addr.
+type Config struct {
+	Server Server `yaml:"server"`
+	Node   Node   `yaml:"node"`
+	Parser Parser `yaml:"parser"`
+	Log    Log    `yaml:"log"`
+	Auth   Auth   `yaml:"auth"`
+	Limits Limits `yaml:"limits"`
+	Notify Notify `yaml:"notify"`
+	Health Health `yaml:"health"`
+}
+
+// Server are the settings of the HTTP server
+type Server struct {
+	Addr            string        `yaml:"addr" env:"SERVER_ADDR" help:"address the HTTP server listens on"`
+	ReadTimeout     time.Duration `yaml:"readTimeout" env:"SERVER_READ_TIMEOUT" help:"longest time to read a request"`
+	WriteTimeout    time.Duration `yaml:"writeTimeout" env:"SERVER_WRITE_TIMEOUT" help:"longest time to write a response, the streams are not bound by it"`
+	IdleTimeout     time.Duration `yaml:"idleTimeout" env:"SERVER_IDLE_TIMEOUT" help:"longest time an idle keep-alive connection stays open"`
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout" env:"SERVER_SHUTDOWN_TIMEOUT" help:"longest time to finish the requests in flight on shutdown"`
+}
+
+// Node are the settings of the JSON-RPC client
+type Node struct {
+	URL     string        `yaml:"url" env:"ETH_NODE_URL" secret:"url" help:"JSON-RPC endpoint of the Ethereum node"`
+	Timeout time.Duration `yaml:"timeout" env:"ETH_NODE_TIMEOUT" help:"longest time of one call to the node"`
+}
+
+// Parser are the settings of the block processing
+type Parser struct {
+	PollInterval     time.Duration `yaml:"pollInterval" env:"PARSER_POLL_INTERVAL" help:"expected time between two blocks, the head is polled when the next one is due"`
+	CatchUpInterval  time.Duration `yaml:"catchUpInterval" env:"PARSER_CATCH_UP_INTERVAL" help:"shortest time between two blocks while behind the head, 0 for no limit"`
+	MinBackoff       time.Duration `yaml:"minBackoff" env:"PARSER_MIN_BACKOFF" help:"wait after a failed round, doubled after every failure in a row"`
+	MaxBackoff       time.Duration `yaml:"maxBackoff" env:"PARSER_MAX_BACKOFF" help:"longest wait after failed rounds"`
+	Confirmations    int           `yaml:"confirmations" env:"PARSER_CONFIRMATIONS" help:"depth at which a transaction is confirmed, 0 disables confirmations"`
+	TxPoolInterval   time.Duration `yaml:"txPoolInterval" env:"PARSER_TXPOOL_INTERVAL" help:"how often the pending transactions are read with txpool_content, 0 disables the watching"`
+	PendingDropAfter time.Duration `yaml:"pendingDropAfter" env:"PARSER_PENDING_DROP_AFTER" help:"how long a pending transaction can be missing from the pool before it is reported dropped"`
+	Balances         bool          `yaml:"balances" env:"PARSER_BALANCES" help:"track the balances of the subscribed addresses and reconcile them with their transactions"`
+}
+
+// Log are the settings of the logger
+type Log struct {
+	Level  string `yaml:"level" env:"LOG_LEVEL" help:"debug, info, warn or error"`
+	Format string `yaml:"format" env:"LOG_FORMAT" help:"text or json"`
+}
+
+// Auth are the settings of the API keys
+type Auth struct {
+	AdminToken string `yaml:"adminToken" env:"API_ADMIN_TOKEN" secret:"true" help:"token of the admin routes, it enables the API keys"`
+	KeysFile   string `yaml:"keysFile" env:"API_KEYS_FILE" help:"file keeping the API keys and their subscriptions"`
+}
+
+// Limits are the rate limits and the subscription quota, 0 disables a limit
+type Limits struct {
+	IPRate            float64 `yaml:"ipRate" env:"RATE_LIMIT_IP" help:"requests per second of a client IP, bursts of twice as many"`
+	KeyRate           float64 `yaml:"keyRate" env:"RATE_LIMIT_KEY" help:"requests per second of an API key, bursts of twice as many"`
+	SubscriptionQuota int     `yaml:"subscriptionQuota" env:"SUBSCRIPTION_QUOTA" help:"most addresses a key, or the server without keys, can subscribe to"`
+}
+
+// Notify are the settings of the notifications
+type Notify struct {
+	File       string `yaml:"file" env:"NOTIFY_FILE" help:"file the events are appended to as NDJSON, - for stdout"`
+	WebhookURL string `yaml:"webhookUrl" env:"NOTIFY_WEBHOOK_URL" secret:"url" help:"URL every event is posted to"`
+	OutboxFile string `yaml:"outboxFile" env:"NOTIFY_OUTBOX_FILE" help:"file keeping the webhooks and their undelivered events"`
+}
+
+// Health are the thresholds of the liveness and readiness probes
+type Health struct {
+	MaxIdle   time.Duration `yaml:"maxIdle" env:"HEALTH_MAX_IDLE" help:"longest time the parser loop can make no progress and be alive"`
+	MaxLag    int           `yaml:"maxLag" env:"READY_MAX_LAG" help:"most blocks the parser can be behind the head and be ready"`
+	MaxRPCAge time.Duration `yaml:"maxRpcAge" env:"READY_MAX_RPC_AGE" help:"longest time since the last successful call to the node to be ready"`
+}
+
+// Default returns the settings used when nothing else is given
+func Default() Config {
+	return Config{
+		Server: Server{
+			Addr:            ":8080",
+			ReadTimeout:     10 * time.Second,
+			WriteTimeout:    10 * time.Second,
+			IdleTimeout:     15 * time.Second,
+			ShutdownTimeout: 5 * time.Second,
+		},
+		Node: Node{URL: ethereum.DefaultURL, Timeout: 10 * time.Second},
+		Parser: Parser{
+			PollInterval:     parser.DefaultPollInterval,
+			CatchUpInterval:  parser.DefaultCatchUpInterval,
+			MinBackoff:       parser.DefaultMinBackoff,
+			MaxBackoff:       parser.DefaultMaxBackoff,
+			Confirmations:    12,
+			PendingDropAfter: parser.DefaultPendingDropAfter,
+		},
+		Log:    Log{Level: "info", Format: "text"},
+		Limits: Limits{IPRate: 20, KeyRate: 50, SubscriptionQuota: 10000},
+		Health: Health{MaxIdle: 5 * time.Minute, MaxLag: 10, MaxRPCAge: 2 * time.Minute},
+	}
+}
+
+// ErrUsage wraps the errors of the flags which do not parse, the flag set already printed them with the usage
+var ErrUsage = errors.New("invalid arguments")
+
+// Load builds the config from the command line arguments and the environment,
+// the config file is given by the -config flag or the CONFIG_FILE variable.
+// define, when not nil, adds the flags of a command to the flag set before the arguments are parsed.
+// It returns the arguments left after the flags, and flag.ErrHelp when -h is given.
+func Load(name string, args []string, getenv func(string) string, define func(fs *flag.FlagSet)) (Config, []string, error) {
+	cfg := Default()
+	fs, flags := FlagSet(name, &cfg)
+	if define != nil {
+		define(fs)
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return cfg, nil, err
+		}
+		return cfg, nil, fmt.Errorf("%w: %w", ErrUsage, err)
+	}
+	if err := flags.Apply(getenv); err != nil {
+		return cfg, nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return cfg, nil, fmt.Errorf("invalid config:\n%w", err)
+	}
+	return cfg, fs.Args(), nil
+}
+
+// Flags are the settings given on a command line, they are applied over the file and the environment
+type Flags struct {
+	cfg        *Config
+	configFile string
+	values     map[string]string
+	order      []string
+}
+
+// FlagSet registers a flag for every setting of cfg, plus -config, on a new flag set.
+// The flags are only recorded while parsing, Flags.Apply sets cfg once the file and the environment are read.
+func FlagSet(name string, cfg *Config) (*flag.FlagSet, *Flags) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags := &Flags{cfg: cfg, values: make(map[string]string)}
+	fs.StringVar(&flags.configFile, "config", "", "YAML or JSON config file, CONFIG_FILE")
+	for _, f := range fields(cfg) {
+		key := f.flag
+		usage := fmt.Sprintf("%s, %s (default %s)", f.help, f.env, f.display())
+		record := func(raw string) error {
+			if err := f.set(raw); err != nil {
+				return err
+			}
+			if _, seen := flags.values[key]; !seen {
+				flags.order = append(flags.order, key)
+			}
+			flags.values[key] = raw
+			return nil
+		}
+		// a switch is turned on by the flag alone, like -parser.balances
+		if f.value.Kind() == reflect.Bool {
+			fs.BoolFunc(key, usage, record)
+			continue
+		}
+		fs.Func(key, usage, record)
+	}
+	return fs, flags
+}
+
+// Apply sets the config from the file, then the environment, then the parsed flags
+func (flags *Flags) Apply(getenv func(string) string) error {
+	// the flags were already set while parsing, start again from the defaults
+	*flags.cfg = Default()
+	path := flags.configFile
+	if path == "" {
+		path = getenv("CONFIG_FILE")
+	}
+	if path != "" {
+		if err := flags.cfg.readFile(path); err != nil {
+			return err
+		}
+	}
+	byFlag := make(map[string]field)
+	for _, f := range fields(flags.cfg) {
+		byFlag[f.flag] = f
+		if raw := getenv(f.env); raw != "" {
+			if err := f.set(raw); err != nil {
+				return fmt.Errorf("invalid %s: %w", f.env, err)
+			}
+		}
+	}
+	for _, key := range flags.order {
+		if err := byFlag[key].set(flags.values[key]); err != nil {
+			return fmt.Errorf("invalid -%s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// readFile reads a YAML or JSON file over the config, the settings missing from the file are kept
+func (c *Config) readFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open config file %w", err)
+	}
+	defer f.Close()
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	// JSON is a subset of YAML, the same decoder reads both
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("invalid config file %s %w", path, err)
+	}
+	return nil
+}
+
+// Validate checks the values of the settings
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	check(c.Server.Addr != "", "server.addr is required")
+	check(c.Server.ReadTimeout > 0, "server.readTimeout must be positive")
+	check(c.Server.WriteTimeout > 0, "server.writeTimeout must be positive")
+	check(c.Server.IdleTimeout > 0, "server.idleTimeout must be positive")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdownTimeout must be positive")
+	check(isHTTPURL(c.Node.URL), "node.url must be an http or https URL")
+	check(c.Node.Timeout > 0, "node.timeout must be positive")
+	check(c.Parser.PollInterval > 0, "parser.pollInterval must be positive")
+	check(c.Parser.CatchUpInterval >= 0, "parser.catchUpInterval cannot be negative")
+	check(c.Parser.MinBackoff > 0, "parser.minBackoff must be positive")
+	check(c.Parser.MaxBackoff >= c.Parser.MinBackoff, "parser.maxBackoff cannot be less than parser.minBackoff")
+	check(c.Parser.Confirmations >= 0, "parser.confirmations cannot be negative")
+	check(c.Parser.TxPoolInterval >= 0, "parser.txPoolInterval cannot be negative")
+	check(c.Parser.PendingDropAfter > 0, "parser.pendingDropAfter must be positive")
+	var level slog.Level
+	check(level.UnmarshalText([]byte(c.Log.Level)) == nil, "log.level %q must be debug, info, warn or error", c.Log.Level)
+	check(c.Log.Format == "text" || c.Log.Format == "json", "log.format %q must be text or json", c.Log.Format)
+	check(c.Limits.IPRate >= 0, "limits.ipRate cannot be negative")
+	check(c.Limits.KeyRate >= 0, "limits.keyRate cannot be negative")
+	check(c.Limits.SubscriptionQuota >= 0, "limits.subscriptionQuota cannot be negative")
+	check(c.Notify.WebhookURL == "" || isHTTPURL(c.Notify.WebhookURL), "notify.webhookUrl must be an http or https URL")
+	check(c.Health.MaxIdle > 0, "health.maxIdle must be positive")
+	check(c.Health.MaxLag >= 0, "health.maxLag cannot be negative")
+	check(c.Health.MaxRPCAge > 0, "health.maxRpcAge must be positive")
+	return errors.Join(errs...)
+}
+
+func isHTTPURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// Redacted returns a copy whose secrets are masked, the URLs only keep their scheme and host
+func (c Config) Redacted() Config {
+	for _, f := range fields(&c) {
+		if f.secret != "" {
+			f.value.SetString(redact(f.secret, f.value.String()))
+		}
+	}
+	return c
+}
+
+const redactedValue = "REDACTED"
+
+func redact(kind, value string) string {
+	if value == "" {
+		return ""
+	}
+	if kind != "url" {
+		return redactedValue
+	}
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" {
+		return redactedValue
+	}
+	if u.User == nil && (u.Path == "" || u.Path == "/") && u.RawQuery == "" {
+		return value
+	}
+	return u.Scheme + "://" + u.Host + "/" + redactedValue
+}
+
+// Write prints the config as YAML, with its secrets masked
+func (c Config) Write(w io.Writer) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(c.Redacted()); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// LogValue logs the config with its secrets masked, one group per section
+func (c Config) LogValue() slog.Value {
+	redacted := c.Redacted()
+	var sections []slog.Attr
+	var section string
+	var attrs []any
+	flush := func() {
+		if section != "" {
+			sections = append(sections, slog.Group(section, attrs...))
+		}
+	}
+	for _, f := range fields(&redacted) {
+		if f.section != section {
+			flush()
+			section, attrs = f.section, nil
+		}
+		if f.value.Kind() == reflect.String {
+			attrs = append(attrs, slog.String(f.key, f.value.String()))
+			continue
+		}
+		attrs = append(attrs, slog.String(f.key, f.display()))
+	}
+	flush()
+	return slog.GroupValue(sections...)
+}
+
+// field is a setting found by reflection on Config
+type field struct {
+	section, key, flag, env, help, secret string
+	value                                 reflect.Value
+}
+
+// fields lists the settings of the config in declaration order
+func fields(cfg *Config) []field {
+	var list []field
+	root := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < root.NumField(); i++ {
+		section := yamlKey(root.Type().Field(i))
+		sectionValue := root.Field(i)
+		for j := 0; j < sectionValue.NumField(); j++ {
+			sf := sectionValue.Type().Field(j)
+			key := yamlKey(sf)
+			list = append(list, field{
+				section: section,
+				key:     key,
+				flag:    section + "." + key,
+				env:     sf.Tag.Get("env"),
+				help:    sf.Tag.Get("help"),
+				secret:  sf.Tag.Get("secret"),
+				value:   sectionValue.Field(j),
+			})
+		}
+	}
+	return list
+}
+
+func yamlKey(sf reflect.StructField) string {
+	key, _, _ := strings.Cut(sf.Tag.Get("yaml"), ",")
+	return key
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// set parses a raw value from the environment or a flag into the field
+func (f field) set(raw string) error {
+	switch {
+	case f.value.Type() == durationType:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		f.value.SetInt(int64(d))
+	case f.value.Kind() == reflect.String:
+		f.value.SetString(raw)
+	case f.value.Kind() == reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", raw)
+		}
+		f.value.SetInt(int64(n))
+	case f.value.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", raw)
+		}
+		f.value.SetBool(b)
+	case f.value.Kind() == reflect.Float64:
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", raw)
+		}
+		f.value.SetFloat(n)
+	default:
+		return fmt.Errorf("unsupported setting type %s", f.value.Type())
+	}
+	return nil
+}
+
+// display formats the value of the field like it is given on the command line
+func (f field) display() string {
+	if f.value.Type() == durationType {
+		return time.Duration(f.value.Int()).String()
+	}
+	if f.value.Kind() == reflect.String {
+		if f.value.String() == "" {
+			return `""`
+		}
+		return f.value.String()
+	}
+	return fmt.Sprint(f.value.Interface())
+}
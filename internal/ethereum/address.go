@@ -0,0 +1,96 @@
+package ethereum
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrInvalidAddress is returned when a string is not a 0x prefixed 20-byte hex address
+	ErrInvalidAddress = errors.New("invalid address")
+	// ErrBadChecksum is returned when a mixed-case address does not match its EIP-55 checksum
+	ErrBadChecksum = errors.New("invalid address checksum")
+)
+
+// AddressLength is the number of bytes of an Ethereum address
+const AddressLength = 20
+
+// Address is a 20-byte Ethereum account address.
+// It is comparable so it can be used as a map key.
+type Address [AddressLength]byte
+
+// ParseAddress parses a 0x prefixed hex address.
+// All lower-case and all upper-case addresses are accepted as is,
+// mixed-case addresses must carry a valid EIP-55 checksum.
+func ParseAddress(s string) (Address, error) {
+	var addr Address
+	digits, ok := strings.CutPrefix(s, "0x")
+	if !ok {
+		digits, ok = strings.CutPrefix(s, "0X")
+	}
+	if !ok || len(digits) != 2*AddressLength {
+		return addr, fmt.Errorf("%w: %q", ErrInvalidAddress, s)
+	}
+	if _, err := hex.Decode(addr[:], []byte(digits)); err != nil {
+		return addr, fmt.Errorf("%w: %q", ErrInvalidAddress, s)
+	}
+	if digits != strings.ToLower(digits) && digits != strings.ToUpper(digits) {
+		if addr.Hex() != "0x"+digits {
+			return addr, fmt.Errorf("%w: %q", ErrBadChecksum, s)
+		}
+	}
+	return addr, nil
+}
+
+// IsZero reports whether the address is the zero address
+func (a Address) IsZero() bool {
+	return a == Address{}
+}
+
+// Hex returns the EIP-55 mixed-case checksummed form of the address
+func (a Address) Hex() string {
+	lower := hex.EncodeToString(a[:])
+	hash := Keccak256([]byte(lower))
+	checksummed := []byte(lower)
+	for i, c := range checksummed {
+		if c < 'a' {
+			continue
+		}
+		// each hex digit of the address is upper-cased when the matching nibble of the hash is >= 8
+		nibble := hash[i/2]
+		if i%2 == 0 {
+			nibble >>= 4
+		}
+		if nibble&0x0f >= 8 {
+			checksummed[i] = c - 'a' + 'A'
+		}
+	}
+	return "0x" + string(checksummed)
+}
+
+// String returns the checksummed form of the address
+func (a Address) String() string {
+	return a.Hex()
+}
+
+// MarshalJSON encodes the address as a checksummed hex string
+func (a Address) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.Hex())
+}
+
+// UnmarshalJSON decodes and validates a hex address string
+func (a *Address) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidAddress, data)
+	}
+	parsed, err := ParseAddress(s)
+	if err != nil {
+		return err
+	}
+	*a = parsed
+	return nil
+}
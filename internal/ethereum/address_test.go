@@ -0,0 +1,77 @@
+package ethereum
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeccak256(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"", "c5d2460186f7233c927e7db2dcc703c0e500b653ca82273b7bfad8045d85a470"},
+		{"abc", "4e03657aea45a94fc7d47ba826c8d667c0d1e6e33a64a036ec44f58fa12d6c45"},
+		// longer than one 136 byte block
+		{string(make([]byte, 200)), "e1bb54e1bc3af48d01e5dbfc81015c98152a574f6428c6948aa4837c9c0baad9"},
+	}
+
+	for _, tt := range tests {
+		digest := Keccak256([]byte(tt.input))
+		assert.Equal(t, tt.expected, hex.EncodeToString(digest[:]))
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		expected    string
+		expectedErr error
+	}{
+		{"EIP-55 vector 1", "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", nil},
+		{"EIP-55 vector 2", "0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359", "0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359", nil},
+		{"EIP-55 vector 3", "0xdbF03B407c01E7cD3CBea99509d93f8DDDC8C6FB", "0xdbF03B407c01E7cD3CBea99509d93f8DDDC8C6FB", nil},
+		{"EIP-55 vector 4", "0xD1220A0cf47c7B9Be7A2E6BA89F429762e7b9aDb", "0xD1220A0cf47c7B9Be7A2E6BA89F429762e7b9aDb", nil},
+		{"All lower case", "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", nil},
+		{"All upper case", "0x5AAEB6053F3E94C9B9A09F33669435E7EF1BEAED", "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", nil},
+		{"Bad checksum", "0x5AAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", "", ErrBadChecksum},
+		{"Missing prefix", "5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", "", ErrInvalidAddress},
+		{"Too short", "0x5aaeb6053f3e94c9b9a09f33669435e7ef1bea", "", ErrInvalidAddress},
+		{"Not hex", "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beazz", "", ErrInvalidAddress},
+		{"Not an address", "hello", "", ErrInvalidAddress},
+		{"Empty", "", "", ErrInvalidAddress},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, err := ParseAddress(tt.input)
+			if tt.expectedErr != nil {
+				assert.True(t, errors.Is(err, tt.expectedErr), "expected %v, got %v", tt.expectedErr, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, addr.Hex())
+		})
+	}
+}
+
+func TestAddressJSON(t *testing.T) {
+	addr, err := ParseAddress("0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed")
+	assert.NoError(t, err)
+
+	data, err := json.Marshal(addr)
+	assert.NoError(t, err)
+	assert.Equal(t, `"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"`, string(data))
+
+	var decoded Address
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, addr, decoded)
+
+	assert.ErrorIs(t, json.Unmarshal([]byte(`"0x5AAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"`), &decoded), ErrBadChecksum)
+	assert.ErrorIs(t, json.Unmarshal([]byte(`42`), &decoded), ErrInvalidAddress)
+}
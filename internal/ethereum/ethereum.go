@@ -2,84 +2,283 @@ package ethereum
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"sort"
 	"strings"
 	"time"
 )
 
-var (
-	ethNodeURL = "https://cloudflare-eth.com"
-)
+// DefaultURL is the JSON-RPC endpoint used without WithURL
+const DefaultURL = "https://cloudflare-eth.com"
 
 type API interface {
 	// GetCurrentBlock returns the current block number
 	GetCurrentBlock() (string, error)
 	// GetTransactions returns the list of transactions for the given block number
 	GetTransactions(blockNumber string) ([]interface{}, error)
+	// GetBlock returns the header fields and the transactions of the given block number
+	GetBlock(blockNumber string) (Block, error)
+}
+
+// TxPoolAPI reads the transaction pool of the node with txpool_content,
+// geth, erigon and nethermind serve it but most hosted endpoints do not
+type TxPoolAPI interface {
+	// GetTxPoolContent returns the transactions waiting in the pool of the node
+	GetTxPoolContent() (TxPool, error)
+}
+
+// TxPool are the transaction objects of the pool, Pending can be mined now and Queued wait for a nonce gap to be filled
+type TxPool struct {
+	Pending []interface{}
+	Queued  []interface{}
+}
+
+// BalanceAPI reads the balances of the accounts and the receipts of their transactions at a block
+type BalanceAPI interface {
+	// GetBalance returns the balance of the address at the end of the given block number
+	GetBalance(address Address, blockNumber string) (Wei, error)
+	// GetTransactionReceipt returns the outcome of a mined transaction
+	GetTransactionReceipt(hash string) (Receipt, error)
+}
+
+// Receipt is the part of an eth_getTransactionReceipt result needed to know how a transaction changed the balances
+type Receipt struct {
+	GasUsed uint64
+	// EffectiveGasPrice is zero when the node does not return it, as some clients do for the receipts from before London,
+	// the gas price of the transaction is then the price paid
+	EffectiveGasPrice Wei
+	// Success is false for a reverted transaction, its value was not transferred but its fee was paid
+	Success bool
+}
+
+// Fee returns what the sender paid for the gas of the transaction
+func (r Receipt) Fee() Wei {
+	return r.EffectiveGasPrice.MulUint64(r.GasUsed)
+}
+
+// Block is the part of an eth_getBlockByNumber result the parser uses,
+// the quantities are kept as the hex strings returned by the node
+type Block struct {
+	Number       string
+	Hash         string
+	ParentHash   string
+	Timestamp    string
+	Transactions []interface{}
 }
 
 type ethereumAPI struct {
+	url    string
 	client *http.Client
 }
 
-func NewEthereumAPI() API {
-	return &ethereumAPI{
+type Option func(*ethereumAPI)
+
+// WithURL sets the JSON-RPC endpoint of the node
+func WithURL(url string) Option {
+	return func(e *ethereumAPI) {
+		e.url = url
+	}
+}
+
+// WithTimeout bounds the time of one call to the node, 10s by default
+func WithTimeout(timeout time.Duration) Option {
+	return func(e *ethereumAPI) {
+		e.client.Timeout = timeout
+	}
+}
+
+// NewEthereumAPI creates a client of the node, it also implements TxPoolAPI and BalanceAPI
+func NewEthereumAPI(options ...Option) API {
+	e := &ethereumAPI{
+		url: DefaultURL,
 		client: &http.Client{
 			Timeout: 10 * time.Second,
 		},
 	}
+	for _, option := range options {
+		option(e)
+	}
+	return e
 }
 
 func (e *ethereumAPI) GetCurrentBlock() (string, error) {
-	reqBody := fmt.Sprintf(`{"jsonrpc":"2.0","method":"eth_blockNumber","params":[],"id":"%s"}`, generateID())
-	resp, err := e.client.Post(ethNodeURL, "application/json", strings.NewReader(reqBody))
-	if err != nil {
+	var hexBlock string
+	if err := e.call("eth_blockNumber", []interface{}{}, &hexBlock); err != nil {
 		return "", err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("StatusCode: %s", resp.Status)
-	}
+	return hexBlock, nil
+}
 
-	var result map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return "", err
+func (e *ethereumAPI) GetTransactions(blockNumber string) ([]interface{}, error) {
+	var txList []interface{}
+	block, err := e.fetchBlock(blockNumber)
+	if err != nil {
+		return txList, err
+	}
+	transactions, ok := block["transactions"].([]interface{})
+	if !ok || len(transactions) == 0 {
+		return txList, fmt.Errorf("no transactions found in block %s", blockNumber)
 	}
+	return transactions, nil
+}
 
-	hexBlock := result["result"].(string)
-	return hexBlock, nil
+func (e *ethereumAPI) GetBlock(blockNumber string) (Block, error) {
+	var block Block
+	result, err := e.fetchBlock(blockNumber)
+	if err != nil {
+		return block, err
+	}
+	block.Number, _ = result["number"].(string)
+	block.Hash, _ = result["hash"].(string)
+	block.ParentHash, _ = result["parentHash"].(string)
+	block.Timestamp, _ = result["timestamp"].(string)
+	if block.Number == "" || block.Hash == "" || block.Timestamp == "" {
+		return block, fmt.Errorf("missing header fields for block %s", blockNumber)
+	}
+	// an empty block is valid, it just has no transactions
+	block.Transactions, _ = result["transactions"].([]interface{})
+	return block, nil
 }
 
-func (e *ethereumAPI) GetTransactions(blockNumber string) ([]interface{}, error) {
+// fetchBlock calls eth_getBlockByNumber with full transaction objects and returns the block object
+func (e *ethereumAPI) fetchBlock(blockNumber string) (map[string]interface{}, error) {
 	// curl -X POST --data '{"jsonrpc":"2.0","method":"eth_getBlockByNumber","params":["0x1b4", true],"id":1}'
-	var txList []interface{}
 	reqBody := fmt.Sprintf(`{"jsonrpc":"2.0","method":"eth_getBlockByNumber","params":["%s", true],"id":"%s"}`, blockNumber, generateID())
-	resp, err := e.client.Post(ethNodeURL, "application/json", strings.NewReader(reqBody))
+	resp, err := e.post(reqBody)
 	if err != nil {
-		return txList, err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return txList, fmt.Errorf("StatusCode: %s", resp.Status)
+		return nil, fmt.Errorf("StatusCode: %s", resp.Status)
 	}
 
 	// Parse the JSON-RPC response
 	var result map[string]interface{}
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return txList, err
+		return nil, err
 	}
 
 	block, ok := result["result"].(map[string]interface{})
 	if !ok {
-		return txList, fmt.Errorf("invalid block data for block %s", blockNumber)
+		return nil, fmt.Errorf("invalid block data for block %s", blockNumber)
 	}
-	transactions, ok := block["transactions"].([]interface{})
-	if !ok || len(transactions) == 0 {
-		return txList, fmt.Errorf("no transactions found in block %s", blockNumber)
+	return block, nil
+}
+
+func (e *ethereumAPI) GetTxPoolContent() (TxPool, error) {
+	// the transactions are grouped by sender then by nonce
+	var content struct {
+		Pending map[string]map[string]interface{} `json:"pending"`
+		Queued  map[string]map[string]interface{} `json:"queued"`
 	}
-	return transactions, nil
+	if err := e.call("txpool_content", []interface{}{}, &content); err != nil {
+		return TxPool{}, err
+	}
+	return TxPool{Pending: flattenPool(content.Pending), Queued: flattenPool(content.Queued)}, nil
+}
+
+func (e *ethereumAPI) GetBalance(address Address, blockNumber string) (Wei, error) {
+	var balance string
+	if err := e.call("eth_getBalance", []interface{}{address.Hex(), blockNumber}, &balance); err != nil {
+		return Wei{}, err
+	}
+	return ParseWei(balance)
+}
+
+func (e *ethereumAPI) GetTransactionReceipt(hash string) (Receipt, error) {
+	var result struct {
+		GasUsed           string `json:"gasUsed"`
+		EffectiveGasPrice string `json:"effectiveGasPrice"`
+		Status            string `json:"status"`
+	}
+	if err := e.call("eth_getTransactionReceipt", []interface{}{hash}, &result); err != nil {
+		return Receipt{}, err
+	}
+	gasUsed, err := ParseWei(result.GasUsed)
+	if err != nil {
+		return Receipt{}, fmt.Errorf("invalid gasUsed of receipt %s %w", hash, err)
+	}
+	receipt := Receipt{GasUsed: gasUsed.Int().Uint64(), Success: result.Status == "0x1"}
+	if result.EffectiveGasPrice != "" {
+		if receipt.EffectiveGasPrice, err = ParseWei(result.EffectiveGasPrice); err != nil {
+			return Receipt{}, fmt.Errorf("invalid effectiveGasPrice of receipt %s %w", hash, err)
+		}
+	}
+	return receipt, nil
+}
+
+// flattenPool lists the transactions of a txpool_content section by sender and nonce
+func flattenPool(section map[string]map[string]interface{}) []interface{} {
+	senders := make([]string, 0, len(section))
+	for sender := range section {
+		senders = append(senders, sender)
+	}
+	sort.Strings(senders)
+	var transactions []interface{}
+	for _, sender := range senders {
+		nonces := make([]string, 0, len(section[sender]))
+		for nonce := range section[sender] {
+			nonces = append(nonces, nonce)
+		}
+		sort.Slice(nonces, func(i, j int) bool {
+			if len(nonces[i]) != len(nonces[j]) {
+				return len(nonces[i]) < len(nonces[j])
+			}
+			return nonces[i] < nonces[j]
+		})
+		for _, nonce := range nonces {
+			transactions = append(transactions, section[sender][nonce])
+		}
+	}
+	return transactions
+}
+
+// call sends a JSON-RPC request and decodes its result into out, the JSON-RPC errors are returned as errors
+func (e *ethereumAPI) call(method string, params []interface{}, out interface{}) error {
+	reqBody, err := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params, "id": generateID()})
+	if err != nil {
+		return err
+	}
+	resp, err := e.post(string(reqBody))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("StatusCode: %s", resp.Status)
+	}
+	var envelope struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return err
+	}
+	if envelope.Error != nil {
+		return fmt.Errorf("%s failed %d %s", method, envelope.Error.Code, envelope.Error.Message)
+	}
+	if len(envelope.Result) == 0 || string(envelope.Result) == "null" {
+		return fmt.Errorf("no result for %s", method)
+	}
+	return json.Unmarshal(envelope.Result, out)
+}
+
+// post sends a JSON-RPC request, its errors leave out the URL which often holds an API key
+func (e *ethereumAPI) post(reqBody string) (*http.Response, error) {
+	resp, err := e.client.Post(e.url, "application/json", strings.NewReader(reqBody))
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return nil, fmt.Errorf("%s node: %w", urlErr.Op, urlErr.Err)
+	}
+	return resp, err
 }
 
 func generateID() string {
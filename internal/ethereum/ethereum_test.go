@@ -1,9 +1,11 @@
 package ethereum
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -29,6 +31,20 @@ func TestGetCurrentBlock(t *testing.T) {
 			expectedBlock:  "",
 			expectError:    true,
 		},
+		{
+			name:           "JSON-RPC error with status OK",
+			mockResponse:   `{"jsonrpc":"2.0","error":{"code":-32005,"message":"rate limited"},"id":"1"}`,
+			mockStatusCode: http.StatusOK,
+			expectedBlock:  "",
+			expectError:    true,
+		},
+		{
+			name:           "Null result",
+			mockResponse:   `{"jsonrpc":"2.0","result":null,"id":"1"}`,
+			mockStatusCode: http.StatusOK,
+			expectedBlock:  "",
+			expectError:    true,
+		},
 		{
 			name:           "Invalid JSON response",
 			mockResponse:   `{"jsonrpc":"2.0","result":`,
@@ -47,10 +63,7 @@ func TestGetCurrentBlock(t *testing.T) {
 			}))
 			defer server.Close()
 
-			// Override the ethNodeURL with the mock server URL
-			ethNodeURL = server.URL
-
-			api := NewEthereumAPI()
+			api := NewEthereumAPI(WithURL(server.URL))
 			block, err := api.GetCurrentBlock()
 
 			if (err != nil) != tt.expectError {
@@ -72,10 +85,7 @@ func TestGetTransactionsWithResponseJsonFile(t *testing.T) {
 	}))
 	defer server.Close()
 
-	// Override the ethNodeURL with the mock server URL
-	ethNodeURL = server.URL
-
-	api := NewEthereumAPI()
+	api := NewEthereumAPI(WithURL(server.URL))
 	txs, err := api.GetTransactions("0x13bb16e")
 
 	if err != nil {
@@ -139,10 +149,7 @@ func TestGetTransactions(t *testing.T) {
 			}))
 			defer server.Close()
 
-			// Override the ethNodeURL with the mock server URL
-			ethNodeURL = server.URL
-
-			api := NewEthereumAPI()
+			api := NewEthereumAPI(WithURL(server.URL))
 			txs, err := api.GetTransactions(tt.blockNumber)
 
 			if (err != nil) != tt.expectError {
@@ -155,3 +162,245 @@ func TestGetTransactions(t *testing.T) {
 		})
 	}
 }
+
+// test GetBlock with the file in the testdata/eth_getblockbynumber.json
+func TestGetBlockWithResponseJsonFile(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, "testdata/eth_getblockbynumber.json")
+	}))
+	defer server.Close()
+
+	api := NewEthereumAPI(WithURL(server.URL))
+	block, err := api.GetBlock("0x13bb16e")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if block.Number != "0x13bb16e" {
+		t.Errorf("expected number 0x13bb16e, got %s", block.Number)
+	}
+	if block.Hash != "0x7432e1d2fad4c8a8ed16977e6f5762cca1f3a76c9836141df09cb2304338aea3" {
+		t.Errorf("unexpected hash %s", block.Hash)
+	}
+	if block.Timestamp != "0x66da8973" {
+		t.Errorf("expected timestamp 0x66da8973, got %s", block.Timestamp)
+	}
+	if len(block.Transactions) != 134 {
+		t.Errorf("expected 134 transaction, got %d", len(block.Transactions))
+	}
+}
+
+func TestGetBlock(t *testing.T) {
+	tests := []struct {
+		name           string
+		mockResponse   string
+		mockStatusCode int
+		expectedTxs    int
+		expectError    bool
+	}{
+		{
+			name:           "Block with transactions",
+			mockResponse:   `{"jsonrpc":"2.0","result":{"number":"0x1b4","hash":"0xab","parentHash":"0xaa","timestamp":"0x1","transactions":[{}, {}]},"id":"1"}`,
+			mockStatusCode: http.StatusOK,
+			expectedTxs:    2,
+			expectError:    false,
+		},
+		{
+			name:           "Empty block is not an error",
+			mockResponse:   `{"jsonrpc":"2.0","result":{"number":"0x1b4","hash":"0xab","parentHash":"0xaa","timestamp":"0x1","transactions":[]},"id":"1"}`,
+			mockStatusCode: http.StatusOK,
+			expectedTxs:    0,
+			expectError:    false,
+		},
+		{
+			name:           "Missing header fields",
+			mockResponse:   `{"jsonrpc":"2.0","result":{"transactions":[{}]},"id":"1"}`,
+			mockStatusCode: http.StatusOK,
+			expectedTxs:    0,
+			expectError:    true,
+		},
+		{
+			name:           "Block not found",
+			mockResponse:   `{"jsonrpc":"2.0","result":null,"id":"1"}`,
+			mockStatusCode: http.StatusOK,
+			expectedTxs:    0,
+			expectError:    true,
+		},
+		{
+			name:           "Error response from server",
+			mockResponse:   `{"jsonrpc":"2.0","error":{"code":-32603,"message":"Internal error"}}`,
+			mockStatusCode: http.StatusInternalServerError,
+			expectedTxs:    0,
+			expectError:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.mockStatusCode)
+				fmt.Fprintln(w, tt.mockResponse)
+			}))
+			defer server.Close()
+
+			api := NewEthereumAPI(WithURL(server.URL))
+			block, err := api.GetBlock("0x1b4")
+
+			if (err != nil) != tt.expectError {
+				t.Errorf("expected error: %v, got: %v", tt.expectError, err)
+			}
+
+			if len(block.Transactions) != tt.expectedTxs {
+				t.Errorf("expected %d transactions, got %d", tt.expectedTxs, len(block.Transactions))
+			}
+		})
+	}
+}
+
+func TestErrorsLeaveOutTheURL(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	api := NewEthereumAPI(WithURL(server.URL + "/v3/secret-key"))
+	_, err := api.GetCurrentBlock()
+	if err == nil || strings.Contains(err.Error(), "secret-key") {
+		t.Errorf("expected an error without the URL, got: %v", err)
+	}
+	_, err = api.GetBlock("0x1")
+	if err == nil || strings.Contains(err.Error(), "secret-key") {
+		t.Errorf("expected an error without the URL, got: %v", err)
+	}
+}
+
+func TestGetTxPoolContent(t *testing.T) {
+	tests := []struct {
+		name            string
+		mockResponse    string
+		expectedPending []string
+		expectedQueued  []string
+		expectError     bool
+	}{
+		{
+			name: "Successful response",
+			mockResponse: `{"jsonrpc":"2.0","id":"1","result":{
+				"pending":{"0xbb":{"10":{"hash":"0x03"},"9":{"hash":"0x02"}},"0xaa":{"1":{"hash":"0x01"}}},
+				"queued":{"0xaa":{"3":{"hash":"0x04"}}}}}`,
+			expectedPending: []string{"0x01", "0x02", "0x03"},
+			expectedQueued:  []string{"0x04"},
+		},
+		{
+			name:         "Method not supported",
+			mockResponse: `{"jsonrpc":"2.0","id":"1","error":{"code":-32601,"message":"the method txpool_content does not exist"}}`,
+			expectError:  true,
+		},
+	}
+
+	hashes := func(transactions []interface{}) []string {
+		var result []string
+		for _, tx := range transactions {
+			result = append(result, tx.(map[string]interface{})["hash"].(string))
+		}
+		return result
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintln(w, tt.mockResponse)
+			}))
+			defer server.Close()
+
+			api := NewEthereumAPI(WithURL(server.URL)).(TxPoolAPI)
+			pool, err := api.GetTxPoolContent()
+			if (err != nil) != tt.expectError {
+				t.Fatalf("expected error: %v, got: %v", tt.expectError, err)
+			}
+			if got := hashes(pool.Pending); fmt.Sprint(got) != fmt.Sprint(tt.expectedPending) {
+				t.Errorf("expected pending: %v, got: %v", tt.expectedPending, got)
+			}
+			if got := hashes(pool.Queued); fmt.Sprint(got) != fmt.Sprint(tt.expectedQueued) {
+				t.Errorf("expected queued: %v, got: %v", tt.expectedQueued, got)
+			}
+		})
+	}
+}
+
+func TestGetBalance(t *testing.T) {
+	var request struct {
+		Method string        `json:"method"`
+		Params []interface{} `json:"params"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&request)
+		fmt.Fprintln(w, `{"jsonrpc":"2.0","id":"1","result":"0x1bc16d674ec80000"}`)
+	}))
+	defer server.Close()
+
+	address, _ := ParseAddress("0xdac17f958d2ee523a2206206994597c13d831ec7")
+	balance, err := NewEthereumAPI(WithURL(server.URL)).(BalanceAPI).GetBalance(address, "0x10")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if balance.Ether() != "2" {
+		t.Errorf("expected 2 ether, got: %s", balance.Ether())
+	}
+	if request.Method != "eth_getBalance" || fmt.Sprint(request.Params) != "[0xdAC17F958D2ee523a2206206994597C13D831ec7 0x10]" {
+		t.Errorf("unexpected request: %s %v", request.Method, request.Params)
+	}
+}
+
+func TestGetTransactionReceipt(t *testing.T) {
+	tests := []struct {
+		name         string
+		mockResponse string
+		expectedFee  string
+		expectedOK   bool
+		expectError  bool
+	}{
+		{
+			name:         "Successful transaction",
+			mockResponse: `{"jsonrpc":"2.0","id":"1","result":{"gasUsed":"0x5208","effectiveGasPrice":"0x3b9aca00","status":"0x1"}}`,
+			expectedFee:  "21000000000000",
+			expectedOK:   true,
+		},
+		{
+			name:         "Reverted transaction",
+			mockResponse: `{"jsonrpc":"2.0","id":"1","result":{"gasUsed":"0x5208","effectiveGasPrice":"0x1","status":"0x0"}}`,
+			expectedFee:  "21000",
+		},
+		{
+			name:         "Receipt without effectiveGasPrice",
+			mockResponse: `{"jsonrpc":"2.0","id":"1","result":{"gasUsed":"0x5208","status":"0x1"}}`,
+			expectedFee:  "0",
+			expectedOK:   true,
+		},
+		{
+			name:         "Invalid effectiveGasPrice",
+			mockResponse: `{"jsonrpc":"2.0","id":"1","result":{"gasUsed":"0x5208","effectiveGasPrice":"0xzz","status":"0x1"}}`,
+			expectError:  true,
+		},
+		{
+			name:         "Unknown transaction",
+			mockResponse: `{"jsonrpc":"2.0","id":"1","result":null}`,
+			expectError:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintln(w, tt.mockResponse)
+			}))
+			defer server.Close()
+
+			receipt, err := NewEthereumAPI(WithURL(server.URL)).(BalanceAPI).GetTransactionReceipt("0x01")
+			if (err != nil) != tt.expectError {
+				t.Fatalf("expected error: %v, got: %v", tt.expectError, err)
+			}
+			if tt.expectError {
+				return
+			}
+			if receipt.Fee().String() != tt.expectedFee || receipt.Success != tt.expectedOK {
+				t.Errorf("expected fee %s and success %v, got: %s and %v", tt.expectedFee, tt.expectedOK, receipt.Fee(), receipt.Success)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package ethereum
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// Keccak-256 as used by Ethereum, it is the original Keccak submission
+// with 0x01 padding and not the FIPS-202 SHA3-256 which pads with 0x06.
+
+const keccak256Rate = 136
+
+var keccakRoundConstants = [24]uint64{
+	0x0000000000000001, 0x0000000000008082, 0x800000000000808A, 0x8000000080008000,
+	0x000000000000808B, 0x0000000080000001, 0x8000000080008081, 0x8000000000008009,
+	0x000000000000008A, 0x0000000000000088, 0x0000000080008009, 0x000000008000000A,
+	0x000000008000808B, 0x800000000000008B, 0x8000000000008089, 0x8000000000008003,
+	0x8000000000008002, 0x8000000000000080, 0x000000000000800A, 0x800000008000000A,
+	0x8000000080008081, 0x8000000000008080, 0x0000000080000001, 0x8000000080008008,
+}
+
+var keccakRotations = [25]int{
+	0, 1, 62, 28, 27,
+	36, 44, 6, 55, 20,
+	3, 10, 43, 25, 39,
+	41, 45, 15, 21, 8,
+	18, 2, 61, 56, 14,
+}
+
+// Keccak256 returns the Keccak-256 digest of data
+func Keccak256(data []byte) [32]byte {
+	var state [25]uint64
+
+	padded := make([]byte, 0, len(data)+keccak256Rate)
+	padded = append(padded, data...)
+	padded = append(padded, 0x01)
+	for len(padded)%keccak256Rate != 0 {
+		padded = append(padded, 0x00)
+	}
+	padded[len(padded)-1] |= 0x80
+
+	for offset := 0; offset < len(padded); offset += keccak256Rate {
+		for i := 0; i < keccak256Rate/8; i++ {
+			state[i] ^= binary.LittleEndian.Uint64(padded[offset+i*8:])
+		}
+		keccakF1600(&state)
+	}
+
+	var digest [32]byte
+	for i := 0; i < 4; i++ {
+		binary.LittleEndian.PutUint64(digest[i*8:], state[i])
+	}
+	return digest
+}
+
+func keccakF1600(a *[25]uint64) {
+	var b [25]uint64
+	var c [5]uint64
+	for round := 0; round < 24; round++ {
+		// theta
+		for x := 0; x < 5; x++ {
+			c[x] = a[x] ^ a[x+5] ^ a[x+10] ^ a[x+15] ^ a[x+20]
+		}
+		for x := 0; x < 5; x++ {
+			d := c[(x+4)%5] ^ bits.RotateLeft64(c[(x+1)%5], 1)
+			for y := 0; y < 25; y += 5 {
+				a[y+x] ^= d
+			}
+		}
+		// rho and pi
+		for x := 0; x < 5; x++ {
+			for y := 0; y < 5; y++ {
+				b[y+5*((2*x+3*y)%5)] = bits.RotateLeft64(a[x+5*y], keccakRotations[x+5*y])
+			}
+		}
+		// chi
+		for y := 0; y < 25; y += 5 {
+			for x := 0; x < 5; x++ {
+				a[y+x] = b[y+x] ^ (^b[y+(x+1)%5] & b[y+(x+2)%5])
+			}
+		}
+		// iota
+		a[0] ^= keccakRoundConstants[round]
+	}
+}
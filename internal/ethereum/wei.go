@@ -0,0 +1,173 @@
+package ethereum
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+var (
+	gweiFactor  = big.NewInt(1_000_000_000)
+	etherFactor = new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
+)
+
+// Wei is an amount of ether denominated in wei.
+// It wraps a big.Int so values above 2^63 keep their full precision, the zero value is 0 wei.
+type Wei struct {
+	v *big.Int
+}
+
+// NewWei returns a Wei holding a copy of the given value
+func NewWei(v *big.Int) Wei {
+	if v == nil {
+		return Wei{}
+	}
+	return Wei{v: new(big.Int).Set(v)}
+}
+
+// ParseWei parses a JSON-RPC hex quantity such as "0x11c37937e08000", signs are rejected
+func ParseWei(hexStr string) (Wei, error) {
+	digits, ok := strings.CutPrefix(hexStr, "0x")
+	if !ok || !onlyDigits(digits, "0123456789abcdefABCDEF") {
+		return Wei{}, fmt.Errorf("invalid hex quantity %q", hexStr)
+	}
+	v, _ := new(big.Int).SetString(digits, 16)
+	return Wei{v: v}, nil
+}
+
+// ParseWeiDecimal parses a base 10 amount of wei such as "5000000000000000", signs are rejected
+func ParseWeiDecimal(s string) (Wei, error) {
+	if !onlyDigits(s, "0123456789") {
+		return Wei{}, fmt.Errorf("invalid decimal amount %q", s)
+	}
+	v, _ := new(big.Int).SetString(s, 10)
+	return Wei{v: v}, nil
+}
+
+// onlyDigits tells if s is not empty and only made of the digits,
+// big.Int.SetString alone also accepts a sign and underscores
+func onlyDigits(s, digits string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if !strings.ContainsRune(digits, c) {
+			return false
+		}
+	}
+	return true
+}
+
+// Int returns a copy of the amount as a big.Int
+func (w Wei) Int() *big.Int {
+	if w.v == nil {
+		return new(big.Int)
+	}
+	return new(big.Int).Set(w.v)
+}
+
+// IsZero reports whether the amount is 0 wei
+func (w Wei) IsZero() bool {
+	return w.v == nil || w.v.Sign() == 0
+}
+
+// Cmp compares two amounts and returns -1, 0 or +1
+func (w Wei) Cmp(other Wei) int {
+	return w.Int().Cmp(other.Int())
+}
+
+// Add returns w + other
+func (w Wei) Add(other Wei) Wei {
+	return Wei{v: new(big.Int).Add(w.Int(), other.Int())}
+}
+
+// Sub returns w - other, it is negative when other is larger
+func (w Wei) Sub(other Wei) Wei {
+	return Wei{v: new(big.Int).Sub(w.Int(), other.Int())}
+}
+
+// MulUint64 returns w * n, it is used to turn a per gas price into a fee
+func (w Wei) MulUint64(n uint64) Wei {
+	return Wei{v: new(big.Int).Mul(w.Int(), new(big.Int).SetUint64(n))}
+}
+
+// String returns the amount in wei as a decimal string
+func (w Wei) String() string {
+	return w.Int().String()
+}
+
+// Gwei returns the amount in gwei as an exact decimal string
+func (w Wei) Gwei() string {
+	return formatUnits(w.Int(), gweiFactor, 9)
+}
+
+// Ether returns the amount in ether as an exact decimal string
+func (w Wei) Ether() string {
+	return formatUnits(w.Int(), etherFactor, 18)
+}
+
+// formatUnits divides v by factor (10^decimals) without going through floats
+func formatUnits(v, factor *big.Int, decimals int) string {
+	sign := ""
+	if v.Sign() < 0 {
+		sign = "-"
+		v = new(big.Int).Neg(v)
+	}
+	quo, rem := new(big.Int).QuoRem(v, factor, new(big.Int))
+	if rem.Sign() == 0 {
+		return sign + quo.String()
+	}
+	frac := fmt.Sprintf("%0*s", decimals, rem.String())
+	return sign + quo.String() + "." + strings.TrimRight(frac, "0")
+}
+
+type weiJSON struct {
+	Wei   string `json:"wei"`
+	Gwei  string `json:"gwei"`
+	Ether string `json:"eth"`
+}
+
+// MarshalJSON encodes the amount in wei, gwei and ether, all as strings so no precision is lost
+func (w Wei) MarshalJSON() ([]byte, error) {
+	return json.Marshal(weiJSON{
+		Wei:   w.String(),
+		Gwei:  w.Gwei(),
+		Ether: w.Ether(),
+	})
+}
+
+// UnmarshalJSON accepts the object written by MarshalJSON,
+// a decimal wei string or a hex quantity string
+func (w *Wei) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		parsed, err := parseWeiString(s)
+		if err != nil {
+			return err
+		}
+		*w = parsed
+		return nil
+	}
+	var obj weiJSON
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return fmt.Errorf("invalid wei amount %s", data)
+	}
+	// a difference such as the change of a balance is written negative
+	parsed, err := ParseWeiDecimal(strings.TrimPrefix(obj.Wei, "-"))
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(obj.Wei, "-") {
+		parsed = Wei{v: parsed.v.Neg(parsed.v)}
+	}
+	*w = parsed
+	return nil
+}
+
+func parseWeiString(s string) (Wei, error) {
+	if strings.HasPrefix(s, "0x") {
+		return ParseWei(s)
+	}
+	return ParseWeiDecimal(s)
+}
@@ -0,0 +1,131 @@
+package ethereum
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseWei(t *testing.T) {
+	tests := []struct {
+		hexStr   string
+		expected string
+		hasError bool
+	}{
+		{"0x0", "0", false},
+		{"0x11c37937e08000", "5000000000000000", false},
+		{"0x8000000000000000", "9223372036854775808", false},
+		{"0xffffffffffffffffffffffffffffffff", "340282366920938463463374607431768211455", false},
+		{"0x", "", true},
+		{"1000", "", true},
+		{"0xG", "", true},
+		{"", "", true},
+		{"0x-1", "", true},
+		{"0x+1", "", true},
+		{"0x_1", "", true},
+		{"0x 1", "", true},
+	}
+
+	for _, tt := range tests {
+		w, err := ParseWei(tt.hexStr)
+		if (err != nil) != tt.hasError {
+			t.Errorf("ParseWei(%s) error = %v, expected error = %v", tt.hexStr, err, tt.hasError)
+		}
+		if err == nil && w.String() != tt.expected {
+			t.Errorf("ParseWei(%s) = %s, expected %s", tt.hexStr, w.String(), tt.expected)
+		}
+	}
+}
+
+func TestParseWeiDecimal(t *testing.T) {
+	tests := []struct {
+		s        string
+		expected string
+		hasError bool
+	}{
+		{"0", "0", false},
+		{"5000000000000000", "5000000000000000", false},
+		{"340282366920938463463374607431768211455", "340282366920938463463374607431768211455", false},
+		{"", "", true},
+		{"-5", "", true},
+		{"+5", "", true},
+		{"1_000", "", true},
+		{"0x10", "", true},
+		{"1.5", "", true},
+	}
+
+	for _, tt := range tests {
+		w, err := ParseWeiDecimal(tt.s)
+		if (err != nil) != tt.hasError {
+			t.Errorf("ParseWeiDecimal(%s) error = %v, expected error = %v", tt.s, err, tt.hasError)
+		}
+		if err == nil && w.String() != tt.expected {
+			t.Errorf("ParseWeiDecimal(%s) = %s, expected %s", tt.s, w.String(), tt.expected)
+		}
+	}
+}
+
+func TestWeiUnits(t *testing.T) {
+	tests := []struct {
+		wei   string
+		gwei  string
+		ether string
+	}{
+		{"0", "0", "0"},
+		{"1", "0.000000001", "0.000000000000000001"},
+		{"20000000000", "20", "0.00000002"},
+		{"1000000000000000000", "1000000000", "1"},
+		{"1500000000000000000", "1500000000", "1.5"},
+		{"340282366920938463463374607431768211455", "340282366920938463463374607431.768211455", "340282366920938463463.374607431768211455"},
+	}
+
+	for _, tt := range tests {
+		w, err := ParseWeiDecimal(tt.wei)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.gwei, w.Gwei())
+		assert.Equal(t, tt.ether, w.Ether())
+	}
+
+	var zero Wei
+	assert.True(t, zero.IsZero())
+	assert.Equal(t, "0", zero.Ether())
+}
+
+func TestWeiJSON(t *testing.T) {
+	w, err := ParseWei("0x8000000000000000")
+	assert.NoError(t, err)
+
+	data, err := json.Marshal(w)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"wei":"9223372036854775808","gwei":"9223372036.854775808","eth":"9.223372036854775808"}`, string(data))
+
+	var decoded Wei
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, 0, w.Cmp(decoded))
+
+	assert.NoError(t, json.Unmarshal([]byte(`"0x8000000000000000"`), &decoded))
+	assert.Equal(t, 0, w.Cmp(decoded))
+
+	assert.NoError(t, json.Unmarshal([]byte(`"9223372036854775808"`), &decoded))
+	assert.Equal(t, 0, w.Cmp(decoded))
+
+	assert.Error(t, json.Unmarshal([]byte(`12`), &decoded))
+	assert.Error(t, json.Unmarshal([]byte(`"abc"`), &decoded))
+	assert.Error(t, json.Unmarshal([]byte(`"-5"`), &decoded))
+
+	// the object form keeps the sign of a difference
+	negative := Wei{}.Sub(w)
+	data, err = json.Marshal(negative)
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, 0, negative.Cmp(decoded))
+}
+
+func TestWeiSub(t *testing.T) {
+	a, _ := ParseWeiDecimal("1000000000000000000")
+	b, _ := ParseWeiDecimal("1500000000000000000")
+	assert.Equal(t, "500000000000000000", b.Sub(a).String())
+	assert.Equal(t, "-0.5", a.Sub(b).Ether())
+	assert.Equal(t, "1", a.Sub(Wei{}).Ether())
+}
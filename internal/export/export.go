@@ -0,0 +1,301 @@
+// Package export writes transactions as CSV or NDJSON rows, one transaction at a time
+// so that an export of any size is streamed instead of buffered
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	// the time zones are embedded since containers often have no zoneinfo
+	_ "time/tzdata"
+
+	"github.com/meirongdev/ethereum_parser/internal/ethereum"
+	"github.com/meirongdev/ethereum_parser/internal/parser"
+)
+
+type Format string
+
+const (
+	FormatCSV    Format = "csv"
+	FormatNDJSON Format = "ndjson"
+)
+
+func ParseFormat(raw string) (Format, error) {
+	switch format := Format(raw); format {
+	case FormatCSV, FormatNDJSON:
+		return format, nil
+	}
+	return "", fmt.Errorf("invalid format %q, expected csv or ndjson", raw)
+}
+
+// ContentType is the media type of the format
+func (f Format) ContentType() string {
+	if f == FormatNDJSON {
+		return "application/x-ndjson"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// Unit is the unit of the amount columns
+type Unit string
+
+const (
+	UnitWei  Unit = "wei"
+	UnitGwei Unit = "gwei"
+	UnitEth  Unit = "eth"
+)
+
+func ParseUnit(raw string) (Unit, error) {
+	switch unit := Unit(raw); unit {
+	case UnitWei, UnitGwei, UnitEth:
+		return unit, nil
+	}
+	return "", fmt.Errorf("invalid units %q, expected wei, gwei or eth", raw)
+}
+
+func (u Unit) format(w ethereum.Wei) string {
+	switch u {
+	case UnitGwei:
+		return w.Gwei()
+	case UnitEth:
+		return w.Ether()
+	}
+	return w.String()
+}
+
+// TimeUnix formats the timestamps as unix seconds
+const TimeUnix = "unix"
+
+// ParseTimeFormat accepts rfc3339, unix or a Go reference layout such as 2006-01-02 15:04:05
+func ParseTimeFormat(raw string) (string, error) {
+	switch raw {
+	case "rfc3339":
+		return time.RFC3339, nil
+	case TimeUnix:
+		return TimeUnix, nil
+	}
+	// a layout without any reference element would print itself for every time
+	if raw == "" || time.Unix(0, 0).UTC().Format(raw) == raw {
+		return "", fmt.Errorf("invalid time format %q, expected rfc3339, unix or a layout such as 2006-01-02 15:04:05", raw)
+	}
+	return raw, nil
+}
+
+// column is a field of the rows, json is its NDJSON value
+type column struct {
+	value func(tx parser.Transaction, opts Options) string
+	json  func(tx parser.Transaction, opts Options) interface{}
+}
+
+func text(value func(tx parser.Transaction, opts Options) string) column {
+	return column{value: value, json: func(tx parser.Transaction, opts Options) interface{} { return value(tx, opts) }}
+}
+
+func integer(value func(tx parser.Transaction) int64) column {
+	return column{
+		value: func(tx parser.Transaction, _ Options) string { return strconv.FormatInt(value(tx), 10) },
+		json:  func(tx parser.Transaction, _ Options) interface{} { return value(tx) },
+	}
+}
+
+// amount is formatted in the unit of the options, as a string in NDJSON so that no precision is lost
+func amount(value func(tx parser.Transaction) ethereum.Wei) column {
+	return text(func(tx parser.Transaction, opts Options) string { return opts.Unit.format(value(tx)) })
+}
+
+var columns = map[string]column{
+	"hash":        text(func(tx parser.Transaction, _ Options) string { return tx.Hash }),
+	"blockNumber": integer(func(tx parser.Transaction) int64 { return int64(tx.BlockNumber) }),
+	"blockHash":   text(func(tx parser.Transaction, _ Options) string { return tx.BlockHash }),
+	"timestamp": {
+		value: func(tx parser.Transaction, opts Options) string { return opts.formatTime(tx.BlockTimestamp) },
+		json: func(tx parser.Transaction, opts Options) interface{} {
+			if opts.TimeFormat == TimeUnix {
+				return tx.BlockTimestamp.Unix()
+			}
+			return opts.formatTime(tx.BlockTimestamp)
+		},
+	},
+	"transactionIndex":     integer(func(tx parser.Transaction) int64 { return int64(tx.TransactionIndex) }),
+	"direction":            text(func(tx parser.Transaction, _ Options) string { return string(tx.Direction) }),
+	"from":                 text(func(tx parser.Transaction, _ Options) string { return tx.From.Hex() }),
+	"to":                   text(func(tx parser.Transaction, _ Options) string { return tx.To.Hex() }),
+	"value":                amount(func(tx parser.Transaction) ethereum.Wei { return tx.Value }),
+	"gas":                  integer(func(tx parser.Transaction) int64 { return int64(tx.Gas) }),
+	"gasPrice":             amount(func(tx parser.Transaction) ethereum.Wei { return tx.GasPrice }),
+	"maxFee":               amount(parser.Transaction.MaxFee),
+	"maxFeePerGas":         amount(func(tx parser.Transaction) ethereum.Wei { return tx.MaxFeePerGas }),
+	"maxPriorityFeePerGas": amount(func(tx parser.Transaction) ethereum.Wei { return tx.MaxPriorityFeePerGas }),
+	"nonce":                integer(func(tx parser.Transaction) int64 { return int64(tx.Nonce) }),
+	"type":                 integer(func(tx parser.Transaction) int64 { return int64(tx.Type) }),
+	"status":               text(func(tx parser.Transaction, _ Options) string { return string(tx.Status) }),
+}
+
+// Columns are the names of all the columns
+var Columns = []string{
+	"hash", "blockNumber", "blockHash", "timestamp", "transactionIndex", "direction", "from", "to",
+	"value", "gas", "gasPrice", "maxFee", "maxFeePerGas", "maxPriorityFeePerGas", "nonce", "type", "status",
+}
+
+// DefaultColumns are exported when no columns are selected
+var DefaultColumns = []string{"timestamp", "blockNumber", "hash", "direction", "from", "to", "value", "maxFee", "status"}
+
+// ParseColumns reads comma separated column names, their order is the order of the output
+func ParseColumns(raw string) ([]string, error) {
+	var names []string
+	seen := map[string]bool{}
+	for _, name := range strings.Split(raw, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("unknown column %q, expected some of %s", name, strings.Join(Columns, ","))
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("column %q is repeated", name)
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no columns in %q", raw)
+	}
+	return names, nil
+}
+
+// Options select the shape of the output, the zero value is replaced by the defaults
+type Options struct {
+	Format  Format
+	Columns []string
+	Unit    Unit
+	// TimeFormat is TimeUnix or a layout of the time package
+	TimeFormat string
+	// Location is the time zone of the timestamps
+	Location *time.Location
+}
+
+func (o Options) withDefaults() Options {
+	if o.Format == "" {
+		o.Format = FormatCSV
+	}
+	if len(o.Columns) == 0 {
+		o.Columns = DefaultColumns
+	}
+	if o.Unit == "" {
+		o.Unit = UnitWei
+	}
+	if o.TimeFormat == "" {
+		o.TimeFormat = time.RFC3339
+	}
+	if o.Location == nil {
+		o.Location = time.UTC
+	}
+	return o
+}
+
+func (o Options) formatTime(t time.Time) string {
+	if o.TimeFormat == TimeUnix {
+		return strconv.FormatInt(t.Unix(), 10)
+	}
+	return t.In(o.Location).Format(o.TimeFormat)
+}
+
+// Writer writes transactions as the rows of an export, the CSV header is written with the first row
+type Writer struct {
+	opts    Options
+	columns []column
+	buf     *bufio.Writer
+	csv     *csv.Writer
+	header  bool
+	row     []string
+}
+
+// NewWriter validates the options, nothing is written to w before the first Write or Flush
+func NewWriter(w io.Writer, opts Options) (*Writer, error) {
+	opts = opts.withDefaults()
+	if _, err := ParseFormat(string(opts.Format)); err != nil {
+		return nil, err
+	}
+	if _, err := ParseUnit(string(opts.Unit)); err != nil {
+		return nil, err
+	}
+	x := &Writer{opts: opts, buf: bufio.NewWriter(w)}
+	for _, name := range opts.Columns {
+		col, ok := columns[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+		x.columns = append(x.columns, col)
+	}
+	if opts.Format == FormatCSV {
+		x.csv = csv.NewWriter(x.buf)
+		x.row = make([]string, len(x.columns))
+	}
+	return x, nil
+}
+
+// Format is the format of the rows, csv when the options left it empty
+func (x *Writer) Format() Format {
+	return x.opts.Format
+}
+
+func (x *Writer) writeHeader() error {
+	if x.header || x.csv == nil {
+		return nil
+	}
+	x.header = true
+	return x.csv.Write(x.opts.Columns)
+}
+
+// Write adds the row of a transaction, it is buffered until Flush
+func (x *Writer) Write(tx parser.Transaction) error {
+	if x.csv != nil {
+		if err := x.writeHeader(); err != nil {
+			return err
+		}
+		for i, col := range x.columns {
+			x.row[i] = col.value(tx, x.opts)
+		}
+		return x.csv.Write(x.row)
+	}
+	return x.writeJSON(tx)
+}
+
+// writeJSON writes an object with the keys in the order of the columns, which a map would not keep
+func (x *Writer) writeJSON(tx parser.Transaction) error {
+	x.buf.WriteByte('{')
+	for i, col := range x.columns {
+		if i > 0 {
+			x.buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(x.opts.Columns[i])
+		value, err := json.Marshal(col.json(tx, x.opts))
+		if err != nil {
+			return err
+		}
+		x.buf.Write(key)
+		x.buf.WriteByte(':')
+		x.buf.Write(value)
+	}
+	x.buf.WriteByte('}')
+	return x.buf.WriteByte('\n')
+}
+
+// Flush writes the buffered rows to the underlying writer
+func (x *Writer) Flush() error {
+	if x.csv != nil {
+		if err := x.writeHeader(); err != nil {
+			return err
+		}
+		x.csv.Flush()
+		if err := x.csv.Error(); err != nil {
+			return err
+		}
+	}
+	return x.buf.Flush()
+}
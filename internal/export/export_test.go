@@ -0,0 +1,127 @@
+package export
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/meirongdev/ethereum_parser/internal/ethereum"
+	"github.com/meirongdev/ethereum_parser/internal/parser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testTransaction() parser.Transaction {
+	from, _ := ethereum.ParseAddress("0x00000000000000000000000000000000000000aa")
+	to, _ := ethereum.ParseAddress("0x00000000000000000000000000000000000000bb")
+	return parser.Transaction{
+		Hash:           "0x01",
+		From:           from,
+		To:             to,
+		Value:          ethereum.NewWei(big.NewInt(1500000000000000000)),
+		Gas:            21000,
+		GasPrice:       ethereum.NewWei(big.NewInt(2000000000)),
+		Nonce:          7,
+		BlockNumber:    100,
+		BlockHash:      "0xbb",
+		BlockTimestamp: time.Date(2024, 3, 1, 23, 30, 0, 0, time.UTC),
+		Direction:      parser.DirectionIn,
+		Status:         parser.StatusConfirmed,
+	}
+}
+
+func TestWriter(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     Options
+		expected string
+	}{
+		{
+			name:     "csv defaults",
+			opts:     Options{},
+			expected: "timestamp,blockNumber,hash,direction,from,to,value,maxFee,status\n2024-03-01T23:30:00Z,100,0x01,in,0x00000000000000000000000000000000000000AA,0x00000000000000000000000000000000000000bb,1500000000000000000,42000000000000,confirmed\n",
+		},
+		{
+			name:     "csv in ether with a time zone",
+			opts:     Options{Columns: []string{"hash", "value", "gasPrice", "timestamp"}, Unit: UnitEth, TimeFormat: "2006-01-02 15:04", Location: time.FixedZone("SGT", 8*3600)},
+			expected: "hash,value,gasPrice,timestamp\n0x01,1.5,0.000000002,2024-03-02 07:30\n",
+		},
+		{
+			name:     "ndjson keeps the column order",
+			opts:     Options{Format: FormatNDJSON, Columns: []string{"value", "blockNumber", "timestamp", "to"}, Unit: UnitGwei, TimeFormat: TimeUnix},
+			expected: `{"value":"1500000000","blockNumber":100,"timestamp":1709335800,"to":"0x00000000000000000000000000000000000000bb"}` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := NewWriter(&buf, tt.opts)
+			require.NoError(t, err)
+			require.NoError(t, w.Write(testTransaction()))
+			require.NoError(t, w.Flush())
+			assert.Equal(t, tt.expected, buf.String())
+		})
+	}
+}
+
+func TestWriterStreams(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, Options{Columns: []string{"hash"}})
+	require.NoError(t, err)
+	// nothing is written before the first flush, so an invalid request can still get an error status
+	assert.Empty(t, buf.String())
+	require.NoError(t, w.Flush())
+	assert.Equal(t, "hash\n", buf.String())
+
+	require.NoError(t, w.Write(testTransaction()))
+	require.NoError(t, w.Flush())
+	require.NoError(t, w.Write(testTransaction()))
+	require.NoError(t, w.Flush())
+	assert.Equal(t, "hash\n0x01\n0x01\n", buf.String())
+}
+
+func TestParseColumns(t *testing.T) {
+	columns, err := ParseColumns(" to, hash ,")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"to", "hash"}, columns)
+
+	for _, raw := range []string{"", ",", "hash,nope", "hash,hash"} {
+		_, err := ParseColumns(raw)
+		assert.Error(t, err, raw)
+	}
+	for _, name := range Columns {
+		_, err := ParseColumns(name)
+		assert.NoError(t, err, name)
+	}
+}
+
+func TestParseTimeFormat(t *testing.T) {
+	tests := []struct {
+		raw      string
+		expected string
+		hasError bool
+	}{
+		{"rfc3339", time.RFC3339, false},
+		{"unix", TimeUnix, false},
+		{"2006-01-02", "2006-01-02", false},
+		{"date", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		layout, err := ParseTimeFormat(tt.raw)
+		if tt.hasError {
+			assert.Error(t, err, tt.raw)
+			continue
+		}
+		require.NoError(t, err, tt.raw)
+		assert.Equal(t, tt.expected, layout)
+	}
+}
+
+func TestNewWriterRejectsInvalidOptions(t *testing.T) {
+	for _, opts := range []Options{{Format: "parquet"}, {Unit: "finney"}, {Columns: []string{"nope"}}} {
+		_, err := NewWriter(&bytes.Buffer{}, opts)
+		assert.Error(t, err, "%+v", opts)
+	}
+}
@@ -0,0 +1,151 @@
+// Package health runs the checks behind the liveness and readiness probes
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/meirongdev/ethereum_parser/internal/parser"
+)
+
+const (
+	statusOK      = "ok"
+	statusFailing = "failing"
+)
+
+// Check is a named condition, Run returns why it does not hold
+type Check struct {
+	Name string
+	Run  func() error
+}
+
+// Result is the outcome of a check
+type Result struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Report is the body of a probe response
+type Report struct {
+	Status string   `json:"status"`
+	Checks []Result `json:"checks"`
+}
+
+// Run runs the checks in order, the report is failing when one of them fails
+func Run(checks ...Check) Report {
+	report := Report{Status: statusOK, Checks: make([]Result, 0, len(checks))}
+	for _, check := range checks {
+		result := Result{Name: check.Name, Status: statusOK}
+		if err := check.Run(); err != nil {
+			result.Status = statusFailing
+			result.Error = err.Error()
+			report.Status = statusFailing
+		}
+		report.Checks = append(report.Checks, result)
+	}
+	return report
+}
+
+type handlerOptions struct {
+	logger *slog.Logger
+}
+
+type Option func(*handlerOptions)
+
+// WithLogger sets the logger of the handler, slog.Default() by default
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *handlerOptions) {
+		o.logger = logger
+	}
+}
+
+// Handler answers 200 when every check holds and 503 otherwise, with the result of each check
+func Handler(checks []Check, options ...Option) http.Handler {
+	o := handlerOptions{logger: slog.Default()}
+	for _, option := range options {
+		option(&o)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		report := Run(checks...)
+		status := http.StatusOK
+		if report.Status != statusOK {
+			status = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			o.logger.Warn("Failed to encode health report", "status", report.Status, "error", err)
+		}
+	})
+}
+
+// Progress is what the parser checks read, *parser.EthereumParser implements it
+type Progress interface {
+	Progress() parser.Progress
+}
+
+// Alive fails when the processing loop of a started parser made no progress for maxIdle,
+// it is wedged on a call or a callback
+func Alive(p Progress, maxIdle time.Duration) Check {
+	return Check{Name: "parser_loop", Run: func() error {
+		progress := p.Progress()
+		if progress.Started.IsZero() {
+			return nil
+		}
+		if idle := time.Since(progress.LastProgress); idle > maxIdle {
+			return fmt.Errorf("the parser made no progress for %s", idle.Round(time.Second))
+		}
+		return nil
+	}}
+}
+
+// Synced fails when the parser is more than maxLag blocks behind the chain head, or does not know the head yet
+func Synced(p Progress, maxLag int) Check {
+	return Check{Name: "sync", Run: func() error {
+		progress := p.Progress()
+		if progress.ChainHead < 0 || progress.CurrentBlock < 0 {
+			return errors.New("the parser has not processed a block yet")
+		}
+		if lag := progress.Lag(); lag > maxLag {
+			return fmt.Errorf("the parser is %d blocks behind the head, more than %d", lag, maxLag)
+		}
+		return nil
+	}}
+}
+
+// NodeReachable fails when no call to the node succeeded for maxAge
+func NodeReachable(p Progress, maxAge time.Duration) Check {
+	return Check{Name: "rpc", Run: func() error {
+		progress := p.Progress()
+		if progress.LastRPC.IsZero() {
+			return errors.New("no call to the node succeeded yet")
+		}
+		if age := time.Since(progress.LastRPC); age > maxAge {
+			return fmt.Errorf("the last successful call to the node was %s ago", age.Round(time.Second))
+		}
+		return nil
+	}}
+}
+
+// Writable fails when a file cannot be written in the directory of a store
+func Writable(name, dir string) Check {
+	return Check{Name: name, Run: func() error {
+		f, err := os.CreateTemp(dir, ".healthcheck-*")
+		if err != nil {
+			return fmt.Errorf("%s is not writable: %w", dir, err)
+		}
+		defer os.Remove(f.Name())
+		if _, err := f.Write([]byte("ok")); err != nil {
+			f.Close()
+			return fmt.Errorf("%s is not writable: %w", dir, err)
+		}
+		return f.Close()
+	}}
+}
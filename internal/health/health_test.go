@@ -0,0 +1,92 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/meirongdev/ethereum_parser/internal/parser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fixedProgress parser.Progress
+
+func (p fixedProgress) Progress() parser.Progress {
+	return parser.Progress(p)
+}
+
+func TestHandler(t *testing.T) {
+	passing := Check{Name: "passing", Run: func() error { return nil }}
+	failing := Check{Name: "failing", Run: func() error { return errors.New("broken") }}
+
+	tests := []struct {
+		name   string
+		checks []Check
+		status int
+		report Report
+	}{
+		{"no checks", nil, http.StatusOK, Report{Status: "ok", Checks: []Result{}}},
+		{"passing", []Check{passing}, http.StatusOK, Report{Status: "ok", Checks: []Result{{Name: "passing", Status: "ok"}}}},
+		{"failing", []Check{passing, failing}, http.StatusServiceUnavailable, Report{Status: "failing", Checks: []Result{
+			{Name: "passing", Status: "ok"},
+			{Name: "failing", Status: "failing", Error: "broken"},
+		}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			Handler(tt.checks).ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+			assert.Equal(t, tt.status, rec.Code)
+			assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+			var report Report
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+			assert.Equal(t, tt.report, report)
+		})
+	}
+}
+
+func TestParserChecks(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name     string
+		check    func(Progress) Check
+		progress parser.Progress
+		err      string
+	}{
+		{"alive before start", func(p Progress) Check { return Alive(p, time.Minute) }, parser.Progress{}, ""},
+		{"alive", func(p Progress) Check { return Alive(p, time.Minute) }, parser.Progress{Started: now, LastProgress: now}, ""},
+		{"wedged", func(p Progress) Check { return Alive(p, time.Minute) }, parser.Progress{Started: now, LastProgress: now.Add(-2 * time.Minute)}, "no progress for 2m0s"},
+		{"synced", func(p Progress) Check { return Synced(p, 5) }, parser.Progress{CurrentBlock: 95, ChainHead: 100}, ""},
+		{"behind", func(p Progress) Check { return Synced(p, 5) }, parser.Progress{CurrentBlock: 94, ChainHead: 100}, "6 blocks behind"},
+		{"head unknown", func(p Progress) Check { return Synced(p, 5) }, parser.Progress{CurrentBlock: -1, ChainHead: -1}, "not processed a block"},
+		{"node reachable", func(p Progress) Check { return NodeReachable(p, time.Minute) }, parser.Progress{LastRPC: now}, ""},
+		{"node never reached", func(p Progress) Check { return NodeReachable(p, time.Minute) }, parser.Progress{}, "no call to the node succeeded"},
+		{"node unreachable", func(p Progress) Check { return NodeReachable(p, time.Minute) }, parser.Progress{LastRPC: now.Add(-3 * time.Minute)}, "3m0s ago"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.check(fixedProgress(tt.progress)).Run()
+			if tt.err == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tt.err)
+		})
+	}
+}
+
+func TestWritable(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, Writable("keys", dir).Run())
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	assert.ErrorContains(t, Writable("keys", filepath.Join(dir, "missing")).Run(), "not writable")
+}
@@ -0,0 +1,153 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/meirongdev/ethereum_parser/internal/ethereum"
+	"github.com/meirongdev/ethereum_parser/internal/parser"
+)
+
+// Stats is what the gauges read from the parser, *parser.EthereumParser implements it
+type Stats interface {
+	Progress() parser.Progress
+	SubscriptionCount() int
+	TransactionCount() int
+}
+
+// Collector keeps the metrics of the parser and of its calls to the node
+type Collector struct {
+	registry    *Registry
+	rpcRequests *Counter
+	rpcDuration *Histogram
+	blocks      *Counter
+	reorgs      *Counter
+	errors      *Counter
+}
+
+func NewCollector() *Collector {
+	r := NewRegistry()
+	return &Collector{
+		registry:    r,
+		rpcRequests: r.NewCounter("ethereum_parser_rpc_requests_total", "Calls to the node by JSON-RPC method and status, ok or error.", "method", "status"),
+		rpcDuration: r.NewHistogram("ethereum_parser_rpc_request_duration_seconds", "Latency of the calls to the node by JSON-RPC method.", DefaultBuckets, "method"),
+		blocks:      r.NewCounter("ethereum_parser_blocks_processed_total", "Blocks processed, a block processed again after a reorg counts twice."),
+		reorgs:      r.NewCounter("ethereum_parser_reorgs_total", "Blocks dropped by a chain reorganization."),
+		errors:      r.NewCounter("ethereum_parser_errors_total", "Errors of the processing loop."),
+	}
+}
+
+// ServeHTTP writes the metrics for a Prometheus scrape
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.registry.ServeHTTP(w, r)
+}
+
+// ParserOptions are the hooks counting the processed blocks, the reorgs and the errors
+func (c *Collector) ParserOptions() []parser.Option {
+	return []parser.Option{
+		parser.OnBlockProcessed(func(parser.BlockInfo) { c.blocks.Inc() }),
+		parser.OnReorg(func(parser.ReorgInfo) { c.reorgs.Inc() }),
+		parser.OnError(func(error) { c.errors.Inc() }),
+	}
+}
+
+// WatchParser registers the gauges read from the parser, it is called once
+func (c *Collector) WatchParser(p Stats) {
+	c.registry.NewGaugeFunc("ethereum_parser_current_block", "Last block processed by the parser, -1 before the first one.", func() float64 {
+		return float64(p.Progress().CurrentBlock)
+	})
+	c.registry.NewGaugeFunc("ethereum_parser_chain_head_block", "Last block number returned by the node, -1 before the first one.", func() float64 {
+		return float64(p.Progress().ChainHead)
+	})
+	c.registry.NewGaugeFunc("ethereum_parser_lag_blocks", "Blocks between the chain head and the last processed block.", func() float64 {
+		return float64(p.Progress().Lag())
+	})
+	c.registry.NewGaugeFunc("ethereum_parser_subscribed_addresses", "Addresses subscribed in the parser.", func() float64 {
+		return float64(p.SubscriptionCount())
+	})
+	c.registry.NewGaugeFunc("ethereum_parser_stored_transactions", "Transactions stored by the parser, for every address seen.", func() float64 {
+		return float64(p.TransactionCount())
+	})
+}
+
+// InstrumentAPI wraps the client of the node to count and time its calls
+func (c *Collector) InstrumentAPI(api ethereum.API) ethereum.API {
+	return &instrumentedAPI{api: api, c: c}
+}
+
+type instrumentedAPI struct {
+	api ethereum.API
+	c   *Collector
+}
+
+// observeRPC records a call to the node which started at start
+func (c *Collector) observeRPC(method string, start time.Time, err error) {
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	c.rpcRequests.Inc(method, status)
+	c.rpcDuration.Observe(time.Since(start).Seconds(), method)
+}
+
+func (a *instrumentedAPI) GetCurrentBlock() (string, error) {
+	start := time.Now()
+	blockNumber, err := a.api.GetCurrentBlock()
+	a.c.observeRPC("eth_blockNumber", start, err)
+	return blockNumber, err
+}
+
+func (a *instrumentedAPI) GetTransactions(blockNumber string) ([]interface{}, error) {
+	start := time.Now()
+	transactions, err := a.api.GetTransactions(blockNumber)
+	a.c.observeRPC("eth_getBlockByNumber", start, err)
+	return transactions, err
+}
+
+func (a *instrumentedAPI) GetBlock(blockNumber string) (ethereum.Block, error) {
+	start := time.Now()
+	block, err := a.api.GetBlock(blockNumber)
+	a.c.observeRPC("eth_getBlockByNumber", start, err)
+	return block, err
+}
+
+// InstrumentTxPool wraps the pool reader of the node like InstrumentAPI
+func (c *Collector) InstrumentTxPool(pool ethereum.TxPoolAPI) ethereum.TxPoolAPI {
+	return &instrumentedTxPool{pool: pool, c: c}
+}
+
+type instrumentedTxPool struct {
+	pool ethereum.TxPoolAPI
+	c    *Collector
+}
+
+func (a *instrumentedTxPool) GetTxPoolContent() (ethereum.TxPool, error) {
+	start := time.Now()
+	content, err := a.pool.GetTxPoolContent()
+	a.c.observeRPC("txpool_content", start, err)
+	return content, err
+}
+
+// InstrumentBalances wraps the balance reader of the node like InstrumentAPI
+func (c *Collector) InstrumentBalances(api ethereum.BalanceAPI) ethereum.BalanceAPI {
+	return &instrumentedBalances{api: api, c: c}
+}
+
+type instrumentedBalances struct {
+	api ethereum.BalanceAPI
+	c   *Collector
+}
+
+func (a *instrumentedBalances) GetBalance(address ethereum.Address, blockNumber string) (ethereum.Wei, error) {
+	start := time.Now()
+	balance, err := a.api.GetBalance(address, blockNumber)
+	a.c.observeRPC("eth_getBalance", start, err)
+	return balance, err
+}
+
+func (a *instrumentedBalances) GetTransactionReceipt(hash string) (ethereum.Receipt, error) {
+	start := time.Now()
+	receipt, err := a.api.GetTransactionReceipt(hash)
+	a.c.observeRPC("eth_getTransactionReceipt", start, err)
+	return receipt, err
+}
@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"errors"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/meirongdev/ethereum_parser/internal/ethereum"
+	"github.com/meirongdev/ethereum_parser/internal/ethereum/mocks"
+	"github.com/meirongdev/ethereum_parser/internal/parser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func scrape(c *Collector) string {
+	rec := httptest.NewRecorder()
+	c.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	return rec.Body.String()
+}
+
+func TestCollector(t *testing.T) {
+	c := NewCollector()
+	mockAPI := new(mocks.API)
+	// the parser starts at the head, then the node fails
+	mockAPI.On("GetCurrentBlock").Return("0x2", nil).Once()
+	mockAPI.On("GetCurrentBlock").Return("", errors.New("unavailable"))
+	mockAPI.On("GetBlock", "0x2").Return(ethereum.Block{
+		Number:     "0x2",
+		Hash:       "0xb2",
+		ParentHash: "0xb1",
+		Timestamp:  "0x66f3e4d7",
+		Transactions: []interface{}{
+			map[string]interface{}{"hash": "0x01", "from": "0x0000000000000000000000000000000000000123", "to": "0x0000000000000000000000000000000000000456", "value": "0x1"},
+		},
+	}, nil)
+
+	options := append(c.ParserOptions(), parser.WithPollInterval(time.Millisecond), parser.WithBackoff(time.Millisecond, time.Millisecond))
+	eParser := parser.NewEthereumParser(c.InstrumentAPI(mockAPI), options...)
+	eParser.Subscribe(ethereum.Address{1})
+	c.WatchParser(eParser)
+	assert.Contains(t, scrape(c), "ethereum_parser_current_block -1\n")
+	assert.Contains(t, scrape(c), "ethereum_parser_chain_head_block -1\n")
+
+	go eParser.Start()
+	// the failing node ends the first iteration
+	assert.Eventually(t, func() bool {
+		return regexp.MustCompile(`ethereum_parser_errors_total [1-9]`).MatchString(scrape(c))
+	}, 5*time.Second, time.Millisecond)
+	eParser.Stop()
+
+	text := scrape(c)
+	for _, line := range []string{
+		"ethereum_parser_current_block 2\n",
+		"ethereum_parser_chain_head_block 2\n",
+		"ethereum_parser_lag_blocks 0\n",
+		"ethereum_parser_subscribed_addresses 1\n",
+		"ethereum_parser_stored_transactions 2\n",
+		"ethereum_parser_blocks_processed_total 1\n",
+		`ethereum_parser_rpc_requests_total{method="eth_blockNumber",status="ok"} 1` + "\n",
+		`ethereum_parser_rpc_requests_total{method="eth_getBlockByNumber",status="ok"} 1` + "\n",
+		`ethereum_parser_rpc_request_duration_seconds_count{method="eth_getBlockByNumber"} 1` + "\n",
+	} {
+		assert.Contains(t, text, line)
+	}
+	assert.Contains(t, text, `ethereum_parser_rpc_requests_total{method="eth_blockNumber",status="error"}`)
+	mockAPI.AssertCalled(t, "GetBlock", mock.Anything)
+}
+
+type failingPool struct{}
+
+func (failingPool) GetTxPoolContent() (ethereum.TxPool, error) {
+	return ethereum.TxPool{}, errors.New("the method txpool_content does not exist")
+}
+
+func TestInstrumentTxPool(t *testing.T) {
+	c := NewCollector()
+	_, err := c.InstrumentTxPool(failingPool{}).GetTxPoolContent()
+	assert.Error(t, err)
+	assert.Contains(t, scrape(c), `ethereum_parser_rpc_requests_total{method="txpool_content",status="error"} 1`+"\n")
+}
@@ -0,0 +1,218 @@
+// Package metrics keeps counters, gauges and histograms and writes them in the Prometheus text format.
+// It only implements what the parser exposes, see https://prometheus.io/docs/instrumenting/exposition_formats/
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// contentType is the version 0.0.4 of the text format
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are the upper bounds in seconds of the latency histograms
+var DefaultBuckets = []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// series is the value of a metric for one set of label values
+type series struct {
+	labelValues []string
+	value       float64
+	// counts and sum are only used by the histograms, counts[i] is the observations in the bucket i
+	counts []uint64
+	sum    float64
+}
+
+// family is a metric and its series
+type family struct {
+	name, help, kind string
+	labelNames       []string
+	buckets          []float64
+	// read is set for the gauges computed when they are written
+	read   func() float64
+	mutex  sync.Mutex
+	series map[string]*series
+}
+
+// get returns the series of the label values, it must be called while holding the mutex
+func (f *family) get(labelValues []string) *series {
+	if len(labelValues) != len(f.labelNames) {
+		panic(fmt.Sprintf("metric %s has labels %v, got values %v", f.name, f.labelNames, labelValues))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string{}, labelValues...)}
+		if f.buckets != nil {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+// Registry holds the metrics written by its handler
+type Registry struct {
+	mutex    sync.Mutex
+	families []*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) add(f *family) *family {
+	f.series = make(map[string]*series)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, existing := range r.families {
+		if existing.name == f.name {
+			panic("metric " + f.name + " is registered twice")
+		}
+	}
+	r.families = append(r.families, f)
+	return f
+}
+
+// Counter is a value which only goes up
+type Counter struct{ f *family }
+
+// NewCounter registers a counter, its name should end with _total
+func (r *Registry) NewCounter(name, help string, labelNames ...string) *Counter {
+	return &Counter{r.add(&family{name: name, help: help, kind: "counter", labelNames: labelNames})}
+}
+
+// Inc adds one to the series of the label values
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds a positive value to the series of the label values
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic("counter " + c.f.name + " cannot decrease")
+	}
+	c.f.mutex.Lock()
+	defer c.f.mutex.Unlock()
+	c.f.get(labelValues).value += v
+}
+
+// NewGaugeFunc registers a gauge without labels, its value is read when the metrics are written
+func (r *Registry) NewGaugeFunc(name, help string, read func() float64) {
+	r.add(&family{name: name, help: help, kind: "gauge", read: read})
+}
+
+// Histogram counts observations in buckets
+type Histogram struct{ f *family }
+
+// NewHistogram registers a histogram, the buckets are sorted upper bounds and +Inf is implied
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	buckets = append([]float64{}, buckets...)
+	sort.Float64s(buckets)
+	return &Histogram{r.add(&family{name: name, help: help, kind: "histogram", labelNames: labelNames, buckets: buckets})}
+}
+
+// Observe records a value in the series of the label values
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.f.mutex.Lock()
+	defer h.f.mutex.Unlock()
+	s := h.f.get(labelValues)
+	s.value++
+	s.sum += v
+	for i, bound := range h.f.buckets {
+		if v <= bound {
+			s.counts[i]++
+			break
+		}
+	}
+}
+
+// WriteText writes the metrics in the Prometheus text format, the series of a metric are sorted by label values
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mutex.Lock()
+	families := append([]*family{}, r.families...)
+	r.mutex.Unlock()
+
+	buf := bufio.NewWriter(w)
+	for _, f := range families {
+		fmt.Fprintf(buf, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+		fmt.Fprintf(buf, "# TYPE %s %s\n", f.name, f.kind)
+		if f.read != nil {
+			fmt.Fprintf(buf, "%s %s\n", f.name, formatFloat(f.read()))
+			continue
+		}
+		f.mutex.Lock()
+		all := make([]*series, 0, len(f.series))
+		for _, s := range f.series {
+			all = append(all, s)
+		}
+		sort.Slice(all, func(i, j int) bool {
+			return strings.Join(all[i].labelValues, "\xff") < strings.Join(all[j].labelValues, "\xff")
+		})
+		for _, s := range all {
+			labels := formatLabels(f.labelNames, s.labelValues)
+			if f.buckets == nil {
+				fmt.Fprintf(buf, "%s%s %s\n", f.name, labels, formatFloat(s.value))
+				continue
+			}
+			var cumulative uint64
+			for i, bound := range f.buckets {
+				cumulative += s.counts[i]
+				fmt.Fprintf(buf, "%s_bucket%s %d\n", f.name, bucketLabels(f.labelNames, s.labelValues, formatFloat(bound)), cumulative)
+			}
+			fmt.Fprintf(buf, "%s_bucket%s %s\n", f.name, bucketLabels(f.labelNames, s.labelValues, "+Inf"), formatFloat(s.value))
+			fmt.Fprintf(buf, "%s_sum%s %s\n", f.name, labels, formatFloat(s.sum))
+			fmt.Fprintf(buf, "%s_count%s %s\n", f.name, labels, formatFloat(s.value))
+		}
+		f.mutex.Unlock()
+	}
+	return buf.Flush()
+}
+
+// ServeHTTP writes the metrics for a Prometheus scrape
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", contentType)
+	if err := r.WriteText(w); err != nil {
+		http.Error(w, "Failed to write metrics", http.StatusInternalServerError)
+	}
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + labelEscaper.Replace(values[i]) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// bucketLabels are the labels of a series with the le label of a bucket
+func bucketLabels(names, values []string, le string) string {
+	return formatLabels(append(append([]string{}, names...), "le"), append(append([]string{}, values...), le))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteText(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounter("requests_total", "Requests by path.", "path")
+	requests.Inc("/b")
+	requests.Add(2, "/a")
+	requests.Inc(`/"quoted"`)
+	latency := r.NewHistogram("latency_seconds", "Latency.", []float64{1, 0.5})
+	latency.Observe(0.2)
+	latency.Observe(0.7)
+	latency.Observe(3)
+	r.NewGaugeFunc("temperature", "Line one\nline two.", func() float64 { return 21.5 })
+
+	var text strings.Builder
+	assert.NoError(t, r.WriteText(&text))
+	assert.Equal(t, `# HELP requests_total Requests by path.
+# TYPE requests_total counter
+requests_total{path="/\"quoted\""} 1
+requests_total{path="/a"} 2
+requests_total{path="/b"} 1
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.5"} 1
+latency_seconds_bucket{le="1"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 3.9
+latency_seconds_count 3
+# HELP temperature Line one\nline two.
+# TYPE temperature gauge
+temperature 21.5
+`, text.String())
+}
+
+func TestRegistryPanicsOnMisuse(t *testing.T) {
+	r := NewRegistry()
+	counter := r.NewCounter("requests_total", "Requests.", "path")
+	assert.Panics(t, func() { r.NewCounter("requests_total", "Again.") })
+	assert.Panics(t, func() { counter.Inc() })
+	assert.Panics(t, func() { counter.Add(-1, "/") })
+}
+
+func TestServeHTTP(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("requests_total", "Requests.").Inc()
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, contentType, rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Body.String(), "requests_total 1\n")
+}
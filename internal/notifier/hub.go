@@ -0,0 +1,137 @@
+package notifier
+
+import (
+	"context"
+	"sync"
+
+	"github.com/meirongdev/ethereum_parser/internal/ethereum"
+)
+
+// Hub is a Sink broadcasting events to live listeners such as streaming HTTP clients.
+// A listener which does not keep up is disconnected instead of slowing down the others.
+type Hub struct {
+	listeners map[*Listener]struct{}
+	mutex     sync.Mutex
+}
+
+// Listener receives the events of a set of addresses
+type Listener struct {
+	hub *Hub
+	// a nil set means every address
+	addresses map[ethereum.Address]struct{}
+	events    chan Event
+	closeOnce sync.Once
+	lagging   bool
+}
+
+// NewHub creates a hub without listeners
+func NewHub() *Hub {
+	return &Hub{listeners: make(map[*Listener]struct{})}
+}
+
+// Listen registers a listener of the given addresses with room for buffer pending events
+func (h *Hub) Listen(addresses []ethereum.Address, buffer int) *Listener {
+	return h.listen(addressSet(addresses), buffer)
+}
+
+// ListenAll registers a listener of every address with room for buffer pending events
+func (h *Hub) ListenAll(buffer int) *Listener {
+	return h.listen(nil, buffer)
+}
+
+// listen registers the listener with its addresses at once, so that no event
+// of another address can reach it in between
+func (h *Hub) listen(addresses map[ethereum.Address]struct{}, buffer int) *Listener {
+	l := &Listener{
+		hub:       h,
+		addresses: addresses,
+		events:    make(chan Event, buffer),
+	}
+	h.mutex.Lock()
+	h.listeners[l] = struct{}{}
+	h.mutex.Unlock()
+	return l
+}
+
+func addressSet(addresses []ethereum.Address) map[ethereum.Address]struct{} {
+	set := make(map[ethereum.Address]struct{}, len(addresses))
+	for _, address := range addresses {
+		set[address] = struct{}{}
+	}
+	return set
+}
+
+func (h *Hub) Name() string {
+	return "hub"
+}
+
+// Send never blocks, a listener whose buffer is full is closed and marked as lagging
+func (h *Hub) Send(_ context.Context, event Event) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for l := range h.listeners {
+		if !l.wants(event.Address) {
+			continue
+		}
+		select {
+		case l.events <- event:
+		default:
+			l.lagging = true
+			h.remove(l)
+		}
+	}
+	return nil
+}
+
+// Close disconnects every listener
+func (h *Hub) Close() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for l := range h.listeners {
+		h.remove(l)
+	}
+	return nil
+}
+
+// remove must be called while holding the mutex
+func (h *Hub) remove(l *Listener) {
+	delete(h.listeners, l)
+	l.closeOnce.Do(func() {
+		close(l.events)
+	})
+}
+
+func (l *Listener) wants(address ethereum.Address) bool {
+	if l.addresses == nil {
+		return true
+	}
+	_, ok := l.addresses[address]
+	return ok
+}
+
+// Events is closed when the listener is closed, by itself, by the hub or because it lagged behind
+func (l *Listener) Events() <-chan Event {
+	return l.events
+}
+
+// Lagging reports whether the hub dropped the listener because its buffer was full
+func (l *Listener) Lagging() bool {
+	l.hub.mutex.Lock()
+	defer l.hub.mutex.Unlock()
+	return l.lagging
+}
+
+// SetAddresses replaces the addresses the listener receives events for, an empty list pauses it
+func (l *Listener) SetAddresses(addresses []ethereum.Address) {
+	set := addressSet(addresses)
+	l.hub.mutex.Lock()
+	defer l.hub.mutex.Unlock()
+	l.addresses = set
+}
+
+// Close stops the listener
+func (l *Listener) Close() {
+	l.hub.mutex.Lock()
+	defer l.hub.mutex.Unlock()
+	l.hub.remove(l)
+}
@@ -0,0 +1,94 @@
+package notifier
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/meirongdev/ethereum_parser/internal/ethereum"
+	"github.com/meirongdev/ethereum_parser/internal/parser"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHubRoutesEventsByAddress(t *testing.T) {
+	hub := NewHub()
+	other := ethereum.Address{1}
+	mine := hub.Listen([]ethereum.Address{testAddress}, 10)
+	everything := hub.ListenAll(10)
+	paused := hub.Listen(nil, 10)
+
+	assert.NoError(t, hub.Send(context.Background(), Event{Address: testAddress, Transaction: parser.Transaction{Hash: "0x1"}}))
+	assert.NoError(t, hub.Send(context.Background(), Event{Address: other, Transaction: parser.Transaction{Hash: "0x2"}}))
+
+	assert.Equal(t, "0x1", (<-mine.Events()).Transaction.Hash)
+	assert.Len(t, mine.Events(), 0)
+	assert.Equal(t, "0x1", (<-everything.Events()).Transaction.Hash)
+	assert.Equal(t, "0x2", (<-everything.Events()).Transaction.Hash)
+	assert.Len(t, paused.Events(), 0)
+
+	paused.SetAddresses([]ethereum.Address{other})
+	assert.NoError(t, hub.Send(context.Background(), Event{Address: other, Transaction: parser.Transaction{Hash: "0x3"}}))
+	assert.Equal(t, "0x3", (<-paused.Events()).Transaction.Hash)
+	<-everything.Events()
+
+	mine.Close()
+	_, ok := <-mine.Events()
+	assert.False(t, ok)
+	assert.False(t, mine.Lagging())
+
+	assert.NoError(t, hub.Close())
+	_, ok = <-everything.Events()
+	assert.False(t, ok)
+}
+
+func TestHubDropsLaggingListener(t *testing.T) {
+	hub := NewHub()
+	slow := hub.ListenAll(1)
+	fast := hub.ListenAll(10)
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, hub.Send(context.Background(), Event{Address: testAddress}))
+	}
+
+	assert.True(t, slow.Lagging())
+	received := 0
+	for range slow.Events() {
+		received++
+	}
+	assert.Equal(t, 1, received)
+	assert.False(t, fast.Lagging())
+	assert.Len(t, fast.Events(), 3)
+}
+
+func TestHubListenNeverReceivesOtherAddresses(t *testing.T) {
+	hub := NewHub()
+	other := ethereum.Address{1}
+	stop := make(chan struct{})
+	var senders sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		senders.Add(1)
+		go func() {
+			defer senders.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					assert.NoError(t, hub.Send(context.Background(), Event{Address: other}))
+				}
+			}
+		}()
+	}
+
+	listeners := make([]*Listener, 0, 2000)
+	for i := 0; i < 2000; i++ {
+		listeners = append(listeners, hub.Listen([]ethereum.Address{testAddress}, 1))
+	}
+	close(stop)
+	senders.Wait()
+
+	for _, l := range listeners {
+		assert.False(t, l.Lagging())
+		assert.Len(t, l.Events(), 0)
+	}
+}
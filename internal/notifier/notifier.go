@@ -0,0 +1,204 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/meirongdev/ethereum_parser/internal/ethereum"
+	"github.com/meirongdev/ethereum_parser/internal/parser"
+)
+
+// ErrClosed is returned when publishing to a closed notifier
+var ErrClosed = errors.New("notifier is closed")
+
+// EventType is the kind of an Event
+type EventType = parser.EventType
+
+const (
+	// EventTransaction is sent when a transaction involving a subscribed address is recorded
+	EventTransaction = parser.EventTransaction
+	// EventConfirmation is sent when the block of a transaction reached the confirmation depth
+	EventConfirmation = parser.EventConfirmation
+	// EventRemoved is sent when the block of a transaction was dropped by a reorganization
+	EventRemoved = parser.EventRemoved
+	// EventPending is sent when a transaction of a subscribed address is seen in the pool of the node
+	EventPending = parser.EventPending
+	// EventDropped is sent when a pending transaction left the pool without being mined
+	EventDropped = parser.EventDropped
+	// EventReplaced is sent when a pending transaction was superseded by another one with the same nonce,
+	// its ReplacedBy is the hash of the replacement
+	EventReplaced = parser.EventReplaced
+)
+
+// Event is what the sinks receive, Address is the subscribed address the transaction belongs to
+type Event struct {
+	Type        EventType          `json:"type"`
+	Address     ethereum.Address   `json:"address"`
+	Transaction parser.Transaction `json:"transaction"`
+	CreatedAt   time.Time          `json:"createdAt"`
+}
+
+// Sink delivers events to one destination
+type Sink interface {
+	// Name identifies the sink in logs
+	Name() string
+	Send(ctx context.Context, event Event) error
+	Close() error
+}
+
+// Notifier fans out the transactions published by the parser to every sink.
+// Each sink has a queue and a goroutine of its own, so a slow sink neither holds the others nor the parser:
+// Notify never blocks, the events of a sink whose queue is full are dropped and counted.
+type Notifier struct {
+	sinks       []*sinkQueue
+	queueSize   int
+	sendTimeout time.Duration
+	closeOnce   sync.Once
+	closed      chan struct{}
+	running     sync.WaitGroup
+	logger      *slog.Logger
+}
+
+// sinkQueue is the queue of the events waiting for a sink
+type sinkQueue struct {
+	sink    Sink
+	events  chan Event
+	dropped atomic.Uint64
+	// behind is set from the first dropped event until an event is queued again
+	behind atomic.Bool
+}
+
+type Option func(*Notifier)
+
+// WithLogger sets the logger of the notifier, slog.Default() by default
+func WithLogger(logger *slog.Logger) Option {
+	return func(n *Notifier) {
+		n.logger = logger
+	}
+}
+
+// WithQueueSize sets how many events can wait for delivery to each sink, 1024 by default
+func WithQueueSize(size int) Option {
+	return func(n *Notifier) {
+		n.queueSize = size
+	}
+}
+
+// WithSendTimeout bounds the time a sink can take for one event
+func WithSendTimeout(timeout time.Duration) Option {
+	return func(n *Notifier) {
+		n.sendTimeout = timeout
+	}
+}
+
+// New creates a notifier and starts a delivery goroutine per sink, Close stops them
+func New(sinks []Sink, options ...Option) *Notifier {
+	n := &Notifier{
+		queueSize:   1024,
+		sendTimeout: 30 * time.Second,
+		closed:      make(chan struct{}),
+		logger:      slog.Default(),
+	}
+	for _, option := range options {
+		option(n)
+	}
+	for _, sink := range sinks {
+		q := &sinkQueue{sink: sink, events: make(chan Event, n.queueSize)}
+		n.sinks = append(n.sinks, q)
+		n.running.Add(1)
+		go n.run(q)
+	}
+	return n
+}
+
+// Notify implements parser.Publisher
+func (n *Notifier) Notify(eventType EventType, address ethereum.Address, tx parser.Transaction) {
+	err := n.Publish(Event{
+		Type:        eventType,
+		Address:     address,
+		Transaction: tx,
+		CreatedAt:   time.Now().UTC(),
+	})
+	if err != nil {
+		n.logger.Error("Dropping event", "type", eventType, "address", address, "tx", tx.Hash, "error", err)
+	}
+}
+
+// Publish queues an event for all sinks, it is dropped for the sinks whose queue is full
+func (n *Notifier) Publish(event Event) error {
+	select {
+	case <-n.closed:
+		return ErrClosed
+	default:
+	}
+	for _, q := range n.sinks {
+		select {
+		case q.events <- event:
+			if q.behind.CompareAndSwap(true, false) {
+				n.logger.Info("Sink caught up", "sink", q.sink.Name(), "dropped", q.dropped.Load())
+			}
+		default:
+			q.dropped.Add(1)
+			if q.behind.CompareAndSwap(false, true) {
+				n.logger.Warn("Sink is behind, dropping its events", "sink", q.sink.Name(), "queueSize", cap(q.events))
+			}
+		}
+	}
+	return nil
+}
+
+// Dropped returns how many events each sink missed because its queue was full, by sink name
+func (n *Notifier) Dropped() map[string]uint64 {
+	dropped := make(map[string]uint64, len(n.sinks))
+	for _, q := range n.sinks {
+		dropped[q.sink.Name()] += q.dropped.Load()
+	}
+	return dropped
+}
+
+func (n *Notifier) run(q *sinkQueue) {
+	defer n.running.Done()
+	for {
+		select {
+		case event := <-q.events:
+			n.deliver(q.sink, event)
+		case <-n.closed:
+			// deliver what is already queued before stopping
+			for {
+				select {
+				case event := <-q.events:
+					n.deliver(q.sink, event)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (n *Notifier) deliver(sink Sink, event Event) {
+	ctx, cancel := context.WithTimeout(context.Background(), n.sendTimeout)
+	defer cancel()
+	if err := sink.Send(ctx, event); err != nil {
+		n.logger.Error("Failed to send event", "sink", sink.Name(), "type", event.Type, "tx", event.Transaction.Hash, "error", err)
+	}
+}
+
+// Close delivers the queued events, then closes every sink
+func (n *Notifier) Close() error {
+	n.closeOnce.Do(func() {
+		close(n.closed)
+	})
+	n.running.Wait()
+	var errs []error
+	for _, q := range n.sinks {
+		if err := q.sink.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,184 @@
+package notifier
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/meirongdev/ethereum_parser/internal/ethereum"
+	"github.com/meirongdev/ethereum_parser/internal/parser"
+	"github.com/stretchr/testify/assert"
+)
+
+var testAddress = func() ethereum.Address {
+	addr, err := ethereum.ParseAddress("0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed")
+	if err != nil {
+		panic(err)
+	}
+	return addr
+}()
+
+type failingSink struct {
+	mutex sync.Mutex
+	calls int
+}
+
+func (s *failingSink) Name() string { return "failing" }
+
+func (s *failingSink) Send(context.Context, Event) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.calls++
+	return errors.New("boom")
+}
+
+func (s *failingSink) Close() error { return nil }
+
+func TestNotifierFansOutToAllSinks(t *testing.T) {
+	channelSink := NewChannelSink(10)
+	var buf bytes.Buffer
+	ndjsonSink := NewNDJSONSink(&buf)
+	failing := &failingSink{}
+	n := New([]Sink{failing, channelSink, ndjsonSink})
+
+	n.Notify(EventTransaction, testAddress, parser.Transaction{Hash: "0x1", Direction: parser.DirectionIn})
+	n.Notify(EventTransaction, testAddress, parser.Transaction{Hash: "0x2", Direction: parser.DirectionOut})
+	assert.NoError(t, n.Close())
+
+	var received []string
+	for event := range channelSink.Events() {
+		assert.Equal(t, EventTransaction, event.Type)
+		assert.Equal(t, testAddress, event.Address)
+		received = append(received, event.Transaction.Hash)
+	}
+	assert.Equal(t, []string{"0x1", "0x2"}, received)
+
+	scanner := bufio.NewScanner(&buf)
+	var lines []Event
+	for scanner.Scan() {
+		var event Event
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+		lines = append(lines, event)
+	}
+	assert.Len(t, lines, 2)
+	assert.Equal(t, "0x2", lines[1].Transaction.Hash)
+
+	// a failing sink does not stop the delivery to the others
+	assert.Equal(t, 2, failing.calls)
+
+	assert.ErrorIs(t, n.Publish(Event{}), ErrClosed)
+}
+
+// blockedSink holds every event until it is released
+type blockedSink struct {
+	release chan struct{}
+	mutex   sync.Mutex
+	sent    int
+}
+
+func (s *blockedSink) Name() string { return "blocked" }
+
+func (s *blockedSink) Send(ctx context.Context, _ Event) error {
+	select {
+	case <-s.release:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sent++
+	return nil
+}
+
+func (s *blockedSink) Close() error { return nil }
+
+func TestNotifierDropsEventsOfASinkBehind(t *testing.T) {
+	blocked := &blockedSink{release: make(chan struct{})}
+	channelSink := NewChannelSink(10)
+	n := New([]Sink{blocked, channelSink}, WithQueueSize(2))
+
+	// the blocked sink holds one event and queues two, the others are dropped without blocking the publisher
+	for i := 0; i < 6; i++ {
+		n.Notify(EventTransaction, testAddress, parser.Transaction{Hash: fmt.Sprintf("0x%d", i)})
+		// the other sink is not held by the blocked one
+		select {
+		case event := <-channelSink.Events():
+			assert.Equal(t, fmt.Sprintf("0x%d", i), event.Transaction.Hash)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the channel sink")
+		}
+	}
+	dropped := n.Dropped()
+	assert.Equal(t, uint64(0), dropped[channelSink.Name()])
+	assert.GreaterOrEqual(t, dropped[blocked.Name()], uint64(3))
+
+	close(blocked.release)
+	assert.NoError(t, n.Close())
+	blocked.mutex.Lock()
+	defer blocked.mutex.Unlock()
+	assert.Equal(t, 6, blocked.sent+int(dropped[blocked.Name()]))
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	sink, err := NewFileSink(path)
+	assert.NoError(t, err)
+	assert.NoError(t, sink.Send(context.Background(), Event{Type: EventTransaction, Address: testAddress}))
+	assert.NoError(t, sink.Close())
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"address":"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"`)
+	assert.Equal(t, byte('\n'), data[len(data)-1])
+
+	_, err = NewFileSink(filepath.Join(t.TempDir(), "missing", "events.ndjson"))
+	assert.Error(t, err)
+}
+
+func TestChannelSinkRespectsContext(t *testing.T) {
+	sink := NewChannelSink(0)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, sink.Send(ctx, Event{}), context.DeadlineExceeded)
+}
+
+func TestWebhookSink(t *testing.T) {
+	tests := []struct {
+		name        string
+		statusCode  int
+		expectError bool
+	}{
+		{"Accepted", http.StatusOK, false},
+		{"No content", http.StatusNoContent, false},
+		{"Server error", http.StatusInternalServerError, true},
+		{"Not found", http.StatusNotFound, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var received Event
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, http.MethodPost, r.Method)
+				assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+				assert.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+				w.WriteHeader(tt.statusCode)
+			}))
+			defer server.Close()
+
+			sink := NewWebhookSink(server.URL)
+			err := sink.Send(context.Background(), Event{Type: EventTransaction, Address: testAddress, Transaction: parser.Transaction{Hash: "0xabc"}})
+			assert.Equal(t, tt.expectError, err != nil, "unexpected error %v", err)
+			assert.Equal(t, "0xabc", received.Transaction.Hash)
+		})
+	}
+}
@@ -0,0 +1,594 @@
+package notifier
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/meirongdev/ethereum_parser/internal/ethereum"
+)
+
+// ErrNotFound is returned when a webhook or a delivery does not exist
+var ErrNotFound = errors.New("not found")
+
+// Webhook is a callback URL registered for one subscribed address,
+// every payload sent to it is signed with its secret
+type Webhook struct {
+	ID string `json:"id"`
+	// KeyID is the API key which registered the webhook, empty without API keys.
+	// A webhook belongs to the subscription of its key and is only ever shown to that key.
+	KeyID     string           `json:"keyId,omitempty"`
+	Address   ethereum.Address `json:"address"`
+	URL       string           `json:"url"`
+	Secret    string           `json:"secret"`
+	CreatedAt time.Time        `json:"createdAt"`
+}
+
+// Delivery is one event waiting to be sent to one webhook
+type Delivery struct {
+	ID          string    `json:"id"`
+	WebhookID   string    `json:"webhookId"`
+	URL         string    `json:"url"`
+	Event       Event     `json:"event"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"nextAttempt"`
+	LastError   string    `json:"lastError,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// DefaultMaxDeadLetters is how many dead letters an outbox keeps by default
+const DefaultMaxDeadLetters = 1000
+
+// defaultCompactSize is the size of the journal which makes the outbox write its file again
+const defaultCompactSize = 4 << 20
+
+type outboxState struct {
+	// Seq is the sequence of the last record applied, the records of the journal up to it are in the file
+	Seq         uint64     `json:"seq"`
+	Webhooks    []Webhook  `json:"webhooks"`
+	Pending     []Delivery `json:"pending"`
+	DeadLetters []Delivery `json:"deadLetters"`
+}
+
+// the operations of the journal records
+const (
+	opRegister  = "register"
+	opRemove    = "remove"
+	opEnqueue   = "enqueue"
+	opComplete  = "complete"
+	opRetry     = "retry"
+	opKill      = "kill"
+	opRedeliver = "redeliver"
+)
+
+// outboxRecord is a change of the outbox, the journal has one JSON record per line
+type outboxRecord struct {
+	Seq        uint64     `json:"seq"`
+	Op         string     `json:"op"`
+	Webhook    *Webhook   `json:"webhook,omitempty"`
+	IDs        []string   `json:"ids,omitempty"`
+	Deliveries []Delivery `json:"deliveries,omitempty"`
+}
+
+// apply makes the change of a record, both when it is made and when the journal is replayed
+func (s *outboxState) apply(r outboxRecord, maxDeadLetters int) {
+	switch r.Op {
+	case opRegister:
+		s.Webhooks = append(s.Webhooks, *r.Webhook)
+	case opRemove:
+		removed := make(map[string]bool, len(r.IDs))
+		for _, id := range r.IDs {
+			removed[id] = true
+		}
+		s.Webhooks = slices.DeleteFunc(s.Webhooks, func(webhook Webhook) bool { return removed[webhook.ID] })
+		s.Pending = slices.DeleteFunc(s.Pending, func(delivery Delivery) bool { return removed[delivery.WebhookID] })
+	case opEnqueue:
+		s.Pending = append(s.Pending, r.Deliveries...)
+	case opComplete:
+		if i := indexOf(s.Pending, r.IDs[0]); i >= 0 {
+			s.Pending = slices.Delete(s.Pending, i, i+1)
+		}
+	case opRetry:
+		if i := indexOf(s.Pending, r.Deliveries[0].ID); i >= 0 {
+			s.Pending[i] = r.Deliveries[0]
+		}
+	case opKill:
+		if i := indexOf(s.Pending, r.Deliveries[0].ID); i >= 0 {
+			s.Pending = slices.Delete(s.Pending, i, i+1)
+			s.DeadLetters = append(s.DeadLetters, r.Deliveries[0])
+		}
+	case opRedeliver:
+		if i := indexOf(s.DeadLetters, r.Deliveries[0].ID); i >= 0 {
+			s.DeadLetters = slices.Delete(s.DeadLetters, i, i+1)
+			s.Pending = append(s.Pending, r.Deliveries[0])
+		}
+	}
+	if maxDeadLetters > 0 && len(s.DeadLetters) > maxDeadLetters {
+		s.DeadLetters = slices.Delete(s.DeadLetters, 0, len(s.DeadLetters)-maxDeadLetters)
+	}
+	s.Seq = r.Seq
+}
+
+// Outbox keeps the webhooks, the pending deliveries and the dead letters.
+// When it has a path its changes are appended to a journal next to the file, <path>.journal,
+// which is written into the file again once it grows, so a change costs a line instead of the whole outbox.
+// The webhooks and the redeliveries are synced to the journal before they are applied,
+// a change which could not be written is not applied.
+// The deliveries are applied at once and a background goroutine writes and syncs them in batches,
+// so the dispatcher never waits for the disk: a crash loses at most the last batch, Close writes what is left.
+type Outbox struct {
+	path           string
+	maxDeadLetters int
+	compactSize    int64
+	state          outboxState
+	mutex          sync.Mutex
+	// journal is only open with a path, buffer are the records not written to it yet
+	journal     *os.File
+	journalSize int64
+	buffer      []byte
+	dirty       chan struct{}
+	closeOnce   sync.Once
+	closed      chan struct{}
+	done        chan struct{}
+	logger      *slog.Logger
+}
+
+type OutboxOption func(*Outbox)
+
+// WithMaxDeadLetters sets how many dead letters are kept, DefaultMaxDeadLetters by default.
+// The oldest ones are dropped to make room, 0 keeps them all.
+func WithMaxDeadLetters(max int) OutboxOption {
+	return func(o *Outbox) {
+		o.maxDeadLetters = max
+	}
+}
+
+// WithOutboxLogger sets the logger of the background writes of the outbox, slog.Default() by default
+func WithOutboxLogger(logger *slog.Logger) OutboxOption {
+	return func(o *Outbox) {
+		o.logger = logger
+	}
+}
+
+// NewOutbox creates an in-memory outbox
+func NewOutbox(options ...OutboxOption) *Outbox {
+	o := &Outbox{maxDeadLetters: DefaultMaxDeadLetters, compactSize: defaultCompactSize, logger: slog.Default()}
+	for _, option := range options {
+		option(o)
+	}
+	return o
+}
+
+// OpenOutbox loads the outbox persisted at path and replays its journal, a missing file is an empty outbox.
+// Close has to be called to write the last changes.
+func OpenOutbox(path string, options ...OutboxOption) (*Outbox, error) {
+	o := NewOutbox(options...)
+	o.path = path
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("error reading outbox %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &o.state); err != nil {
+			return nil, fmt.Errorf("error decoding outbox %s %w", path, err)
+		}
+	}
+	if err := o.replay(); err != nil {
+		return nil, err
+	}
+	if o.journal, err = os.OpenFile(o.journalPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600); err != nil {
+		return nil, fmt.Errorf("error opening outbox journal %w", err)
+	}
+	// the replayed records go to the file so that the journal starts empty
+	if err := o.compact(); err != nil {
+		o.journal.Close()
+		return nil, err
+	}
+	o.dirty = make(chan struct{}, 1)
+	o.closed = make(chan struct{})
+	o.done = make(chan struct{})
+	go o.run()
+	return o, nil
+}
+
+func (o *Outbox) journalPath() string {
+	return o.path + ".journal"
+}
+
+// replay applies the records of the journal which are newer than the file
+func (o *Outbox) replay() error {
+	f, err := os.Open(o.journalPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading outbox journal %w", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64<<20)
+	for scanner.Scan() {
+		var r outboxRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			// a crash while writing leaves a torn last record, it was never applied
+			break
+		}
+		if r.Seq > o.state.Seq {
+			o.state.apply(r, o.maxDeadLetters)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading outbox journal %w", err)
+	}
+	return nil
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// commit applies a change, with a journal a durable change is synced to it first
+// and the others are left to the background writer. It must be called while holding the mutex.
+func (o *Outbox) commit(r outboxRecord, durable bool) error {
+	r.Seq = o.state.Seq + 1
+	if o.journal != nil {
+		line, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		o.buffer = append(append(o.buffer, line...), '\n')
+		if !durable {
+			select {
+			case o.dirty <- struct{}{}:
+			default:
+			}
+		} else if err := o.writeBuffer(); err != nil {
+			o.buffer = o.buffer[:len(o.buffer)-len(line)-1]
+			return err
+		} else if err := o.journal.Sync(); err != nil {
+			// the record may not be on disk, it is cut off and not applied
+			o.journalSize -= int64(len(line) + 1)
+			_ = o.journal.Truncate(o.journalSize)
+			return fmt.Errorf("error syncing outbox journal %w", err)
+		}
+	}
+	o.state.apply(r, o.maxDeadLetters)
+	return nil
+}
+
+// writeBuffer appends the buffered records to the journal, a failed write is cut off so the journal stays whole.
+// It must be called while holding the mutex.
+func (o *Outbox) writeBuffer() error {
+	if len(o.buffer) == 0 {
+		return nil
+	}
+	if _, err := o.journal.Write(o.buffer); err != nil {
+		_ = o.journal.Truncate(o.journalSize)
+		return fmt.Errorf("error writing outbox journal %w", err)
+	}
+	o.journalSize += int64(len(o.buffer))
+	o.buffer = o.buffer[:0]
+	return nil
+}
+
+// run writes the buffered records in batches until Close
+func (o *Outbox) run() {
+	defer close(o.done)
+	for {
+		select {
+		case <-o.dirty:
+			if err := o.flush(); err != nil {
+				o.logger.Error("Failed to write outbox", "path", o.path, "error", err)
+			}
+		case <-o.closed:
+			return
+		}
+	}
+}
+
+// flush writes and syncs the buffered records, the sync is made without the mutex so the changes go on meanwhile.
+// Once the journal is large it is compacted into the file.
+func (o *Outbox) flush() error {
+	o.mutex.Lock()
+	err := o.writeBuffer()
+	o.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := o.journal.Sync(); err != nil {
+		return fmt.Errorf("error syncing outbox journal %w", err)
+	}
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if o.journalSize < o.compactSize {
+		return nil
+	}
+	return o.compact()
+}
+
+// compact writes the state to the file and empties the journal, the records still buffered are part of the state.
+// The file keeps the sequence of its last record, so a crash before the journal is emptied replays nothing twice.
+// It must be called while holding the mutex.
+func (o *Outbox) compact() error {
+	if err := o.persist(o.state); err != nil {
+		return err
+	}
+	if err := o.journal.Truncate(0); err != nil {
+		return fmt.Errorf("error truncating outbox journal %w", err)
+	}
+	o.journalSize = 0
+	o.buffer = o.buffer[:0]
+	return nil
+}
+
+// Close writes and syncs the changes left, the outbox must not be changed afterwards
+func (o *Outbox) Close() error {
+	if o.journal == nil {
+		return nil
+	}
+	var err error
+	o.closeOnce.Do(func() {
+		close(o.closed)
+		<-o.done
+		err = o.flush()
+		o.mutex.Lock()
+		defer o.mutex.Unlock()
+		err = errors.Join(err, o.journal.Close())
+	})
+	return err
+}
+
+// persist writes the state to a temporary file, syncs it and renames it so a crash never leaves half a file
+func (o *Outbox) persist(state outboxState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(o.path), filepath.Base(o.path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("error writing outbox %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing outbox %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error syncing outbox %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing outbox %w", err)
+	}
+	if err := os.Rename(tmp.Name(), o.path); err != nil {
+		return fmt.Errorf("error writing outbox %w", err)
+	}
+	return syncDir(filepath.Dir(o.path))
+}
+
+// syncDir makes the rename of the file durable
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error syncing outbox directory %w", err)
+	}
+	defer dir.Close()
+	if err := dir.Sync(); err != nil {
+		return fmt.Errorf("error syncing outbox directory %w", err)
+	}
+	return nil
+}
+
+// RegisterWebhook adds a callback URL for an address on behalf of an API key, empty without API keys.
+// Registering the same URL twice for an address with the same key returns the existing webhook,
+// another key registering it gets a webhook and a secret of its own.
+func (o *Outbox) RegisterWebhook(keyID string, address ethereum.Address, url string) (Webhook, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	for _, webhook := range o.state.Webhooks {
+		if webhook.KeyID == keyID && webhook.Address == address && webhook.URL == url {
+			return webhook, nil
+		}
+	}
+	webhook := Webhook{
+		ID:        newID(),
+		KeyID:     keyID,
+		Address:   address,
+		URL:       url,
+		Secret:    newID() + newID(),
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := o.commit(outboxRecord{Op: opRegister, Webhook: &webhook}, true); err != nil {
+		return Webhook{}, err
+	}
+	return webhook, nil
+}
+
+// Webhooks returns the registered webhooks
+func (o *Outbox) Webhooks() []Webhook {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return append([]Webhook{}, o.state.Webhooks...)
+}
+
+// WebhooksOf returns the webhooks registered by the key
+func (o *Outbox) WebhooksOf(keyID string) []Webhook {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	webhooks := []Webhook{}
+	for _, webhook := range o.state.Webhooks {
+		if webhook.KeyID == keyID {
+			webhooks = append(webhooks, webhook)
+		}
+	}
+	return webhooks
+}
+
+// UnregisterWebhook removes a webhook of the key, ErrNotFound when the key has no webhook with the id
+func (o *Outbox) UnregisterWebhook(keyID, id string) (Webhook, error) {
+	removed, err := o.removeWebhooks(func(webhook Webhook) bool { return webhook.KeyID == keyID && webhook.ID == id })
+	if err != nil {
+		return Webhook{}, err
+	}
+	if len(removed) == 0 {
+		return Webhook{}, fmt.Errorf("webhook %s %w", id, ErrNotFound)
+	}
+	return removed[0], nil
+}
+
+// RemoveWebhooks removes the webhooks of the key for the addresses, when the key unsubscribes from them
+func (o *Outbox) RemoveWebhooks(keyID string, addresses []ethereum.Address) ([]Webhook, error) {
+	unsubscribed := make(map[ethereum.Address]bool, len(addresses))
+	for _, address := range addresses {
+		unsubscribed[address] = true
+	}
+	return o.removeWebhooks(func(webhook Webhook) bool { return webhook.KeyID == keyID && unsubscribed[webhook.Address] })
+}
+
+// RemoveKeyWebhooks removes every webhook of the key, when it is revoked
+func (o *Outbox) RemoveKeyWebhooks(keyID string) ([]Webhook, error) {
+	return o.removeWebhooks(func(webhook Webhook) bool { return webhook.KeyID == keyID })
+}
+
+// removeWebhooks removes the matching webhooks with their pending deliveries, the dead letters are kept
+func (o *Outbox) removeWebhooks(match func(Webhook) bool) ([]Webhook, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	var removed []Webhook
+	var ids []string
+	for _, webhook := range o.state.Webhooks {
+		if match(webhook) {
+			removed = append(removed, webhook)
+			ids = append(ids, webhook.ID)
+		}
+	}
+	if len(removed) == 0 {
+		return nil, nil
+	}
+	if err := o.commit(outboxRecord{Op: opRemove, IDs: ids}, true); err != nil {
+		return nil, err
+	}
+	return removed, nil
+}
+
+func (o *Outbox) webhook(id string) (Webhook, bool) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	for _, webhook := range o.state.Webhooks {
+		if webhook.ID == id {
+			return webhook, true
+		}
+	}
+	return Webhook{}, false
+}
+
+// enqueue creates a delivery of the event for every webhook of its address, they are written in the background
+func (o *Outbox) enqueue(event Event) (int, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	now := time.Now().UTC()
+	var deliveries []Delivery
+	for _, webhook := range o.state.Webhooks {
+		if webhook.Address != event.Address {
+			continue
+		}
+		deliveries = append(deliveries, Delivery{
+			ID:          newID(),
+			WebhookID:   webhook.ID,
+			URL:         webhook.URL,
+			Event:       event,
+			NextAttempt: now,
+			CreatedAt:   now,
+		})
+	}
+	if len(deliveries) == 0 {
+		return 0, nil
+	}
+	if err := o.commit(outboxRecord{Op: opEnqueue, Deliveries: deliveries}, false); err != nil {
+		return 0, err
+	}
+	return len(deliveries), nil
+}
+
+// Pending returns the deliveries waiting to be sent
+func (o *Outbox) Pending() []Delivery {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return append([]Delivery{}, o.state.Pending...)
+}
+
+// DeadLetters returns the deliveries which ran out of attempts
+func (o *Outbox) DeadLetters() []Delivery {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return append([]Delivery{}, o.state.DeadLetters...)
+}
+
+func indexOf(deliveries []Delivery, id string) int {
+	for i, delivery := range deliveries {
+		if delivery.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// complete removes a delivered delivery
+func (o *Outbox) complete(id string) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if indexOf(o.state.Pending, id) < 0 {
+		return nil
+	}
+	return o.commit(outboxRecord{Op: opComplete, IDs: []string{id}}, false)
+}
+
+// retry stores the failed attempt and the time of the next one
+func (o *Outbox) retry(delivery Delivery) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if indexOf(o.state.Pending, delivery.ID) < 0 {
+		return nil
+	}
+	return o.commit(outboxRecord{Op: opRetry, Deliveries: []Delivery{delivery}}, false)
+}
+
+// kill moves a delivery from the pending list to the dead letters, dropping the oldest dead letter when they are full
+func (o *Outbox) kill(delivery Delivery) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if indexOf(o.state.Pending, delivery.ID) < 0 {
+		return nil
+	}
+	return o.commit(outboxRecord{Op: opKill, Deliveries: []Delivery{delivery}}, false)
+}
+
+// Redeliver moves a dead letter back to the pending deliveries with a fresh set of attempts
+func (o *Outbox) Redeliver(id string) (Delivery, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	i := indexOf(o.state.DeadLetters, id)
+	if i < 0 {
+		return Delivery{}, fmt.Errorf("dead letter %s %w", id, ErrNotFound)
+	}
+	delivery := o.state.DeadLetters[i]
+	delivery.Attempts = 0
+	delivery.NextAttempt = time.Now().UTC()
+	if err := o.commit(outboxRecord{Op: opRedeliver, Deliveries: []Delivery{delivery}}, true); err != nil {
+		return Delivery{}, err
+	}
+	return delivery, nil
+}
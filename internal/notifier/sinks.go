@@ -0,0 +1,140 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// ChannelSink hands events to an in-process consumer through a channel
+type ChannelSink struct {
+	events    chan Event
+	closeOnce sync.Once
+}
+
+// NewChannelSink creates a ChannelSink whose channel holds buffer events
+func NewChannelSink(buffer int) *ChannelSink {
+	return &ChannelSink{events: make(chan Event, buffer)}
+}
+
+// Events is closed when the sink is closed
+func (s *ChannelSink) Events() <-chan Event {
+	return s.events
+}
+
+func (s *ChannelSink) Name() string {
+	return "channel"
+}
+
+// Send waits for room in the channel until the context is done
+func (s *ChannelSink) Send(ctx context.Context, event Event) error {
+	select {
+	case s.events <- event:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *ChannelSink) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.events)
+	})
+	return nil
+}
+
+// NDJSONSink writes one JSON encoded event per line
+type NDJSONSink struct {
+	name   string
+	w      io.Writer
+	closer io.Closer
+	mutex  sync.Mutex
+}
+
+// NewNDJSONSink writes to w, closing the sink does not close w
+func NewNDJSONSink(w io.Writer) *NDJSONSink {
+	return &NDJSONSink{name: "ndjson", w: w}
+}
+
+// NewFileSink appends to the file at path, creating it when needed
+func NewFileSink(path string) (*NDJSONSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening notification file %w", err)
+	}
+	return &NDJSONSink{name: "file:" + path, w: f, closer: f}, nil
+}
+
+func (s *NDJSONSink) Name() string {
+	return s.name
+}
+
+func (s *NDJSONSink) Send(_ context.Context, event Event) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, err = s.w.Write(append(line, '\n'))
+	return err
+}
+
+func (s *NDJSONSink) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
+
+// WebhookSink posts each event as JSON to a URL, any non-2xx response is an error
+type WebhookSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookSink posts to url with a client using a 10 second timeout
+func NewWebhookSink(url string) *WebhookSink {
+	return &WebhookSink{
+		url: url,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+func (s *WebhookSink) Name() string {
+	return "webhook:" + s.url
+}
+
+func (s *WebhookSink) Send(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("StatusCode: %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *WebhookSink) Close() error {
+	return nil
+}
@@ -0,0 +1,69 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// ErrPrivateTarget is returned for a webhook whose host is a loopback, private, link-local or unspecified address,
+// the webhooks are registered by the tenants and must not reach the network of the parser
+var ErrPrivateTarget = errors.New("webhook target is not a public address")
+
+// LookupIP resolves a host name, net.DefaultResolver.LookupIP is one
+type LookupIP func(ctx context.Context, network, host string) ([]net.IP, error)
+
+// PublicIP tells if a webhook may be delivered to the IP
+func PublicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() && !ip.IsUnspecified()
+}
+
+// CheckTarget fails with ErrPrivateTarget when the host or one of the addresses it resolves to is not public.
+// The dispatcher checks the address it connects to again since the host may resolve to another one later.
+func CheckTarget(ctx context.Context, lookup LookupIP, host string) error {
+	if ip := net.ParseIP(host); ip != nil {
+		if !PublicIP(ip) {
+			return fmt.Errorf("%w: %s", ErrPrivateTarget, host)
+		}
+		return nil
+	}
+	ips, err := lookup(ctx, "ip", host)
+	if err != nil {
+		return fmt.Errorf("cannot resolve %s: %w", host, err)
+	}
+	for _, ip := range ips {
+		if !PublicIP(ip) {
+			return fmt.Errorf("%w: %s resolves to %s", ErrPrivateTarget, host, ip)
+		}
+	}
+	return nil
+}
+
+// publicTransport only connects to public addresses, the check runs on the resolved address of each connection
+// so that neither a DNS answer changed after the registration nor a redirect reaches a private address.
+// It does not use a proxy since the proxy would connect to the target in its place.
+func publicTransport() *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   10 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !PublicIP(ip) {
+				return fmt.Errorf("%w: %s", ErrPrivateTarget, host)
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return transport
+}
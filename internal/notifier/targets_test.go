@@ -0,0 +1,72 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublicIP(t *testing.T) {
+	for _, tt := range []struct {
+		ip     string
+		public bool
+	}{
+		{"203.0.113.10", true},
+		{"2001:db8::1", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"fd00::1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"::ffff:127.0.0.1", false},
+	} {
+		assert.Equal(t, tt.public, PublicIP(net.ParseIP(tt.ip)), tt.ip)
+	}
+}
+
+func TestCheckTarget(t *testing.T) {
+	lookup := func(_ context.Context, _, host string) ([]net.IP, error) {
+		switch host {
+		case "public.test":
+			return []net.IP{net.ParseIP("203.0.113.10")}, nil
+		case "mixed.test":
+			return []net.IP{net.ParseIP("203.0.113.10"), net.ParseIP("10.0.0.1")}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+
+	assert.NoError(t, CheckTarget(context.Background(), lookup, "public.test"))
+	assert.NoError(t, CheckTarget(context.Background(), lookup, "203.0.113.10"))
+	assert.ErrorIs(t, CheckTarget(context.Background(), lookup, "mixed.test"), ErrPrivateTarget)
+	assert.ErrorIs(t, CheckTarget(context.Background(), lookup, "169.254.169.254"), ErrPrivateTarget)
+	err := CheckTarget(context.Background(), lookup, "unknown.test")
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrPrivateTarget)
+}
+
+func TestWebhookDispatcherRefusesPrivateTargets(t *testing.T) {
+	outbox := NewOutbox()
+	server := newFlakyServer(t, 0, func() string { return "" })
+	defer server.Close()
+	_, err := outbox.RegisterWebhook("", testAddress, server.URL)
+	assert.NoError(t, err)
+
+	// the URL was registered before the check, the dispatcher still does not connect to the loopback address
+	dispatcher := NewWebhookDispatcher(outbox, WithMaxAttempts(1))
+	defer dispatcher.Close()
+	assert.NoError(t, dispatcher.Send(context.Background(), Event{Type: EventTransaction, Address: testAddress}))
+
+	waitFor(t, func() bool { return len(outbox.DeadLetters()) == 1 })
+	assert.Contains(t, outbox.DeadLetters()[0].LastError, ErrPrivateTarget.Error())
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	assert.Zero(t, server.requests)
+}
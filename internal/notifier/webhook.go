@@ -0,0 +1,372 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// SignatureHeader carries "sha256=" followed by the hex HMAC-SHA256 of "<timestamp>.<body>"
+	SignatureHeader = "X-Webhook-Signature"
+	// TimestampHeader carries the unix time the payload was signed at
+	TimestampHeader = "X-Webhook-Timestamp"
+	// DeliveryHeader carries the delivery ID, it stays the same across retries so receivers can de-duplicate
+	DeliveryHeader = "X-Webhook-Delivery"
+)
+
+var (
+	// ErrInvalidSignature is returned by VerifySignature when the signature does not match the payload
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	// ErrExpiredTimestamp is returned by VerifySignature when the payload was signed too long ago
+	ErrExpiredTimestamp = errors.New("webhook timestamp outside of tolerance")
+)
+
+// Sign returns the value of the signature header for a payload signed at timestamp
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature checks the headers of a received webhook against its body,
+// payloads signed more than tolerance away from now are rejected to prevent replays
+func VerifySignature(secret, timestampHeader, signatureHeader string, body []byte, tolerance time.Duration, now time.Time) error {
+	timestamp, err := strconv.ParseInt(timestampHeader, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: bad timestamp %q", ErrInvalidSignature, timestampHeader)
+	}
+	if delta := now.Sub(time.Unix(timestamp, 0)); delta > tolerance || delta < -tolerance {
+		return ErrExpiredTimestamp
+	}
+	expected := Sign(secret, timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(signatureHeader)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// WebhookDispatcher is a Sink delivering events to the webhooks registered in an Outbox.
+// Send only writes the deliveries to the outbox, a background goroutine sends them,
+// retries failures with an exponential backoff and moves them to the dead letters after the last attempt.
+// The deliveries to different URLs are sent concurrently, the ones to the same URL in order,
+// so a slow or failing receiver does not hold the others.
+type WebhookDispatcher struct {
+	outbox *Outbox
+	client *http.Client
+	// privateTargets lets the default client connect to the addresses refused by PublicIP
+	privateTargets bool
+	concurrency    int
+	maxAttempts    int
+	baseBackoff    time.Duration
+	maxBackoff     time.Duration
+	now            func() time.Time
+	wake           chan struct{}
+	// busy are the URLs a goroutine is delivering to, blocked the ones waiting for the retry of a failed delivery,
+	// both are only used by the run goroutine which the others report to through finished
+	busy      map[string]bool
+	blocked   map[string]time.Time
+	finished  chan urlResult
+	workers   sync.WaitGroup
+	ctx       context.Context
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+	done      chan struct{}
+	logger    *slog.Logger
+}
+
+type WebhookOption func(*WebhookDispatcher)
+
+// WithWebhookLogger sets the logger of the dispatcher, slog.Default() by default
+func WithWebhookLogger(logger *slog.Logger) WebhookOption {
+	return func(d *WebhookDispatcher) {
+		d.logger = logger
+	}
+}
+
+// WithMaxAttempts sets how many times a delivery is tried before it becomes a dead letter
+func WithMaxAttempts(attempts int) WebhookOption {
+	return func(d *WebhookDispatcher) {
+		d.maxAttempts = attempts
+	}
+}
+
+// WithConcurrency sets how many URLs are delivered to at the same time, 8 by default
+func WithConcurrency(concurrency int) WebhookOption {
+	return func(d *WebhookDispatcher) {
+		d.concurrency = concurrency
+	}
+}
+
+// WithBackoff sets the delay after the first failure, it doubles after each failure up to max
+func WithBackoff(base, max time.Duration) WebhookOption {
+	return func(d *WebhookDispatcher) {
+		d.baseBackoff = base
+		d.maxBackoff = max
+	}
+}
+
+// WithHTTPClient replaces the client used to post the payloads,
+// the client is used as is: it is up to it to keep away from the private addresses
+func WithHTTPClient(client *http.Client) WebhookOption {
+	return func(d *WebhookDispatcher) {
+		d.client = client
+	}
+}
+
+// WithPrivateTargets lets the default client deliver to loopback, private and link-local addresses,
+// e.g. when the receivers run next to the parser
+func WithPrivateTargets() WebhookOption {
+	return func(d *WebhookDispatcher) {
+		d.privateTargets = true
+	}
+}
+
+// NewWebhookDispatcher starts delivering the pending deliveries of the outbox, Close stops it
+func NewWebhookDispatcher(outbox *Outbox, options ...WebhookOption) *WebhookDispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &WebhookDispatcher{
+		outbox:      outbox,
+		concurrency: 8,
+		maxAttempts: 8,
+		baseBackoff: time.Second,
+		maxBackoff:  10 * time.Minute,
+		now:         time.Now,
+		wake:        make(chan struct{}, 1),
+		busy:        make(map[string]bool),
+		blocked:     make(map[string]time.Time),
+		finished:    make(chan urlResult),
+		ctx:         ctx,
+		cancel:      cancel,
+		done:        make(chan struct{}),
+		logger:      slog.Default(),
+	}
+	for _, option := range options {
+		option(d)
+	}
+	if d.client == nil {
+		d.client = &http.Client{Timeout: 10 * time.Second}
+		if !d.privateTargets {
+			d.client.Transport = publicTransport()
+		}
+	}
+	go d.run()
+	return d
+}
+
+func (d *WebhookDispatcher) Name() string {
+	return "webhooks"
+}
+
+// Send stores a delivery per webhook of the event address
+func (d *WebhookDispatcher) Send(_ context.Context, event Event) error {
+	count, err := d.outbox.enqueue(event)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		d.Wake()
+	}
+	return nil
+}
+
+// Wake makes the dispatcher look at the outbox now, e.g. after a dead letter was redelivered
+func (d *WebhookDispatcher) Wake() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Close stops the delivery goroutine, the undelivered events stay in the outbox
+func (d *WebhookDispatcher) Close() error {
+	d.closeOnce.Do(d.cancel)
+	<-d.done
+	return nil
+}
+
+func (d *WebhookDispatcher) run() {
+	defer close(d.done)
+	for {
+		next := d.deliverDue()
+		wait := time.Minute
+		if !next.IsZero() {
+			wait = next.Sub(d.now())
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-d.ctx.Done():
+			timer.Stop()
+			d.workers.Wait()
+			return
+		case <-d.wake:
+		case result := <-d.finished:
+			delete(d.busy, result.url)
+			if result.retryAt.IsZero() {
+				delete(d.blocked, result.url)
+			} else {
+				d.blocked[result.url] = result.retryAt
+			}
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// urlResult is how the deliveries to a URL went, retryAt is zero when they were all attempted
+type urlResult struct {
+	url     string
+	retryAt time.Time
+}
+
+// deliverDue starts a goroutine for each URL with deliveries whose time has come and returns when the next one is due,
+// a URL waits for the retry of its failed delivery before the next ones are attempted
+func (d *WebhookDispatcher) deliverDue() time.Time {
+	var next time.Time
+	// the due deliveries by URL, in the order they were queued
+	var urls []string
+	due := make(map[string][]Delivery)
+	now := d.now()
+	for _, delivery := range d.outbox.Pending() {
+		if d.busy[delivery.URL] {
+			// the goroutine of the URL tells the loop when it is done
+			continue
+		}
+		at := delivery.NextAttempt
+		if blocked := d.blocked[delivery.URL]; blocked.After(at) {
+			at = blocked
+		}
+		if at.After(now) {
+			if next.IsZero() || at.Before(next) {
+				next = at
+			}
+			continue
+		}
+		if _, ok := due[delivery.URL]; !ok {
+			urls = append(urls, delivery.URL)
+		}
+		due[delivery.URL] = append(due[delivery.URL], delivery)
+	}
+	for _, url := range urls {
+		if len(d.busy) >= max(d.concurrency, 1) {
+			// a finishing goroutine wakes the loop for the others
+			break
+		}
+		d.busy[url] = true
+		d.workers.Add(1)
+		go func(url string, deliveries []Delivery) {
+			defer d.workers.Done()
+			result := urlResult{url: url, retryAt: d.deliverInOrder(deliveries)}
+			select {
+			case d.finished <- result:
+			case <-d.ctx.Done():
+			}
+		}(url, due[url])
+	}
+	return next
+}
+
+// deliverInOrder attempts the deliveries of one URL until one fails and returns when to retry it
+func (d *WebhookDispatcher) deliverInOrder(deliveries []Delivery) time.Time {
+	for _, delivery := range deliveries {
+		if d.ctx.Err() != nil {
+			return time.Time{}
+		}
+		if retryAt, retry := d.attempt(delivery); retry {
+			return retryAt
+		}
+	}
+	return time.Time{}
+}
+
+// attempt sends one delivery and records the outcome, it returns when to retry if it failed
+func (d *WebhookDispatcher) attempt(delivery Delivery) (time.Time, bool) {
+	logger := d.logger.With("delivery", delivery.ID, "webhook", delivery.WebhookID)
+	webhook, ok := d.outbox.webhook(delivery.WebhookID)
+	if !ok {
+		logger.Warn("Dropping delivery of a removed webhook")
+		if err := d.outbox.complete(delivery.ID); err != nil {
+			logger.Error("Failed to update the outbox", "error", err)
+		}
+		return time.Time{}, false
+	}
+	err := d.post(webhook, delivery)
+	if err == nil {
+		if err := d.outbox.complete(delivery.ID); err != nil {
+			logger.Error("Failed to update the outbox", "error", err)
+		}
+		return time.Time{}, false
+	}
+	if d.ctx.Err() != nil {
+		// shutting down, the attempt does not count
+		return time.Time{}, false
+	}
+
+	delivery.Attempts++
+	delivery.LastError = err.Error()
+	if delivery.Attempts >= d.maxAttempts {
+		logger.Error("Delivery failed, moving it to the dead letters", "url", delivery.URL, "attempts", delivery.Attempts, "error", err)
+		if err := d.outbox.kill(delivery); err != nil {
+			logger.Error("Failed to update the outbox", "error", err)
+		}
+		return time.Time{}, false
+	}
+	delivery.NextAttempt = d.now().Add(d.backoff(delivery.Attempts))
+	logger.Warn("Delivery failed, retrying", "url", delivery.URL, "attempt", delivery.Attempts+1, "nextAttempt", delivery.NextAttempt, "error", err)
+	if err := d.outbox.retry(delivery); err != nil {
+		logger.Error("Failed to update the outbox", "error", err)
+	}
+	return delivery.NextAttempt, true
+}
+
+// backoff returns the delay after the given number of failed attempts
+func (d *WebhookDispatcher) backoff(attempts int) time.Duration {
+	delay := d.baseBackoff
+	for i := 1; i < attempts && delay < d.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > d.maxBackoff {
+		delay = d.maxBackoff
+	}
+	return delay
+}
+
+func (d *WebhookDispatcher) post(webhook Webhook, delivery Delivery) error {
+	body, err := json.Marshal(delivery.Event)
+	if err != nil {
+		return err
+	}
+	timestamp := d.now().Unix()
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(webhook.Secret, timestamp, body))
+	req.Header.Set(DeliveryHeader, delivery.ID)
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("StatusCode: %s %s", resp.Status, strings.TrimSpace(string(snippet)))
+	}
+	return nil
+}
@@ -0,0 +1,368 @@
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/meirongdev/ethereum_parser/internal/ethereum"
+	"github.com/meirongdev/ethereum_parser/internal/parser"
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyServer fails the first failures requests, then accepts and records the verified ones
+type flakyServer struct {
+	*httptest.Server
+	mutex      sync.Mutex
+	failures   int
+	requests   int
+	deliveries []string
+	received   chan struct{}
+}
+
+func newFlakyServer(t *testing.T, failures int, secret func() string) *flakyServer {
+	s := &flakyServer{failures: failures, received: make(chan struct{}, 100)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		defer func() { s.received <- struct{}{} }()
+		s.requests++
+		if s.requests <= s.failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		err := VerifySignature(secret(), r.Header.Get(TimestampHeader), r.Header.Get(SignatureHeader), body, time.Minute, time.Now())
+		assert.NoError(t, err)
+		s.deliveries = append(s.deliveries, r.Header.Get(DeliveryHeader))
+		w.WriteHeader(http.StatusOK)
+	}))
+	return s
+}
+
+func (s *flakyServer) waitRequests(t *testing.T, n int) {
+	for i := 0; i < n; i++ {
+		select {
+		case <-s.received:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for request %d", i+1)
+		}
+	}
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	body := []byte(`{"type":"transaction"}`)
+	now := time.Unix(1700000000, 0)
+	signature := Sign("secret", now.Unix(), body)
+
+	assert.NoError(t, VerifySignature("secret", "1700000000", signature, body, time.Minute, now))
+	assert.ErrorIs(t, VerifySignature("other", "1700000000", signature, body, time.Minute, now), ErrInvalidSignature)
+	assert.ErrorIs(t, VerifySignature("secret", "1700000000", signature, []byte("{}"), time.Minute, now), ErrInvalidSignature)
+	assert.ErrorIs(t, VerifySignature("secret", "1700000001", signature, body, time.Minute, now), ErrInvalidSignature)
+	assert.ErrorIs(t, VerifySignature("secret", "abc", signature, body, time.Minute, now), ErrInvalidSignature)
+	// a replay of a valid payload after the tolerance is rejected
+	assert.ErrorIs(t, VerifySignature("secret", "1700000000", signature, body, time.Minute, now.Add(2*time.Minute)), ErrExpiredTimestamp)
+}
+
+func TestWebhookDispatcherRetriesUntilDelivered(t *testing.T) {
+	outbox := NewOutbox()
+	var webhook Webhook
+	server := newFlakyServer(t, 2, func() string { return webhook.Secret })
+	defer server.Close()
+
+	var err error
+	webhook, err = outbox.RegisterWebhook("", testAddress, server.URL)
+	assert.NoError(t, err)
+
+	dispatcher := NewWebhookDispatcher(outbox, WithPrivateTargets(), WithBackoff(time.Millisecond, 10*time.Millisecond), WithMaxAttempts(5))
+	defer dispatcher.Close()
+
+	assert.NoError(t, dispatcher.Send(context.Background(), Event{Type: EventTransaction, Address: testAddress, Transaction: parser.Transaction{Hash: "0x1"}}))
+	// events of other addresses have no webhook
+	assert.NoError(t, dispatcher.Send(context.Background(), Event{Type: EventTransaction, Address: ethereum.Address{1}}))
+
+	server.waitRequests(t, 3)
+	waitFor(t, func() bool { return len(outbox.Pending()) == 0 })
+	assert.Len(t, server.deliveries, 1)
+	assert.Empty(t, outbox.DeadLetters())
+}
+
+func TestWebhookDispatcherDeadLetterAndRedeliver(t *testing.T) {
+	outbox := NewOutbox()
+	var webhook Webhook
+	server := newFlakyServer(t, 3, func() string { return webhook.Secret })
+	defer server.Close()
+
+	var err error
+	webhook, err = outbox.RegisterWebhook("", testAddress, server.URL)
+	assert.NoError(t, err)
+
+	dispatcher := NewWebhookDispatcher(outbox, WithPrivateTargets(), WithBackoff(time.Millisecond, time.Millisecond), WithMaxAttempts(3))
+	defer dispatcher.Close()
+
+	assert.NoError(t, dispatcher.Send(context.Background(), Event{Type: EventTransaction, Address: testAddress}))
+	server.waitRequests(t, 3)
+	waitFor(t, func() bool { return len(outbox.DeadLetters()) == 1 })
+	dead := outbox.DeadLetters()[0]
+	assert.Equal(t, 3, dead.Attempts)
+	assert.Contains(t, dead.LastError, "503")
+	assert.Empty(t, outbox.Pending())
+
+	_, err = outbox.Redeliver("missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	_, err = outbox.Redeliver(dead.ID)
+	assert.NoError(t, err)
+	dispatcher.Wake()
+	server.waitRequests(t, 1)
+	waitFor(t, func() bool { return len(outbox.Pending()) == 0 })
+	assert.Empty(t, outbox.DeadLetters())
+	assert.Equal(t, []string{dead.ID}, server.deliveries)
+}
+
+func TestOutboxSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.json")
+	outbox, err := OpenOutbox(path)
+	assert.NoError(t, err)
+
+	// the receiver is down, nothing is delivered before the restart
+	webhook, err := outbox.RegisterWebhook("", testAddress, "http://127.0.0.1:1/unreachable")
+	assert.NoError(t, err)
+	again, err := outbox.RegisterWebhook("", testAddress, "http://127.0.0.1:1/unreachable")
+	assert.NoError(t, err)
+	assert.Equal(t, webhook, again)
+	_, err = outbox.enqueue(Event{Type: EventTransaction, Address: testAddress, Transaction: parser.Transaction{Hash: "0x1"}})
+	assert.NoError(t, err)
+	assert.NoError(t, outbox.Close())
+
+	reopened, err := OpenOutbox(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []Webhook{webhook}, reopened.Webhooks())
+	pending := reopened.Pending()
+	assert.Len(t, pending, 1)
+	assert.Equal(t, "0x1", pending[0].Event.Transaction.Hash)
+
+	server := newFlakyServer(t, 0, func() string { return webhook.Secret })
+	defer server.Close()
+	reopened.state.Webhooks[0].URL = server.URL
+
+	dispatcher := NewWebhookDispatcher(reopened, WithPrivateTargets())
+	server.waitRequests(t, 1)
+	waitFor(t, func() bool { return len(reopened.Pending()) == 0 })
+	assert.NoError(t, dispatcher.Close())
+	assert.NoError(t, reopened.Close())
+
+	final, err := OpenOutbox(path)
+	assert.NoError(t, err)
+	defer final.Close()
+	assert.Equal(t, []Webhook{webhook}, final.Webhooks())
+	assert.Empty(t, final.Pending())
+}
+
+func TestOutboxScopesWebhooksToKeys(t *testing.T) {
+	outbox := NewOutbox()
+	const url = "https://example.com/hook"
+	alice, err := outbox.RegisterWebhook("alice", testAddress, url)
+	assert.NoError(t, err)
+	// the same URL for the same address gets another webhook and another secret for another key
+	bob, err := outbox.RegisterWebhook("bob", testAddress, url)
+	assert.NoError(t, err)
+	assert.NotEqual(t, alice.ID, bob.ID)
+	assert.NotEqual(t, alice.Secret, bob.Secret)
+	again, err := outbox.RegisterWebhook("alice", testAddress, url)
+	assert.NoError(t, err)
+	assert.Equal(t, alice, again)
+	assert.Equal(t, []Webhook{bob}, outbox.WebhooksOf("bob"))
+	other, err := outbox.RegisterWebhook("bob", ethereum.Address{1}, url)
+	assert.NoError(t, err)
+
+	// an event gets a delivery per webhook, removing a webhook drops its deliveries
+	count, err := outbox.enqueue(Event{Type: EventTransaction, Address: testAddress})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+	_, err = outbox.UnregisterWebhook("bob", alice.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	removed, err := outbox.UnregisterWebhook("alice", alice.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, alice, removed)
+	if pending := outbox.Pending(); assert.Len(t, pending, 1) {
+		assert.Equal(t, bob.ID, pending[0].WebhookID)
+	}
+
+	removedAll, err := outbox.RemoveWebhooks("bob", []ethereum.Address{testAddress})
+	assert.NoError(t, err)
+	assert.Equal(t, []Webhook{bob}, removedAll)
+	assert.Empty(t, outbox.Pending())
+	removedAll, err = outbox.RemoveKeyWebhooks("bob")
+	assert.NoError(t, err)
+	assert.Equal(t, []Webhook{other}, removedAll)
+	assert.Empty(t, outbox.Webhooks())
+}
+
+func TestOutboxBoundsDeadLetters(t *testing.T) {
+	outbox := NewOutbox(WithMaxDeadLetters(2))
+	_, err := outbox.RegisterWebhook("", testAddress, "http://127.0.0.1:1/unreachable")
+	assert.NoError(t, err)
+	for _, hash := range []string{"0x1", "0x2", "0x3"} {
+		_, err := outbox.enqueue(Event{Type: EventTransaction, Address: testAddress, Transaction: parser.Transaction{Hash: hash}})
+		assert.NoError(t, err)
+	}
+	for _, delivery := range outbox.Pending() {
+		assert.NoError(t, outbox.kill(delivery))
+	}
+	var hashes []string
+	for _, delivery := range outbox.DeadLetters() {
+		hashes = append(hashes, delivery.Event.Transaction.Hash)
+	}
+	assert.Equal(t, []string{"0x2", "0x3"}, hashes)
+}
+
+func TestOutboxKeepsItsStateWhenWritingFails(t *testing.T) {
+	outbox, err := OpenOutbox(filepath.Join(t.TempDir(), "outbox.json"))
+	assert.NoError(t, err)
+	webhook, err := outbox.RegisterWebhook("", testAddress, "http://127.0.0.1:1/unreachable")
+	assert.NoError(t, err)
+
+	// the journal cannot be written anymore, the webhooks are not changed without it
+	assert.NoError(t, outbox.journal.Close())
+	_, err = outbox.RegisterWebhook("", testAddress, "http://127.0.0.1:1/other")
+	assert.Error(t, err)
+	_, err = outbox.UnregisterWebhook("", webhook.ID)
+	assert.Error(t, err)
+	assert.Equal(t, []Webhook{webhook}, outbox.Webhooks())
+}
+
+func TestOutboxReplaysItsJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.json")
+	outbox, err := OpenOutbox(path)
+	assert.NoError(t, err)
+	webhook, err := outbox.RegisterWebhook("", testAddress, "http://127.0.0.1:1/unreachable")
+	assert.NoError(t, err)
+	for _, hash := range []string{"0x1", "0x2", "0x3"} {
+		_, err := outbox.enqueue(Event{Type: EventTransaction, Address: testAddress, Transaction: parser.Transaction{Hash: hash}})
+		assert.NoError(t, err)
+	}
+	pending := outbox.Pending()
+	assert.NoError(t, outbox.complete(pending[0].ID))
+	failed := pending[1]
+	failed.Attempts, failed.LastError = 1, "HTTP 500"
+	assert.NoError(t, outbox.retry(failed))
+	assert.NoError(t, outbox.kill(pending[2]))
+	// the events go through JSON, their zero amounts are not nil anymore
+	summary := func(deliveries []Delivery) []string {
+		var lines []string
+		for _, d := range deliveries {
+			lines = append(lines, fmt.Sprintf("%s %s %d %s", d.ID, d.Event.Transaction.Hash, d.Attempts, d.LastError))
+		}
+		return lines
+	}
+	expected := summary(outbox.Pending())
+	// the changes are written in the background, without a Close as after a crash
+	waitFor(t, func() bool {
+		outbox.mutex.Lock()
+		defer outbox.mutex.Unlock()
+		return len(outbox.buffer) == 0
+	})
+	assert.NoError(t, outbox.flush())
+
+	// a crash while writing left a torn record
+	journal, err := os.OpenFile(path+".journal", os.O_WRONLY|os.O_APPEND, 0o600)
+	assert.NoError(t, err)
+	_, err = journal.WriteString(`{"seq":99,"op":"enq`)
+	assert.NoError(t, err)
+	assert.NoError(t, journal.Close())
+
+	reopened, err := OpenOutbox(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []Webhook{webhook}, reopened.Webhooks())
+	assert.Equal(t, expected, summary(reopened.Pending()))
+	if deadLetters := reopened.DeadLetters(); assert.Len(t, deadLetters, 1) {
+		assert.Equal(t, "0x3", deadLetters[0].Event.Transaction.Hash)
+	}
+	// the journal was written into the file, opening it again replays nothing twice
+	info, err := os.Stat(path + ".journal")
+	assert.NoError(t, err)
+	assert.Zero(t, info.Size())
+	assert.NoError(t, reopened.Close())
+	again, err := OpenOutbox(path)
+	assert.NoError(t, err)
+	defer again.Close()
+	assert.Equal(t, expected, summary(again.Pending()))
+	assert.Len(t, again.DeadLetters(), 1)
+}
+
+func TestOutboxCompactsItsJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.json")
+	outbox, err := OpenOutbox(path)
+	assert.NoError(t, err)
+	outbox.compactSize = 1024
+	_, err = outbox.RegisterWebhook("", testAddress, "http://127.0.0.1:1/unreachable")
+	assert.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		_, err := outbox.enqueue(Event{Type: EventTransaction, Address: testAddress, Transaction: parser.Transaction{Hash: fmt.Sprintf("0x%d", i)}})
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, outbox.flush())
+	outbox.mutex.Lock()
+	assert.Less(t, outbox.journalSize, outbox.compactSize)
+	outbox.mutex.Unlock()
+	assert.NoError(t, outbox.Close())
+
+	reopened, err := OpenOutbox(path)
+	assert.NoError(t, err)
+	defer reopened.Close()
+	assert.Len(t, reopened.Pending(), 20)
+}
+
+func TestWebhookDispatcherDeliversURLsConcurrently(t *testing.T) {
+	outbox := NewOutbox()
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+	var webhook Webhook
+	fast := newFlakyServer(t, 0, func() string { return webhook.Secret })
+	defer fast.Close()
+
+	_, err := outbox.RegisterWebhook("", testAddress, slow.URL)
+	assert.NoError(t, err)
+	webhook, err = outbox.RegisterWebhook("", testAddress, fast.URL)
+	assert.NoError(t, err)
+	dispatcher := NewWebhookDispatcher(outbox, WithPrivateTargets())
+	defer dispatcher.Close()
+
+	// the slow receiver holds its first delivery, the other one still gets the next events
+	assert.NoError(t, dispatcher.Send(context.Background(), Event{Type: EventTransaction, Address: testAddress}))
+	fast.waitRequests(t, 1)
+	assert.NoError(t, dispatcher.Send(context.Background(), Event{Type: EventTransaction, Address: testAddress}))
+	fast.waitRequests(t, 1)
+	waitFor(t, func() bool { return len(outbox.Pending()) == 2 })
+}
+
+func TestBackoff(t *testing.T) {
+	d := &WebhookDispatcher{baseBackoff: time.Second, maxBackoff: 10 * time.Second}
+	assert.Equal(t, time.Second, d.backoff(1))
+	assert.Equal(t, 2*time.Second, d.backoff(2))
+	assert.Equal(t, 8*time.Second, d.backoff(4))
+	assert.Equal(t, 10*time.Second, d.backoff(5))
+	assert.Equal(t, 10*time.Second, d.backoff(50))
+}
@@ -0,0 +1,238 @@
+package parser
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/meirongdev/ethereum_parser/internal/ethereum"
+)
+
+// Balance is the balance of a subscribed address at the end of a block
+type Balance struct {
+	BlockNumber int          `json:"blockNumber"`
+	Balance     ethereum.Wei `json:"balance"`
+	// Change is the difference with the previous balance of the history, zero for the first one
+	Change ethereum.Wei `json:"change"`
+	// Unexplained is the part of the change which the recorded transactions and their fees do not account for,
+	// such as internal transfers from contracts, withdrawals or block rewards
+	Unexplained ethereum.Wei `json:"unexplained"`
+	// Mismatch tells if Unexplained is not zero
+	Mismatch bool `json:"mismatch"`
+}
+
+// balances is the state of the balance tracking, the maps are guarded by the mutex of the parser
+type balances struct {
+	api ethereum.BalanceAPI
+	// history is the balance of each subscribed address at its subscription and at every block where it has a transaction
+	history map[ethereum.Address][]Balance
+	// unknown are the subscribed addresses whose first balance is still to be read by watchBaselines
+	unknown map[ethereum.Address]struct{}
+	// wake tells watchBaselines that there are balances to read, it holds one signal at most
+	wake chan struct{}
+	// rollbacks counts the reorganizations, a first balance read across one may belong to a dropped block
+	rollbacks int
+}
+
+// balanceWork is what updateBalances reads from the node for an address
+type balanceWork struct {
+	address  ethereum.Address
+	previous Balance
+	// transactions are the recorded transactions of the address since the previous balance
+	transactions []Transaction
+}
+
+// WithBalances tracks the balances of the subscribed addresses. The first balance is read at the last processed block
+// by a goroutine of its own, one address at a time, so subscribing many addresses does not hold the processing of the blocks.
+// The balance is then read at every block where the address has a transaction and the change is reconciled with
+// the value and the fees of the recorded transactions. The node has to keep the state of the recent blocks, which full nodes do.
+func WithBalances(api ethereum.BalanceAPI) Option {
+	return func(p *EthereumParser) {
+		p.balances.api = api
+	}
+}
+
+// GetBalance returns the last known balance of the address, false when it is not tracked or not read yet
+func (p *EthereumParser) GetBalance(address ethereum.Address) (Balance, bool) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	history := p.balances.history[address]
+	if len(history) == 0 {
+		return Balance{}, false
+	}
+	return history[len(history)-1], true
+}
+
+// BalanceHistory returns the known balances of the address, oldest first
+func (p *EthereumParser) BalanceHistory(address ethereum.Address) []Balance {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return append([]Balance(nil), p.balances.history[address]...)
+}
+
+// watchBaselines reads the first balances until the parser is stopped
+func (p *EthereumParser) watchBaselines() {
+	for {
+		select {
+		case <-p.stopChannel:
+			return
+		case <-p.balances.wake:
+			p.readBaselines()
+		}
+	}
+}
+
+// wakeBaselines tells watchBaselines to read the first balances, it does not block
+func (p *EthereumParser) wakeBaselines() {
+	select {
+	case p.balances.wake <- struct{}{}:
+	default:
+	}
+}
+
+// readBaselines reads the first balance of the subscribed addresses at the last processed block.
+// An address whose balance could not be read is tried again after the next block.
+func (p *EthereumParser) readBaselines() {
+	p.mutex.RLock()
+	blockNumber := p.currentBlock
+	rollbacks := p.balances.rollbacks
+	addresses := make([]ethereum.Address, 0, len(p.balances.unknown))
+	for address := range p.balances.unknown {
+		addresses = append(addresses, address)
+	}
+	p.mutex.RUnlock()
+	if blockNumber < 0 {
+		// nothing is processed yet, the first block wakes the reading again
+		return
+	}
+	sort.Slice(addresses, func(i, j int) bool { return addresses[i].Hex() < addresses[j].Hex() })
+	for _, address := range addresses {
+		balance, err := p.balances.api.GetBalance(address, fmt.Sprintf("0x%x", blockNumber))
+		if err != nil {
+			err = fmt.Errorf("error getting the balance of %s %w", address.Hex(), err)
+			p.logger.Warn("Failed to read the balance", "address", address.Hex(), "block", blockNumber, "error", err)
+			p.reportError(err)
+			continue
+		}
+		p.mutex.Lock()
+		_, unknown := p.balances.unknown[address]
+		if unknown && p.isSubscribed(address) && p.balances.rollbacks == rollbacks {
+			delete(p.balances.unknown, address)
+			p.balances.history[address] = []Balance{{BlockNumber: blockNumber, Balance: balance}}
+		}
+		p.mutex.Unlock()
+	}
+}
+
+// updateBalances reads the balances of the subscribed addresses with a transaction in the processed block.
+// An address whose balance could not be read is reconciled at its next transaction,
+// one whose first balance is not read yet is left to watchBaselines.
+func (p *EthereumParser) updateBalances(blockNumber int, touched map[ethereum.Address]struct{}) {
+	if p.balances.api == nil {
+		return
+	}
+	var work []balanceWork
+	p.mutex.RLock()
+	for address := range touched {
+		history := p.balances.history[address]
+		if !p.isSubscribed(address) || len(history) == 0 {
+			continue
+		}
+		item := balanceWork{address: address, previous: history[len(history)-1]}
+		transactions := p.transactions[address]
+		for i := len(transactions) - 1; i >= 0 && transactions[i].BlockNumber > item.previous.BlockNumber; i-- {
+			item.transactions = append(item.transactions, transactions[i])
+		}
+		work = append(work, item)
+	}
+	pending := len(p.balances.unknown) > 0
+	p.mutex.RUnlock()
+	if pending {
+		p.wakeBaselines()
+	}
+	sort.Slice(work, func(i, j int) bool { return work[i].address.Hex() < work[j].address.Hex() })
+
+	receipts := make(map[string]ethereum.Receipt)
+	for _, item := range work {
+		balance, err := p.readBalance(item, blockNumber, receipts)
+		if err != nil {
+			p.logger.Warn("Failed to read the balance", "address", item.address.Hex(), "block", blockNumber, "error", err)
+			p.reportError(err)
+			continue
+		}
+		p.mutex.Lock()
+		if p.isSubscribed(item.address) {
+			p.balances.history[item.address] = append(p.balances.history[item.address], balance)
+		}
+		p.mutex.Unlock()
+		if balance.Mismatch {
+			p.logger.Warn("Balance change not explained by the transactions", "address", item.address.Hex(),
+				"block", balance.BlockNumber, "change", balance.Change.String(), "unexplained", balance.Unexplained.String())
+		}
+	}
+}
+
+// readBalance returns the balance to add to the history of the address, the receipts are shared between the addresses
+func (p *EthereumParser) readBalance(item balanceWork, blockNumber int, receipts map[string]ethereum.Receipt) (Balance, error) {
+	balance, err := p.balances.api.GetBalance(item.address, fmt.Sprintf("0x%x", blockNumber))
+	if err != nil {
+		return Balance{}, fmt.Errorf("error getting the balance of %s %w", item.address.Hex(), err)
+	}
+	expected := ethereum.Wei{}
+	for _, tx := range item.transactions {
+		receipt, ok := receipts[tx.Hash]
+		if !ok {
+			receipt, err = p.balances.api.GetTransactionReceipt(tx.Hash)
+			if err != nil {
+				return Balance{}, fmt.Errorf("error getting the receipt of %s %w", tx.Hash, err)
+			}
+			if receipt.EffectiveGasPrice.IsZero() {
+				// the receipts from before London may not have it, the gas price of the transaction is what was paid
+				receipt.EffectiveGasPrice = tx.GasPrice
+			}
+			receipts[tx.Hash] = receipt
+		}
+		expected = expected.Add(balanceChange(tx, receipt))
+	}
+	change := balance.Sub(item.previous.Balance)
+	unexplained := change.Sub(expected)
+	return Balance{
+		BlockNumber: blockNumber,
+		Balance:     balance,
+		Change:      change,
+		Unexplained: unexplained,
+		Mismatch:    !unexplained.IsZero(),
+	}, nil
+}
+
+// balanceChange is how the transaction changed the balance of the address it is stored for,
+// the sender pays the fee even when the transaction reverted
+func balanceChange(tx Transaction, receipt ethereum.Receipt) ethereum.Wei {
+	change := ethereum.Wei{}
+	if tx.Direction != DirectionIn {
+		change = change.Sub(receipt.Fee())
+	}
+	if receipt.Success {
+		switch tx.Direction {
+		case DirectionIn:
+			change = change.Add(tx.Value)
+		case DirectionOut:
+			change = change.Sub(tx.Value)
+		}
+	}
+	return change
+}
+
+// rollbackBalances forgets the balances of a block dropped by a reorganization, the mutex must be held
+func (p *EthereumParser) rollbackBalances(blockNumber int) {
+	p.balances.rollbacks++
+	for address, history := range p.balances.history {
+		kept := history
+		for len(kept) > 0 && kept[len(kept)-1].BlockNumber >= blockNumber {
+			kept = kept[:len(kept)-1]
+		}
+		p.balances.history[address] = kept
+		if len(kept) == 0 && p.balances.api != nil {
+			p.balances.unknown[address] = struct{}{}
+		}
+	}
+}
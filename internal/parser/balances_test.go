@@ -0,0 +1,194 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/meirongdev/ethereum_parser/internal/ethereum"
+	"github.com/meirongdev/ethereum_parser/internal/ethereum/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeBalances answers the balances by address and block and the receipts by hash
+type fakeBalances struct {
+	balances map[string]int64
+	receipts map[string]ethereum.Receipt
+}
+
+func (f *fakeBalances) GetBalance(address ethereum.Address, blockNumber string) (ethereum.Wei, error) {
+	balance, ok := f.balances[address.String()+"@"+blockNumber]
+	if !ok {
+		return ethereum.Wei{}, fmt.Errorf("missing trie node for block %s", blockNumber)
+	}
+	return weiInt(balance), nil
+}
+
+func (f *fakeBalances) GetTransactionReceipt(hash string) (ethereum.Receipt, error) {
+	receipt, ok := f.receipts[hash]
+	if !ok {
+		return ethereum.Receipt{}, errors.New("no result for eth_getTransactionReceipt")
+	}
+	return receipt, nil
+}
+
+func weiInt(v int64) ethereum.Wei {
+	w, err := ethereum.ParseWeiDecimal(fmt.Sprint(v))
+	if err != nil {
+		panic(err)
+	}
+	return w
+}
+
+func balanceChanges(history []Balance) []string {
+	var changes []string
+	for _, balance := range history {
+		changes = append(changes, fmt.Sprintf("%d:%s:%s", balance.BlockNumber, balance.Change, balance.Unexplained))
+	}
+	return changes
+}
+
+func TestBalanceReconciliation(t *testing.T) {
+	mockAPI := new(mocks.API)
+	node := &fakeBalances{
+		balances: map[string]int64{
+			addrABC.String() + "@0x1": 1000000,
+			addrABC.String() + "@0x2": 1000000 - 100 - 21000,
+			// 5 wei came from a withdrawal
+			addrABC.String() + "@0x3": 1000000 - 100 - 21000 + 7 + 5,
+			addrABC.String() + "@0x4": 1000000 - 100 - 21000 + 7 + 5 - 21000,
+		},
+		receipts: map[string]ethereum.Receipt{
+			"0x01": {GasUsed: 21000, EffectiveGasPrice: weiInt(1), Success: true},
+			"0x02": {GasUsed: 30000, EffectiveGasPrice: weiInt(2), Success: true},
+			"0x03": {GasUsed: 21000, EffectiveGasPrice: weiInt(1), Success: false},
+		},
+	}
+	send := poolTx("0x01", hexABC, hexDEF, "0x1")
+	send["value"] = "0x64"
+	receive := poolTx("0x02", hex789, hexABC, "0x1")
+	receive["value"] = "0x7"
+	reverted := poolTx("0x03", hexABC, hexDEF, "0x2")
+	mockAPI.On("GetBlock", "0x1").Return(testBlock("0x1", []interface{}{}), nil)
+	mockAPI.On("GetBlock", "0x2").Return(testBlock("0x2", []interface{}{send}), nil)
+	mockAPI.On("GetBlock", "0x3").Return(testBlock("0x3", []interface{}{receive}), nil)
+	mockAPI.On("GetBlock", "0x4").Return(testBlock("0x4", []interface{}{reverted}), nil)
+	eParser := NewEthereumParser(mockAPI, WithBalances(node))
+	eParser.Subscribe(addrABC)
+
+	for block := 1; block <= 4; block++ {
+		assert.NoError(t, eParser.processBlock(block))
+		eParser.setCurrentBlock(block)
+		eParser.readBaselines()
+	}
+	assert.Equal(t, []string{"1:0:0", "2:-21100:0", "3:12:5", "4:-21000:0"}, balanceChanges(eParser.BalanceHistory(addrABC)))
+	assert.True(t, eParser.BalanceHistory(addrABC)[2].Mismatch)
+	latest, ok := eParser.GetBalance(addrABC)
+	assert.True(t, ok)
+	assert.Equal(t, "957912", latest.Balance.String())
+
+	// the balances of a dropped block are forgotten with its transactions
+	eParser.rollback(4)
+	assert.Len(t, eParser.BalanceHistory(addrABC), 3)
+
+	// unsubscribing forgets the history
+	eParser.Unsubscribe([]ethereum.Address{addrABC})
+	_, ok = eParser.GetBalance(addrABC)
+	assert.False(t, ok)
+}
+
+func TestBalanceReadFailures(t *testing.T) {
+	mockAPI := new(mocks.API)
+	node := &fakeBalances{
+		balances: map[string]int64{
+			// the address is subscribed before its transaction of block 1
+			addrABC.String() + "@0x0": 500,
+			addrABC.String() + "@0x3": 500 + 7 + 7,
+		},
+		receipts: map[string]ethereum.Receipt{
+			"0x02": {Success: true},
+			"0x03": {Success: true},
+		},
+	}
+	receive := poolTx("0x02", hex789, hexABC, "0x1")
+	receive["value"] = "0x7"
+	again := poolTx("0x03", hex789, hexABC, "0x2")
+	again["value"] = "0x7"
+	mockAPI.On("GetBlock", "0x1").Return(testBlock("0x1", []interface{}{receive}), nil)
+	mockAPI.On("GetBlock", "0x2").Return(testBlock("0x2", []interface{}{}), nil)
+	mockAPI.On("GetBlock", "0x3").Return(testBlock("0x3", []interface{}{again}), nil)
+	var reported []error
+	eParser := NewEthereumParser(mockAPI, WithBalances(node), OnError(func(err error) { reported = append(reported, err) }))
+	eParser.Subscribe(addrABC)
+	eParser.setCurrentBlock(0)
+	eParser.readBaselines()
+
+	// the balance after block 1 is missing, the change is reconciled at the next transaction
+	for block := 1; block <= 3; block++ {
+		assert.NoError(t, eParser.processBlock(block))
+		eParser.setCurrentBlock(block)
+	}
+	assert.Equal(t, []string{"0:0:0", "3:14:0"}, balanceChanges(eParser.BalanceHistory(addrABC)))
+	assert.Len(t, reported, 1)
+	assert.ErrorContains(t, reported[0], "missing trie node")
+}
+
+func TestBalanceBaselines(t *testing.T) {
+	mockAPI := new(mocks.API)
+	node := &fakeBalances{
+		balances: map[string]int64{
+			addrABC.String() + "@0x2": 100000,
+			addrABC.String() + "@0x3": 100000 - 100 - 21000*3,
+		},
+		receipts: map[string]ethereum.Receipt{
+			// a receipt from before London without effectiveGasPrice
+			"0x01": {GasUsed: 21000, Success: true},
+		},
+	}
+	send := poolTx("0x01", hexABC, hexDEF, "0x1")
+	send["value"] = "0x64"
+	send["gasPrice"] = "0x3"
+	mockAPI.On("GetBlock", "0x1").Return(testBlock("0x1", []interface{}{}), nil)
+	mockAPI.On("GetBlock", "0x2").Return(testBlock("0x2", []interface{}{}), nil)
+	mockAPI.On("GetBlock", "0x3").Return(testBlock("0x3", []interface{}{send}), nil)
+	eParser := NewEthereumParser(mockAPI, WithBalances(node))
+
+	// subscribing does not read the balance, it wakes the goroutine reading the first balances
+	eParser.Subscribe(addrABC)
+	assert.Len(t, eParser.balances.wake, 1)
+	<-eParser.balances.wake
+
+	// the processing of the blocks does not read the first balance, the block 1 is missing from the node
+	assert.NoError(t, eParser.processBlock(1))
+	eParser.setCurrentBlock(1)
+	assert.Len(t, eParser.balances.wake, 1)
+	eParser.readBaselines()
+	assert.Empty(t, eParser.BalanceHistory(addrABC))
+
+	// it is read again after the next block
+	assert.NoError(t, eParser.processBlock(2))
+	eParser.setCurrentBlock(2)
+	eParser.readBaselines()
+	assert.NoError(t, eParser.processBlock(3))
+	eParser.setCurrentBlock(3)
+	assert.Equal(t, []string{"2:0:0", "3:-63100:0"}, balanceChanges(eParser.BalanceHistory(addrABC)))
+	assert.Empty(t, eParser.balances.unknown)
+}
+
+func TestBalanceBaselineAcrossRollback(t *testing.T) {
+	mockAPI := new(mocks.API)
+	node := &fakeBalances{balances: map[string]int64{addrABC.String() + "@0x1": 1000}}
+	mockAPI.On("GetBlock", "0x1").Return(testBlock("0x1", []interface{}{}), nil)
+	eParser := NewEthereumParser(mockAPI, WithBalances(node))
+	eParser.Subscribe(addrABC)
+	assert.NoError(t, eParser.processBlock(1))
+	eParser.setCurrentBlock(1)
+
+	// a reorganization between the read of the balance and its recording drops it
+	node.balances = nil
+	eParser.mutex.Lock()
+	eParser.rollbackBalances(1)
+	eParser.mutex.Unlock()
+	eParser.readBaselines()
+	assert.Empty(t, eParser.BalanceHistory(addrABC))
+}
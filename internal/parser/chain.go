@@ -0,0 +1,116 @@
+package parser
+
+import (
+	"errors"
+
+	"github.com/meirongdev/ethereum_parser/internal/ethereum"
+)
+
+// reorgWindow is how many recent block hashes are kept to detect reorganizations
+const reorgWindow = 64
+
+// errReorg is returned by processBlock when the block does not extend the processed chain,
+// the last processed block has been rolled back and has to be processed again
+var errReorg = errors.New("chain reorganization")
+
+// blockRecord remembers a recently processed block
+type blockRecord struct {
+	hash string
+	// the addresses which have a transaction in the block
+	addresses map[ethereum.Address]struct{}
+}
+
+// WithConfirmations sets the depth at which a transaction is confirmed,
+// a transaction in the head block has one confirmation. 0 disables confirmations.
+func WithConfirmations(confirmations int) Option {
+	return func(p *EthereumParser) {
+		p.confirmations = confirmations
+	}
+}
+
+// checkParent rolls back the previous block when the new block is not its child
+func (p *EthereumParser) checkParent(blockNumber int, parentHash string) error {
+	previous, known := p.recentBlocks[blockNumber-1]
+	if !known || previous.hash == parentHash {
+		return nil
+	}
+	p.logger.Warn("Chain reorganization, rolling back the previous block", "block", blockNumber, "parentHash", parentHash, "processedHash", previous.hash)
+	p.rollback(blockNumber - 1)
+	return errReorg
+}
+
+// rollback removes the transactions of the block, tells the publishers about the subscribed ones
+// and moves the current block back so the block is processed again
+func (p *EthereumParser) rollback(blockNumber int) {
+	record := p.recentBlocks[blockNumber]
+	delete(p.recentBlocks, blockNumber)
+
+	var removed []Transaction
+	p.mutex.Lock()
+	for address := range record.addresses {
+		kept := p.transactions[address][:0]
+		for _, tx := range p.transactions[address] {
+			if tx.BlockNumber != blockNumber {
+				kept = append(kept, tx)
+				continue
+			}
+			p.unminedNonce(tx)
+			if p.watches(address, tx) {
+				tx.Status = StatusRemoved
+				removed = append(removed, tx)
+			}
+		}
+		p.transactions[address] = kept
+	}
+	p.rollbackBalances(blockNumber)
+	p.currentBlock = blockNumber - 1
+	p.mutex.Unlock()
+
+	p.publish(EventRemoved, removed)
+	p.reorged(ReorgInfo{BlockNumber: blockNumber, DroppedHash: record.hash, Removed: removed})
+}
+
+// remember records a processed block, confirms the block reaching the confirmation depth
+// and forgets the blocks which left the window
+func (p *EthereumParser) remember(blockNumber int, hash string, addresses map[ethereum.Address]struct{}) {
+	p.recentBlocks[blockNumber] = &blockRecord{hash: hash, addresses: addresses}
+
+	if p.confirmations > 0 {
+		p.confirm(blockNumber - p.confirmations + 1)
+	}
+
+	keep := reorgWindow
+	if p.confirmations > keep {
+		keep = p.confirmations
+	}
+	for number := range p.recentBlocks {
+		if number <= blockNumber-keep {
+			delete(p.recentBlocks, number)
+		}
+	}
+}
+
+// confirm marks the transactions of the block as confirmed and tells the publishers about the subscribed ones
+func (p *EthereumParser) confirm(blockNumber int) {
+	record, known := p.recentBlocks[blockNumber]
+	if !known {
+		return
+	}
+	var confirmed []Transaction
+	p.mutex.Lock()
+	for address := range record.addresses {
+		transactions := p.transactions[address]
+		// the transactions are stored in block order, the block is near the end
+		for i := len(transactions) - 1; i >= 0 && transactions[i].BlockNumber >= blockNumber; i-- {
+			if transactions[i].BlockNumber != blockNumber || transactions[i].Status != StatusMined {
+				continue
+			}
+			transactions[i].Status = StatusConfirmed
+			if p.watches(address, transactions[i]) {
+				confirmed = append(confirmed, transactions[i])
+			}
+		}
+	}
+	p.mutex.Unlock()
+	p.publish(EventConfirmation, confirmed)
+}
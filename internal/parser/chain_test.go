@@ -0,0 +1,104 @@
+package parser
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/meirongdev/ethereum_parser/internal/ethereum"
+	"github.com/meirongdev/ethereum_parser/internal/ethereum/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// testChain serves the blocks of a mutable chain to the mocked API
+type testChain struct {
+	head   string
+	blocks map[string]ethereum.Block
+}
+
+func newTestChain(mockAPI *mocks.API) *testChain {
+	chain := &testChain{blocks: make(map[string]ethereum.Block)}
+	mockAPI.On("GetCurrentBlock").Return(func() string { return chain.head }, nil)
+	mockAPI.On("GetBlock", mock.Anything).Return(func(number string) ethereum.Block { return chain.blocks[number] }, nil)
+	return chain
+}
+
+// add appends a block sending value from addrABC to addrDEF in a transaction with the given hash
+func (c *testChain) add(number int, hash, parentHash, txHash string) {
+	numberStr := fmt.Sprintf("0x%x", number)
+	c.blocks[numberStr] = ethereum.Block{
+		Number:     numberStr,
+		Hash:       hash,
+		ParentHash: parentHash,
+		Timestamp:  "0x66f3e4d7",
+		Transactions: []interface{}{
+			map[string]interface{}{"hash": txHash, "from": hexABC, "to": hexDEF, "value": "0x1"},
+		},
+	}
+	c.head = numberStr
+}
+
+func TestConfirmations(t *testing.T) {
+	mockAPI := new(mocks.API)
+	publisher := &recordingPublisher{}
+	eParser := NewEthereumParser(mockAPI, WithCatchUpInterval(0), WithConfirmations(2), WithPublisher(publisher))
+	eParser.currentBlock = 0
+	eParser.Subscribe(addrABC)
+	chain := newTestChain(mockAPI)
+
+	chain.add(1, "0xa1", "0xa0", "0x01")
+	assert.NoError(t, eParser.retrieveBlockDatas())
+	assert.Equal(t, StatusMined, eParser.GetTransactions(addrABC)[0].Status)
+
+	chain.add(2, "0xa2", "0xa1", "0x02")
+	assert.NoError(t, eParser.retrieveBlockDatas())
+	transactions := eParser.GetTransactions(addrABC)
+	assert.Equal(t, StatusConfirmed, transactions[0].Status)
+	assert.Equal(t, StatusMined, transactions[1].Status)
+	// the unsubscribed side is confirmed too, it is just not published
+	assert.Equal(t, StatusConfirmed, eParser.transactions[addrDEF][0].Status)
+
+	assert.Equal(t, []EventType{EventTransaction, EventTransaction, EventConfirmation}, publisher.events)
+	assert.Equal(t, []string{"0x01", "0x02", "0x01"}, publisher.hashes)
+}
+
+func TestReorgRollsBackBlocks(t *testing.T) {
+	mockAPI := new(mocks.API)
+	publisher := &recordingPublisher{}
+	eParser := NewEthereumParser(mockAPI, WithCatchUpInterval(0), WithConfirmations(0), WithPublisher(publisher))
+	eParser.currentBlock = 0
+	eParser.Subscribe(addrABC)
+	chain := newTestChain(mockAPI)
+
+	chain.add(1, "0xa1", "0xa0", "0x01")
+	chain.add(2, "0xa2", "0xa1", "0x02")
+	chain.add(3, "0xa3", "0xa2", "0x03")
+	assert.NoError(t, eParser.retrieveBlockDatas())
+	assert.Equal(t, 3, eParser.GetCurrentBlock())
+
+	// blocks 2 and 3 are replaced by a fork, block 4 is built on it
+	chain.add(2, "0xb2", "0xa1", "0x12")
+	chain.add(3, "0xb3", "0xb2", "0x13")
+	chain.add(4, "0xb4", "0xb3", "0x14")
+	assert.NoError(t, eParser.retrieveBlockDatas())
+	assert.Equal(t, 4, eParser.GetCurrentBlock())
+
+	assert.Equal(t, []string{"0x01", "0x12", "0x13", "0x14"}, hashes(eParser.GetTransactions(addrABC)))
+	assert.Equal(t, []string{"0x01", "0x12", "0x13", "0x14"}, hashes(eParser.transactions[addrDEF]))
+	assert.Equal(t, []EventType{
+		EventTransaction, EventTransaction, EventTransaction,
+		EventRemoved, EventRemoved,
+		EventTransaction, EventTransaction, EventTransaction,
+	}, publisher.events)
+	assert.Equal(t, []string{"0x01", "0x02", "0x03", "0x03", "0x02", "0x12", "0x13", "0x14"}, publisher.hashes)
+}
+
+func TestRecentBlocksArePruned(t *testing.T) {
+	eParser := NewEthereumParser(new(mocks.API), WithConfirmations(0))
+	for i := 1; i <= reorgWindow*2; i++ {
+		eParser.remember(i, fmt.Sprintf("0x%x", i), nil)
+	}
+	assert.Len(t, eParser.recentBlocks, reorgWindow)
+	_, known := eParser.recentBlocks[reorgWindow*2-reorgWindow+1]
+	assert.True(t, known)
+}
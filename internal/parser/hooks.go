@@ -0,0 +1,115 @@
+package parser
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/meirongdev/ethereum_parser/internal/ethereum"
+)
+
+// slowHookThreshold is how long a callback can run before a warning is logged
+const slowHookThreshold = time.Second
+
+// BlockInfo describes a block which has been processed
+type BlockInfo struct {
+	Number    int
+	Hash      string
+	Timestamp time.Time
+	// Transactions is the number of transactions in the block
+	Transactions int
+}
+
+// ReorgInfo describes a block dropped by a chain reorganization
+type ReorgInfo struct {
+	BlockNumber int
+	// DroppedHash is the hash of the block which is no longer canonical
+	DroppedHash string
+	// Removed are the transactions of subscribed addresses which were in the dropped block
+	Removed []Transaction
+}
+
+// hooks are the callbacks registered with the On options.
+//
+// Every callback runs synchronously on the goroutine processing the blocks, so they are called one at a time
+// and in chain order: the OnTransaction calls of a block in transaction order, then its OnBlockProcessed call.
+// OnReorg is called after the dropped block was rolled back and before its replacement is processed.
+// A slow callback delays the processing of the next blocks, consumers which can fall behind should
+// use a Publisher such as the notifier package instead. A callback which panics is recovered,
+// the panic is logged and reported to the OnError callbacks and the processing carries on.
+type hooks struct {
+	onTransaction    []func(address ethereum.Address, tx Transaction)
+	onBlockProcessed []func(block BlockInfo)
+	onReorg          []func(reorg ReorgInfo)
+	onError          []func(err error)
+}
+
+// OnTransaction registers a callback for every transaction recorded for a subscribed address
+func OnTransaction(callback func(address ethereum.Address, tx Transaction)) Option {
+	return func(p *EthereumParser) {
+		p.hooks.onTransaction = append(p.hooks.onTransaction, callback)
+	}
+}
+
+// OnBlockProcessed registers a callback called once all the transactions of a block are recorded
+func OnBlockProcessed(callback func(block BlockInfo)) Option {
+	return func(p *EthereumParser) {
+		p.hooks.onBlockProcessed = append(p.hooks.onBlockProcessed, callback)
+	}
+}
+
+// OnReorg registers a callback called when a processed block is dropped by a reorganization
+func OnReorg(callback func(reorg ReorgInfo)) Option {
+	return func(p *EthereumParser) {
+		p.hooks.onReorg = append(p.hooks.onReorg, callback)
+	}
+}
+
+// OnError registers a callback for the errors of the processing loop and the panics of the other callbacks
+func OnError(callback func(err error)) Option {
+	return func(p *EthereumParser) {
+		p.hooks.onError = append(p.hooks.onError, callback)
+	}
+}
+
+// runHook calls a callback, recovering from its panic and warning when it is slow
+func (p *EthereumParser) runHook(name string, call func()) {
+	start := time.Now()
+	defer func() {
+		if elapsed := time.Since(start); elapsed > slowHookThreshold {
+			p.logger.Warn("Slow callback delays the block processing", "callback", name, "elapsed", elapsed)
+		}
+		if r := recover(); r != nil {
+			err := fmt.Errorf("%s callback panicked: %v", name, r)
+			p.logger.Error("Callback panicked", "callback", name, "panic", r)
+			// a panicking OnError callback is not reported to itself
+			if name != "OnError" {
+				p.reportError(err)
+			}
+		}
+	}()
+	call()
+}
+
+func (p *EthereumParser) transactionRecorded(address ethereum.Address, tx Transaction) {
+	for _, callback := range p.hooks.onTransaction {
+		p.runHook("OnTransaction", func() { callback(address, tx) })
+	}
+}
+
+func (p *EthereumParser) blockProcessed(block BlockInfo) {
+	for _, callback := range p.hooks.onBlockProcessed {
+		p.runHook("OnBlockProcessed", func() { callback(block) })
+	}
+}
+
+func (p *EthereumParser) reorged(reorg ReorgInfo) {
+	for _, callback := range p.hooks.onReorg {
+		p.runHook("OnReorg", func() { callback(reorg) })
+	}
+}
+
+func (p *EthereumParser) reportError(err error) {
+	for _, callback := range p.hooks.onError {
+		p.runHook("OnError", func() { callback(err) })
+	}
+}
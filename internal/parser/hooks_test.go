@@ -0,0 +1,89 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/meirongdev/ethereum_parser/internal/ethereum"
+	"github.com/meirongdev/ethereum_parser/internal/ethereum/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHooksOrdering(t *testing.T) {
+	var calls []string
+	mockAPI := new(mocks.API)
+	eParser := NewEthereumParser(mockAPI,
+		WithCatchUpInterval(0),
+		WithConfirmations(0),
+		OnTransaction(func(address ethereum.Address, tx Transaction) {
+			calls = append(calls, fmt.Sprintf("tx %s %s", tx.Hash, tx.Direction))
+		}),
+		OnBlockProcessed(func(block BlockInfo) {
+			calls = append(calls, fmt.Sprintf("block %d %s %d", block.Number, block.Hash, block.Transactions))
+		}),
+		OnReorg(func(reorg ReorgInfo) {
+			calls = append(calls, fmt.Sprintf("reorg %d %s %d", reorg.BlockNumber, reorg.DroppedHash, len(reorg.Removed)))
+		}),
+	)
+	eParser.currentBlock = 0
+	eParser.Subscribe(addrABC)
+	chain := newTestChain(mockAPI)
+
+	chain.add(1, "0xa1", "0xa0", "0x01")
+	chain.add(2, "0xa2", "0xa1", "0x02")
+	assert.NoError(t, eParser.retrieveBlockDatas())
+	chain.add(2, "0xb2", "0xa1", "0x12")
+	chain.add(3, "0xb3", "0xb2", "0x13")
+	assert.NoError(t, eParser.retrieveBlockDatas())
+
+	assert.Equal(t, []string{
+		"tx 0x01 out", "block 1 0xa1 1",
+		"tx 0x02 out", "block 2 0xa2 1",
+		"reorg 2 0xa2 1",
+		"tx 0x12 out", "block 2 0xb2 1",
+		"tx 0x13 out", "block 3 0xb3 1",
+	}, calls)
+}
+
+func TestHooksRecoverPanics(t *testing.T) {
+	var reported []string
+	var processed []int
+	mockAPI := new(mocks.API)
+	eParser := NewEthereumParser(mockAPI,
+		WithCatchUpInterval(0),
+		OnTransaction(func(ethereum.Address, Transaction) {
+			panic("boom")
+		}),
+		OnBlockProcessed(func(block BlockInfo) {
+			processed = append(processed, block.Number)
+		}),
+		OnError(func(err error) {
+			reported = append(reported, err.Error())
+			panic("the error callback panics too")
+		}),
+	)
+	eParser.currentBlock = 0
+	eParser.Subscribe(addrABC)
+	chain := newTestChain(mockAPI)
+	chain.add(1, "0xa1", "0xa0", "0x01")
+
+	assert.NoError(t, eParser.retrieveBlockDatas())
+	assert.Equal(t, []int{1}, processed)
+	assert.Equal(t, []string{"OnTransaction callback panicked: boom"}, reported)
+}
+
+func TestOnErrorReportsProcessingErrors(t *testing.T) {
+	var reported []error
+	mockAPI := new(mocks.API)
+	eParser := NewEthereumParser(mockAPI, OnError(func(err error) {
+		reported = append(reported, err)
+	}))
+	rpcErr := errors.New("429 Too Many Requests")
+	mockAPI.On("GetCurrentBlock").Return("", rpcErr)
+
+	err := eParser.retrieveBlockDatas()
+	assert.ErrorIs(t, err, rpcErr)
+	assert.Len(t, reported, 1)
+	assert.ErrorIs(t, reported[0], rpcErr)
+}
@@ -2,8 +2,11 @@ package parser
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -11,42 +14,170 @@ import (
 	"github.com/meirongdev/ethereum_parser/internal/ethereum"
 )
 
+// Direction tells which side of a transaction the queried address is on
+type Direction string
+
+const (
+	// DirectionIn is a transaction received by the address
+	DirectionIn Direction = "in"
+	// DirectionOut is a transaction sent by the address
+	DirectionOut Direction = "out"
+	// DirectionSelf is a transaction the address sent to itself
+	DirectionSelf Direction = "self"
+)
+
+// ParseDirection converts the in, out and self strings into a Direction
+func ParseDirection(s string) (Direction, error) {
+	switch d := Direction(strings.ToLower(s)); d {
+	case DirectionIn, DirectionOut, DirectionSelf:
+		return d, nil
+	default:
+		return "", fmt.Errorf("invalid direction %q, expected in, out or self", s)
+	}
+}
+
 type Transaction struct {
-	Hash        string
-	From        string
-	To          string
-	Value       string
-	BlockNumber int
+	Hash  string           `json:"hash"`
+	From  ethereum.Address `json:"from"`
+	To    ethereum.Address `json:"to"`
+	Value ethereum.Wei     `json:"value"`
+	// Gas is the gas limit of the transaction
+	Gas uint64 `json:"gas"`
+	// GasPrice is the effective price paid per gas
+	GasPrice ethereum.Wei `json:"gasPrice"`
+	// MaxFeePerGas and MaxPriorityFeePerGas are only set for EIP-1559 transactions
+	MaxFeePerGas         ethereum.Wei `json:"maxFeePerGas"`
+	MaxPriorityFeePerGas ethereum.Wei `json:"maxPriorityFeePerGas"`
+	Nonce                uint64       `json:"nonce"`
+	// Type is the EIP-2718 transaction type, 0 for legacy, 1 for access list and 2 for EIP-1559
+	Type           int       `json:"type"`
+	BlockNumber    int       `json:"blockNumber"`
+	BlockHash      string    `json:"blockHash"`
+	BlockTimestamp time.Time `json:"blockTimestamp"`
+	// TransactionIndex is the position of the transaction in its block
+	TransactionIndex int `json:"transactionIndex"`
+	// Direction is relative to the address the transaction is stored for
+	Direction Direction `json:"direction"`
+	Status    Status    `json:"status"`
+	// ReplacedBy is the hash of the transaction which took the nonce of a replaced or cancelled one.
+	// Like those statuses it is only seen in events, the replaced transaction was never mined so it is not stored,
+	// the stored history has the replacement once its block is processed.
+	ReplacedBy string `json:"replacedBy,omitempty"`
+}
+
+// MaxFee returns the most the sender can pay for the transaction (gas limit * gas price),
+// the actual fee depends on the gas used which is only known from the receipt
+func (t Transaction) MaxFee() ethereum.Wei {
+	return t.GasPrice.MulUint64(t.Gas)
+}
+
+// sides returns a copy of the transaction for each address it is stored for,
+// a self-transfer is stored once instead of once per side
+func (t Transaction) sides() []Transaction {
+	if t.From == t.To {
+		t.Direction = DirectionSelf
+		return []Transaction{t}
+	}
+	outgoing, incoming := t, t
+	outgoing.Direction = DirectionOut
+	incoming.Direction = DirectionIn
+	return []Transaction{outgoing, incoming}
+}
+
+// addressSide returns the address the transaction is stored for according to its direction
+func (t Transaction) addressSide() ethereum.Address {
+	if t.Direction == DirectionIn {
+		return t.To
+	}
+	return t.From
+}
+
+// SideOf returns the copy of the transaction stored for the address, with the direction it has for it,
+// and false when the address neither sent nor received the transaction
+func (t Transaction) SideOf(address ethereum.Address) (Transaction, bool) {
+	for _, side := range t.sides() {
+		if side.addressSide() == address {
+			return side, true
+		}
+	}
+	return t, false
 }
 
 type Parser interface {
 	// last parsed block
 	GetCurrentBlock() int
 	// add address to observer
-	Subscribe(address string) bool
+	Subscribe(address ethereum.Address) bool
 	// list of inbound or outbound transactions for an address
-	GetTransactions(address string) []Transaction
+	GetTransactions(address ethereum.Address) []Transaction
+}
+
+// EventType is the kind of change a Publisher is told about
+type EventType string
+
+const (
+	// EventTransaction is a transaction recorded from a newly processed block
+	EventTransaction EventType = "transaction"
+	// EventConfirmation is a transaction whose block reached the confirmation depth
+	EventConfirmation EventType = "confirmation"
+	// EventRemoved is a transaction whose block was dropped by a chain reorganization
+	EventRemoved EventType = "removed"
+	// EventPending is a transaction seen in the pool of the node, see WithTxPool
+	EventPending EventType = "pending"
+	// EventDropped is a pending transaction which left the pool without being mined
+	EventDropped EventType = "dropped"
+	// EventReplaced is a pending transaction superseded by another one from the same sender with the same nonce,
+	// in the pool or in a block, the transaction has the status StatusReplaced or StatusCancelled
+	EventReplaced EventType = "replaced"
+)
+
+// Publisher is told about the transactions of subscribed addresses,
+// the notifier package implements it to push them to its sinks
+type Publisher interface {
+	Notify(eventType EventType, address ethereum.Address, tx Transaction)
 }
 
 type EthereumParser struct {
 	api          ethereum.API
 	currentBlock int
 	// The addresses which are being subscribed
-	addresses map[string]struct{}
+	addresses map[ethereum.Address]Subscription
 	// The transactions for each address
-	transactions map[string][]Transaction
+	transactions map[ethereum.Address][]Transaction
 	mutex        sync.RWMutex
 	// closing channel is for elegent stop the go routine
 	stopChannel chan struct{}
 	doneChannel chan struct{}
-	waitTime    time.Duration
+	schedule    schedule
+	mempool     mempool
+	balances    balances
+	publishers  []Publisher
+	// confirmations is the depth at which transactions are confirmed
+	confirmations int
+	// recentBlocks are only used by the processing goroutine
+	recentBlocks map[int]*blockRecord
+	// lastBlockTime is the time of the last processed block, only used by the processing goroutine
+	lastBlockTime time.Time
+	hooks         hooks
+	// progress is reported by Progress, the current block is kept apart
+	progress Progress
+	logger   *slog.Logger
 }
 
 type Option func(*EthereumParser)
 
-func WithWaitTime(duration time.Duration) Option {
+// WithLogger sets the logger of the parser, slog.Default() by default.
+// The processed blocks are logged at info, the details of each polling round at debug.
+func WithLogger(logger *slog.Logger) Option {
+	return func(p *EthereumParser) {
+		p.logger = logger
+	}
+}
+
+// WithPublisher adds a publisher which is told about every transaction of a subscribed address
+func WithPublisher(publisher Publisher) Option {
 	return func(p *EthereumParser) {
-		p.waitTime = duration
+		p.publishers = append(p.publishers, publisher)
 	}
 }
 
@@ -59,14 +190,136 @@ func hexToInt(hexStr string) (int, error) {
 	return result, nil
 }
 
+func hexToUint64(hexStr string) (uint64, error) {
+	digits, ok := strings.CutPrefix(hexStr, "0x")
+	if !ok {
+		return 0, fmt.Errorf("invalid hex quantity %q", hexStr)
+	}
+	return strconv.ParseUint(digits, 16, 64)
+}
+
+// optionalUint64 parses a hex quantity field which may be missing, a missing field is 0
+func optionalUint64(txMap map[string]interface{}, field string) (uint64, error) {
+	raw, exists := txMap[field]
+	if !exists || raw == nil {
+		return 0, nil
+	}
+	hexStr, ok := raw.(string)
+	if !ok {
+		return 0, fmt.Errorf("invalid %s %v", field, raw)
+	}
+	return hexToUint64(hexStr)
+}
+
+// optionalWei parses a hex quantity field which is not present on every transaction type
+func optionalWei(txMap map[string]interface{}, field string) (ethereum.Wei, error) {
+	raw, exists := txMap[field]
+	if !exists || raw == nil {
+		return ethereum.Wei{}, nil
+	}
+	hexStr, ok := raw.(string)
+	if !ok {
+		return ethereum.Wei{}, fmt.Errorf("invalid %s %v", field, raw)
+	}
+	return ethereum.ParseWei(hexStr)
+}
+
+// ErrContractCreation is returned by DecodeTransaction for a transaction without a to address, they are not recorded
+var ErrContractCreation = errors.New("contract creation transaction")
+
+// DecodeTransaction converts a transaction object of eth_getBlockByNumber, the block fields and the direction are not set.
+// The hash is set when it is valid even if an error is returned.
+func DecodeTransaction(raw interface{}) (Transaction, error) {
+	var tx Transaction
+	txMap, ok := raw.(map[string]interface{})
+	if !ok {
+		return tx, fmt.Errorf("invalid transaction object %T", raw)
+	}
+	if tx.Hash, ok = txMap["hash"].(string); !ok {
+		return tx, fmt.Errorf("invalid hash %v", txMap["hash"])
+	}
+	fromStr, ok := txMap["from"].(string)
+	if !ok {
+		return tx, fmt.Errorf("invalid from %v", txMap["from"])
+	}
+	var err error
+	if tx.From, err = ethereum.ParseAddress(fromStr); err != nil {
+		return tx, fmt.Errorf("invalid from %w", err)
+	}
+	// contract creations have no "to" field
+	toStr, ok := txMap["to"].(string)
+	if !ok {
+		return tx, ErrContractCreation
+	}
+	if tx.To, err = ethereum.ParseAddress(toStr); err != nil {
+		return tx, fmt.Errorf("invalid to %w", err)
+	}
+	valueStr, ok := txMap["value"].(string)
+	if !ok {
+		return tx, fmt.Errorf("invalid value %v", txMap["value"])
+	}
+	if tx.Value, err = ethereum.ParseWei(valueStr); err != nil {
+		return tx, fmt.Errorf("invalid value %w", err)
+	}
+	if tx.Gas, err = optionalUint64(txMap, "gas"); err != nil {
+		return tx, fmt.Errorf("invalid gas %w", err)
+	}
+	if tx.Nonce, err = optionalUint64(txMap, "nonce"); err != nil {
+		return tx, fmt.Errorf("invalid nonce %w", err)
+	}
+	txIndex, err := optionalUint64(txMap, "transactionIndex")
+	if err != nil {
+		return tx, fmt.Errorf("invalid transactionIndex %w", err)
+	}
+	tx.TransactionIndex = int(txIndex)
+	// legacy transactions from before EIP-2718 have no type field, which is type 0
+	txType, err := optionalUint64(txMap, "type")
+	if err != nil {
+		return tx, fmt.Errorf("invalid type %w", err)
+	}
+	tx.Type = int(txType)
+	if tx.GasPrice, err = optionalWei(txMap, "gasPrice"); err != nil {
+		return tx, fmt.Errorf("invalid gasPrice %w", err)
+	}
+	if tx.MaxFeePerGas, err = optionalWei(txMap, "maxFeePerGas"); err != nil {
+		return tx, fmt.Errorf("invalid maxFeePerGas %w", err)
+	}
+	if tx.MaxPriorityFeePerGas, err = optionalWei(txMap, "maxPriorityFeePerGas"); err != nil {
+		return tx, fmt.Errorf("invalid maxPriorityFeePerGas %w", err)
+	}
+	tx.Status = StatusMined
+	return tx, nil
+}
+
 func NewEthereumParser(api ethereum.API, options ...Option) *EthereumParser {
 	p := &EthereumParser{
-		api:          api,
-		currentBlock: -1,
-		addresses:    make(map[string]struct{}),
-		transactions: make(map[string][]Transaction),
-		stopChannel:  make(chan struct{}),
-		doneChannel:  make(chan struct{}),
+		api:           api,
+		currentBlock:  -1,
+		addresses:     make(map[ethereum.Address]Subscription),
+		transactions:  make(map[ethereum.Address][]Transaction),
+		stopChannel:   make(chan struct{}),
+		doneChannel:   make(chan struct{}),
+		confirmations: 12,
+		recentBlocks:  make(map[int]*blockRecord),
+		progress:      Progress{ChainHead: -1},
+		logger:        slog.Default(),
+		schedule: schedule{
+			pollInterval:    DefaultPollInterval,
+			catchUpInterval: DefaultCatchUpInterval,
+			minBackoff:      DefaultMinBackoff,
+			maxBackoff:      DefaultMaxBackoff,
+		},
+		mempool: mempool{
+			dropAfter: DefaultPendingDropAfter,
+			byHash:    make(map[string]*pendingTx),
+			byNonce:   make(map[nonceKey]string),
+			nonces:    make(map[ethereum.Address]uint64),
+		},
+		balances: balances{
+			history: make(map[ethereum.Address][]Balance),
+			unknown: make(map[ethereum.Address]struct{}),
+			wake:    make(chan struct{}, 1),
+		},
 	}
 	for _, option := range options {
 		option(p)
@@ -76,55 +329,108 @@ func NewEthereumParser(api ethereum.API, options ...Option) *EthereumParser {
 
 // GetCurrentBlock returns the last parsed block
 func (p *EthereumParser) GetCurrentBlock() int {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
 	return p.currentBlock
 }
 
-func (p *EthereumParser) Subscribe(address string) bool {
+// setCurrentBlock is only called by the processing goroutine, which reads the current block without the lock
+func (p *EthereumParser) setCurrentBlock(blockNumber int) {
 	p.mutex.Lock()
-	defer p.mutex.Unlock()
-	address = strings.ToLower(address)
-	if _, exists := p.addresses[address]; exists {
-		return false
+	p.currentBlock = blockNumber
+	p.mutex.Unlock()
+}
+
+func (p *EthereumParser) Subscribe(address ethereum.Address) bool {
+	return p.SubscribeAll([]Subscription{{Address: address}})[0]
+}
+
+// Addresses returns the subscribed addresses sorted by their hex form
+func (p *EthereumParser) Addresses() []ethereum.Address {
+	p.mutex.RLock()
+	addresses := make([]ethereum.Address, 0, len(p.addresses))
+	for address := range p.addresses {
+		addresses = append(addresses, address)
 	}
-	// Add the address to the list of addresses
-	p.addresses[address] = struct{}{}
-	return true
+	p.mutex.RUnlock()
+	sort.Slice(addresses, func(i, j int) bool { return addresses[i].Hex() < addresses[j].Hex() })
+	return addresses
 }
 
-func (p *EthereumParser) GetTransactions(address string) []Transaction {
+func (p *EthereumParser) GetTransactions(address ethereum.Address) []Transaction {
 	p.mutex.RLock()
 	defer p.mutex.RUnlock()
-	address = strings.ToLower(address)
-	if _, exists := p.addresses[address]; !exists {
-		return []Transaction{}
+	transactions := []Transaction{}
+	// a copy, the stored transactions change when they are confirmed or rolled back
+	for _, tx := range p.transactions[address] {
+		if p.watches(address, tx) {
+			transactions = append(transactions, tx)
+		}
 	}
-	return p.transactions[address]
+	return transactions
 }
 
+// FilterByDirection returns the transactions with the given direction
+func FilterByDirection(transactions []Transaction, direction Direction) []Transaction {
+	filtered := []Transaction{}
+	for _, tx := range transactions {
+		if tx.Direction == direction {
+			filtered = append(filtered, tx)
+		}
+	}
+	return filtered
+}
+
+// Start runs the processing loop until Stop is called. Each round polls the head and processes the new blocks,
+// then the loop waits for the next block to be due, or backs off after an error.
+// The pool of the node is watched by a second goroutine when WithTxPool is given,
+// and the first balances of the subscribed addresses are read by another one when WithBalances is given.
 func (p *EthereumParser) Start() {
+	p.mutex.Lock()
+	p.progress.Started = time.Now()
+	p.mutex.Unlock()
+	poolDone := make(chan struct{})
+	if p.mempool.api != nil {
+		go func() {
+			defer close(poolDone)
+			p.watchTxPool()
+		}()
+	} else {
+		close(poolDone)
+	}
+	baselinesDone := make(chan struct{})
+	if p.balances.api != nil {
+		go func() {
+			defer close(baselinesDone)
+			p.watchBaselines()
+		}()
+	} else {
+		close(baselinesDone)
+	}
 	for {
-		select {
-		case <-p.stopChannel:
+		p.progressed(false)
+		before := p.currentBlock
+		err := p.retrieveBlockDatas()
+		if err != nil {
+			p.logger.Error("Failed to retrieve blocks", "error", err)
+		}
+		wait := p.schedule.next(err, p.currentBlock != before, p.lastBlockTime, time.Now())
+		p.logger.Debug("Waiting for the next round", "wait", wait, "failures", p.schedule.failures)
+		if !p.sleep(wait) {
+			<-poolDone
+			<-baselinesDone
 			p.doneChannel <- struct{}{}
 			return
-		default:
-			// Get the current block number
-			// To avoid 429 error
-			err := p.retrieveBlockDatas()
-			if err != nil {
-				log.Println("error retrieveBlockDatas %w", err)
-				time.Sleep(p.waitTime)
-			}
 		}
 	}
 }
 
-func (p *EthereumParser) retrieveBlockDatas() error {
-	log.Println("show me all the addresses")
-	for addr := range p.transactions {
-		log.Println(addr)
-	}
-
+func (p *EthereumParser) retrieveBlockDatas() (err error) {
+	defer func() {
+		if err != nil {
+			p.reportError(err)
+		}
+	}()
 	blockNumberStr, err := p.api.GetCurrentBlock()
 	if err != nil {
 		return fmt.Errorf("error getting current block %w", err)
@@ -133,90 +439,121 @@ func (p *EthereumParser) retrieveBlockDatas() error {
 	if err != nil {
 		return fmt.Errorf("error converting block number %w", err)
 	}
+	p.mutex.Lock()
+	p.progress.ChainHead = blockNumber
+	p.mutex.Unlock()
+	p.progressed(true)
 	if blockNumber <= p.currentBlock {
-		log.Printf("blockNumer %d is less or equals then currentBlock%d \n", blockNumber, p.currentBlock)
+		p.logger.Debug("No new block", "head", blockNumber, "currentBlock", p.currentBlock)
 		return nil
 	}
 	if p.currentBlock < 0 {
-		p.currentBlock = blockNumber - 1
+		p.setCurrentBlock(blockNumber - 1)
 	}
-	log.Printf("have %d block to process\n", blockNumber-p.currentBlock)
-	for i := p.currentBlock + 1; i <= blockNumber; i++ {
+	p.logger.Debug("New blocks to process", "count", blockNumber-p.currentBlock, "head", blockNumber)
+	for p.currentBlock < blockNumber {
+		i := p.currentBlock + 1
+		started := time.Now()
 		err := p.processBlock(i)
+		if errors.Is(err, errReorg) {
+			// the current block was moved back, process the new canonical blocks from there
+			continue
+		}
 		if err != nil {
 			return fmt.Errorf("error proccing block %d %w", i, err)
 		}
-		p.currentBlock = i
-		time.Sleep(p.waitTime)
+		p.setCurrentBlock(i)
+		p.progressed(true)
+		if p.currentBlock < blockNumber && !p.sleep(p.schedule.catchUpInterval-time.Since(started)) {
+			return nil
+		}
 	}
 	return nil
 }
 
 func (p *EthereumParser) processBlock(blockNumber int) error {
 	blockNumberStr := fmt.Sprintf("0x%x", blockNumber)
-	log.Printf("Processing block %d", blockNumber)
-	txList, err := p.api.GetTransactions(blockNumberStr)
+	logger := p.logger.With("block", blockNumber)
+	logger.Debug("Processing block")
+	block, err := p.api.GetBlock(blockNumberStr)
 	if err != nil {
 		return err
 	}
-	log.Printf("Found %d transactions in block %d", len(txList), blockNumber)
+	if err := p.checkParent(blockNumber, block.ParentHash); err != nil {
+		return err
+	}
+	timestamp, err := hexToInt(block.Timestamp)
+	if err != nil {
+		return fmt.Errorf("error converting timestamp of block %d %w", blockNumber, err)
+	}
+	blockTime := time.Unix(int64(timestamp), 0).UTC()
 
+	// the addresses with a transaction in this block
+	touched := make(map[ethereum.Address]struct{})
 	// convert the tx to Transaction struct
-	for _, tx := range txList {
-		txMap, ok := tx.(map[string]interface{})
-		if !ok {
-			continue
-		}
-
-		hash, ok := txMap["hash"].(string)
-		if !ok {
-			log.Printf("Error getting hash for transaction %v", txMap["hash"])
+	for _, tx := range block.Transactions {
+		transaction, err := DecodeTransaction(tx)
+		if errors.Is(err, ErrContractCreation) {
+			logger.Debug("Skipping contract creation transaction", "tx", transaction.Hash)
 			continue
 		}
-		from, ok := txMap["from"].(string)
-		if !ok {
-			log.Printf("Error getting from address for transaction %s %v", hash, txMap["from"])
+		if err != nil {
+			logger.Warn("Skipping transaction", "tx", transaction.Hash, "error", err)
 			continue
 		}
-		// some transactions don't have a "to" field
-		to, ok := txMap["to"].(string)
-		if !ok {
-			log.Printf("Error getting to address for transaction %s %v", hash, txMap["to"])
-			continue
+		transaction.BlockNumber = blockNumber
+		transaction.BlockHash = block.Hash
+		transaction.BlockTimestamp = blockTime
+		p.mutex.Lock()
+		replaced := p.minedPending(transaction)
+		var subscribed []Transaction
+		for _, tx := range transaction.sides() {
+			address := tx.addressSide()
+			p.insertTransaction(address, tx)
+			touched[address] = struct{}{}
+			if p.watches(address, tx) {
+				subscribed = append(subscribed, tx)
+			}
 		}
+		p.mutex.Unlock()
+		p.publish(EventReplaced, replaced)
+		p.publish(EventTransaction, subscribed)
+	}
 
-		value, ok := txMap["value"].(string)
-		if !ok {
-			log.Printf("Error getting value for transaction %s %v", hash, txMap["value"])
-			continue
-		}
+	p.remember(blockNumber, block.Hash, touched)
+	p.updateBalances(blockNumber, touched)
+	p.lastBlockTime = blockTime
+	logger.Info("Processed block", "hash", block.Hash, "transactions", len(block.Transactions))
+	p.blockProcessed(BlockInfo{
+		Number:       blockNumber,
+		Hash:         block.Hash,
+		Timestamp:    blockTime,
+		Transactions: len(block.Transactions),
+	})
+	return nil
+}
 
-		// Check if the address is involved in the transaction (either as sender or receiver)
-		transaction := Transaction{
-			Hash:        hash,
-			From:        from,
-			To:          to,
-			Value:       value,
-			BlockNumber: blockNumber,
+// publish hands the transactions to the publishers, it must be called without holding the mutex
+func (p *EthereumParser) publish(eventType EventType, transactions []Transaction) {
+	for _, tx := range transactions {
+		for _, publisher := range p.publishers {
+			publisher.Notify(eventType, tx.addressSide(), tx)
+		}
+		if eventType == EventTransaction {
+			p.transactionRecorded(tx.addressSide(), tx)
 		}
-		p.mutex.Lock()
-		defer p.mutex.Unlock()
-		p.transactions[from] = append(p.transactions[from], transaction)
-		p.transactions[to] = append(p.transactions[to], transaction)
 	}
-
-	return nil
 }
 
 func (p *EthereumParser) Stop() {
-	log.Println("Parser is closing")
+	p.logger.Info("Parser is closing")
 	close(p.stopChannel)
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*60)
 	defer cancel()
 	select {
 	case <-ctx.Done():
-		log.Println("Stop timeout")
+		p.logger.Warn("Parser did not stop in time")
 	case <-p.doneChannel:
-		log.Println("Parser closed")
+		p.logger.Info("Parser closed")
 	}
 }
@@ -1,14 +1,75 @@
 package parser
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"log/slog"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/meirongdev/ethereum_parser/internal/ethereum"
 	"github.com/meirongdev/ethereum_parser/internal/ethereum/mocks"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
 
+const (
+	hex123 = "0x0000000000000000000000000000000000000123"
+	hex456 = "0x0000000000000000000000000000000000000456"
+	hex789 = "0x0000000000000000000000000000000000000789"
+	hexABC = "0x0000000000000000000000000000000000000abc"
+	hexDEF = "0x0000000000000000000000000000000000000def"
+)
+
+var (
+	addr123 = address(hex123)
+	addr456 = address(hex456)
+	addr789 = address(hex789)
+	addrABC = address(hexABC)
+	addrDEF = address(hexDEF)
+)
+
+func address(hexStr string) ethereum.Address {
+	addr, err := ethereum.ParseAddress(hexStr)
+	if err != nil {
+		panic(err)
+	}
+	return addr
+}
+
+// 0x66f3e4d7
+var testBlockTime = time.Date(2024, time.September, 25, 10, 24, 23, 0, time.UTC)
+
+// testBlockHash is the hash of the test block with the given number
+func testBlockHash(number int) string {
+	return fmt.Sprintf("0x%064x", number+0xb10c)
+}
+
+// testBlock returns a block whose parent hash is the hash of the previous test block
+func testBlock(number string, transactions []interface{}) ethereum.Block {
+	n, err := hexToInt(number)
+	if err != nil {
+		panic(err)
+	}
+	return ethereum.Block{
+		Number:       number,
+		Hash:         testBlockHash(n),
+		ParentHash:   testBlockHash(n - 1),
+		Timestamp:    "0x66f3e4d7",
+		Transactions: transactions,
+	}
+}
+
+func wei(hexStr string) ethereum.Wei {
+	w, err := ethereum.ParseWei(hexStr)
+	if err != nil {
+		panic(err)
+	}
+	return w
+}
+
 func TestHexToInt(t *testing.T) {
 	tests := []struct {
 		hexStr   string
@@ -62,30 +123,30 @@ func TestGetCurrentBlock(t *testing.T) {
 func TestSubscribe(t *testing.T) {
 	tests := []struct {
 		name          string
-		initialAddrs  map[string]struct{}
-		subscribeAddr string
+		initialAddrs  map[ethereum.Address]Subscription
+		subscribeAddr ethereum.Address
 		expected      bool
 	}{
 		{
 			name:          "Subscribe new address",
-			initialAddrs:  map[string]struct{}{},
-			subscribeAddr: "0x123",
+			initialAddrs:  map[ethereum.Address]Subscription{},
+			subscribeAddr: addr123,
 			expected:      true,
 		},
 		{
 			name: "Subscribe existing address",
-			initialAddrs: map[string]struct{}{
-				"0x123": {},
+			initialAddrs: map[ethereum.Address]Subscription{
+				addr123: {},
 			},
-			subscribeAddr: "0x123",
+			subscribeAddr: addr123,
 			expected:      false,
 		},
 		{
 			name: "Subscribe another new address",
-			initialAddrs: map[string]struct{}{
-				"0x123": {},
+			initialAddrs: map[ethereum.Address]Subscription{
+				addr123: {},
 			},
-			subscribeAddr: "0x456",
+			subscribeAddr: addr456,
 			expected:      true,
 		},
 	}
@@ -102,51 +163,60 @@ func TestSubscribe(t *testing.T) {
 		})
 	}
 }
+func TestAddresses(t *testing.T) {
+	parser := NewEthereumParser(new(mocks.API))
+	assert.Empty(t, parser.Addresses())
+	parser.Subscribe(addr456)
+	parser.Subscribe(addr123)
+	parser.Subscribe(addr456)
+	assert.Equal(t, []ethereum.Address{addr123, addr456}, parser.Addresses())
+}
+
 func TestGetTransactions(t *testing.T) {
 	tests := []struct {
 		name         string
-		addresses    map[string]struct{}
-		transactions map[string][]Transaction
-		queryAddress string
+		addresses    map[ethereum.Address]Subscription
+		transactions map[ethereum.Address][]Transaction
+		queryAddress ethereum.Address
 		expected     []Transaction
 	}{
 		{
 			name: "Address not subscribed",
-			addresses: map[string]struct{}{
-				"0x123": {},
+			addresses: map[ethereum.Address]Subscription{
+				addr123: {},
 			},
-			transactions: map[string][]Transaction{
-				"0x123": {
-					{Hash: "0xabc", From: "0x123", To: "0x456", Value: "100", BlockNumber: 1},
+			transactions: map[ethereum.Address][]Transaction{
+				addr123: {
+					{Hash: "0xabc", From: addr123, To: addr456, Value: wei("0x64"), BlockNumber: 1},
 				},
 			},
-			queryAddress: "0x789",
+			queryAddress: addr789,
 			expected:     []Transaction{},
 		},
 		{
 			name: "Address subscribed with transactions",
-			addresses: map[string]struct{}{
-				"0x123": {},
+			addresses: map[ethereum.Address]Subscription{
+				addr123: {},
 			},
-			transactions: map[string][]Transaction{
-				"0x123": {
-					{Hash: "0xabc", From: "0x123", To: "0x456", Value: "100", BlockNumber: 1},
-					{Hash: "0xdef", From: "0x123", To: "0x789", Value: "200", BlockNumber: 2},
+			transactions: map[ethereum.Address][]Transaction{
+				addr123: {
+					{Hash: "0xabc", From: addr123, To: addr456, Value: wei("0x64"), BlockNumber: 1},
+					{Hash: "0xdef", From: addr123, To: addr789, Value: wei("0xc8"), BlockNumber: 2},
 				},
 			},
-			queryAddress: "0x123",
+			queryAddress: addr123,
 			expected: []Transaction{
-				{Hash: "0xabc", From: "0x123", To: "0x456", Value: "100", BlockNumber: 1},
-				{Hash: "0xdef", From: "0x123", To: "0x789", Value: "200", BlockNumber: 2},
+				{Hash: "0xabc", From: addr123, To: addr456, Value: wei("0x64"), BlockNumber: 1},
+				{Hash: "0xdef", From: addr123, To: addr789, Value: wei("0xc8"), BlockNumber: 2},
 			},
 		},
 		{
 			name: "Address subscribed with no transactions",
-			addresses: map[string]struct{}{
-				"0x123": {},
+			addresses: map[ethereum.Address]Subscription{
+				addr123: {},
 			},
-			transactions: map[string][]Transaction{},
-			queryAddress: "0x123",
+			transactions: map[ethereum.Address][]Transaction{},
+			queryAddress: addr123,
 			expected:     []Transaction{},
 		},
 	}
@@ -162,7 +232,7 @@ func TestGetTransactions(t *testing.T) {
 				t.Errorf("GetTransactions(%s) = %v, expected %v", test.queryAddress, result, test.expected)
 			}
 			for i, tx := range result {
-				if tx != test.expected[i] {
+				if tx.Hash != test.expected[i].Hash || tx.Value.Cmp(test.expected[i].Value) != 0 {
 					t.Errorf("GetTransactions(%s)[%d] = %v, expected %v", test.queryAddress, i, tx, test.expected[i])
 				}
 			}
@@ -177,7 +247,7 @@ func TestProcessBlock(t *testing.T) {
 		blockNumberStr string
 		mockReturn     []interface{}
 		expectedError  error
-		expectedTxs    map[string][]Transaction
+		expectedTxs    map[ethereum.Address][]Transaction
 	}{
 		{
 			name:           "Valid block with transactions",
@@ -186,34 +256,136 @@ func TestProcessBlock(t *testing.T) {
 			mockReturn: []interface{}{
 				map[string]interface{}{
 					"hash":  "0x123",
-					"from":  "0xabc",
-					"to":    "0xdef",
+					"from":  hexABC,
+					"to":    hexDEF,
 					"value": "0x100",
 				},
 			},
 			expectedError: nil,
-			expectedTxs: map[string][]Transaction{
-				"0xabc": {
+			expectedTxs: map[ethereum.Address][]Transaction{
+				addrABC: {
 					{
 						Hash:        "0x123",
-						From:        "0xabc",
-						To:          "0xdef",
-						Value:       "0x100",
+						From:        addrABC,
+						To:          addrDEF,
+						Value:       wei("0x100"),
 						BlockNumber: 123456,
+						Direction:   DirectionOut,
 					},
 				},
-				"0xdef": {
+				addrDEF: {
 					{
 						Hash:        "0x123",
-						From:        "0xabc",
-						To:          "0xdef",
-						Value:       "0x100",
+						From:        addrABC,
+						To:          addrDEF,
+						Value:       wei("0x100"),
 						BlockNumber: 123456,
+						Direction:   DirectionIn,
 					},
 				},
 			},
 		},
-		// Add more test cases as needed
+		{
+			name:           "Self-transfer is stored once",
+			blockNumber:    4,
+			blockNumberStr: "0x4",
+			mockReturn: []interface{}{
+				map[string]interface{}{
+					"hash":  "0x123",
+					"from":  hexABC,
+					"to":    hexABC,
+					"value": "0x1",
+				},
+			},
+			expectedError: nil,
+			expectedTxs: map[ethereum.Address][]Transaction{
+				addrABC: {
+					{Hash: "0x123", From: addrABC, To: addrABC, Value: wei("0x1"), BlockNumber: 4, Direction: DirectionSelf},
+				},
+			},
+		},
+		{
+			name:           "Values above 2^63 and gas fields",
+			blockNumber:    1,
+			blockNumberStr: "0x1",
+			mockReturn: []interface{}{
+				map[string]interface{}{
+					"hash":                 "0x456",
+					"from":                 hexABC,
+					"to":                   hexDEF,
+					"value":                "0x8000000000000000",
+					"nonce":                "0x2a",
+					"transactionIndex":     "0x3",
+					"type":                 "0x2",
+					"gas":                  "0x5208",
+					"gasPrice":             "0x4a817c800",
+					"maxFeePerGas":         "0x4a817c800",
+					"maxPriorityFeePerGas": "0x3b9aca00",
+				},
+				map[string]interface{}{
+					"hash":             "0x789",
+					"from":             hexDEF,
+					"to":               hexABC,
+					"value":            "0x0",
+					"transactionIndex": "0x4",
+				},
+			},
+			expectedError: nil,
+			expectedTxs: map[ethereum.Address][]Transaction{
+				addrABC: {
+					{
+						Hash:                 "0x456",
+						From:                 addrABC,
+						To:                   addrDEF,
+						Value:                wei("0x8000000000000000"),
+						Gas:                  21000,
+						GasPrice:             wei("0x4a817c800"),
+						MaxFeePerGas:         wei("0x4a817c800"),
+						MaxPriorityFeePerGas: wei("0x3b9aca00"),
+						Nonce:                42,
+						Type:                 2,
+						TransactionIndex:     3,
+						BlockNumber:          1,
+						Direction:            DirectionOut,
+					},
+					{Hash: "0x789", From: addrDEF, To: addrABC, Value: wei("0x0"), BlockNumber: 1, TransactionIndex: 4, Direction: DirectionIn},
+				},
+			},
+		},
+		{
+			name:           "Invalid address is skipped",
+			blockNumber:    3,
+			blockNumberStr: "0x3",
+			mockReturn: []interface{}{
+				map[string]interface{}{
+					"hash":  "0x123",
+					"from":  "hello",
+					"to":    hexDEF,
+					"value": "0x1",
+				},
+			},
+			expectedError: nil,
+			expectedTxs: map[ethereum.Address][]Transaction{
+				addrDEF: {},
+			},
+		},
+		{
+			name:           "Invalid value is skipped",
+			blockNumber:    2,
+			blockNumberStr: "0x2",
+			mockReturn: []interface{}{
+				map[string]interface{}{
+					"hash":  "0x123",
+					"from":  hexABC,
+					"to":    hexDEF,
+					"value": "0xzz",
+				},
+			},
+			expectedError: nil,
+			expectedTxs: map[ethereum.Address][]Transaction{
+				addrABC: {},
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -221,8 +393,8 @@ func TestProcessBlock(t *testing.T) {
 			mockAPI := new(mocks.API)
 			eParser := NewEthereumParser(mockAPI)
 
-			// Mock the GetTransactions method
-			mockAPI.On("GetTransactions", tt.blockNumberStr).Return(tt.mockReturn, nil)
+			// Mock the GetBlock method
+			mockAPI.On("GetBlock", tt.blockNumberStr).Return(testBlock(tt.blockNumberStr, tt.mockReturn), nil)
 
 			err := eParser.processBlock(tt.blockNumber)
 			assert.Equal(t, tt.expectedError, err)
@@ -233,8 +405,19 @@ func TestProcessBlock(t *testing.T) {
 					assert.Equal(t, tx.Hash, eParser.transactions[addr][i].Hash)
 					assert.Equal(t, tx.From, eParser.transactions[addr][i].From)
 					assert.Equal(t, tx.To, eParser.transactions[addr][i].To)
-					assert.Equal(t, tx.Value, eParser.transactions[addr][i].Value)
+					assert.Equal(t, tx.Value.String(), eParser.transactions[addr][i].Value.String())
+					assert.Equal(t, tx.Gas, eParser.transactions[addr][i].Gas)
+					assert.Equal(t, tx.GasPrice.String(), eParser.transactions[addr][i].GasPrice.String())
+					assert.Equal(t, tx.MaxFeePerGas.String(), eParser.transactions[addr][i].MaxFeePerGas.String())
+					assert.Equal(t, tx.MaxPriorityFeePerGas.String(), eParser.transactions[addr][i].MaxPriorityFeePerGas.String())
 					assert.Equal(t, tx.BlockNumber, eParser.transactions[addr][i].BlockNumber)
+					assert.Equal(t, tx.Direction, eParser.transactions[addr][i].Direction)
+					assert.Equal(t, StatusMined, eParser.transactions[addr][i].Status)
+					assert.Equal(t, tx.Nonce, eParser.transactions[addr][i].Nonce)
+					assert.Equal(t, tx.Type, eParser.transactions[addr][i].Type)
+					assert.Equal(t, tx.TransactionIndex, eParser.transactions[addr][i].TransactionIndex)
+					assert.Equal(t, testBlockHash(tt.blockNumber), eParser.transactions[addr][i].BlockHash)
+					assert.Equal(t, testBlockTime, eParser.transactions[addr][i].BlockTimestamp)
 				}
 			}
 
@@ -243,13 +426,161 @@ func TestProcessBlock(t *testing.T) {
 		})
 	}
 }
+func TestParseDirection(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected Direction
+		hasError bool
+	}{
+		{"in", DirectionIn, false},
+		{"OUT", DirectionOut, false},
+		{"self", DirectionSelf, false},
+		{"sideways", "", true},
+		{"", "", true},
+	}
+
+	for _, tt := range tests {
+		direction, err := ParseDirection(tt.input)
+		assert.Equal(t, tt.hasError, err != nil, "ParseDirection(%s) error = %v", tt.input, err)
+		assert.Equal(t, tt.expected, direction)
+	}
+}
+
+func TestFilterByDirection(t *testing.T) {
+	transactions := []Transaction{
+		{Hash: "0x1", Direction: DirectionIn},
+		{Hash: "0x2", Direction: DirectionOut},
+		{Hash: "0x3", Direction: DirectionIn},
+		{Hash: "0x4", Direction: DirectionSelf},
+	}
+
+	incoming := FilterByDirection(transactions, DirectionIn)
+	assert.Len(t, incoming, 2)
+	assert.Equal(t, "0x1", incoming[0].Hash)
+	assert.Equal(t, "0x3", incoming[1].Hash)
+	assert.Len(t, FilterByDirection(transactions, DirectionSelf), 1)
+	assert.Empty(t, FilterByDirection(nil, DirectionOut))
+}
+
+func TestTransactionMaxFee(t *testing.T) {
+	tx := Transaction{Gas: 21000, GasPrice: wei("0x4a817c800")}
+	assert.Equal(t, "420000000000000", tx.MaxFee().String())
+	assert.Equal(t, "0.00042", tx.MaxFee().Ether())
+}
+
+func TestTransactionSideOf(t *testing.T) {
+	tx := Transaction{Hash: "0x01", From: addrABC, To: addrDEF}
+	side, ok := tx.SideOf(addrABC)
+	assert.True(t, ok)
+	assert.Equal(t, DirectionOut, side.Direction)
+	side, ok = tx.SideOf(addrDEF)
+	assert.True(t, ok)
+	assert.Equal(t, DirectionIn, side.Direction)
+	_, ok = tx.SideOf(addr123)
+	assert.False(t, ok)
+
+	self := Transaction{Hash: "0x02", From: addrABC, To: addrABC}
+	side, ok = self.SideOf(addrABC)
+	assert.True(t, ok)
+	assert.Equal(t, DirectionSelf, side.Direction)
+}
+
+type recordingPublisher struct {
+	events       []EventType
+	addresses    []ethereum.Address
+	hashes       []string
+	transactions []Transaction
+}
+
+func (r *recordingPublisher) Notify(eventType EventType, address ethereum.Address, tx Transaction) {
+	r.events = append(r.events, eventType)
+	r.addresses = append(r.addresses, address)
+	r.hashes = append(r.hashes, tx.Hash)
+	r.transactions = append(r.transactions, tx)
+}
+
+func TestProcessBlockPublishesSubscribedTransactions(t *testing.T) {
+	mockAPI := new(mocks.API)
+	publisher := &recordingPublisher{}
+	eParser := NewEthereumParser(mockAPI, WithPublisher(publisher))
+	eParser.Subscribe(addrABC)
+
+	mockAPI.On("GetBlock", "0x1").Return(testBlock("0x1", []interface{}{
+		map[string]interface{}{"hash": "0x01", "from": hexABC, "to": hexDEF, "value": "0x1"},
+		map[string]interface{}{"hash": "0x02", "from": hex123, "to": hex456, "value": "0x1"},
+		map[string]interface{}{"hash": "0x03", "from": hex789, "to": hexABC, "value": "0x1"},
+		map[string]interface{}{"hash": "0x04", "from": hexABC, "to": hexABC, "value": "0x1"},
+	}), nil)
+
+	assert.NoError(t, eParser.processBlock(1))
+	assert.Equal(t, []string{"0x01", "0x03", "0x04"}, publisher.hashes)
+	assert.Equal(t, []ethereum.Address{addrABC, addrABC, addrABC}, publisher.addresses)
+	mockAPI.AssertExpectations(t)
+}
+
+func TestProcessBlockLogsWithFields(t *testing.T) {
+	var buf bytes.Buffer
+	mockAPI := new(mocks.API)
+	eParser := NewEthereumParser(mockAPI, WithLogger(slog.New(slog.NewJSONHandler(&buf, nil))))
+
+	mockAPI.On("GetBlock", "0x1").Return(testBlock("0x1", []interface{}{
+		map[string]interface{}{"hash": "0x01", "from": hexABC, "to": hexDEF, "value": "0xzz"},
+		map[string]interface{}{"hash": "0x02", "from": hexABC, "to": nil, "value": "0x1"},
+	}), nil)
+	assert.NoError(t, eParser.processBlock(1))
+
+	var records []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record map[string]interface{}
+		assert.NoError(t, json.Unmarshal([]byte(line), &record))
+		records = append(records, record)
+	}
+	// the contract creation is only logged at debug
+	if assert.Len(t, records, 2) {
+		assert.Equal(t, "WARN", records[0]["level"])
+		assert.Equal(t, float64(1), records[0]["block"])
+		assert.Equal(t, "0x01", records[0]["tx"])
+		assert.Contains(t, records[0], "error")
+		assert.Equal(t, "Processed block", records[1]["msg"])
+		assert.Equal(t, testBlockHash(1), records[1]["hash"])
+		assert.Equal(t, float64(2), records[1]["transactions"])
+	}
+}
+
+func TestDecodeTransaction(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  interface{}
+		hash string
+		err  string
+	}{
+		{"valid", map[string]interface{}{"hash": "0x01", "from": hexABC, "to": hexDEF, "value": "0x1", "type": "0x2"}, "0x01", ""},
+		{"not an object", "0x01", "", "invalid transaction object string"},
+		{"no hash", map[string]interface{}{"from": hexABC}, "", "invalid hash"},
+		{"contract creation", map[string]interface{}{"hash": "0x02", "from": hexABC, "value": "0x1"}, "0x02", ErrContractCreation.Error()},
+		{"invalid gas", map[string]interface{}{"hash": "0x03", "from": hexABC, "to": hexDEF, "value": "0x1", "gas": "12"}, "0x03", "invalid gas"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx, err := DecodeTransaction(tt.raw)
+			assert.Equal(t, tt.hash, tx.Hash)
+			if tt.err != "" {
+				assert.ErrorContains(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, Transaction{Hash: "0x01", From: addrABC, To: addrDEF, Value: wei("0x1"), Type: 2, Status: StatusMined}, tx)
+		})
+	}
+}
+
 func TestRetrieveBlockDatas(t *testing.T) {
 	tests := []struct {
 		name            string
 		currentBlock    int
 		mockBlockNum    string
 		mockBlockNumErr error
-		mockTxs         map[string][]Transaction
+		mockTxs         map[ethereum.Address][]Transaction
 		mockProcessErr  error
 		expectedError   error
 		expectedBlock   int
@@ -289,7 +620,7 @@ func TestRetrieveBlockDatas(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			mockAPI := new(mocks.API)
-			eParser := NewEthereumParser(mockAPI, WithWaitTime(0))
+			eParser := NewEthereumParser(mockAPI, WithCatchUpInterval(0))
 			eParser.currentBlock = tt.currentBlock
 
 			// Mock the GetCurrentBlock method
@@ -298,9 +629,11 @@ func TestRetrieveBlockDatas(t *testing.T) {
 			// Mock the processBlock method
 			if tt.mockBlockNumErr == nil && (tt.currentBlock < tt.expectedBlock || tt.currentBlock == 0) {
 				if tt.mockProcessErr != nil {
-					mockAPI.On("GetTransactions", mock.Anything).Return(nil, tt.mockProcessErr)
+					mockAPI.On("GetBlock", mock.Anything).Return(ethereum.Block{}, tt.mockProcessErr)
 				} else {
-					mockAPI.On("GetTransactions", mock.Anything).Return([]interface{}{}, nil)
+					mockAPI.On("GetBlock", mock.Anything).Return(func(number string) ethereum.Block {
+						return testBlock(number, []interface{}{})
+					}, nil)
 				}
 			}
 
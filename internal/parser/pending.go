@@ -0,0 +1,212 @@
+package parser
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/meirongdev/ethereum_parser/internal/ethereum"
+)
+
+// DefaultPendingDropAfter is how long a pending transaction can be missing from the pool before it is reported dropped
+const DefaultPendingDropAfter = 2 * time.Minute
+
+// pendingTx is a transaction of the pool involving a subscribed address
+type pendingTx struct {
+	tx       Transaction
+	lastSeen time.Time
+}
+
+// nonceKey is the slot a transaction takes in the pool, a new transaction in the same slot replaces it
+type nonceKey struct {
+	from  ethereum.Address
+	nonce uint64
+}
+
+// mempool is the state of the pending transactions watching, the maps are guarded by the mutex of the parser
+type mempool struct {
+	api       ethereum.TxPoolAPI
+	interval  time.Duration
+	dropAfter time.Duration
+	byHash    map[string]*pendingTx
+	byNonce   map[nonceKey]string
+	// nonces is the next nonce of each subscribed sender, one more than the nonce of its last mined transaction
+	nonces map[ethereum.Address]uint64
+}
+
+// WithTxPool watches the pending transactions of the subscribed addresses by reading the pool of the node every interval.
+// A transaction is published as EventPending when it enters the pool, then EventTransaction once mined,
+// EventReplaced when another transaction of the sender takes its nonce, in the pool or in a block, or EventDropped when it is gone from the pool
+// without being mined. The publishers are then called from the goroutine watching the pool too,
+// the On callbacks are not called for pending transactions.
+func WithTxPool(api ethereum.TxPoolAPI, interval time.Duration) Option {
+	return func(p *EthereumParser) {
+		p.mempool.api = api
+		p.mempool.interval = interval
+	}
+}
+
+// WithPendingDropAfter sets how long a pending transaction can be missing from the pool before it is reported dropped,
+// DefaultPendingDropAfter by default. It should be longer than the lag of the parser,
+// a transaction which left the pool because it was mined is only known once its block is processed.
+func WithPendingDropAfter(d time.Duration) Option {
+	return func(p *EthereumParser) {
+		p.mempool.dropAfter = d
+	}
+}
+
+// watchTxPool polls the pool until the parser is stopped
+func (p *EthereumParser) watchTxPool() {
+	for {
+		if err := p.pollTxPool(time.Now()); err != nil {
+			p.logger.Error("Failed to read the transaction pool", "error", err)
+		}
+		if !p.sleep(p.mempool.interval) {
+			return
+		}
+	}
+}
+
+// pollTxPool reads the pool and publishes the changes of the pending transactions since the previous poll
+func (p *EthereumParser) pollTxPool(now time.Time) error {
+	pool, err := p.mempool.api.GetTxPoolContent()
+	if err != nil {
+		return fmt.Errorf("error getting the pool content %w", err)
+	}
+	var seen []Transaction
+	for _, raw := range append(pool.Pending, pool.Queued...) {
+		// contract creations and malformed transactions are skipped like in the blocks
+		tx, err := DecodeTransaction(raw)
+		if err != nil {
+			continue
+		}
+		tx.Status = StatusPending
+		seen = append(seen, tx)
+	}
+
+	var entered, replaced, dropped []Transaction
+	p.mutex.Lock()
+	present := make(map[string]bool)
+	for _, tx := range seen {
+		if !p.isSubscribed(tx.From) && !p.isSubscribed(tx.To) {
+			continue
+		}
+		present[tx.Hash] = true
+		if tracked, ok := p.mempool.byHash[tx.Hash]; ok {
+			tracked.lastSeen = now
+			continue
+		}
+		// the pool may still list a transaction whose block or whose replacement was just processed
+		if p.recordedRecently(tx) || p.nonceUsed(tx) {
+			continue
+		}
+		key := nonceKey{from: tx.From, nonce: tx.Nonce}
+		if previous, ok := p.mempool.byNonce[key]; ok {
+			replaced = append(replaced, p.replacePending(previous, tx)...)
+		}
+		p.mempool.byHash[tx.Hash] = &pendingTx{tx: tx, lastSeen: now}
+		p.mempool.byNonce[key] = tx.Hash
+		entered = append(entered, p.subscribedSides(tx)...)
+	}
+	var gone []string
+	for hash, tracked := range p.mempool.byHash {
+		if !present[hash] && now.Sub(tracked.lastSeen) >= p.mempool.dropAfter {
+			gone = append(gone, hash)
+		}
+	}
+	sort.Strings(gone)
+	for _, hash := range gone {
+		dropped = append(dropped, p.untrackPending(hash, StatusDropped)...)
+	}
+	p.mutex.Unlock()
+
+	p.publish(EventReplaced, replaced)
+	p.publish(EventPending, entered)
+	p.publish(EventDropped, dropped)
+	return nil
+}
+
+// replacePending forgets a pending transaction whose nonce was taken by the replacement
+// and returns its sides of the subscribed addresses pointing to the replacement, the mutex must be held
+func (p *EthereumParser) replacePending(hash string, replacement Transaction) []Transaction {
+	status := StatusReplaced
+	if replacement.From == replacement.To && replacement.Value.IsZero() {
+		status = StatusCancelled
+	}
+	sides := p.untrackPending(hash, status)
+	for i := range sides {
+		sides[i].ReplacedBy = replacement.Hash
+	}
+	return sides
+}
+
+// untrackPending forgets a pending transaction and returns its sides of the subscribed addresses with the status,
+// the mutex must be held
+func (p *EthereumParser) untrackPending(hash string, status Status) []Transaction {
+	tracked, ok := p.mempool.byHash[hash]
+	if !ok {
+		return nil
+	}
+	delete(p.mempool.byHash, hash)
+	key := nonceKey{from: tracked.tx.From, nonce: tracked.tx.Nonce}
+	if p.mempool.byNonce[key] == hash {
+		delete(p.mempool.byNonce, key)
+	}
+	tx := tracked.tx
+	tx.Status = status
+	return p.subscribedSides(tx)
+}
+
+// minedPending forgets a pending transaction which has been mined, records the nonce of the sender
+// and returns the sides of the pending transaction it replaced when it took the nonce of another one, the mutex must be held
+func (p *EthereumParser) minedPending(tx Transaction) []Transaction {
+	if p.isSubscribed(tx.From) && tx.Nonce >= p.mempool.nonces[tx.From] {
+		p.mempool.nonces[tx.From] = tx.Nonce + 1
+	}
+	p.untrackPending(tx.Hash, StatusMined)
+	if previous, ok := p.mempool.byNonce[nonceKey{from: tx.From, nonce: tx.Nonce}]; ok {
+		return p.replacePending(previous, tx)
+	}
+	return nil
+}
+
+// unminedNonce moves the next nonce of the sender back when its transaction was rolled back, the mutex must be held
+func (p *EthereumParser) unminedNonce(tx Transaction) {
+	if next, ok := p.mempool.nonces[tx.From]; ok && tx.Nonce < next {
+		p.mempool.nonces[tx.From] = tx.Nonce
+	}
+}
+
+// nonceUsed tells if a transaction of the sender with the same nonce has already been mined, the mutex must be held
+func (p *EthereumParser) nonceUsed(tx Transaction) bool {
+	next, ok := p.mempool.nonces[tx.From]
+	return ok && tx.Nonce < next
+}
+
+// recordedRecently tells if the transaction is in one of the last processed blocks, the mutex must be held
+func (p *EthereumParser) recordedRecently(tx Transaction) bool {
+	transactions := p.transactions[tx.From]
+	for i := len(transactions) - 1; i >= 0 && transactions[i].BlockNumber > p.currentBlock-reorgWindow; i-- {
+		if transactions[i].Hash == tx.Hash {
+			return true
+		}
+	}
+	return false
+}
+
+// subscribedSides returns the sides of the transaction for the subscribed addresses, the mutex must be held
+func (p *EthereumParser) subscribedSides(tx Transaction) []Transaction {
+	var subscribed []Transaction
+	for _, side := range tx.sides() {
+		if p.isSubscribed(side.addressSide()) {
+			subscribed = append(subscribed, side)
+		}
+	}
+	return subscribed
+}
+
+// isSubscribed tells if the address is subscribed, whatever its start block, the mutex must be held
+func (p *EthereumParser) isSubscribed(address ethereum.Address) bool {
+	_, exists := p.addresses[address]
+	return exists
+}
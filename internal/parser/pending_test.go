@@ -0,0 +1,171 @@
+package parser
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/meirongdev/ethereum_parser/internal/ethereum"
+	"github.com/meirongdev/ethereum_parser/internal/ethereum/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakePool returns the pending transactions set by the test
+type fakePool struct {
+	pending []interface{}
+	err     error
+}
+
+func (f *fakePool) GetTxPoolContent() (ethereum.TxPool, error) {
+	return ethereum.TxPool{Pending: f.pending}, f.err
+}
+
+func poolTx(hash, from, to, nonce string) map[string]interface{} {
+	return map[string]interface{}{"hash": hash, "from": from, "to": to, "value": "0x1", "nonce": nonce}
+}
+
+func TestPendingLifecycle(t *testing.T) {
+	mockAPI := new(mocks.API)
+	pool := &fakePool{}
+	publisher := &recordingPublisher{}
+	eParser := NewEthereumParser(mockAPI, WithTxPool(pool, time.Second), WithPendingDropAfter(time.Minute), WithPublisher(publisher), WithConfirmations(0))
+	eParser.Subscribe(addrABC)
+	now := time.Now()
+
+	// only the transactions of subscribed addresses are tracked
+	pool.pending = []interface{}{
+		poolTx("0x01", hexABC, hexDEF, "0x1"),
+		poolTx("0x02", hex789, hexABC, "0x5"),
+		poolTx("0x03", hex123, hex456, "0x1"),
+		poolTx("0x04", hexABC, hexDEF, "0x2"),
+	}
+	assert.NoError(t, eParser.pollTxPool(now))
+	assert.Equal(t, []EventType{EventPending, EventPending, EventPending}, publisher.events)
+	assert.Equal(t, []string{"0x01", "0x02", "0x04"}, publisher.hashes)
+
+	// polling again publishes nothing new
+	assert.NoError(t, eParser.pollTxPool(now.Add(time.Second)))
+	assert.Len(t, publisher.events, 3)
+
+	// 0x05 takes the nonce of 0x01, 0x02 is mined
+	*publisher = recordingPublisher{}
+	pool.pending = []interface{}{poolTx("0x05", hexABC, hexDEF, "0x1"), poolTx("0x04", hexABC, hexDEF, "0x2")}
+	mockAPI.On("GetBlock", "0x1").Return(testBlock("0x1", []interface{}{poolTx("0x02", hex789, hexABC, "0x5")}), nil)
+	assert.NoError(t, eParser.processBlock(1))
+	eParser.setCurrentBlock(1)
+	assert.NoError(t, eParser.pollTxPool(now.Add(2*time.Second)))
+	assert.Equal(t, []EventType{EventTransaction, EventReplaced, EventPending}, publisher.events)
+	assert.Equal(t, []string{"0x02", "0x01", "0x05"}, publisher.hashes)
+	assert.Equal(t, "0x05", publisher.transactions[1].ReplacedBy)
+
+	// 0x04 is dropped once it is missing for the drop delay
+	*publisher = recordingPublisher{}
+	pool.pending = []interface{}{poolTx("0x05", hexABC, hexDEF, "0x1")}
+	assert.NoError(t, eParser.pollTxPool(now.Add(time.Minute)))
+	assert.Empty(t, publisher.events)
+	assert.NoError(t, eParser.pollTxPool(now.Add(2*time.Second+time.Minute)))
+	assert.Equal(t, []EventType{EventDropped}, publisher.events)
+	assert.Equal(t, []string{"0x04"}, publisher.hashes)
+	assert.Equal(t, []string{"0x05"}, pendingHashes(eParser))
+}
+
+func TestPendingSkipsRecordedTransactions(t *testing.T) {
+	mockAPI := new(mocks.API)
+	pool := &fakePool{pending: []interface{}{poolTx("0x01", hexABC, hexDEF, "0x1")}}
+	publisher := &recordingPublisher{}
+	eParser := NewEthereumParser(mockAPI, WithTxPool(pool, time.Second), WithPublisher(publisher))
+	eParser.Subscribe(addrABC)
+
+	// the block is processed before the pool forgets the transaction
+	mockAPI.On("GetBlock", "0x1").Return(testBlock("0x1", pool.pending), nil)
+	assert.NoError(t, eParser.processBlock(1))
+	eParser.setCurrentBlock(1)
+	assert.NoError(t, eParser.pollTxPool(time.Now()))
+	assert.Equal(t, []EventType{EventTransaction}, publisher.events)
+	assert.Empty(t, pendingHashes(eParser))
+}
+
+func TestMinedReplacements(t *testing.T) {
+	cancel := poolTx("0x03", hexABC, hexABC, "0x2")
+	cancel["value"] = "0x0"
+	tests := []struct {
+		name        string
+		mined       map[string]interface{}
+		status      Status
+		replacedBy  string
+		pendingLeft []string
+	}{
+		{"sped up", poolTx("0x02", hexABC, hexDEF, "0x2"), StatusReplaced, "0x02", []string{"0x01"}},
+		{"cancelled", cancel, StatusCancelled, "0x03", []string{"0x01"}},
+		{"other nonce", poolTx("0x04", hexABC, hexDEF, "0x3"), "", "", []string{"0x01", "0x11"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockAPI := new(mocks.API)
+			pool := &fakePool{pending: []interface{}{poolTx("0x01", hexABC, hexDEF, "0x1"), poolTx("0x11", hexABC, hexDEF, "0x2")}}
+			publisher := &recordingPublisher{}
+			eParser := NewEthereumParser(mockAPI, WithTxPool(pool, time.Second), WithPublisher(publisher), WithConfirmations(0))
+			eParser.Subscribe(addrABC)
+			assert.NoError(t, eParser.pollTxPool(time.Now()))
+
+			// the replacement is mined without being seen in the pool
+			*publisher = recordingPublisher{}
+			mockAPI.On("GetBlock", "0x1").Return(testBlock("0x1", []interface{}{tt.mined}), nil)
+			assert.NoError(t, eParser.processBlock(1))
+			if tt.status == "" {
+				assert.Equal(t, []EventType{EventTransaction}, publisher.events)
+			} else {
+				assert.Equal(t, []EventType{EventReplaced, EventTransaction}, publisher.events)
+				assert.Equal(t, "0x11", publisher.transactions[0].Hash)
+				assert.Equal(t, tt.status, publisher.transactions[0].Status)
+				assert.Equal(t, tt.replacedBy, publisher.transactions[0].ReplacedBy)
+			}
+			assert.ElementsMatch(t, tt.pendingLeft, pendingHashes(eParser))
+
+			// the replacement is only told in the events, the history has the mined transaction alone
+			stored := eParser.GetTransactions(addrABC)
+			if assert.Len(t, stored, 1) {
+				assert.Equal(t, tt.mined["hash"], stored[0].Hash)
+				assert.Empty(t, stored[0].ReplacedBy)
+				assert.False(t, stored[0].Status.Pending())
+			}
+		})
+	}
+}
+
+func TestPendingSkipsUsedNonces(t *testing.T) {
+	mockAPI := new(mocks.API)
+	pool := &fakePool{}
+	publisher := &recordingPublisher{}
+	eParser := NewEthereumParser(mockAPI, WithTxPool(pool, time.Second), WithPublisher(publisher), WithConfirmations(0))
+	eParser.Subscribe(addrABC)
+	mockAPI.On("GetBlock", "0x1").Return(testBlock("0x1", []interface{}{poolTx("0x02", hexABC, hexDEF, "0x5")}), nil)
+	assert.NoError(t, eParser.processBlock(1))
+	eParser.setCurrentBlock(1)
+
+	// the pool still lists the original of the mined replacement
+	*publisher = recordingPublisher{}
+	pool.pending = []interface{}{poolTx("0x01", hexABC, hexDEF, "0x5"), poolTx("0x03", hexABC, hexDEF, "0x6")}
+	assert.NoError(t, eParser.pollTxPool(time.Now()))
+	assert.Equal(t, []string{"0x03"}, publisher.hashes)
+
+	// the nonce is free again once its block is rolled back
+	eParser.rollback(1)
+	assert.NoError(t, eParser.pollTxPool(time.Now()))
+	assert.Contains(t, pendingHashes(eParser), "0x01")
+}
+
+func TestPendingPoolErrors(t *testing.T) {
+	eParser := NewEthereumParser(new(mocks.API), WithTxPool(&fakePool{err: errors.New("the method txpool_content does not exist")}, time.Second))
+	assert.ErrorContains(t, eParser.pollTxPool(time.Now()), "txpool_content")
+}
+
+func pendingHashes(p *EthereumParser) []string {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	var hashes []string
+	for hash := range p.mempool.byHash {
+		hashes = append(hashes, hash)
+	}
+	return hashes
+}
@@ -0,0 +1,44 @@
+package parser
+
+import "time"
+
+// Progress is a snapshot of the progress of the parser, for the health checks and the metrics
+type Progress struct {
+	CurrentBlock int
+	// ChainHead is the last block number returned by the node, -1 before the first one
+	ChainHead int
+	// Started is when Start was called, zero before
+	Started time.Time
+	// LastProgress is when the processing loop last began an iteration or processed a block
+	LastProgress time.Time
+	// LastRPC is when a call to the node last succeeded
+	LastRPC time.Time
+}
+
+// Progress returns the progress of the parser
+func (p *EthereumParser) Progress() Progress {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	progress := p.progress
+	progress.CurrentBlock = p.currentBlock
+	return progress
+}
+
+// progressed records that the loop is alive, rpc tells that it just got an answer from the node
+func (p *EthereumParser) progressed(rpc bool) {
+	now := time.Now()
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.progress.LastProgress = now
+	if rpc {
+		p.progress.LastRPC = now
+	}
+}
+
+// Lag is the number of blocks the parser is behind the chain head, 0 before both are known
+func (p Progress) Lag() int {
+	if p.ChainHead < 0 || p.CurrentBlock < 0 || p.CurrentBlock > p.ChainHead {
+		return 0
+	}
+	return p.ChainHead - p.CurrentBlock
+}
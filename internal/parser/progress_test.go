@@ -0,0 +1,35 @@
+package parser
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/meirongdev/ethereum_parser/internal/ethereum"
+	"github.com/meirongdev/ethereum_parser/internal/ethereum/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestProgress(t *testing.T) {
+	mockAPI := new(mocks.API)
+	eParser := NewEthereumParser(mockAPI, WithCatchUpInterval(0))
+	assert.Equal(t, Progress{CurrentBlock: -1, ChainHead: -1}, eParser.Progress())
+
+	mockAPI.On("GetCurrentBlock").Return("0x2", nil).Once()
+	mockAPI.On("GetBlock", mock.Anything).Return(func(number string) ethereum.Block {
+		return testBlock(number, []interface{}{})
+	}, nil)
+	before := time.Now()
+	assert.NoError(t, eParser.retrieveBlockDatas())
+	progress := eParser.Progress()
+	assert.Equal(t, 2, progress.CurrentBlock)
+	assert.Equal(t, 2, progress.ChainHead)
+	assert.False(t, progress.LastRPC.Before(before))
+	assert.Equal(t, progress.LastRPC, progress.LastProgress)
+
+	// a failing node does not move the last successful call
+	mockAPI.On("GetCurrentBlock").Return("", errors.New("unavailable"))
+	assert.Error(t, eParser.retrieveBlockDatas())
+	assert.Equal(t, progress.LastRPC, eParser.Progress().LastRPC)
+}
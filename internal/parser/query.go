@@ -0,0 +1,278 @@
+package parser
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"iter"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/meirongdev/ethereum_parser/internal/ethereum"
+)
+
+const (
+	// DefaultQueryLimit is the page size used when a query has no limit
+	DefaultQueryLimit = 100
+	// MaxQueryLimit is the largest page size a query can ask for
+	MaxQueryLimit = 1000
+	// NativeToken is the only token the parser indexes for now, plain ether transfers
+	NativeToken = "ETH"
+)
+
+// ErrInvalidCursor is returned when a query cursor was not produced by QueryTransactions
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Status is the lifecycle state of a transaction
+type Status string
+
+const (
+	// StatusMined is a transaction included in a processed block
+	StatusMined Status = "mined"
+	// StatusConfirmed is a mined transaction whose block reached the confirmation depth
+	StatusConfirmed Status = "confirmed"
+	// StatusRemoved is a transaction whose block was dropped by a reorganization, it is only seen in events
+	StatusRemoved Status = "removed"
+	// StatusPending is a transaction seen in the pool of the node and not yet mined, it is only seen in events
+	StatusPending Status = "pending"
+	// StatusDropped is a pending transaction which left the pool without being mined, it is only seen in events
+	StatusDropped Status = "dropped"
+	// StatusReplaced is a pending transaction superseded by another one with the same nonce, it is only seen in events
+	StatusReplaced Status = "replaced"
+	// StatusCancelled is a replaced transaction whose replacement sends nothing to the sender itself,
+	// the usual way to cancel a transaction, it is only seen in events
+	StatusCancelled Status = "cancelled"
+)
+
+// Pending tells if the status is one of a transaction of the pool which is not in a block:
+// pending, dropped, replaced or cancelled
+func (s Status) Pending() bool {
+	return s == StatusPending || s == StatusDropped || s == StatusReplaced || s == StatusCancelled
+}
+
+// ParseStatus converts a status string into a Status
+func ParseStatus(s string) (Status, error) {
+	switch st := Status(strings.ToLower(s)); st {
+	case StatusMined, StatusConfirmed:
+		return st, nil
+	default:
+		return "", fmt.Errorf("invalid status %q", s)
+	}
+}
+
+// SortOrder is the order of a query result by block number and transaction index
+type SortOrder string
+
+const (
+	SortAsc  SortOrder = "asc"
+	SortDesc SortOrder = "desc"
+)
+
+// ParseSortOrder converts asc or desc into a SortOrder
+func ParseSortOrder(s string) (SortOrder, error) {
+	switch o := SortOrder(strings.ToLower(s)); o {
+	case SortAsc, SortDesc:
+		return o, nil
+	default:
+		return "", fmt.Errorf("invalid sort order %q, expected asc or desc", s)
+	}
+}
+
+// Query selects a page of transactions of an address, the zero value of each field means no filter
+type Query struct {
+	// FromBlock and ToBlock are inclusive, a ToBlock of 0 means no upper bound
+	FromBlock int
+	ToBlock   int
+	// Since is inclusive and Until is exclusive
+	Since     time.Time
+	Until     time.Time
+	Direction Direction
+	// MinValue is inclusive
+	MinValue ethereum.Wei
+	Token    string
+	Status   Status
+	// Order defaults to ascending
+	Order SortOrder
+	// Limit defaults to DefaultQueryLimit and is capped at MaxQueryLimit
+	Limit int
+	// Cursor is the NextCursor of the previous page
+	Cursor string
+}
+
+// Page is one page of a query result, NextCursor is empty on the last page
+type Page struct {
+	Transactions []Transaction
+	NextCursor   string
+}
+
+// txPosition orders the transactions of an address, the hash makes it unique
+type txPosition struct {
+	BlockNumber      int
+	TransactionIndex int
+	Hash             string
+}
+
+func positionOf(tx Transaction) txPosition {
+	return txPosition{BlockNumber: tx.BlockNumber, TransactionIndex: tx.TransactionIndex, Hash: tx.Hash}
+}
+
+func (a txPosition) less(b txPosition) bool {
+	if a.BlockNumber != b.BlockNumber {
+		return a.BlockNumber < b.BlockNumber
+	}
+	if a.TransactionIndex != b.TransactionIndex {
+		return a.TransactionIndex < b.TransactionIndex
+	}
+	return a.Hash < b.Hash
+}
+
+func encodeCursor(pos txPosition) string {
+	raw := fmt.Sprintf("%d:%d:%s", pos.BlockNumber, pos.TransactionIndex, pos.Hash)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(cursor string) (txPosition, error) {
+	var pos txPosition
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return pos, ErrInvalidCursor
+	}
+	parts := strings.SplitN(string(raw), ":", 3)
+	if len(parts) != 3 {
+		return pos, ErrInvalidCursor
+	}
+	if _, err := fmt.Sscanf(parts[0]+" "+parts[1], "%d %d", &pos.BlockNumber, &pos.TransactionIndex); err != nil {
+		return pos, ErrInvalidCursor
+	}
+	pos.Hash = parts[2]
+	return pos, nil
+}
+
+func (q Query) matches(tx Transaction) bool {
+	if tx.BlockNumber < q.FromBlock {
+		return false
+	}
+	if q.ToBlock > 0 && tx.BlockNumber > q.ToBlock {
+		return false
+	}
+	if !q.Since.IsZero() && tx.BlockTimestamp.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !tx.BlockTimestamp.Before(q.Until) {
+		return false
+	}
+	if q.Direction != "" && tx.Direction != q.Direction {
+		return false
+	}
+	if !q.MinValue.IsZero() && tx.Value.Cmp(q.MinValue) < 0 {
+		return false
+	}
+	if q.Token != "" && !strings.EqualFold(q.Token, NativeToken) {
+		return false
+	}
+	if q.Status != "" && tx.Status != q.Status {
+		return false
+	}
+	return true
+}
+
+// QueryTransactions returns a page of the transactions of a subscribed address matching the query.
+// The transactions of an address are kept in position order, so the block range and the cursor are found
+// by binary search and only the page is copied.
+func (p *EthereumParser) QueryTransactions(address ethereum.Address, q Query) (Page, error) {
+	page := Page{Transactions: []Transaction{}}
+	if q.Limit <= 0 {
+		q.Limit = DefaultQueryLimit
+	}
+	if q.Limit > MaxQueryLimit {
+		q.Limit = MaxQueryLimit
+	}
+	if q.Order == "" {
+		q.Order = SortAsc
+	}
+	var after *txPosition
+	if q.Cursor != "" {
+		pos, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return page, err
+		}
+		after = &pos
+	}
+
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	subscription, exists := p.addresses[address]
+	if !exists {
+		return page, nil
+	}
+	// the transactions before the start of the subscription are hidden
+	q.FromBlock = max(q.FromBlock, subscription.StartBlock)
+	transactions := p.transactions[address]
+	lo := sort.Search(len(transactions), func(i int) bool { return transactions[i].BlockNumber >= q.FromBlock })
+	hi := len(transactions)
+	if q.ToBlock > 0 {
+		hi = sort.Search(len(transactions), func(i int) bool { return transactions[i].BlockNumber > q.ToBlock })
+	}
+	if after != nil {
+		// skip everything up to and including the cursor position
+		if q.Order == SortAsc {
+			lo = max(lo, sort.Search(len(transactions), func(i int) bool { return after.less(positionOf(transactions[i])) }))
+		} else {
+			hi = min(hi, sort.Search(len(transactions), func(i int) bool { return !positionOf(transactions[i]).less(*after) }))
+		}
+	}
+
+	for n := 0; n < hi-lo; n++ {
+		i := lo + n
+		if q.Order == SortDesc {
+			i = hi - 1 - n
+		}
+		tx := transactions[i]
+		if !q.matches(tx) {
+			continue
+		}
+		if len(page.Transactions) == q.Limit {
+			page.NextCursor = encodeCursor(positionOf(page.Transactions[len(page.Transactions)-1]))
+			break
+		}
+		page.Transactions = append(page.Transactions, tx)
+	}
+	return page, nil
+}
+
+// Querier runs the transaction queries, EthereumParser implements it
+type Querier interface {
+	QueryTransactions(address ethereum.Address, q Query) (Page, error)
+}
+
+// Pages iterates over the pages of the transactions of the address matching the query, following the cursors.
+// Each page is a separate query which seeks its cursor, so reading a whole history costs one page of memory
+// and the parser is not locked while the caller handles a page. The iteration stops after an error.
+func Pages(querier Querier, address ethereum.Address, q Query) iter.Seq2[Page, error] {
+	return func(yield func(Page, error) bool) {
+		for {
+			page, err := querier.QueryTransactions(address, q)
+			if !yield(page, err) || err != nil || page.NextCursor == "" {
+				return
+			}
+			q.Cursor = page.NextCursor
+		}
+	}
+}
+
+// insertTransaction adds the transaction to the ones of the address, keeping them in position order.
+// Blocks are processed in order so it is an append, unless a backfill goes back in time. The mutex must be held.
+func (p *EthereumParser) insertTransaction(address ethereum.Address, tx Transaction) {
+	transactions := p.transactions[address]
+	// transactions at the same place keep the order they were added in, a block has no index twice
+	before := func(a, b Transaction) bool {
+		return a.BlockNumber < b.BlockNumber || a.BlockNumber == b.BlockNumber && a.TransactionIndex < b.TransactionIndex
+	}
+	i := len(transactions)
+	if i > 0 && before(tx, transactions[i-1]) {
+		i = sort.Search(len(transactions), func(j int) bool { return before(tx, transactions[j]) })
+	}
+	p.transactions[address] = slices.Insert(transactions, i, tx)
+}
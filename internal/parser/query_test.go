@@ -0,0 +1,149 @@
+package parser
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/meirongdev/ethereum_parser/internal/ethereum"
+	"github.com/stretchr/testify/assert"
+)
+
+func queryTestParser() *EthereumParser {
+	base := time.Date(2024, time.September, 1, 0, 0, 0, 0, time.UTC)
+	tx := func(hash string, block, index int, direction Direction, value string) Transaction {
+		return Transaction{
+			Hash:             hash,
+			BlockNumber:      block,
+			TransactionIndex: index,
+			BlockTimestamp:   base.Add(time.Duration(block) * 12 * time.Second),
+			Direction:        direction,
+			Value:            wei(value),
+			Status:           StatusMined,
+		}
+	}
+	return &EthereumParser{
+		addresses: map[ethereum.Address]Subscription{addrABC: {}},
+		transactions: map[ethereum.Address][]Transaction{
+			addrABC: {
+				tx("0x01", 1, 0, DirectionIn, "0x10"),
+				tx("0x02", 1, 5, DirectionOut, "0x20"),
+				tx("0x03", 2, 1, DirectionIn, "0x30"),
+				tx("0x04", 3, 0, DirectionSelf, "0x40"),
+				tx("0x05", 5, 2, DirectionIn, "0x8000000000000000"),
+			},
+			addrDEF: {
+				tx("0x06", 1, 1, DirectionIn, "0x10"),
+			},
+		},
+	}
+}
+
+func hashes(transactions []Transaction) []string {
+	result := []string{}
+	for _, tx := range transactions {
+		result = append(result, tx.Hash)
+	}
+	return result
+}
+
+func TestQueryTransactions(t *testing.T) {
+	base := time.Date(2024, time.September, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		address  ethereum.Address
+		query    Query
+		expected []string
+	}{
+		{"No filter", addrABC, Query{}, []string{"0x01", "0x02", "0x03", "0x04", "0x05"}},
+		{"Not subscribed", addrDEF, Query{}, []string{}},
+		{"Block range", addrABC, Query{FromBlock: 2, ToBlock: 3}, []string{"0x03", "0x04"}},
+		{"Time range", addrABC, Query{Since: base.Add(24 * time.Second), Until: base.Add(60 * time.Second)}, []string{"0x03", "0x04"}},
+		{"Direction", addrABC, Query{Direction: DirectionIn}, []string{"0x01", "0x03", "0x05"}},
+		{"Min value", addrABC, Query{MinValue: wei("0x30")}, []string{"0x03", "0x04", "0x05"}},
+		{"Native token", addrABC, Query{Token: "eth", ToBlock: 1}, []string{"0x01", "0x02"}},
+		{"Other token", addrABC, Query{Token: "USDC"}, []string{}},
+		{"Status", addrABC, Query{Status: StatusMined, FromBlock: 5}, []string{"0x05"}},
+		{"Descending", addrABC, Query{Order: SortDesc}, []string{"0x05", "0x04", "0x03", "0x02", "0x01"}},
+		{"Limit", addrABC, Query{Limit: 2}, []string{"0x01", "0x02"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := queryTestParser().QueryTransactions(tt.address, tt.query)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, hashes(page.Transactions))
+		})
+	}
+}
+
+func TestQueryTransactionsPagination(t *testing.T) {
+	for _, order := range []SortOrder{SortAsc, SortDesc} {
+		t.Run(string(order), func(t *testing.T) {
+			p := queryTestParser()
+			var all []string
+			query := Query{Limit: 2, Order: order}
+			for pages := 0; ; pages++ {
+				if pages > 5 {
+					t.Fatal("pagination did not terminate")
+				}
+				page, err := p.QueryTransactions(addrABC, query)
+				assert.NoError(t, err)
+				all = append(all, hashes(page.Transactions)...)
+				if page.NextCursor == "" {
+					break
+				}
+				query.Cursor = page.NextCursor
+			}
+			expected := []string{"0x01", "0x02", "0x03", "0x04", "0x05"}
+			if order == SortDesc {
+				expected = []string{"0x05", "0x04", "0x03", "0x02", "0x01"}
+			}
+			assert.Equal(t, expected, all)
+		})
+	}
+}
+
+func TestQueryTransactionsInvalidCursor(t *testing.T) {
+	for _, cursor := range []string{"!!!", base64.RawURLEncoding.EncodeToString([]byte("garbage")), base64.RawURLEncoding.EncodeToString([]byte("a:b:c"))} {
+		_, err := queryTestParser().QueryTransactions(addrABC, Query{Cursor: cursor})
+		assert.ErrorIs(t, err, ErrInvalidCursor)
+	}
+}
+
+func TestQueryTransactionsHidesBlocksBeforeTheSubscription(t *testing.T) {
+	p := queryTestParser()
+	p.addresses[addrABC] = Subscription{Address: addrABC, StartBlock: 3}
+	page, err := p.QueryTransactions(addrABC, Query{FromBlock: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"0x04", "0x05"}, hashes(page.Transactions))
+}
+
+func TestInsertTransactionKeepsPositionOrder(t *testing.T) {
+	p := queryTestParser()
+	// a backfill adds older blocks after the newer ones
+	p.insertTransaction(addrABC, Transaction{Hash: "0x07", BlockNumber: 2, TransactionIndex: 0})
+	p.insertTransaction(addrABC, Transaction{Hash: "0x08", BlockNumber: 6, TransactionIndex: 0})
+	p.insertTransaction(addrABC, Transaction{Hash: "0x09", BlockNumber: 1, TransactionIndex: 3})
+	assert.Equal(t, []string{"0x01", "0x09", "0x02", "0x07", "0x03", "0x04", "0x05", "0x08"}, hashes(p.transactions[addrABC]))
+
+	page, err := p.QueryTransactions(addrABC, Query{Limit: 3, Cursor: encodeCursor(positionOf(p.transactions[addrABC][2]))})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"0x07", "0x03", "0x04"}, hashes(page.Transactions))
+}
+
+func TestPages(t *testing.T) {
+	p := queryTestParser()
+	var pages [][]string
+	for page, err := range Pages(p, addrABC, Query{Limit: 2}) {
+		assert.NoError(t, err)
+		pages = append(pages, hashes(page.Transactions))
+	}
+	assert.Equal(t, [][]string{{"0x01", "0x02"}, {"0x03", "0x04"}, {"0x05"}}, pages)
+
+	var errs []error
+	for _, err := range Pages(p, addrABC, Query{Cursor: "!!!"}) {
+		errs = append(errs, err)
+	}
+	assert.Equal(t, []error{ErrInvalidCursor}, errs)
+}
@@ -0,0 +1,108 @@
+package parser
+
+import "time"
+
+const (
+	// DefaultPollInterval is the slot time of the mainnet, a new block is expected every 12 seconds
+	DefaultPollInterval    = 12 * time.Second
+	DefaultCatchUpInterval = 100 * time.Millisecond
+	DefaultMinBackoff      = time.Second
+	DefaultMaxBackoff      = 2 * time.Minute
+)
+
+// schedule decides how long the processing loop waits between two rounds
+type schedule struct {
+	// pollInterval is the expected time between two blocks, the head is polled when the next one is due
+	pollInterval time.Duration
+	// catchUpInterval is the shortest time between the start of two blocks while the parser is behind,
+	// it keeps a catch-up from hitting the rate limit of the node
+	catchUpInterval time.Duration
+	// the wait after an error doubles from minBackoff up to maxBackoff, it is reset by a successful round
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	failures   int
+}
+
+// WithPollInterval sets the expected time between two blocks, DefaultPollInterval by default.
+// Once caught up the parser polls the head when the next block is due,
+// then every quarter of the interval until it comes.
+func WithPollInterval(interval time.Duration) Option {
+	return func(p *EthereumParser) {
+		p.schedule.pollInterval = interval
+	}
+}
+
+// WithCatchUpInterval sets the shortest time between two blocks while the parser is behind the head,
+// DefaultCatchUpInterval by default, 0 processes them as fast as the node answers
+func WithCatchUpInterval(interval time.Duration) Option {
+	return func(p *EthereumParser) {
+		p.schedule.catchUpInterval = interval
+	}
+}
+
+// WithBackoff sets the wait after a failed round, doubled after every failure in a row up to max.
+// DefaultMinBackoff and DefaultMaxBackoff by default.
+func WithBackoff(min, max time.Duration) Option {
+	return func(p *EthereumParser) {
+		p.schedule.minBackoff = min
+		p.schedule.maxBackoff = max
+	}
+}
+
+// WithWaitTime sets the poll interval, the catch-up interval and the backoff to the same duration.
+//
+// Deprecated: use WithPollInterval, WithCatchUpInterval and WithBackoff.
+func WithWaitTime(duration time.Duration) Option {
+	return func(p *EthereumParser) {
+		p.schedule = schedule{pollInterval: duration, catchUpInterval: duration, minBackoff: duration, maxBackoff: duration}
+	}
+}
+
+// next returns the wait after a round, advanced tells whether the round processed blocks
+// and lastBlock is the time of the last processed block, zero when unknown
+func (s *schedule) next(err error, advanced bool, lastBlock, now time.Time) time.Duration {
+	if err != nil {
+		s.failures++
+		backoff := s.minBackoff
+		for i := 1; i < s.failures && backoff < s.maxBackoff; i++ {
+			backoff *= 2
+		}
+		return min(backoff, s.maxBackoff)
+	}
+	s.failures = 0
+	if !lastBlock.IsZero() {
+		// the next block is due one interval after the last one
+		if due := lastBlock.Add(s.pollInterval).Sub(now); due > 0 {
+			return min(due, s.pollInterval)
+		}
+	}
+	if advanced {
+		// the head may have moved while the blocks were processed
+		return 0
+	}
+	if lastBlock.IsZero() {
+		return s.pollInterval
+	}
+	// the block is late or the slot was missed
+	return s.pollInterval / 4
+}
+
+// sleep waits for the duration and returns false when the parser is stopped first
+func (p *EthereumParser) sleep(d time.Duration) bool {
+	if d <= 0 {
+		select {
+		case <-p.stopChannel:
+			return false
+		default:
+			return true
+		}
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-p.stopChannel:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
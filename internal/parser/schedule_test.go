@@ -0,0 +1,88 @@
+package parser
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/meirongdev/ethereum_parser/internal/ethereum"
+	"github.com/meirongdev/ethereum_parser/internal/ethereum/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestScheduleNext(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 30, 0, time.UTC)
+	tests := []struct {
+		name      string
+		advanced  bool
+		lastBlock time.Time
+		expected  time.Duration
+	}{
+		{"next block due", true, now.Add(-5 * time.Second), 7 * time.Second},
+		{"caught up and polled before the block", false, now.Add(-10 * time.Second), 2 * time.Second},
+		{"behind", true, now.Add(-time.Hour), 0},
+		{"block late", false, now.Add(-13 * time.Second), 3 * time.Second},
+		{"block from the future", true, now.Add(time.Minute), 12 * time.Second},
+		{"no block yet", false, time.Time{}, 12 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := schedule{pollInterval: 12 * time.Second, minBackoff: time.Second, maxBackoff: time.Minute}
+			assert.Equal(t, tt.expected, s.next(nil, tt.advanced, tt.lastBlock, now))
+		})
+	}
+}
+
+func TestScheduleBacksOff(t *testing.T) {
+	s := schedule{pollInterval: 12 * time.Second, minBackoff: time.Second, maxBackoff: 5 * time.Second}
+	err := errors.New("unavailable")
+	var waits []time.Duration
+	for i := 0; i < 5; i++ {
+		waits = append(waits, s.next(err, false, time.Time{}, time.Now()))
+	}
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}, waits)
+
+	// a successful round resets the backoff
+	s.next(nil, false, time.Time{}, time.Now())
+	assert.Equal(t, time.Second, s.next(err, false, time.Time{}, time.Now()))
+}
+
+func TestStopInterruptsTheWait(t *testing.T) {
+	mockAPI := new(mocks.API)
+	mockAPI.On("GetCurrentBlock").Return("0x1", nil)
+	mockAPI.On("GetBlock", mock.Anything).Return(func(number string) ethereum.Block {
+		return testBlock(number, []interface{}{})
+	}, nil)
+	eParser := NewEthereumParser(mockAPI, WithPollInterval(time.Hour))
+
+	go eParser.Start()
+	assert.Eventually(t, func() bool { return eParser.GetCurrentBlock() == 1 }, time.Second, time.Millisecond)
+	stopped := make(chan struct{})
+	go func() {
+		eParser.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Stop waited for the poll interval")
+	}
+}
+
+func TestCatchUpIsRateLimited(t *testing.T) {
+	mockAPI := new(mocks.API)
+	mockAPI.On("GetCurrentBlock").Return("0x4", nil)
+	mockAPI.On("GetBlock", mock.Anything).Return(func(number string) ethereum.Block {
+		return testBlock(number, []interface{}{})
+	}, nil)
+	eParser := NewEthereumParser(mockAPI, WithCatchUpInterval(20*time.Millisecond))
+	eParser.setCurrentBlock(0)
+
+	started := time.Now()
+	assert.NoError(t, eParser.retrieveBlockDatas())
+	assert.Equal(t, 4, eParser.GetCurrentBlock())
+	// three pauses between the four blocks, none after the last one
+	assert.GreaterOrEqual(t, time.Since(started), 60*time.Millisecond)
+	assert.Less(t, time.Since(started), 500*time.Millisecond)
+}
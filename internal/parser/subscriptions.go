@@ -0,0 +1,99 @@
+package parser
+
+import (
+	"sort"
+
+	"github.com/meirongdev/ethereum_parser/internal/ethereum"
+)
+
+// Subscription is a subscribed address, its transactions in blocks before StartBlock are hidden
+type Subscription struct {
+	Address    ethereum.Address `json:"address"`
+	Label      string           `json:"label,omitempty"`
+	StartBlock int              `json:"startBlock,omitempty"`
+}
+
+// watches tells if the address is subscribed from the block of the transaction,
+// it must be called while holding the mutex
+func (p *EthereumParser) watches(address ethereum.Address, tx Transaction) bool {
+	subscription, exists := p.addresses[address]
+	return exists && tx.BlockNumber >= subscription.StartBlock
+}
+
+// SubscribeAll adds the subscriptions at once, it returns false for the addresses which were already subscribed.
+// An existing subscription takes the new label when one is given and keeps the earliest start block.
+func (p *EthereumParser) SubscribeAll(subscriptions []Subscription) []bool {
+	created := make([]bool, len(subscriptions))
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for i, subscription := range subscriptions {
+		existing, exists := p.addresses[subscription.Address]
+		if !exists {
+			p.addresses[subscription.Address] = subscription
+			if p.balances.api != nil {
+				p.balances.unknown[subscription.Address] = struct{}{}
+				p.wakeBaselines()
+			}
+			created[i] = true
+			continue
+		}
+		if subscription.Label != "" {
+			existing.Label = subscription.Label
+		}
+		if subscription.StartBlock < existing.StartBlock {
+			existing.StartBlock = subscription.StartBlock
+		}
+		p.addresses[subscription.Address] = existing
+	}
+	return created
+}
+
+// Unsubscribe removes the addresses at once, it returns false for the addresses which were not subscribed.
+// Their transactions are kept like the ones of every address seen by the parser, their balance history is forgotten.
+func (p *EthereumParser) Unsubscribe(addresses []ethereum.Address) []bool {
+	removed := make([]bool, len(addresses))
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for i, address := range addresses {
+		if _, exists := p.addresses[address]; exists {
+			delete(p.addresses, address)
+			delete(p.mempool.nonces, address)
+			delete(p.balances.history, address)
+			delete(p.balances.unknown, address)
+			removed[i] = true
+		}
+	}
+	return removed
+}
+
+// Subscriptions returns the subscriptions sorted by the hex form of their address
+func (p *EthereumParser) Subscriptions() []Subscription {
+	p.mutex.RLock()
+	subscriptions := make([]Subscription, 0, len(p.addresses))
+	for _, subscription := range p.addresses {
+		subscriptions = append(subscriptions, subscription)
+	}
+	p.mutex.RUnlock()
+	sort.Slice(subscriptions, func(i, j int) bool {
+		return subscriptions[i].Address.Hex() < subscriptions[j].Address.Hex()
+	})
+	return subscriptions
+}
+
+// SubscriptionCount returns the number of subscribed addresses
+func (p *EthereumParser) SubscriptionCount() int {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return len(p.addresses)
+}
+
+// TransactionCount returns the number of stored transactions, of every address seen and not only the subscribed ones
+func (p *EthereumParser) TransactionCount() int {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	count := 0
+	for _, transactions := range p.transactions {
+		count += len(transactions)
+	}
+	return count
+}
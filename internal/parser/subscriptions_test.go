@@ -0,0 +1,47 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/meirongdev/ethereum_parser/internal/ethereum"
+	"github.com/meirongdev/ethereum_parser/internal/ethereum/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscribeAll(t *testing.T) {
+	parser := NewEthereumParser(new(mocks.API))
+	parser.Subscribe(addr123)
+
+	created := parser.SubscribeAll([]Subscription{
+		{Address: addr456, Label: "deposit", StartBlock: 10},
+		{Address: addr123, Label: "hot wallet", StartBlock: 5},
+		{Address: addr456, StartBlock: 20},
+	})
+	assert.Equal(t, []bool{true, false, false}, created)
+	assert.Equal(t, []Subscription{
+		// the existing subscription keeps its earliest start block
+		{Address: addr123, Label: "hot wallet", StartBlock: 0},
+		{Address: addr456, Label: "deposit", StartBlock: 10},
+	}, parser.Subscriptions())
+
+	assert.Equal(t, 2, parser.SubscriptionCount())
+
+	assert.Equal(t, []bool{true, false}, parser.Unsubscribe([]ethereum.Address{addr123, addr789}))
+	assert.Equal(t, []ethereum.Address{addr456}, parser.Addresses())
+	assert.Equal(t, 1, parser.SubscriptionCount())
+}
+
+func TestStartBlockHidesEarlierTransactions(t *testing.T) {
+	parser := queryTestParser()
+	parser.SubscribeAll([]Subscription{{Address: addrDEF, StartBlock: 2}})
+	parser.addresses[addrABC] = Subscription{Address: addrABC, StartBlock: 2}
+
+	page, err := parser.QueryTransactions(addrABC, Query{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"0x03", "0x04", "0x05"}, hashes(page.Transactions))
+	assert.Len(t, parser.GetTransactions(addrABC), 3)
+	assert.Empty(t, parser.GetTransactions(addrDEF))
+	// the hidden transactions are still stored
+	assert.Equal(t, 6, parser.TransactionCount())
+}
@@ -0,0 +1,112 @@
+// Package ratelimit implements token bucket rate limits keyed by client
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Result is the outcome of a request against a limit
+type Result struct {
+	Allowed bool
+	// Limit is the burst size, the most requests a client can make at once
+	Limit int
+	// Remaining is how many requests the client can still make right now
+	Remaining int
+	// RetryAfter is how long a rejected client has to wait for the next token
+	RetryAfter time.Duration
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter gives every key a bucket of burst tokens refilled at rate tokens per second
+type Limiter struct {
+	rate  float64
+	burst int
+	now   func() time.Time
+
+	mutex     sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type Option func(*Limiter)
+
+// WithClock replaces time.Now, for the tests
+func WithClock(now func() time.Time) Option {
+	return func(l *Limiter) {
+		l.now = now
+	}
+}
+
+// New creates a limiter allowing rate requests per second on average and bursts of burst requests
+func New(rate float64, burst int, options ...Option) *Limiter {
+	l := &Limiter{
+		rate:    rate,
+		burst:   burst,
+		now:     time.Now,
+		buckets: make(map[string]*bucket),
+	}
+	for _, option := range options {
+		option(l)
+	}
+	l.lastSweep = l.now()
+	return l
+}
+
+// refillTime is how long an empty bucket takes to be full again, a full bucket is the same as no bucket
+func (l *Limiter) refillTime() time.Duration {
+	return time.Duration(float64(l.burst) / l.rate * float64(time.Second))
+}
+
+// Allow takes a token from the bucket of the key
+func (l *Limiter) Allow(key string) Result {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := l.now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(l.burst), b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	result := Result{Limit: l.burst}
+	if b.tokens < 1 {
+		result.RetryAfter = time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return result
+	}
+	b.tokens--
+	result.Allowed = true
+	result.Remaining = int(b.tokens)
+	return result
+}
+
+// sweep forgets the buckets which are full again so idle clients do not use memory,
+// it must be called while holding the mutex
+func (l *Limiter) sweep(now time.Time) {
+	idle := l.refillTime()
+	if now.Sub(l.lastSweep) < idle {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= idle {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Len returns the number of clients with a bucket
+func (l *Limiter) Len() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return len(l.buckets)
+}
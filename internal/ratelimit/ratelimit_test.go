@@ -0,0 +1,65 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func TestAllow(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	limiter := New(2, 3, WithClock(clock.Now))
+
+	for remaining := 2; remaining >= 0; remaining-- {
+		result := limiter.Allow("a")
+		assert.Equal(t, Result{Allowed: true, Limit: 3, Remaining: remaining}, result)
+	}
+	result := limiter.Allow("a")
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 500*time.Millisecond, result.RetryAfter)
+
+	// the other keys have their own bucket
+	assert.True(t, limiter.Allow("b").Allowed)
+
+	clock.Advance(250 * time.Millisecond)
+	result = limiter.Allow("a")
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 250*time.Millisecond, result.RetryAfter)
+
+	clock.Advance(250 * time.Millisecond)
+	assert.Equal(t, Result{Allowed: true, Limit: 3, Remaining: 0}, limiter.Allow("a"))
+
+	// a bucket never holds more than the burst
+	clock.Advance(time.Hour)
+	assert.Equal(t, Result{Allowed: true, Limit: 3, Remaining: 2}, limiter.Allow("a"))
+}
+
+func TestIdleBucketsAreForgotten(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	limiter := New(1, 2, WithClock(clock.Now))
+	limiter.Allow("a")
+	limiter.Allow("b")
+	assert.Equal(t, 2, limiter.Len())
+
+	clock.Advance(time.Second)
+	limiter.Allow("b")
+	assert.Equal(t, 2, limiter.Len())
+
+	clock.Advance(time.Second)
+	limiter.Allow("c")
+	// a was idle long enough to be full, b was used a second ago
+	assert.Equal(t, 2, limiter.Len())
+}
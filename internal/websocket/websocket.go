@@ -0,0 +1,274 @@
+// Package websocket is a small server side implementation of RFC 6455,
+// enough for the JSON subscription API: text, binary, ping, pong and close frames.
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Opcodes of RFC 6455 section 5.2
+const (
+	OpContinuation = 0x0
+	OpText         = 0x1
+	OpBinary       = 0x2
+	OpClose        = 0x8
+	OpPing         = 0x9
+	OpPong         = 0xA
+)
+
+// Close codes of RFC 6455 section 7.4.1
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseTryAgainLater   = 1013
+)
+
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+var (
+	// ErrClosed is returned by ReadMessage once the peer sent a close frame
+	ErrClosed = errors.New("websocket closed")
+	// ErrMessageTooBig is returned when a message is larger than the read limit
+	ErrMessageTooBig = errors.New("websocket message too big")
+)
+
+// Conn is an upgraded connection, one goroutine may read while others write
+type Conn struct {
+	conn       net.Conn
+	reader     *bufio.Reader
+	writeMutex sync.Mutex
+	readLimit  int64
+	closeOnce  sync.Once
+}
+
+// AcceptKey computes the Sec-WebSocket-Accept value for a Sec-WebSocket-Key
+func AcceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Upgrade performs the opening handshake, on failure it has already replied with an HTTP error
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return nil, fmt.Errorf("websocket handshake with method %s", r.Method)
+	}
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "Expected a websocket upgrade", http.StatusBadRequest)
+		return nil, errors.New("websocket handshake without upgrade headers")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Unsupported websocket version", http.StatusUpgradeRequired)
+		return nil, errors.New("unsupported websocket version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		http.Error(w, "Invalid Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, errors.New("invalid websocket key")
+	}
+
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, "Websocket not supported", http.StatusInternalServerError)
+		return nil, err
+	}
+	// the server timeouts do not apply to hijacked connections
+	_ = conn.SetDeadline(time.Time{})
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + AcceptKey(key) + "\r\n\r\n"
+	if _, err := conn.Write([]byte(response)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &Conn{conn: conn, reader: rw.Reader, readLimit: 1 << 20}, nil
+}
+
+// SetReadLimit sets the largest message ReadMessage accepts
+func (c *Conn) SetReadLimit(limit int64) {
+	c.readLimit = limit
+}
+
+// SetReadDeadline bounds the next reads, a zero time removes the deadline
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// RemoteAddr returns the address of the peer
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// ReadMessage returns the next text or binary message.
+// Pings are answered and pongs skipped, a close frame is answered and returns ErrClosed.
+func (c *Conn) ReadMessage() (int, []byte, error) {
+	var message []byte
+	opcode := -1
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch op {
+		case OpPing:
+			if err := c.WriteMessage(OpPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case OpPong:
+			continue
+		case OpClose:
+			code := CloseNormal
+			if len(payload) >= 2 {
+				code = int(binary.BigEndian.Uint16(payload))
+			}
+			_ = c.WriteClose(code, "")
+			return 0, nil, ErrClosed
+		case OpText, OpBinary:
+			if opcode != -1 {
+				_ = c.WriteClose(CloseProtocolError, "expected continuation frame")
+				return 0, nil, errors.New("websocket data frame inside a fragmented message")
+			}
+			opcode = op
+		case OpContinuation:
+			if opcode == -1 {
+				_ = c.WriteClose(CloseProtocolError, "unexpected continuation frame")
+				return 0, nil, errors.New("websocket continuation frame without a message")
+			}
+		default:
+			_ = c.WriteClose(CloseProtocolError, "unknown opcode")
+			return 0, nil, fmt.Errorf("websocket unknown opcode %d", op)
+		}
+		if int64(len(message)+len(payload)) > c.readLimit {
+			_ = c.WriteClose(CloseMessageTooBig, "")
+			return 0, nil, ErrMessageTooBig
+		}
+		message = append(message, payload...)
+		if fin {
+			return opcode, message, nil
+		}
+	}
+}
+
+func (c *Conn) readFrame() (bool, int, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin := header[0]&0x80 != 0
+	opcode := int(header[0] & 0x0f)
+	masked := header[1]&0x80 != 0
+	length := int64(header[1] & 0x7f)
+	if header[0]&0x70 != 0 {
+		_ = c.WriteClose(CloseProtocolError, "reserved bits set")
+		return false, 0, nil, errors.New("websocket frame with reserved bits")
+	}
+	if !masked {
+		// RFC 6455 section 5.1, a server must close the connection on an unmasked client frame
+		_ = c.WriteClose(CloseProtocolError, "client frames must be masked")
+		return false, 0, nil, errors.New("websocket unmasked client frame")
+	}
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = int64(binary.BigEndian.Uint64(ext[:]))
+	}
+	if length < 0 || length > c.readLimit {
+		_ = c.WriteClose(CloseMessageTooBig, "")
+		return false, 0, nil, ErrMessageTooBig
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// WriteMessage sends one unfragmented frame, it is safe to call from several goroutines
+func (c *Conn) WriteMessage(opcode int, data []byte) error {
+	return c.WriteMessageDeadline(opcode, data, time.Time{})
+}
+
+// WriteMessageDeadline sends one frame and fails if it cannot be written before the deadline
+func (c *Conn) WriteMessageDeadline(opcode int, data []byte, deadline time.Time) error {
+	frame := make([]byte, 0, len(data)+10)
+	frame = append(frame, 0x80|byte(opcode))
+	switch {
+	case len(data) < 126:
+		frame = append(frame, byte(len(data)))
+	case len(data) <= 0xffff:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(data)))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(data)))
+	}
+	frame = append(frame, data...)
+
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	if err := c.conn.SetWriteDeadline(deadline); err != nil {
+		return err
+	}
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// WriteClose sends a close frame with a status code and a reason
+func (c *Conn) WriteClose(code int, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	payload = append(payload, reason...)
+	return c.WriteMessageDeadline(OpClose, payload, time.Now().Add(time.Second))
+}
+
+// Close closes the underlying connection without a close frame
+func (c *Conn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		err = c.conn.Close()
+	})
+	return err
+}
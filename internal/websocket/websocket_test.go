@@ -0,0 +1,178 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testClient is the client half of the protocol, just enough to exercise the server
+type testClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dial(t *testing.T, serverURL string) *testClient {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(serverURL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := "dGhlIHNhbXBsZSBub25jZQ=="
+	request := "GET / HTTP/1.1\r\nHost: test\r\nUpgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n" +
+		"Sec-WebSocket-Key: " + key + "\r\nSec-WebSocket-Version: 13\r\n\r\n"
+	if _, err := conn.Write([]byte(request)); err != nil {
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", resp.Header.Get("Sec-WebSocket-Accept"))
+	return &testClient{conn: conn, reader: reader}
+}
+
+func (c *testClient) write(t *testing.T, fin bool, opcode int, payload []byte) {
+	first := byte(opcode)
+	if fin {
+		first |= 0x80
+	}
+	frame := []byte{first}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, 0x80|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		frame = append(frame, 0x80|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, 0x80|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+	mask := []byte{1, 2, 3, 4}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	if _, err := c.conn.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (c *testClient) read(t *testing.T) (int, []byte) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		t.Fatal(err)
+	}
+	length := int(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		io.ReadFull(c.reader, ext[:])
+		length = int(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		io.ReadFull(c.reader, ext[:])
+		length = int(binary.BigEndian.Uint64(ext[:]))
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		t.Fatal(err)
+	}
+	return int(header[0] & 0x0f), payload
+}
+
+// echoServer sends every message back and records how the read loop ended
+func echoServer(t *testing.T, readLimit int64, ended chan<- error) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetReadLimit(readLimit)
+		for {
+			opcode, message, err := conn.ReadMessage()
+			if err != nil {
+				ended <- err
+				return
+			}
+			assert.NoError(t, conn.WriteMessage(opcode, message))
+		}
+	}))
+}
+
+func TestEcho(t *testing.T) {
+	ended := make(chan error, 1)
+	server := echoServer(t, 1<<20, ended)
+	defer server.Close()
+	client := dial(t, server.URL)
+
+	client.write(t, true, OpText, []byte("hello"))
+	opcode, payload := client.read(t)
+	assert.Equal(t, OpText, opcode)
+	assert.Equal(t, "hello", string(payload))
+
+	// a fragmented message with a ping in the middle
+	client.write(t, false, OpText, []byte("frag"))
+	client.write(t, true, OpPing, []byte("p"))
+	client.write(t, true, OpContinuation, []byte("mented"))
+	opcode, payload = client.read(t)
+	assert.Equal(t, OpPong, opcode)
+	assert.Equal(t, "p", string(payload))
+	opcode, payload = client.read(t)
+	assert.Equal(t, OpText, opcode)
+	assert.Equal(t, "fragmented", string(payload))
+
+	big := strings.Repeat("x", 70000)
+	client.write(t, true, OpBinary, []byte(big))
+	opcode, payload = client.read(t)
+	assert.Equal(t, OpBinary, opcode)
+	assert.Equal(t, big, string(payload))
+
+	client.write(t, true, OpClose, binary.BigEndian.AppendUint16(nil, CloseNormal))
+	opcode, payload = client.read(t)
+	assert.Equal(t, OpClose, opcode)
+	assert.Equal(t, uint16(CloseNormal), binary.BigEndian.Uint16(payload))
+	assert.ErrorIs(t, <-ended, ErrClosed)
+}
+
+func TestReadLimit(t *testing.T) {
+	ended := make(chan error, 1)
+	server := echoServer(t, 10, ended)
+	defer server.Close()
+	client := dial(t, server.URL)
+
+	client.write(t, true, OpText, []byte("much more than ten bytes"))
+	opcode, payload := client.read(t)
+	assert.Equal(t, OpClose, opcode)
+	assert.Equal(t, uint16(CloseMessageTooBig), binary.BigEndian.Uint16(payload))
+	assert.ErrorIs(t, <-ended, ErrMessageTooBig)
+}
+
+func TestUpgradeRejectsPlainRequests(t *testing.T) {
+	ended := make(chan error, 1)
+	server := echoServer(t, 10, ended)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "8")
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUpgradeRequired, resp.StatusCode)
+}